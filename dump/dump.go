@@ -0,0 +1,192 @@
+// Package dump reads MediaWiki multistream XML dumps: the bzip2 streams
+// named by the index file, the pages inside them, and the siteinfo header.
+package dump
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strings"
+)
+
+// Page represents a Wikipedia page XML structure
+type Page struct {
+	Title    string   `xml:"title"`
+	ID       int      `xml:"id"`
+	Revision Revision `xml:"revision"`
+}
+
+// Revision holds the wikitext of a page's current revision
+type Revision struct {
+	Text string `xml:"text"`
+}
+
+// ErrDumpUnreadable is wrapped around failures to open or read the dump
+// file itself, as opposed to damaged data found in it.
+var ErrDumpUnreadable = errors.New("dump file can't be read")
+
+// ExtractBzip2Range decompresses the bzip2 stream between two byte offsets.
+// An end offset of zero reads to the end of the file.
+func ExtractBzip2Range(filename string, startOffset, endOffset int64) ([]byte, error) {
+	if startOffset < 0 || endOffset < 0 || (endOffset > 0 && endOffset <= startOffset) {
+		return nil, fmt.Errorf("invalid offset values")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%w: opening file: %v", ErrDumpUnreadable, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(startOffset, 0); err != nil {
+		return nil, fmt.Errorf("%w: seeking to offset: %v", ErrDumpUnreadable, err)
+	}
+
+	// The last stream in the file has no end offset; read it to EOF
+	var compressedData []byte
+	if endOffset == 0 {
+		compressedData, err = io.ReadAll(f)
+	} else {
+		compressedData = make([]byte, endOffset-startOffset)
+		_, err = io.ReadFull(f, compressedData)
+	}
+	if err == io.ErrUnexpectedEOF {
+		// The file is shorter than the index says: damaged, not unreadable
+		return nil, fmt.Errorf("reading compressed data: file ends inside the stream")
+	}
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%w: reading compressed data: %v", ErrDumpUnreadable, err)
+	}
+	if err := ValidateStreamStart(compressedData, startOffset); err != nil {
+		return nil, err
+	}
+
+	bzReader := bzip2.NewReader(bytes.NewReader(compressedData))
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, bzReader); err != nil {
+		return nil, fmt.Errorf("decompressing data: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ExtractPageText parses XML data and returns the text content for a given page ID
+func ExtractPageText(data []byte, pageID int) (string, error) {
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("error decoding XML: %v", err)
+		}
+
+		if se, ok := token.(xml.StartElement); ok {
+			if se.Name.Local == "page" {
+				var page Page
+				if err := decoder.DecodeElement(&page, &se); err != nil {
+					return "", fmt.Errorf("error decoding page: %v", err)
+				}
+				if page.ID == pageID {
+					return page.Revision.Text, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("page with ID %d not found", pageID)
+}
+
+// ExtractPages parses XML data and returns every page in it
+func ExtractPages(data []byte) ([]Page, error) {
+	var pages []Page
+	for page, err := range Pages(bytes.NewReader(data)) {
+		if err != nil {
+			return pages, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// Pages iterates over the pages in an XML document or fragment, decoding
+// one page at a time so whole dumps can be walked in constant memory.
+func Pages(r io.Reader) iter.Seq2[Page, error] {
+	return func(yield func(Page, error) bool) {
+		decoder := xml.NewDecoder(r)
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF || isTrailingEnd(err) {
+				return
+			}
+			if err != nil {
+				yield(Page{}, fmt.Errorf("error decoding XML: %v", err))
+				return
+			}
+
+			if se, ok := token.(xml.StartElement); ok && se.Name.Local == "page" {
+				var page Page
+				if err := decoder.DecodeElement(&page, &se); err != nil {
+					yield(Page{}, fmt.Errorf("error decoding page: %v", err))
+					return
+				}
+				if !yield(page, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// isTrailingEnd reports whether err is the closing </mediawiki> tag at the
+// end of the last stream, which has no matching start within the fragment.
+func isTrailingEnd(err error) bool {
+	var syntaxErr *xml.SyntaxError
+	return errors.As(err, &syntaxErr) && strings.Contains(syntaxErr.Msg, "unexpected end element </mediawiki>")
+}
+
+// Stream is one independently compressed bzip2 stream of a multistream dump
+type Stream struct {
+	Start int64
+	End   int64 // zero for the last stream in the file
+}
+
+// Pages decompresses the stream and iterates over the pages inside it.
+func (s Stream) Pages(filename string) iter.Seq2[Page, error] {
+	return func(yield func(Page, error) bool) {
+		data, err := ExtractBzip2Range(filename, s.Start, s.End)
+		if err != nil {
+			yield(Page{}, err)
+			return
+		}
+		for page, err := range Pages(bytes.NewReader(data)) {
+			if !yield(page, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// AllPages iterates over every page in a dump file by decompressing it
+// sequentially, without needing an index.
+func AllPages(filename string) iter.Seq2[Page, error] {
+	return func(yield func(Page, error) bool) {
+		f, err := os.Open(filename)
+		if err != nil {
+			yield(Page{}, fmt.Errorf("opening file: %v", err))
+			return
+		}
+		defer f.Close()
+		for page, err := range Pages(bzip2.NewReader(f)) {
+			if !yield(page, err) || err != nil {
+				return
+			}
+		}
+	}
+}
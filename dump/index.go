@@ -0,0 +1,222 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// IndexLine is one "offset:pageid:title" line of a multistream index
+type IndexLine struct {
+	Offset int64
+	PageID int
+	Title  string
+}
+
+// ParseIndexLine splits an index line into its fields. Only the first two
+// colons are separators; the title is kept verbatim, so titles such as
+// "Star Wars: Episode IV – A New Hope" or "2001: A Space Odyssey" keep
+// every colon. Lines with a missing or empty title or non-numeric or
+// negative offsets are rejected.
+func ParseIndexLine(line string) (IndexLine, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	offsetStr, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return IndexLine{}, false
+	}
+	pageIDStr, title, ok := strings.Cut(rest, ":")
+	if !ok || title == "" {
+		return IndexLine{}, false
+	}
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil || offset < 0 {
+		return IndexLine{}, false
+	}
+	pageID, err := strconv.Atoi(pageIDStr)
+	if err != nil || pageID < 0 {
+		return IndexLine{}, false
+	}
+	return IndexLine{Offset: offset, PageID: pageID, Title: title}, true
+}
+
+// MaxIndexLineLength bounds the length of one index line. Real lines are a
+// few hundred bytes at most; longer ones come from corrupt or foreign files
+// and are skipped without being held in memory whole.
+const MaxIndexLineLength = 1 << 20
+
+// IndexStats counts the lines read by an IndexReader.
+type IndexStats struct {
+	Lines     int // lines read, including skipped ones
+	Malformed int // lines that are not "offset:pageid:title"
+	Overlong  int // lines longer than MaxIndexLineLength
+}
+
+// Skipped returns the number of lines that were not returned.
+func (s IndexStats) Skipped() int {
+	return s.Malformed + s.Overlong
+}
+
+// IndexReader reads index lines with bounded memory, counting the lines
+// it has to skip instead of stopping at them.
+type IndexReader struct {
+	r     *bufio.Reader
+	line  []byte
+	Stats IndexStats
+}
+
+// NewIndexReader reads an uncompressed index from r.
+func NewIndexReader(r io.Reader) *IndexReader {
+	return &IndexReader{r: bufio.NewReaderSize(r, 64<<10)}
+}
+
+// Next returns the next well-formed line, skipping malformed and overlong
+// ones. It returns io.EOF after the last line.
+func (ir *IndexReader) Next() (IndexLine, error) {
+	for {
+		ir.line = ir.line[:0]
+		overlong := false
+		for {
+			chunk, err := ir.r.ReadSlice('\n')
+			if !overlong {
+				if len(ir.line)+len(chunk) > MaxIndexLineLength {
+					overlong = true
+					ir.line = ir.line[:0]
+				} else {
+					ir.line = append(ir.line, chunk...)
+				}
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err == io.EOF && len(ir.line) == 0 && !overlong {
+				return IndexLine{}, io.EOF
+			}
+			if err != nil && err != io.EOF {
+				return IndexLine{}, err
+			}
+			break
+		}
+
+		ir.Stats.Lines++
+		if overlong {
+			ir.Stats.Overlong++
+			continue
+		}
+		line, ok := ParseIndexLine(string(ir.line))
+		if !ok {
+			if len(strings.TrimSpace(string(ir.line))) > 0 {
+				ir.Stats.Malformed++
+			}
+			continue
+		}
+		return line, nil
+	}
+}
+
+// indexFile is an open index file read through its decompressor.
+type indexFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *indexFile) Close() error {
+	var first error
+	for _, c := range f.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// OpenIndex opens an index file for reading as plain text. The index may
+// be bzip2 compressed, as Wikimedia publishes it, gzip compressed, or
+// already decompressed; the format is detected from the first bytes, not
+// the file name.
+func OpenIndex(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening index file: %v", err)
+	}
+	br := bufio.NewReader(f)
+	head, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, fmt.Errorf("reading index file: %v", err)
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("BZh")):
+		return &indexFile{Reader: bzip2.NewReader(br), closers: []io.Closer{f}}, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reading gzip index file: %v", err)
+		}
+		return &indexFile{Reader: zr, closers: []io.Closer{zr, f}}, nil
+	case len(head) == 0 || head[0] >= '0' && head[0] <= '9':
+		return &indexFile{Reader: br, closers: []io.Closer{f}}, nil
+	}
+	f.Close()
+	return nil, fmt.Errorf("%s is not a multistream index: expected offset:pageid:title lines, plain or compressed with bzip2 or gzip", filename)
+}
+
+// IndexLines iterates over the parsed lines of an index file, plain or
+// compressed with bzip2 or gzip, skipping malformed lines.
+func IndexLines(filename string) iter.Seq2[IndexLine, error] {
+	return func(yield func(IndexLine, error) bool) {
+		f, err := OpenIndex(filename)
+		if err != nil {
+			yield(IndexLine{}, err)
+			return
+		}
+		defer f.Close()
+
+		ir := NewIndexReader(f)
+		for {
+			line, err := ir.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(IndexLine{}, fmt.Errorf("reading index file: %v", err))
+				return
+			}
+			if !yield(line, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Streams iterates over the streams named by an index file, in file order.
+// Index files list pages in offset order, so each stream's end is the start
+// of the next one.
+func Streams(indexFilename string) iter.Seq2[Stream, error] {
+	return func(yield func(Stream, error) bool) {
+		var start int64 = -1
+		for line, err := range IndexLines(indexFilename) {
+			if err != nil {
+				yield(Stream{}, err)
+				return
+			}
+			if line.Offset == start {
+				continue
+			}
+			if start >= 0 && !yield(Stream{Start: start, End: line.Offset}, nil) {
+				return
+			}
+			start = line.Offset
+		}
+		if start >= 0 {
+			yield(Stream{Start: start}, nil)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package dump
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseIndexLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want IndexLine
+		ok   bool
+	}{
+		{"597:10:AccessibleComputing", IndexLine{597, 10, "AccessibleComputing"}, true},
+		{"597:12:Anarchism\n", IndexLine{597, 12, "Anarchism"}, true},
+		{"597:12:Anarchism\r\n", IndexLine{597, 12, "Anarchism"}, true},
+		{"0:1:Main Page\r", IndexLine{0, 1, "Main Page"}, true},
+
+		// Only the first two colons separate fields
+		{"1210:25:Star Wars: Episode IV – A New Hope", IndexLine{1210, 25, "Star Wars: Episode IV – A New Hope"}, true},
+		{"1210:26:2001: A Space Odyssey", IndexLine{1210, 26, "2001: A Space Odyssey"}, true},
+		{"88:3:Wikipedia:Manual of Style/Dates and numbers", IndexLine{88, 3, "Wikipedia:Manual of Style/Dates and numbers"}, true},
+		{"88:4:Talk:C++::operator", IndexLine{88, 4, "Talk:C++::operator"}, true},
+		{"88:5::", IndexLine{88, 5, ":"}, true},
+		{"88:6:Ratio 1:2:3\r\n", IndexLine{88, 6, "Ratio 1:2:3"}, true},
+		{"9223372036854775807:7:Largest offset", IndexLine{9223372036854775807, 7, "Largest offset"}, true},
+
+		// Missing or empty title
+		{"597:10:", IndexLine{}, false},
+		{"597:10:\r\n", IndexLine{}, false},
+		{"597:10", IndexLine{}, false},
+		{"597", IndexLine{}, false},
+		{"", IndexLine{}, false},
+		{"\r\n", IndexLine{}, false},
+
+		// Negative or non-numeric fields
+		{"-1:10:Title", IndexLine{}, false},
+		{"597:-10:Title", IndexLine{}, false},
+		{"abc:10:Title", IndexLine{}, false},
+		{"597:ten:Title", IndexLine{}, false},
+		{"5 97:10:Title", IndexLine{}, false},
+		{"0x10:10:Title", IndexLine{}, false},
+		{":10:Title", IndexLine{}, false},
+		{"597::Title", IndexLine{}, false},
+		{"9223372036854775808:10:Offset overflows", IndexLine{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseIndexLine(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParseIndexLine(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIndexReaderSkipsBadLines(t *testing.T) {
+	input := strings.Join([]string{
+		"597:10:AccessibleComputing",
+		"not an index line",
+		"",
+		"597:12:Anarchism: A History",
+		strings.Repeat("x", MaxIndexLineLength+1),
+		"-5:13:Negative",
+		"1210:25:Last line without newline\r",
+	}, "\r\n")
+
+	ir := NewIndexReader(strings.NewReader(input))
+	var titles []string
+	for {
+		line, err := ir.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		titles = append(titles, line.Title)
+	}
+
+	want := []string{"AccessibleComputing", "Anarchism: A History", "Last line without newline"}
+	if strings.Join(titles, "|") != strings.Join(want, "|") {
+		t.Errorf("titles = %q, want %q", titles, want)
+	}
+	if want := (IndexStats{Lines: 7, Malformed: 2, Overlong: 1}); ir.Stats != want {
+		t.Errorf("Stats = %+v, want %+v", ir.Stats, want)
+	}
+	if ir.Stats.Skipped() != 3 {
+		t.Errorf("Skipped = %d, want 3", ir.Stats.Skipped())
+	}
+}
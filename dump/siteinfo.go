@@ -0,0 +1,141 @@
+package dump
+
+import (
+	"compress/bzip2"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SiteInfo is the <siteinfo> header at the start of a dump
+type SiteInfo struct {
+	SiteName   string      `xml:"sitename"`
+	DBName     string      `xml:"dbname"`
+	Base       string      `xml:"base"`
+	Generator  string      `xml:"generator"`
+	Case       string      `xml:"case"`
+	Namespaces []Namespace `xml:"namespaces>namespace"`
+
+	// Lang is the content language, from the xml:lang attribute of the
+	// <mediawiki> element around the header
+	Lang string `xml:"-"`
+}
+
+// Namespace is one entry of the siteinfo namespace list. The main article
+// namespace has key 0 and an empty name.
+type Namespace struct {
+	Key  int    `xml:"key,attr"`
+	Name string `xml:",chardata"`
+}
+
+// defaultNamespaces is used when the dump header can't be read
+var defaultNamespaces = []string{
+	"Media", "Special", "Talk", "User", "User talk", "Wikipedia", "Wikipedia talk",
+	"File", "File talk", "MediaWiki", "MediaWiki talk", "Template", "Template talk",
+	"Help", "Help talk", "Category", "Category talk", "Portal", "Portal talk",
+	"Draft", "Draft talk", "TimedText", "TimedText talk", "Module", "Module talk",
+}
+
+// ReadSiteInfo decodes the siteinfo header from the first stream of a
+// multistream dump without reading any further.
+func ReadSiteInfo(filename string) (*SiteInfo, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %v", err)
+	}
+	defer f.Close()
+
+	decoder := xml.NewDecoder(bzip2.NewReader(f))
+	var lang string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no siteinfo found")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding XML: %v", err)
+		}
+		if se, ok := token.(xml.StartElement); ok {
+			switch se.Name.Local {
+			case "mediawiki":
+				for _, attr := range se.Attr {
+					if attr.Name.Local == "lang" {
+						lang = attr.Value
+					}
+				}
+			case "siteinfo":
+				var info SiteInfo
+				if err := decoder.DecodeElement(&info, &se); err != nil {
+					return nil, fmt.Errorf("error decoding siteinfo: %v", err)
+				}
+				info.Lang = lang
+				return &info, nil
+			case "page":
+				return nil, fmt.Errorf("no siteinfo found before first page")
+			}
+		}
+	}
+}
+
+// NamespaceSet holds the prefixes of all non-article namespaces
+type NamespaceSet map[string]bool
+
+// NamespaceSet returns the non-article namespaces listed in the header.
+func (info *SiteInfo) NamespaceSet() NamespaceSet {
+	set := make(NamespaceSet)
+	for _, ns := range info.Namespaces {
+		if ns.Key != 0 && ns.Name != "" {
+			set[ns.Name] = true
+		}
+	}
+	return set
+}
+
+// TemplateNamespace is the key of the namespace holding templates.
+const TemplateNamespace = 10
+
+// NamespaceName returns the local name of the namespace with the given
+// key, such as "Vorlage" for templates on German Wikipedia, or "" if the
+// header doesn't list it.
+func (info *SiteInfo) NamespaceName(key int) string {
+	for _, ns := range info.Namespaces {
+		if ns.Key == key {
+			return ns.Name
+		}
+	}
+	return ""
+}
+
+// DefaultNamespaceSet returns the standard English Wikipedia namespaces.
+func DefaultNamespaceSet() NamespaceSet {
+	set := make(NamespaceSet)
+	for _, name := range defaultNamespaces {
+		set[name] = true
+	}
+	return set
+}
+
+// IsNamespaced reports whether a title belongs to a non-article namespace.
+// Titles such as "Star Trek: The Next Generation" contain a colon but no
+// namespace prefix.
+func (ns NamespaceSet) IsNamespaced(title string) bool {
+	prefix, _, ok := strings.Cut(title, ":")
+	return ok && ns[prefix]
+}
+
+var dumpDateRe = regexp.MustCompile(`-(\d{4})(\d{2})(\d{2})-`)
+
+// DumpDate returns the snapshot date embedded in a standard dump filename
+// such as enwiki-20240601-pages-articles-multistream.xml.bz2, formatted as
+// YYYY-MM-DD, or "" if the name has no date.
+func DumpDate(filename string) string {
+	m := dumpDateRe.FindStringSubmatch(filepath.Base(filename))
+	if m == nil {
+		return ""
+	}
+	return m[1] + "-" + m[2] + "-" + m[3]
+}
@@ -0,0 +1,147 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+)
+
+// SQLRows iterates over the rows a MediaWiki SQL dump, such as
+// enwiki-20240601-page_props.sql.gz, inserts into one table. The file may
+// be gzip compressed, as Wikimedia publishes it, or plain. Each row is its
+// column values in table order, with quotes and escapes removed and NULL
+// read as "".
+func SQLRows(filename, table string) iter.Seq2[[]string, error] {
+	return func(yield func([]string, error) bool) {
+		f, err := os.Open(filename)
+		if err != nil {
+			yield(nil, fmt.Errorf("opening SQL dump: %v", err))
+			return
+		}
+		defer f.Close()
+		br := bufio.NewReaderSize(f, 1<<20)
+		var r io.Reader = br
+		if head, _ := br.Peek(2); bytes.Equal(head, []byte{0x1f, 0x8b}) {
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				yield(nil, fmt.Errorf("reading gzip SQL dump: %v", err))
+				return
+			}
+			defer zr.Close()
+			r = zr
+		}
+
+		prefix := []byte("INSERT INTO `" + table + "` VALUES ")
+		lines := bufio.NewReaderSize(r, 1<<20)
+		for {
+			// Each INSERT statement is one line of up to a few megabytes
+			line, err := lines.ReadBytes('\n')
+			if bytes.HasPrefix(line, prefix) {
+				ok, perr := parseSQLValues(line[len(prefix):], yield)
+				if perr != nil {
+					yield(nil, fmt.Errorf("reading SQL dump: %v", perr))
+					return
+				}
+				if !ok {
+					return
+				}
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, fmt.Errorf("reading SQL dump: %v", err))
+				return
+			}
+		}
+	}
+}
+
+// parseSQLValues reads the (a,'b',NULL),(...); tuples of one INSERT
+// statement, passing each to yield. It reports false when yield asks to
+// stop.
+func parseSQLValues(data []byte, yield func([]string, error) bool) (bool, error) {
+	var row []string
+	var value []byte
+	i := 0
+	for i < len(data) {
+		if data[i] != '(' {
+			return false, fmt.Errorf("expected ( at %q", truncate(data[i:]))
+		}
+		i++
+		row = nil
+		for {
+			value = value[:0]
+			if i < len(data) && data[i] == '\'' {
+				i++
+				for i < len(data) && data[i] != '\'' {
+					c := data[i]
+					if c == '\\' && i+1 < len(data) {
+						i++
+						c = sqlUnescape(data[i])
+					}
+					value = append(value, c)
+					i++
+				}
+				if i == len(data) {
+					return false, fmt.Errorf("unterminated string")
+				}
+				i++
+			} else {
+				start := i
+				for i < len(data) && data[i] != ',' && data[i] != ')' {
+					i++
+				}
+				if token := data[start:i]; !bytes.Equal(token, []byte("NULL")) {
+					value = append(value, token...)
+				}
+			}
+			row = append(row, string(value))
+			if i == len(data) {
+				return false, fmt.Errorf("unterminated row")
+			}
+			if data[i] == ')' {
+				i++
+				break
+			}
+			i++ // the comma between values
+		}
+		if !yield(row, nil) {
+			return false, nil
+		}
+		if i < len(data) && data[i] == ',' {
+			i++
+			continue
+		}
+		return true, nil // the closing semicolon
+	}
+	return true, nil
+}
+
+// sqlUnescape returns the byte a mysqldump backslash escape stands for.
+func sqlUnescape(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return 0x1a
+	}
+	return c
+}
+
+func truncate(b []byte) string {
+	if len(b) > 20 {
+		return string(b[:20]) + "…"
+	}
+	return string(b)
+}
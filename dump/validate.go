@@ -0,0 +1,89 @@
+package dump
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	// bzip2 block and end-of-stream magic numbers following the BZh header
+	blockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	eosMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// ErrNotStreamStart is returned when an offset does not point at the start
+// of a bzip2 stream, which usually means the index belongs to a different
+// or partially downloaded copy of the dump.
+var ErrNotStreamStart = errors.New("offset is not the start of a bzip2 stream")
+
+// streamHeaderLen is the length of "BZh" + level digit + a 6-byte magic
+const streamHeaderLen = 10
+
+// isStreamHeader reports whether b begins with a bzip2 stream header.
+func isStreamHeader(b []byte) bool {
+	if len(b) < streamHeaderLen || !bytes.HasPrefix(b, []byte("BZh")) || b[3] < '1' || b[3] > '9' {
+		return false
+	}
+	return bytes.Equal(b[4:10], blockMagic) || bytes.Equal(b[4:10], eosMagic)
+}
+
+// ValidateStreamStart checks that data begins with a bzip2 stream header.
+func ValidateStreamStart(data []byte, offset int64) error {
+	if isStreamHeader(data) {
+		return nil
+	}
+	n := len(data)
+	if n > streamHeaderLen {
+		n = streamHeaderLen
+	}
+	return fmt.Errorf("%w: offset %d starts with % x; the index may not match this dump, try `wikiseek index repair`", ErrNotStreamStart, offset, data[:n])
+}
+
+// ScanStreams reads the whole dump and returns the offset of every bzip2
+// stream header in it. progress, if set, is called periodically with the
+// number of bytes scanned.
+func ScanStreams(filename string, progress func(scanned int64)) ([]int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %v", err)
+	}
+	defer f.Close()
+
+	const chunkSize = 4 << 20
+	buf := make([]byte, chunkSize+streamHeaderLen)
+	var starts []int64
+	var base int64 // file offset of buf[0]
+	carry := 0
+	for {
+		n, err := io.ReadFull(f, buf[carry:])
+		n += carry
+		for i := 0; i+streamHeaderLen <= n; {
+			j := bytes.Index(buf[i:n], []byte("BZh"))
+			if j == -1 || i+j+streamHeaderLen > n {
+				break
+			}
+			i += j
+			if isStreamHeader(buf[i:n]) {
+				starts = append(starts, base+int64(i))
+			}
+			i++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return starts, nil
+		}
+		if err != nil {
+			return starts, fmt.Errorf("reading file: %v", err)
+		}
+
+		// Keep the tail so headers spanning chunks are found
+		carry = streamHeaderLen - 1
+		copy(buf, buf[n-carry:n])
+		base += int64(n - carry)
+		if progress != nil {
+			progress(base)
+		}
+	}
+}
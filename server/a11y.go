@@ -0,0 +1,136 @@
+package server
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxCaptionColumns is how many column names a generated table caption
+// lists.
+const maxCaptionColumns = 5
+
+var (
+	headingTagRe   = regexp.MustCompile(`(?is)<h([1-6])(\s[^>]*)?>(.*?)</h[1-6]>`)
+	figureRe       = regexp.MustCompile(`(?is)<figure[^>]*>.*?</figure>`)
+	figcaptionRe   = regexp.MustCompile(`(?is)<figcaption[^>]*>(.*?)</figcaption>`)
+	imgRe          = regexp.MustCompile(`(?i)<img[^>]*>`)
+	imgAltRe       = regexp.MustCompile(`(?i)\salt="([^"]*)"`)
+	imgTitleRe     = regexp.MustCompile(`(?i)\stitle="([^"]*)"`)
+	tableOpenRe    = regexp.MustCompile(`(?i)<table[^>]*>`)
+	tableNextRe    = regexp.MustCompile(`(?i)<caption[\s>]|<table[\s>]|</table>`)
+	headerCellRe   = regexp.MustCompile(`(?is)<th(\s[^>]*)?>(.*?)</th>`)
+	thWithoutScope = regexp.MustCompile(`(?i)<th(\s[^>]*)?>`)
+)
+
+// accessibilityStage adjusts rendered articles for screen readers: heading
+// levels follow on from the page title without gaps, images get alt text
+// from their captions, and data tables get a caption naming their columns.
+func accessibilityStage(ctx *RenderContext) error {
+	ctx.HTML = fixHeadingOrder(ctx.HTML)
+	ctx.HTML = addImageAlts(ctx.HTML)
+	ctx.HTML = addTableCaptions(ctx.HTML)
+	return nil
+}
+
+// fixHeadingOrder renumbers headings so that none skips a level: the page
+// title is the only h1, so the article starts at h2, and each heading is
+// at most one level below the one before it.
+func fixHeadingOrder(body string) string {
+	prev := 1
+	return headingTagRe.ReplaceAllStringFunc(body, func(m string) string {
+		sub := headingTagRe.FindStringSubmatch(m)
+		level, _ := strconv.Atoi(sub[1])
+		fixed := max(2, min(level, prev+1))
+		prev = fixed
+		if fixed == level {
+			return m
+		}
+		n := strconv.Itoa(fixed)
+		return "<h" + n + sub[2] + ">" + sub[3] + "</h" + n + ">"
+	})
+}
+
+// addImageAlts gives images without alt text the caption of their figure,
+// or their title. Images with neither are marked decorative with an empty
+// alt, so screen readers skip them rather than reading the file name.
+func addImageAlts(body string) string {
+	body = figureRe.ReplaceAllStringFunc(body, func(figure string) string {
+		m := figcaptionRe.FindStringSubmatch(figure)
+		if m == nil {
+			return figure
+		}
+		caption := strings.TrimSpace(html.UnescapeString(tagRe.ReplaceAllString(m[1], "")))
+		return imgRe.ReplaceAllStringFunc(figure, func(img string) string {
+			return setImageAlt(img, caption)
+		})
+	})
+	return imgRe.ReplaceAllStringFunc(body, func(img string) string {
+		title := ""
+		if m := imgTitleRe.FindStringSubmatch(img); m != nil {
+			title = html.UnescapeString(m[1])
+		}
+		return setImageAlt(img, title)
+	})
+}
+
+// setImageAlt sets the alt text of an img tag that has none.
+func setImageAlt(img, alt string) string {
+	if m := imgAltRe.FindStringSubmatch(img); m != nil && strings.TrimSpace(m[1]) != "" {
+		return img
+	}
+	img = imgAltRe.ReplaceAllString(img, "")
+	return "<img alt=\"" + html.EscapeString(alt) + "\"" + img[len("<img"):]
+}
+
+// addTableCaptions gives tables with header cells but no caption a
+// visually hidden one listing the first few columns, and marks those
+// header cells as column headers.
+func addTableCaptions(body string) string {
+	var b strings.Builder
+	for {
+		loc := tableOpenRe.FindStringIndex(body)
+		if loc == nil {
+			b.WriteString(body)
+			return b.String()
+		}
+		b.WriteString(body[:loc[1]])
+		body = body[loc[1]:]
+
+		next := tableNextRe.FindStringIndex(body)
+		if next != nil && strings.HasPrefix(strings.ToLower(body[next[0]:]), "<caption") {
+			continue
+		}
+		// Only the first row holds column headers
+		rowEnd := strings.Index(strings.ToLower(body), "</tr>")
+		if rowEnd < 0 || (next != nil && rowEnd > next[0]) {
+			continue
+		}
+		cells := headerCellRe.FindAllStringSubmatch(body[:rowEnd], -1)
+		if len(cells) == 0 {
+			continue
+		}
+		var columns []string
+		for _, cell := range cells {
+			text := strings.TrimSpace(html.UnescapeString(tagRe.ReplaceAllString(cell[2], "")))
+			if text != "" && len(columns) < maxCaptionColumns {
+				columns = append(columns, text)
+			}
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		if len(cells) > len(columns) {
+			columns = append(columns, "…")
+		}
+		b.WriteString(`<caption class="visually-hidden">Table columns: ` + html.EscapeString(strings.Join(columns, ", ")) + "</caption>")
+		row := thWithoutScope.ReplaceAllStringFunc(body[:rowEnd], func(th string) string {
+			if strings.Contains(strings.ToLower(th), "scope=") {
+				return th
+			}
+			return `<th scope="col"` + th[len("<th"):]
+		})
+		body = row + body[rowEnd:]
+	}
+}
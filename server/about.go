@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// DumpInfo describes the snapshot being served
+type DumpInfo struct {
+	SiteName  string `json:"sitename,omitempty"`
+	DBName    string `json:"dbname,omitempty"`
+	Base      string `json:"base,omitempty"`
+	Generator string `json:"generator,omitempty"`
+	DumpDate  string `json:"dump_date,omitempty"`
+	File      string `json:"file"`
+	Lang      string `json:"lang,omitempty"` // content language, such as "ar"
+	Dir       string `json:"dir"`            // "rtl" or "ltr", following Lang
+}
+
+// loadDumpInfo reads the siteinfo header of the dump. The file name and
+// date are filled in even when the header can't be read.
+func loadDumpInfo(inputFile string, log io.Writer) *DumpInfo {
+	info := &DumpInfo{
+		File:     filepath.Base(inputFile),
+		DumpDate: dump.DumpDate(inputFile),
+		Dir:      "ltr",
+	}
+	site, err := dump.ReadSiteInfo(inputFile)
+	if err != nil {
+		fmt.Fprintf(log, "Warning: could not read dump siteinfo: %v\n", err)
+		return info
+	}
+	info.SiteName = site.SiteName
+	info.DBName = site.DBName
+	info.Base = site.Base
+	info.Generator = site.Generator
+	info.Lang = dumpLanguage(site.Lang, site.DBName)
+	info.Dir = languageDirection(info.Lang)
+	return info
+}
+
+// Summary is the one-line description used in page footers.
+func (d *DumpInfo) Summary() string {
+	var parts []string
+	if d.SiteName != "" {
+		parts = append(parts, d.SiteName)
+	}
+	if d.DBName != "" {
+		parts = append(parts, d.DBName)
+	}
+	if d.DumpDate != "" {
+		parts = append(parts, "dump of "+d.DumpDate)
+	}
+	if len(parts) == 0 {
+		return d.File
+	}
+	return strings.Join(parts, " · ")
+}
+
+// handleAbout describes the dump, as JSON when requested and as a page
+// otherwise.
+func handleAbout(w http.ResponseWriter, r *http.Request, tmpl *template.Template, articleCount int) {
+	info := requestServer(r).info
+	if r.FormValue("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, struct {
+			*DumpInfo
+			Articles int `json:"articles"`
+		}{info, articleCount})
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("<dl class=\"dump-info\">")
+	row := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "<dt>%s</dt><dd>%s</dd>", label, html.EscapeString(value))
+		}
+	}
+	row("Site", info.SiteName)
+	row("Database", info.DBName)
+	if info.Lang != "" {
+		row("Language", info.Lang+" ("+info.Dir+")")
+	}
+	row("Source", info.Base)
+	row("Generator", info.Generator)
+	row("Dump date", info.DumpDate)
+	row("File", info.File)
+	row("Articles", fmt.Sprint(articleCount))
+	b.WriteString("</dl>")
+
+	tmpl.Execute(w, ArticleView{
+		Layout:  Layout{Dump: info},
+		Title:   "About this snapshot",
+		Content: template.HTML(b.String()),
+	})
+}
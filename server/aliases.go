@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aliasCheckInterval is how often the alias file is checked for changes.
+const aliasCheckInterval = 2 * time.Second
+
+// titleNames are the names search and URLs match titles by besides their
+// own: the -aliases file and, with -transliterate, the Latin spelling of
+// titles in other scripts. A nil *titleNames adds none.
+type titleNames struct {
+	aliases       *aliasTable
+	translit      map[int]string // page ID to Latin spelling
+	translitBuilt chan struct{}  // closed once translit is filled; nil without -transliterate
+}
+
+// transliteratedNames returns names holding the Latin spelling of the
+// titles in index. Transliterating millions of titles takes a while, so
+// it happens in the background; searches made before then wait for it.
+func transliteratedNames(index []IndexEntry, log io.Writer) *titleNames {
+	n := &titleNames{translitBuilt: make(chan struct{})}
+	go func() {
+		n.translit = buildTransliterations(index)
+		close(n.translitBuilt)
+		fmt.Fprintf(log, "Transliterated %d titles for search\n", len(n.translit))
+	}()
+	return n
+}
+
+// resolveAlias returns the title an alias stands for.
+func (n *titleNames) resolveAlias(alias string) (string, bool) {
+	if n == nil {
+		return "", false
+	}
+	return n.aliases.Resolve(alias)
+}
+
+// transliterations returns the Latin spellings of titles, or nil without
+// -transliterate.
+func (n *titleNames) transliterations() map[int]string {
+	if n == nil || n.translitBuilt == nil {
+		return nil
+	}
+	<-n.translitBuilt
+	return n.translit
+}
+
+// aliasTable maps alternate names, such as household shorthand, to article
+// titles. It is read from a file of "alias = Title" lines, which can be
+// edited while the server runs.
+type aliasTable struct {
+	file string
+	log  io.Writer
+
+	mu        sync.RWMutex
+	targets   map[string]string // lowercase alias to title
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// loadAliases reads an alias file. Reloads are reported to log.
+func loadAliases(file string, log io.Writer) (*aliasTable, error) {
+	a := &aliasTable{file: file, log: log}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// aliasKey normalizes a title or alias for lookup.
+func aliasKey(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(s, "_", " ")), " "))
+}
+
+// parseAliases reads "alias = Title" lines; "→" may be used instead of
+// "=". Blank lines and lines starting with # are ignored.
+func parseAliases(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("opening alias file: %v", err)
+	}
+	defer f.Close()
+
+	targets := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		alias, title, ok := strings.Cut(line, "=")
+		if !ok {
+			alias, title, ok = strings.Cut(line, "→")
+		}
+		alias, title = aliasKey(alias), strings.TrimSpace(strings.ReplaceAll(title, "_", " "))
+		if !ok || alias == "" || title == "" {
+			return nil, fmt.Errorf("line %d: expected \"alias = Title\"", lineNo)
+		}
+		targets[alias] = title
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading alias file: %v", err)
+	}
+	return targets, nil
+}
+
+// reload rereads the file if it changed since it was last read.
+func (a *aliasTable) reload() error {
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return fmt.Errorf("reading alias file: %v", err)
+	}
+	a.mu.RLock()
+	unchanged := a.targets != nil && info.ModTime().Equal(a.modTime) && info.Size() == a.size
+	a.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	targets, err := parseAliases(a.file)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	if a.targets != nil {
+		fmt.Fprintf(a.log, "[%s] Reloaded %d title aliases from %s\n", time.Now().Format("2006-01-02 15:04:05"), len(targets), a.file)
+	}
+	a.targets, a.modTime, a.size = targets, info.ModTime(), info.Size()
+	a.mu.Unlock()
+	return nil
+}
+
+// Resolve returns the title an alias stands for. Edits to the file are
+// picked up within aliasCheckInterval; a file that no longer parses keeps
+// the aliases read before.
+func (a *aliasTable) Resolve(alias string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	a.mu.Lock()
+	check := time.Since(a.lastCheck) >= aliasCheckInterval
+	if check {
+		a.lastCheck = time.Now()
+	}
+	a.mu.Unlock()
+	if check {
+		if err := a.reload(); err != nil {
+			fmt.Fprintf(a.log, "Warning: keeping previous aliases: %v\n", err)
+		}
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	title, ok := a.targets[aliasKey(alias)]
+	return title, ok
+}
+
+// Len returns the number of aliases.
+func (a *aliasTable) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.targets)
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fullTextLimit caps the number of full-text hits, since each one needs
+// its stream decompressed for a snippet.
+const fullTextLimit = 20
+
+type apiSearchResult struct {
+	Match       string `json:"match,omitempty"`
+	Title       string `json:"title"`
+	PageID      int    `json:"page_id"`
+	StartOffset int64  `json:"start_offset"`
+	EndOffset   int64  `json:"end_offset"`
+	Snippet     string `json:"snippet,omitempty"`
+	Score       int    `json:"score,omitempty"`
+	SizeBytes   int    `json:"size_bytes,omitempty"`
+	Stub        bool   `json:"stub,omitempty"`
+	Redirect    bool   `json:"redirect,omitempty"`
+	RedirectTo  string `json:"redirect_to,omitempty"`
+	Disambig    bool   `json:"disambiguation,omitempty"`
+	Quality     string `json:"quality,omitempty"`
+}
+
+func newAPISearchResult(match string, e IndexEntry, meta *metaStore) apiSearchResult {
+	result := apiSearchResult{
+		Match:       match,
+		Title:       e.Title,
+		PageID:      e.PageID,
+		StartOffset: e.Offsets.Start,
+		EndOffset:   e.Offsets.End,
+	}
+	if m, ok := meta.Get(e.PageID); ok {
+		result.SizeBytes = m.Bytes
+		result.Stub = m.Stub
+		result.Redirect = m.Redirect
+		result.RedirectTo = m.RedirectTarget
+		result.Disambig = m.Disambig
+		result.Quality = m.Quality
+	}
+	return result
+}
+
+type apiResultGroup struct {
+	Label   string            `json:"label"`
+	Results []apiSearchResult `json:"results"`
+}
+
+type apiSearchResponse struct {
+	Query       string            `json:"query"`
+	Count       int               `json:"count"`
+	Groups      []apiResultGroup  `json:"groups"`
+	FullText    []apiSearchResult `json:"fulltext,omitempty"`
+	Suggestions []string          `json:"suggestions,omitempty"` // corrections when nothing matched
+	Next        string            `json:"next,omitempty"`        // cursor of the next page of title matches
+}
+
+// newAPISearchResponse lists title search results as /api/search returns
+// them.
+func newAPISearchResponse(query string, groups []ResultGroup, meta *metaStore) apiSearchResponse {
+	resp := apiSearchResponse{Query: query, Count: countResults(groups), Groups: []apiResultGroup{}}
+	for _, g := range groups {
+		group := apiResultGroup{Label: g.Label}
+		for _, e := range g.Entries {
+			group.Results = append(group.Results, newAPISearchResult("", e, meta))
+		}
+		resp.Groups = append(resp.Groups, group)
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func handleAPISearch(w http.ResponseWriter, r *http.Request, index []IndexEntry, views *viewTracker, speller *spellModel, names *titleNames, fullText *fullTextSearch, meta *metaStore) {
+	query := r.FormValue("q")
+	if query == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing q parameter"})
+		return
+	}
+
+	paged, after, limit, err := searchPaging(r, query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	groups := searchIndex(index, query, meta, views, names)
+	count := countResults(groups)
+	var next *searchCursor
+	if paged {
+		groups, next = pageResults(groups, after, limit, query)
+	}
+	resp := newAPISearchResponse(query, groups, meta)
+	resp.Count = count
+	if next != nil {
+		resp.Next = next.encode()
+		w.Header().Set("Link", "<"+nextPageURL(r, resp.Next)+`>; rel="next"`)
+	}
+
+	// Full-text hits come with the first page only
+	var hits []FullTextResult
+	if after == nil {
+		if hits, err = fullText.search(query, fullTextLimit, meta, views); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	for _, hit := range hits {
+		result := newAPISearchResult("", hit.Entry, meta)
+		result.Snippet = string(hit.Snippet)
+		result.Score = hit.Score
+		resp.FullText = append(resp.FullText, result)
+	}
+	if resp.Count == 0 && len(resp.FullText) == 0 && after == nil {
+		resp.Suggestions = speller.suggest(query)
+	}
+
+	switch negotiateFormat(r) {
+	case "csv":
+		writeSearchCSV(w, resp)
+	case "ndjson":
+		writeSearchNDJSON(w, resp)
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// negotiateFormat picks the response format from ?format= or, failing
+// that, the Accept header. JSON is the default.
+func negotiateFormat(r *http.Request) string {
+	switch strings.ToLower(r.FormValue("format")) {
+	case "csv":
+		return "csv"
+	case "ndjson", "jsonl":
+		return "ndjson"
+	case "json":
+		return "json"
+	}
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "text/csv"):
+		return "csv"
+	case strings.Contains(accept, "application/x-ndjson"), strings.Contains(accept, "application/jsonl"):
+		return "ndjson"
+	}
+	return "json"
+}
+
+// flatResults lists every result as one row, labelled with its match group.
+func (resp apiSearchResponse) flatResults() []apiSearchResult {
+	var rows []apiSearchResult
+	for _, g := range resp.Groups {
+		for _, result := range g.Results {
+			result.Match = g.Label
+			rows = append(rows, result)
+		}
+	}
+	for _, result := range resp.FullText {
+		result.Match = "Full text"
+		rows = append(rows, result)
+	}
+	return rows
+}
+
+func writeSearchCSV(w http.ResponseWriter, resp apiSearchResponse) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"match", "title", "page_id", "start_offset", "end_offset", "score", "size_bytes", "stub", "redirect", "snippet"})
+	for _, row := range resp.flatResults() {
+		cw.Write([]string{
+			row.Match,
+			row.Title,
+			strconv.Itoa(row.PageID),
+			strconv.FormatInt(row.StartOffset, 10),
+			strconv.FormatInt(row.EndOffset, 10),
+			strconv.Itoa(row.Score),
+			strconv.Itoa(row.SizeBytes),
+			strconv.FormatBool(row.Stub),
+			strconv.FormatBool(row.Redirect),
+			row.Snippet,
+		})
+	}
+	cw.Flush()
+}
+
+func writeSearchNDJSON(w http.ResponseWriter, resp apiSearchResponse) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, row := range resp.flatResults() {
+		enc.Encode(row)
+	}
+}
+
+// streamSet returns the known stream offset pairs of an index, so the
+// stream API only serves ranges that are real stream boundaries.
+func streamSet(index []IndexEntry) map[OffsetPair]bool {
+	set := make(map[OffsetPair]bool)
+	for _, e := range index {
+		set[*e.Offsets] = true
+	}
+	return set
+}
+
+// handleAPIStream serves the decompressed XML of one stream, addressed as
+// /api/stream/{start}-{end}. Range and conditional requests are supported.
+func handleAPIStream(w http.ResponseWriter, r *http.Request, inputFile string, caches *streamCaches, streams map[OffsetPair]bool) {
+	spec := strings.TrimPrefix(r.URL.Path, "/api/stream/")
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	start, err1 := strconv.ParseInt(startStr, 10, 64)
+	end, err2 := strconv.ParseInt(endStr, 10, 64)
+	if !ok || err1 != nil || err2 != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expected /api/stream/{start}-{end}"})
+		return
+	}
+	if !streams[OffsetPair{Start: start, End: end}] {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no stream with those offsets"})
+		return
+	}
+
+	info, err := os.Stat(inputFile)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	data, err := caches.extract(inputFile, &OffsetPair{Start: start, End: end})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+// maxSummaryIDs caps the number of pages in one /api/summaries request.
+const maxSummaryIDs = 100
+
+type apiSummary struct {
+	Description string `json:"description,omitempty"`
+	Thumbnail   string `json:"thumbnail,omitempty"`
+	SizeBytes   int    `json:"size_bytes,omitempty"`
+	Stub        bool   `json:"stub,omitempty"`
+	Redirect    bool   `json:"redirect,omitempty"`
+	RedirectTo  string `json:"redirect_to,omitempty"`
+	Disambig    bool   `json:"disambiguation,omitempty"`
+	Quality     string `json:"quality,omitempty"`
+}
+
+// handleAPISummaries returns the description and lead image thumbnail of
+// each page in ?ids=1,2,3, for pages with known metadata. Search results
+// fetch these after the page has loaded.
+func handleAPISummaries(w http.ResponseWriter, r *http.Request, meta *metaStore) {
+	ids := strings.Split(r.FormValue("ids"), ",")
+	if len(ids) > maxSummaryIDs {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("at most %d ids per request", maxSummaryIDs)})
+		return
+	}
+	pages := make(map[string]apiSummary)
+	for _, id := range ids {
+		pageID, err := strconv.Atoi(strings.TrimSpace(id))
+		if err != nil {
+			continue
+		}
+		if m, ok := meta.Get(pageID); ok {
+			pages[strconv.Itoa(pageID)] = apiSummary{
+				Description: m.Description,
+				Thumbnail:   thumbnailURL(requestServer(r).cfg.Media, m.Image, 80),
+				SizeBytes:   m.Bytes,
+				Stub:        m.Stub,
+				Redirect:    m.Redirect,
+				RedirectTo:  m.RedirectTarget,
+				Disambig:    m.Disambig,
+				Quality:     m.Quality,
+			}
+		}
+	}
+	w.Header().Set("Cache-Control", "max-age=300")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"pages": pages})
+}
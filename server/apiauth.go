@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API token scopes. Full access includes everything search access allows.
+const (
+	scopeSearch = "search"
+	scopeFull   = "full"
+)
+
+const defaultTokenRate = 60
+
+// apiToken is one entry of the -api-tokens file
+type apiToken struct {
+	Scope         string
+	RatePerMinute int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow takes one request from the token's bucket, returning how long to
+// wait when the bucket is empty.
+func (t *apiToken) allow(now time.Time) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	perSecond := float64(t.RatePerMinute) / 60
+	if t.last.IsZero() {
+		t.tokens = float64(t.RatePerMinute)
+	} else {
+		t.tokens = math.Min(float64(t.RatePerMinute), t.tokens+now.Sub(t.last).Seconds()*perSecond)
+	}
+	t.last = now
+	if t.tokens < 1 {
+		return false, time.Duration((1 - t.tokens) / perSecond * float64(time.Second))
+	}
+	t.tokens--
+	return true, 0
+}
+
+// apiTokens maps secret tokens to their settings. A nil map means the API
+// is open.
+type apiTokens map[string]*apiToken
+
+// loadAPITokens reads a token file with one "token scope [requests/minute]"
+// entry per line. Blank lines and # comments are ignored.
+func loadAPITokens(filename string) (apiTokens, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening token file: %v", err)
+	}
+	defer f.Close()
+
+	tokens := make(apiTokens)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected \"token scope [rate]\"", lineNo)
+		}
+		t := &apiToken{Scope: fields[1], RatePerMinute: defaultTokenRate}
+		if t.Scope != scopeSearch && t.Scope != scopeFull {
+			return nil, fmt.Errorf("line %d: unknown scope %q (want %s or %s)", lineNo, t.Scope, scopeSearch, scopeFull)
+		}
+		if len(fields) > 2 {
+			rate, err := strconv.Atoi(fields[2])
+			if err != nil || rate <= 0 {
+				return nil, fmt.Errorf("line %d: invalid rate %q", lineNo, fields[2])
+			}
+			t.RatePerMinute = rate
+		}
+		tokens[fields[0]] = t
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading token file: %v", err)
+	}
+	return tokens, nil
+}
+
+// requestToken returns the bearer token or ?token= value of a request.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return r.URL.Query().Get("token")
+}
+
+// require wraps an API handler so it needs a token with the given scope.
+// When no tokens are configured the handler is served unchanged.
+func (tokens apiTokens) require(scope string, h http.HandlerFunc) http.HandlerFunc {
+	if tokens == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := tokens[requestToken(r)]
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wikiseek"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid API token"})
+			return
+		}
+		if scope == scopeFull && t.Scope != scopeFull {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "token does not have full scope"})
+			return
+		}
+		if ok, wait := t.allow(time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// admin wraps an admin or debug handler. With tokens it needs a full scope
+// token. Without, these routes, which start scans and show the dump's
+// internals, only answer requests made straight from the server's own
+// machine.
+func (tokens apiTokens) admin(h http.HandlerFunc) http.HandlerFunc {
+	if tokens != nil {
+		return tokens.require(scopeFull, h)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !localRequest(r) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "only served to this machine unless -api-tokens is set"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// localRequest reports whether a request came from a loopback address
+// without passing through a proxy, which would make every client look
+// local.
+func localRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !addr.Unmap().IsLoopback() {
+		return false
+	}
+	for _, header := range []string{"Forwarded", "X-Forwarded-For", "X-Real-Ip"} {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAdminRoutesWithoutTokens checks that without -api-tokens admin
+// routes only answer direct requests from this machine.
+func TestAdminRoutesWithoutTokens(t *testing.T) {
+	var tokens apiTokens
+	h := tokens.admin(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		remote string
+		header string
+		want   int
+	}{
+		{"127.0.0.1:5000", "", http.StatusOK},
+		{"[::1]:5000", "", http.StatusOK},
+		{"[::ffff:127.0.0.1]:5000", "", http.StatusOK},
+		{"192.168.1.20:5000", "", http.StatusForbidden},
+		{"10.0.0.1:5000", "", http.StatusForbidden},
+		{"127.0.0.1:5000", "X-Forwarded-For", http.StatusForbidden},
+		{"127.0.0.1:5000", "Forwarded", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/admin", nil)
+		r.RemoteAddr = tt.remote
+		if tt.header != "" {
+			r.Header.Set(tt.header, "for=203.0.113.5")
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s with %q: got %d, want %d", tt.remote, tt.header, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchStep is the timing of one step of the render across the sample.
+type benchStep struct {
+	Name  string  `json:"name"`
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+	Mean  float64 `json:"mean_ms"`
+	Share float64 `json:"share"` // of the total time spent rendering
+
+	times []time.Duration
+}
+
+// benchReport is the result of "wikiseek bench".
+type benchReport struct {
+	Pages      int         `json:"pages"`
+	Redirects  int         `json:"redirects_skipped"`
+	Failures   int         `json:"failures"`
+	Workers    int         `json:"workers"`
+	Renderer   string      `json:"renderer"`
+	Stages     string      `json:"stages"`
+	Seed       int64       `json:"seed"`
+	Seconds    float64     `json:"seconds"`
+	PagesPerS  float64     `json:"pages_per_second"`
+	InputBytes int64       `json:"wikitext_bytes"`
+	Steps      []benchStep `json:"steps"`
+}
+
+// runBench implements "wikiseek bench": it renders a random sample of
+// articles through the render pipeline and reports latency percentiles for
+// the whole render and for each stage, so hardware and configuration
+// changes can be compared. The sample is drawn from -seed, so runs with
+// the same seed render the same articles.
+func runBench(args []string) {
+	fs := newCommandFlags("bench")
+	inputFile, indexPath := indexFlags(fs)
+	n := fs.Int("n", 500, "Number of articles to render")
+	seed := fs.Int64("seed", 1, "Seed for choosing the sample; the same seed picks the same articles")
+	workers := fs.Int("workers", 1, "Number of articles rendered in parallel (1 measures latency, more measures throughput)")
+	rendererName := fs.String("renderer", "native", "Renderer: pandoc, native, or auto to use pandoc when installed")
+	stages := fs.String("stages", defaultStages, "Render pipeline stages")
+	render := renderFlags(fs)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+	requireIndexFlags(fs, *inputFile, *indexPath)
+	if *n < 1 || *workers < 1 {
+		fmt.Println("Error: -n and -workers must be at least 1")
+		os.Exit(1)
+	}
+
+	loaded := loadCommandIndex(*inputFile, *indexPath)
+	reader, err := newArticleReader(loaded, *inputFile, "html", *rendererName, *stages, render)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	report := benchPipeline(reader.pipeline, loaded.Entries, *n, *workers, *seed)
+	report.Renderer, report.Stages = reader.pipeline.renderer.Name(), *stages
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	printBenchReport(report)
+}
+
+// benchPipeline renders articles drawn at random from index until n have
+// rendered. Redirects stop early in the pipeline and would skew the
+// timings, so they are skipped and another article drawn.
+func benchPipeline(pipeline *Pipeline, index []IndexEntry, n, workers int, seed int64) *benchReport {
+	rng := rand.New(rand.NewSource(seed))
+	order := rng.Perm(len(index))
+	report := &benchReport{Workers: workers, Seed: seed}
+	steps := make(map[string]*benchStep)
+	var names []string // in the order first seen, which is pipeline order
+
+	var mu sync.Mutex
+	next := 0
+	// take hands out the next article while fewer than n have rendered
+	take := func() (*IndexEntry, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if report.Pages >= n || next >= len(order) {
+			return nil, false
+		}
+		next++
+		return &index[order[next-1]], true
+	}
+
+	runtime.GC()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				entry, ok := take()
+				if !ok {
+					return
+				}
+				tr := &trace{spans: []*span{{Name: "total", Start: time.Now()}}}
+				ctx, err := pipeline.Run(entry, nil, tr)
+				tr.spans[0].End = time.Now()
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					report.Failures++
+					fmt.Fprintf(os.Stderr, "Warning: rendering %q: %v\n", entry.Title, err)
+				case ctx.Redirect != "":
+					report.Redirects++
+				case report.Pages < n:
+					report.Pages++
+					report.InputBytes += int64(len(ctx.WikiText))
+					for _, s := range tr.spans {
+						step, ok := steps[s.Name]
+						if !ok {
+							step = &benchStep{Name: s.Name}
+							steps[s.Name] = step
+							names = append(names, s.Name)
+						}
+						step.times = append(step.times, s.End.Sub(s.Start))
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	report.Seconds = elapsed.Seconds()
+	if report.Seconds > 0 {
+		report.PagesPerS = float64(report.Pages) / report.Seconds
+	}
+
+	var total time.Duration
+	if s, ok := steps["total"]; ok {
+		for _, d := range s.times {
+			total += d
+		}
+	}
+	for _, name := range names {
+		step := steps[name]
+		sort.Slice(step.times, func(i, j int) bool { return step.times[i] < step.times[j] })
+		var sum time.Duration
+		for _, d := range step.times {
+			sum += d
+		}
+		step.Count = len(step.times)
+		step.P50 = durationMs(percentile(step.times, 0.50))
+		step.P95 = durationMs(percentile(step.times, 0.95))
+		step.P99 = durationMs(percentile(step.times, 0.99))
+		step.Mean = durationMs(sum / time.Duration(len(step.times)))
+		if total > 0 {
+			step.Share = float64(sum) / float64(total)
+		}
+		report.Steps = append(report.Steps, *step)
+	}
+	return report
+}
+
+// percentile returns the p-th quantile of sorted durations, by the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func printBenchReport(r *benchReport) {
+	fmt.Printf("Rendered %d articles (%s of wikitext) in %.2fs: %.1f articles/s with %d worker", r.Pages, formatBytes(r.InputBytes), r.Seconds, r.PagesPerS, r.Workers)
+	if r.Workers != 1 {
+		fmt.Print("s")
+	}
+	fmt.Println()
+	fmt.Printf("Renderer %s, seed %d", r.Renderer, r.Seed)
+	if r.Redirects > 0 {
+		fmt.Printf(", %d redirects skipped", r.Redirects)
+	}
+	if r.Failures > 0 {
+		fmt.Printf(", %d failed", r.Failures)
+	}
+	fmt.Println()
+	fmt.Println()
+	fmt.Printf("%-18s %10s %10s %10s %10s %7s\n", "step", "p50 ms", "p95 ms", "p99 ms", "mean ms", "share")
+	for _, s := range r.Steps {
+		// Stages are named stage:NAME in traces; the steps inside a stage,
+		// such as decompress, are indented under it
+		name, isStage := strings.CutPrefix(s.Name, "stage:")
+		if !isStage && s.Name != "total" {
+			name = "  " + name
+		}
+		fmt.Printf("%-18s %10.2f %10.2f %10.2f %10.2f %6.1f%%\n", name, s.P50, s.P95, s.P99, s.Mean, 100*s.Share)
+	}
+}
@@ -0,0 +1,485 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bookChapter is one rendered article of an exported collection.
+type bookChapter struct {
+	Title string
+	File  string // file name within the book, without extension
+	Body  string // rendered HTML with links between chapters rewritten
+
+	aliases []string // titles in the collection that redirect here
+}
+
+// handleCollectionExport sends a collection as an EPUB book or a zip of
+// HTML pages. Every article is rendered before anything is written, so a
+// failure is reported rather than leaving a truncated download.
+func handleCollectionExport(w http.ResponseWriter, r *http.Request, col Collection, format string, wiki *wikiSource) {
+	if len(col.Titles) == 0 {
+		http.Error(w, "the collection is empty", http.StatusBadRequest)
+		return
+	}
+	s := requestServer(r)
+	start := time.Now()
+	chapters, err := collectionChapters(col, wiki, s.cfg.BasePath, s.log)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	ext, contentType := ".zip", "application/zip"
+	if format == "epub" {
+		ext, contentType = ".epub", "application/epub+zip"
+		err = writeEPUB(&buf, col, chapters, wiki.Info)
+	} else {
+		err = writeHTMLBundle(&buf, col, chapters, wiki.Info)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": bookFileName(col.Name) + ext}))
+	w.Write(buf.Bytes())
+	fmt.Fprintf(s.log, "[%s] Exported collection %q as %s: %d articles, %s in %v\n", time.Now().Format("2006-01-02 15:04:05"),
+		col.Name, strings.TrimPrefix(ext, "."), len(chapters), formatBytes(int64(buf.Len())), time.Since(start).Round(time.Millisecond))
+}
+
+// collectionChapters renders the articles of a collection, following
+// redirects, for a server mounted at basePath. Articles no longer in the
+// index are left out, with a warning to log.
+func collectionChapters(col Collection, wiki *wikiSource, basePath string, log io.Writer) ([]bookChapter, error) {
+	var chapters []bookChapter
+	bodies := make(map[string]string)
+	aliases := make(map[string][]string)
+	for _, title := range col.Titles {
+		entry := findPageByTitle(wiki.Index, wiki.Titles, title)
+		if entry == nil {
+			fmt.Fprintf(log, "Warning: collection %q: no article titled %q\n", col.Name, title)
+			continue
+		}
+		page, err := renderEntry(wiki.Pipeline, entry, wiki.Cache, nil, nil)
+		if err == nil && page.Redirect != "" {
+			var target string
+			if target, _, err = followRedirects(wiki.Index, wiki.Titles, wiki.Pipeline, wiki.Cache, nil, nil, entry, page.Redirect); err == nil {
+				if entry = findPageByTitle(wiki.Index, wiki.Titles, target); entry == nil {
+					continue
+				}
+				page, err = renderEntry(wiki.Pipeline, entry, wiki.Cache, nil, nil)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rendering %q: %v", title, err)
+		}
+		if entry.Title != title {
+			aliases[entry.Title] = append(aliases[entry.Title], title)
+		}
+		if _, dup := bodies[entry.Title]; dup {
+			continue
+		}
+		bodies[entry.Title] = page.Content
+		chapters = append(chapters, bookChapter{
+			Title: entry.Title,
+			File:  fmt.Sprintf("%03d-%s", len(chapters)+1, bookFileName(entry.Title)),
+		})
+	}
+	if len(chapters) == 0 {
+		return nil, fmt.Errorf("none of the articles in %q could be found", col.Name)
+	}
+	for i := range chapters {
+		chapters[i].aliases = aliases[chapters[i].Title]
+	}
+	for i := range chapters {
+		chapters[i].Body = bookLinks(bodies[chapters[i].Title], chapters, basePath)
+	}
+	return chapters, nil
+}
+
+// bookFileName turns a title into a file name that is safe on every
+// system, keeping letters and digits and replacing the rest with
+// underscores.
+func bookFileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
+			return r
+		}
+		return '_'
+	}, title)
+	for len(name) > 60 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+var bookLinkRe = regexp.MustCompile(`(?s)<a\s[^>]*?href="([^"]*)"[^>]*>(.*?)</a>`)
+
+// bookLinks points links to articles in the book at their chapters, and
+// turns links to articles outside it into plain text, since the book is
+// read away from the server. Links within the page and to other sites are
+// kept. Article links start with basePath.
+func bookLinks(body string, chapters []bookChapter, basePath string) string {
+	files := make(map[string]string, len(chapters))
+	for _, c := range chapters {
+		files[c.Title] = c.File
+		for _, alias := range c.aliases {
+			files[alias] = c.File
+		}
+	}
+	return bookLinkRe.ReplaceAllStringFunc(body, func(link string) string {
+		m := bookLinkRe.FindStringSubmatch(link)
+		href := html.UnescapeString(m[1])
+		if strings.HasPrefix(href, "#") || strings.Contains(href, "//") || strings.HasPrefix(href, "mailto:") {
+			return link
+		}
+		href = strings.TrimPrefix(href, basePath)
+		href = strings.TrimPrefix(href, "/wiki/")
+		path, fragment, _ := strings.Cut(href, "#")
+		title, err := url.PathUnescape(path)
+		if err != nil {
+			return m[2]
+		}
+		file, ok := files[ucfirst(strings.ReplaceAll(title, "_", " "))]
+		if !ok {
+			return m[2]
+		}
+		target := file + bookChapterExt
+		if fragment != "" {
+			target += "#" + fragment
+		}
+		return `<a href="` + html.EscapeString(target) + `">` + m[2] + `</a>`
+	})
+}
+
+// bookChapterExt is the extension of chapter files. EPUB readers go by the
+// manifest rather than the name, so the HTML bundle and the EPUB share it
+// and links between chapters work in both.
+const bookChapterExt = ".html"
+
+// bookCSS styles exported pages, which can't use the server's stylesheet.
+const bookCSS = `body { font-family: Georgia, serif; line-height: 1.5; margin: 0 auto; max-width: 42em; padding: 0 1em; }
+h1, h2, h3, h4 { font-family: sans-serif; line-height: 1.2; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: start; vertical-align: top; }
+table.infobox { float: right; margin: 0 0 1em 1em; max-width: 40%; font-size: 0.9em; }
+figure { margin: 1em 0; }
+img { max-width: 100%; height: auto; }
+figcaption { font-size: 0.9em; color: #555; }
+.citation-popup { font-size: 0.8em; }
+.citation-body { color: #555; }
+.citation-body:before { content: " ("; }
+.citation-body:after { content: ")"; }
+.visually-hidden { position: absolute; width: 1px; height: 1px; overflow: hidden; clip: rect(0 0 0 0); }
+.book-nav { font-family: sans-serif; font-size: 0.9em; margin: 1em 0; }
+.book-source { color: #555; font-size: 0.9em; }
+`
+
+// bookSource credits the dump the articles came from.
+func bookSource(info *DumpInfo) string {
+	var b strings.Builder
+	b.WriteString("Articles from ")
+	site := "the wiki"
+	if info != nil {
+		site = firstNonEmpty(info.SiteName, info.DBName, site)
+	}
+	if info != nil && info.Base != "" {
+		b.WriteString(`<a href="` + html.EscapeString(info.Base) + `">` + html.EscapeString(site) + `</a>`)
+	} else {
+		b.WriteString(html.EscapeString(site))
+	}
+	if info != nil && info.DumpDate != "" {
+		b.WriteString(", dump of " + html.EscapeString(info.DumpDate))
+	}
+	b.WriteString(", exported with WikiSeek. Text is available under the license of the wiki it came from.")
+	return b.String()
+}
+
+// bookLang returns the lang and dir attributes for a book's pages.
+func bookLang(info *DumpInfo) (string, string) {
+	if info == nil || info.Lang == "" {
+		return "en", "ltr"
+	}
+	return info.Lang, info.Dir
+}
+
+// writeHTMLBundle writes a collection as a zip of static pages: index.html
+// with the table of contents, one page per article and a stylesheet, in a
+// folder named after the collection.
+func writeHTMLBundle(w io.Writer, col Collection, chapters []bookChapter, info *DumpInfo) error {
+	z := zip.NewWriter(w)
+	dir := bookFileName(col.Name) + "/"
+	lang, textDir := bookLang(info)
+	page := func(title, body string) string {
+		return `<!DOCTYPE html>
+<html lang="` + html.EscapeString(lang) + `" dir="` + textDir + `">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>` + html.EscapeString(title) + `</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+` + body + `
+</body>
+</html>
+`
+	}
+
+	var toc strings.Builder
+	toc.WriteString("<h1>" + html.EscapeString(col.Name) + "</h1>\n<nav><h2>Contents</h2>\n<ol>\n")
+	for _, c := range chapters {
+		toc.WriteString(`<li><a href="` + html.EscapeString(c.File+bookChapterExt) + `">` + html.EscapeString(c.Title) + "</a></li>\n")
+	}
+	toc.WriteString("</ol>\n</nav>\n<p class=\"book-source\">" + bookSource(info) + "</p>")
+	files := []struct{ name, content string }{
+		{"index.html", page(col.Name, toc.String())},
+		{"style.css", bookCSS},
+	}
+	for i, c := range chapters {
+		nav := `<nav class="book-nav"><a href="index.html">Contents</a>`
+		if i > 0 {
+			nav += ` · <a href="` + html.EscapeString(chapters[i-1].File+bookChapterExt) + `" rel="prev">← ` + html.EscapeString(chapters[i-1].Title) + `</a>`
+		}
+		if i+1 < len(chapters) {
+			nav += ` · <a href="` + html.EscapeString(chapters[i+1].File+bookChapterExt) + `" rel="next">` + html.EscapeString(chapters[i+1].Title) + ` →</a>`
+		}
+		nav += "</nav>"
+		files = append(files, struct{ name, content string }{c.File + bookChapterExt,
+			page(c.Title+" - "+col.Name, nav+"\n<h1>"+html.EscapeString(c.Title)+"</h1>\n"+c.Body+"\n"+nav)})
+	}
+	for _, f := range files {
+		out, err := z.CreateHeader(&zip.FileHeader{Name: dir + f.name, Method: zip.Deflate, Modified: col.Updated})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(out, f.content); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// writeEPUB writes a collection as an EPUB 3 book, with an EPUB 2 table of
+// contents as well for older readers. Images aren't part of the dump and
+// books may not load them from the network, so they are left out; their
+// captions stay.
+func writeEPUB(w io.Writer, col Collection, chapters []bookChapter, info *DumpInfo) error {
+	z := zip.NewWriter(w)
+	// The mimetype file must come first and be stored uncompressed
+	out, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store, Modified: col.Updated})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(out, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	lang, textDir := bookLang(info)
+	// A name-based UUID, so a collection keeps its identifier across
+	// exports and readers replace the old copy
+	h := fmt.Sprintf("%x", sha1.Sum([]byte("wikiseek collection\x00"+col.Name)))
+	id := "urn:uuid:" + h[0:8] + "-" + h[8:12] + "-5" + h[13:16] + "-" + h[16:20] + "-" + h[20:32]
+	modified := col.Updated.UTC().Format("2006-01-02T15:04:05Z")
+	x := xmlEscaper.Replace
+
+	xhtml := func(title, body string) string {
+		return `<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="` + x(lang) + `" xml:lang="` + x(lang) + `" dir="` + textDir + `">
+<head>
+<title>` + x(title) + `</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+` + body + `
+</body>
+</html>
+`
+	}
+
+	var manifest, spine, navList, ncxPoints strings.Builder
+	type file struct{ name, content string }
+	var files []file
+	for i, c := range chapters {
+		body := toXHTML(bookImageRe.ReplaceAllString(c.Body, ""))
+		var props []string
+		if strings.Contains(body, "<svg") {
+			props = append(props, "svg")
+		}
+		if strings.Contains(body, "<math") {
+			props = append(props, "mathml")
+		}
+		properties := ""
+		if len(props) > 0 {
+			properties = ` properties="` + strings.Join(props, " ") + `"`
+		}
+		itemID := fmt.Sprintf("c%03d", i+1)
+		fmt.Fprintf(&manifest, `    <item id="%s" href="%s" media-type="application/xhtml+xml"%s/>`+"\n", itemID, x(c.File+bookChapterExt), properties)
+		fmt.Fprintf(&spine, `    <itemref idref="%s"/>`+"\n", itemID)
+		fmt.Fprintf(&navList, `<li><a href="%s">%s</a></li>`+"\n", x(c.File+bookChapterExt), x(c.Title))
+		fmt.Fprintf(&ncxPoints, `<navPoint id="%s" playOrder="%d"><navLabel><text>%s</text></navLabel><content src="%s"/></navPoint>`+"\n",
+			itemID, i+1, x(c.Title), x(c.File+bookChapterExt))
+		files = append(files, file{"OEBPS/" + c.File + bookChapterExt, xhtml(c.Title, "<h1>"+x(c.Title)+"</h1>\n"+body)})
+	}
+
+	files = append([]file{
+		{"META-INF/container.xml", `<?xml version="1.0" encoding="utf-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`},
+		{"OEBPS/content.opf", `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="` + x(lang) + `">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">` + id + `</dc:identifier>
+    <dc:title>` + x(col.Name) + `</dc:title>
+    <dc:language>` + x(lang) + `</dc:language>
+    <dc:publisher>WikiSeek</dc:publisher>
+    <meta property="dcterms:modified">` + modified + `</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.html" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="css" href="style.css" media-type="text/css"/>
+` + manifest.String() + `  </manifest>
+  <spine toc="ncx" page-progression-direction="` + textDir + `">
+    <itemref idref="nav"/>
+` + spine.String() + `  </spine>
+</package>
+`},
+		{"OEBPS/nav.html", xhtml(col.Name, `<h1>`+x(col.Name)+`</h1>
+<nav epub:type="toc" id="toc">
+<h2>Contents</h2>
+<ol>
+`+navList.String()+`</ol>
+</nav>
+<p class="book-source">`+toXHTML(bookSource(info))+`</p>`)},
+		{"OEBPS/toc.ncx", `<?xml version="1.0" encoding="utf-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<head><meta name="dtb:uid" content="` + id + `"/></head>
+<docTitle><text>` + x(col.Name) + `</text></docTitle>
+<navMap>
+` + ncxPoints.String() + `</navMap>
+</ncx>
+`},
+		{"OEBPS/style.css", bookCSS},
+	}, files...)
+
+	for _, f := range files {
+		out, err := z.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: col.Updated})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(out, f.content); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+var bookImageRe = regexp.MustCompile(`<img[^>]*>`)
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+var (
+	xhtmlTokenRe   = regexp.MustCompile(`(?s)<!--.*?-->|<![^>]*>|<(/?)([a-zA-Z][a-zA-Z0-9:-]*)((?:\s+[^\s/>"'=]+(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*)\s*(/?)>`)
+	xhtmlVoidTags  = tagSet("area base br col embed hr img input link meta source track wbr")
+	xhtmlInvalidRe = regexp.MustCompile("[\x00-\x08\x0b\x0c\x0e-\x1f]")
+	xhtmlNamespace = map[string]string{"svg": "http://www.w3.org/2000/svg", "math": "http://www.w3.org/1998/Math/MathML"}
+)
+
+// toXHTML rewrites rendered HTML as well-formed XHTML, as EPUB requires:
+// entities become characters, void elements are closed, attributes are
+// quoted, and elements left open or closed out of order are balanced.
+// Comments and doctype-like declarations are dropped.
+func toXHTML(body string) string {
+	var b strings.Builder
+	var open []string
+	inForeign := 0 // depth inside svg or math, where names keep their case
+	text := func(s string) {
+		b.WriteString(xmlEscaper.Replace(xhtmlInvalidRe.ReplaceAllString(html.UnescapeString(s), "")))
+	}
+
+	last := 0
+	for _, m := range xhtmlTokenRe.FindAllStringSubmatchIndex(body, -1) {
+		text(body[last:m[0]])
+		last = m[1]
+		if m[4] < 0 {
+			continue // comment or declaration
+		}
+		closing, name, attrs, selfClosing := body[m[2]:m[3]] == "/", body[m[4]:m[5]], body[m[6]:m[7]], m[8] < m[9]
+		if inForeign == 0 {
+			name = strings.ToLower(name)
+		}
+		if closing {
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != name {
+					continue
+				}
+				for len(open) > i {
+					top := open[len(open)-1]
+					if xhtmlNamespace[top] != "" {
+						inForeign--
+					}
+					b.WriteString("</" + top + ">")
+					open = open[:len(open)-1]
+				}
+				break
+			}
+			continue
+		}
+
+		b.WriteString("<" + name)
+		seen := make(map[string]bool)
+		for _, a := range htmlAttrRe.FindAllStringSubmatch(attrs, -1) {
+			attr := a[1]
+			if inForeign == 0 && xhtmlNamespace[name] == "" {
+				attr = strings.ToLower(attr)
+			}
+			if seen[attr] {
+				continue
+			}
+			seen[attr] = true
+			value := a[2] + a[3] + a[4]
+			if a[2] == "" && a[3] == "" && a[4] == "" && !strings.Contains(a[0], "=") {
+				value = attr // boolean attribute
+			}
+			b.WriteString(" " + attr + `="` + xmlEscaper.Replace(html.UnescapeString(value)) + `"`)
+		}
+		if ns := xhtmlNamespace[name]; ns != "" && !seen["xmlns"] {
+			b.WriteString(` xmlns="` + ns + `"`)
+		}
+		if xhtmlVoidTags[name] && inForeign == 0 || selfClosing {
+			b.WriteString("/>")
+			continue
+		}
+		b.WriteString(">")
+		if xhtmlNamespace[name] != "" {
+			inForeign++
+		}
+		open = append(open, name)
+	}
+	text(body[last:])
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String()
+}
@@ -0,0 +1,444 @@
+package server
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The caches built next to an index (the gob cache with page metadata, the
+// binary index, the template sidecar and the full-text index) and the
+// stream cache are slow to build on a small machine. "wikiseek cache
+// export" packs them into a tar archive on a machine that has built them,
+// and "wikiseek cache import" unpacks them beside the same dump and index
+// elsewhere, so a fleet of readers skips the work.
+//
+// The caches are keyed to fingerprints of the index and dump that include
+// their modification times, which copying a file usually changes. The
+// archive records the sizes, times and leading bytes of both files; import
+// checks that the local copies hold the same data and, since the caches
+// are only recognized beside files with the recorded times, refuses local
+// copies with other times unless -set-times allows it to change them.
+
+// cacheManifestName is the first entry of an export archive.
+const cacheManifestName = "wikiseek-cache.json"
+
+// cacheArchiveVersion is the layout version of export archives.
+const cacheArchiveVersion = 1
+
+// indexCacheSuffixes are the caches kept beside an index file, named by
+// the suffix added to its name. Directories are archived whole.
+var indexCacheSuffixes = []string{".cache", ".bin", ".templates", ".fulltext"}
+
+type cacheManifest struct {
+	Version int         `json:"version"`
+	Created time.Time   `json:"created"`
+	Index   cacheSource `json:"index"`
+	Dump    cacheSource `json:"dump"`
+	Files   []string    `json:"files"`
+}
+
+// cacheSource describes the index or dump file the caches were built for.
+type cacheSource struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Head    string    `json:"head"` // SHA-256 of the first megabyte
+}
+
+func describeCacheSource(filename string) (cacheSource, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return cacheSource{}, fmt.Errorf("opening file: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return cacheSource{}, fmt.Errorf("reading file info: %v", err)
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, 1<<20); err != nil && err != io.EOF {
+		return cacheSource{}, fmt.Errorf("reading file: %v", err)
+	}
+	return cacheSource{Name: filepath.Base(filename), Size: info.Size(), ModTime: info.ModTime(), Head: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// sameData reports whether a local file holds the data the caches were
+// built from, whatever its name and modification time.
+func (s cacheSource) sameData(local cacheSource) bool {
+	return s.Size == local.Size && s.Head == local.Head
+}
+
+// cacheArchiveFile is one file to export: its name in the archive and on
+// disk.
+type cacheArchiveFile struct {
+	name, path string
+	info       fs.FileInfo
+}
+
+// exportableCaches lists the cache files present for an index and, when
+// streamDir is given, the streams cached there for the dump.
+func exportableCaches(inputFile, indexPath, streamDir string) ([]cacheArchiveFile, error) {
+	var files []cacheArchiveFile
+	for _, suffix := range indexCacheSuffixes {
+		root := indexPath + suffix
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(root, p)
+			name := "index" + suffix
+			if rel != "." {
+				name = path.Join(name, filepath.ToSlash(rel))
+			}
+			files = append(files, cacheArchiveFile{name: name, path: p, info: info})
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("listing %s: %v", root, err)
+		}
+	}
+	if streamDir == "" {
+		return files, nil
+	}
+	key, err := fileFingerprint(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(streamDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading stream cache directory: %v", err)
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), key+"-") || !strings.HasSuffix(e.Name(), ".xml") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheArchiveFile{name: "streams/" + e.Name(), path: filepath.Join(streamDir, e.Name()), info: info})
+	}
+	return files, nil
+}
+
+// writeCacheArchive writes the manifest and then every file to a tar
+// archive.
+func writeCacheArchive(w io.Writer, manifest cacheManifest, files []cacheArchiveFile) error {
+	tw := tar.NewWriter(w)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: cacheManifestName, Mode: 0644, Size: int64(len(data)), ModTime: manifest.Created}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := addArchiveFile(tw, file); err != nil {
+			return fmt.Errorf("adding %s: %v", file.path, err)
+		}
+	}
+	return tw.Close()
+}
+
+func addArchiveFile(tw *tar.Writer, file cacheArchiveFile) error {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: file.info.Size(), ModTime: file.info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// importTarget returns where an archived file goes, or "" for names this
+// build doesn't know, which are skipped.
+func importTarget(name, indexPath, streamDir string) string {
+	if strings.Contains(name, "..") || strings.HasPrefix(name, "/") {
+		return ""
+	}
+	if stream, ok := strings.CutPrefix(name, "streams/"); ok {
+		if streamDir == "" || strings.Contains(stream, "/") || !strings.HasSuffix(stream, ".xml") {
+			return ""
+		}
+		return filepath.Join(streamDir, stream)
+	}
+	for _, suffix := range indexCacheSuffixes {
+		if rest, ok := strings.CutPrefix(name, "index"+suffix); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+			return indexPath + suffix + filepath.FromSlash(rest)
+		}
+	}
+	return ""
+}
+
+// extractArchiveFile atomically writes one archived file, keeping its
+// modification time, which orders the stream cache.
+func extractArchiveFile(r io.Reader, target string, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Chtimes(target, modTime, modTime)
+}
+
+// matchSource checks that a local file holds the data an archive was built
+// for with the recorded modification time, which the fingerprints the
+// caches are keyed to include. With setTimes a differing time is changed
+// to the recorded one; otherwise the file is left alone and an error
+// returned.
+func matchSource(filename string, want cacheSource, setTimes bool) error {
+	local, err := describeCacheSource(filename)
+	if err != nil {
+		return err
+	}
+	if !want.sameData(local) {
+		return fmt.Errorf("%s is not the file the caches were built for (%s, %d bytes)", filename, want.Name, want.Size)
+	}
+	if local.ModTime.Equal(want.ModTime) {
+		return nil
+	}
+	if !setTimes {
+		return fmt.Errorf("%s was modified at %s, the caches were built for %s; copy it with its time kept (cp -p, rsync -t) or give -set-times to change it", filename, local.ModTime.Format(time.RFC3339Nano), want.ModTime.Format(time.RFC3339Nano))
+	}
+	if err := os.Chtimes(filename, want.ModTime, want.ModTime); err != nil {
+		return fmt.Errorf("setting the modification time of %s: %v (copy it with its time kept, e.g. cp -p or rsync -t)", filename, err)
+	}
+	fmt.Printf("Set the modification time of %s to %s, as on the exporting machine\n", filename, want.ModTime.Format(time.RFC3339))
+	return nil
+}
+
+func runCacheExport(args []string) {
+	fs := newCommandFlags("cache export")
+	inputFile := fs.String("file", "", "Path to multistream bzip2 file")
+	indexPath := fs.String("index", "", "Path to index file")
+	streamDir := fs.String("stream-cache-dir", "", "Also export the streams of this dump cached in this directory")
+	out := fs.String("out", "", "Archive to write (default: <index>.caches.tar)")
+	fs.Parse(args)
+	requireIndexFlags(fs, *inputFile, *indexPath)
+	if *out == "" {
+		*out = *indexPath + ".caches.tar"
+	}
+
+	// Loading writes the index caches if they are missing or stale
+	loadCommandIndex(*inputFile, *indexPath)
+	manifest := cacheManifest{Version: cacheArchiveVersion, Created: time.Now().UTC()}
+	var err error
+	if manifest.Index, err = describeCacheSource(*indexPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if manifest.Dump, err = describeCacheSource(*inputFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	files, err := exportableCaches(*inputFile, *indexPath, *streamDir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	var size int64
+	for _, f := range files {
+		manifest.Files = append(manifest.Files, f.name)
+		size += f.info.Size()
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Printf("Error creating archive: %v\n", err)
+		os.Exit(1)
+	}
+	err = writeCacheArchive(f, manifest, files)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*out)
+		fmt.Printf("Error writing archive: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Exported %d files (%.1f MB) to %s\n", len(files), float64(size)/(1<<20), *out)
+}
+
+func runCacheImport(args []string) {
+	fs := newCommandFlags("cache import")
+	inputFile := fs.String("file", "", "Path to multistream bzip2 file")
+	indexPath := fs.String("index", "", "Path to index file")
+	streamDir := fs.String("stream-cache-dir", "", "Directory to put exported streams in, as given to the server (skipped when empty)")
+	setTimes := fs.Bool("set-times", false, "Change the modification times of the dump and index to the exported ones when they differ")
+	fs.Parse(args)
+	requireIndexFlags(fs, *inputFile, *indexPath)
+	if fs.NArg() != 1 {
+		fmt.Println("Error: give the archive to import")
+		fs.Usage()
+		os.Exit(1)
+	}
+	archive := fs.Arg(0)
+
+	f, err := os.Open(archive)
+	if err != nil {
+		fmt.Printf("Error opening archive: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	header, err := tr.Next()
+	if err != nil || header.Name != cacheManifestName {
+		fmt.Printf("Error: %s is not a wikiseek cache archive\n", archive)
+		os.Exit(1)
+	}
+	var manifest cacheManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		fmt.Printf("Error reading archive manifest: %v\n", err)
+		os.Exit(1)
+	}
+	if manifest.Version != cacheArchiveVersion {
+		fmt.Printf("Error: archive version %d, this build reads %d\n", manifest.Version, cacheArchiveVersion)
+		os.Exit(1)
+	}
+	for _, check := range []struct {
+		file string
+		want cacheSource
+	}{{*indexPath, manifest.Index}, {*inputFile, manifest.Dump}} {
+		if err := matchSource(check.file, check.want, *setTimes); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	imported, skipped := 0, 0
+	var size int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("Error reading archive: %v\n", err)
+			os.Exit(1)
+		}
+		target := importTarget(header.Name, *indexPath, *streamDir)
+		if target == "" || header.Typeflag != tar.TypeReg {
+			skipped++
+			continue
+		}
+		if err := extractArchiveFile(tr, target, header.ModTime); err != nil {
+			fmt.Printf("Error writing %s: %v\n", target, err)
+			os.Exit(1)
+		}
+		imported++
+		size += header.Size
+	}
+	fmt.Printf("Imported %d files (%.1f MB) from %s", imported, float64(size)/(1<<20), archive)
+	if skipped > 0 {
+		fmt.Printf(", skipped %d", skipped)
+		if *streamDir == "" {
+			fmt.Print(" (give -stream-cache-dir to import cached streams)")
+		}
+	}
+	fmt.Println()
+}
+
+func runCacheWarm(args []string) {
+	fs := newCommandFlags("cache warm")
+	inputFile := fs.String("file", "", "Path to multistream bzip2 file")
+	indexPath := fs.String("index", "", "Path to index file")
+	metadata := fs.Bool("metadata", true, "Collect page metadata if the index cache has none")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of streams to decompress in parallel")
+	streamDir := fs.String("stream-cache-dir", "", "Fill this stream cache directory with the streams of -titles")
+	streamMB := fs.Int("stream-cache-mb", 1024, "Maximum size of the stream cache in megabytes, as given to the server")
+	titleList := fs.String("titles", "vital", "Articles whose streams to cache: vital, or a file of titles, one per line")
+	fs.Parse(args)
+	requireIndexFlags(fs, *inputFile, *indexPath)
+
+	start := time.Now()
+	loaded := loadCommandIndex(*inputFile, *indexPath)
+	fmt.Printf("Index caches ready: %s, %s\n", indexCacheFile(*indexPath), indexBinFile(*indexPath))
+
+	if *metadata && !hasTextMetadata(loaded.allMeta()) {
+		fmt.Print("Collecting page metadata")
+		pages, err := collectMetadata(context.Background(), *inputFile, loaded.Entries, nil, *workers, func(done, total int) {
+			if done > 0 && done%1000 == 0 {
+				fmt.Print(".")
+			}
+		}, os.Stdout)
+		if err != nil {
+			fmt.Printf("\nError collecting metadata: %v\n", err)
+			os.Exit(1)
+		}
+		keepImported(pages, loaded.pageMeta())
+		loaded.Meta = pages
+		if err := saveIndexCache(loaded, indexCacheFile(*indexPath), *indexPath, os.Stdout); err != nil {
+			fmt.Printf("\nError saving metadata: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nCollected metadata for %d pages\n", len(pages))
+	}
+
+	if *streamDir != "" {
+		titles, err := prerenderTitles(*titleList, nil)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		disk, err := newStreamCache(*streamDir, *inputFile, int64(*streamMB)<<20, os.Stdout)
+		if err != nil {
+			fmt.Printf("Error opening stream cache: %v\n", err)
+			os.Exit(1)
+		}
+		streams := &streamCaches{disk: disk}
+		known := loaded.titleSet()
+		cached, missing := 0, 0
+		for _, title := range titles {
+			entry := findPageByTitle(loaded.Entries, known, title)
+			if entry == nil {
+				missing++
+				continue
+			}
+			if _, err := streams.extract(*inputFile, entry.Offsets); err != nil {
+				fmt.Printf("Warning: %s: %v\n", title, err)
+				continue
+			}
+			cached++
+		}
+		fmt.Printf("Cached the streams of %d articles in %s (%d not found)\n", cached, *streamDir, missing)
+	}
+	fmt.Printf("Warmed caches in %v\n", time.Since(start).Round(time.Millisecond))
+}
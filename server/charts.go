@@ -0,0 +1,205 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	defaultTemplates.registerLocalized("historical populations", historicalPopulations)
+	defaultTemplates.Register("graph:chart", graphChart)
+}
+
+// historicalPopulations renders {{Historical populations}} year/population
+// pairs as a table with percentage change and a bar chart.
+func historicalPopulations(l *locale, args []string) string {
+	positional, named := templateArgs(args)
+	var labels []string
+	var values []float64
+	var b strings.Builder
+
+	b.WriteString(`<div class="data-chart">`)
+	if title := named["title"]; title != "" {
+		b.WriteString("<p><strong>" + escapeText(title) + "</strong></p>")
+	}
+	b.WriteString(`<table class="wikitable"><tr><th>Year</th><th>Pop.</th><th>±%</th></tr>`)
+	var prev float64
+	for i := 0; i+1 < len(positional); i += 2 {
+		year, pop := positional[i], positional[i+1]
+		value, err := parseNumber(pop)
+		change := ""
+		if err == nil && prev > 0 {
+			change = strings.Replace(fmt.Sprintf("%+.1f%%", (value-prev)/prev*100), ".", l.decimal, 1)
+		}
+		fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>", escapeText(year), escapeText(l.formatNumberText(pop)), change)
+		if err == nil {
+			labels = append(labels, year)
+			values = append(values, value)
+			prev = value
+		}
+	}
+	b.WriteString("</table>")
+	b.WriteString(svgBarChart(labels, values))
+	if source := named["source"]; source != "" {
+		b.WriteString(`<p class="chart-source">Source: ` + escapeText(source) + "</p>")
+	}
+	b.WriteString("</div>")
+	return "\n" + b.String() + "\n"
+}
+
+// graphChart renders {{Graph:Chart}} x/y series as a data table, with a bar
+// chart when there is a single series.
+func graphChart(args []string) string {
+	_, named := templateArgs(args)
+	xs := splitList(named["x"])
+	if len(xs) == 0 {
+		return ""
+	}
+
+	var seriesNames []string
+	var series [][]string
+	if y := named["y"]; y != "" {
+		seriesNames = append(seriesNames, firstNonEmpty(named["ytitle"], named["yaxistitle"], "Value"))
+		series = append(series, splitList(y))
+	}
+	for i := 1; ; i++ {
+		y, ok := named[fmt.Sprintf("y%d", i)]
+		if !ok {
+			break
+		}
+		seriesNames = append(seriesNames, firstNonEmpty(named[fmt.Sprintf("y%dtitle", i)], fmt.Sprintf("Series %d", i)))
+		series = append(series, splitList(y))
+	}
+
+	var b strings.Builder
+	b.WriteString(`<div class="data-chart"><table class="wikitable"><tr><th>`)
+	b.WriteString(escapeText(firstNonEmpty(named["xaxistitle"], "")) + "</th>")
+	for _, name := range seriesNames {
+		b.WriteString("<th>" + escapeText(name) + "</th>")
+	}
+	b.WriteString("</tr>")
+	for i, x := range xs {
+		b.WriteString("<tr><td>" + escapeText(x) + "</td>")
+		for _, s := range series {
+			cell := ""
+			if i < len(s) {
+				cell = s[i]
+			}
+			b.WriteString("<td>" + escapeText(cell) + "</td>")
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</table>")
+	if len(series) == 1 {
+		var labels []string
+		var values []float64
+		for i, x := range xs {
+			if i < len(series[0]) {
+				if v, err := parseNumber(series[0][i]); err == nil {
+					labels = append(labels, x)
+					values = append(values, v)
+				}
+			}
+		}
+		b.WriteString(svgBarChart(labels, values))
+	}
+	b.WriteString("</div>")
+	return "\n" + b.String() + "\n"
+}
+
+var (
+	timelineRe = regexp.MustCompile(`(?is)<timeline[^>]*>(.*?)</timeline>`)
+	timelineKV = regexp.MustCompile(`(\w+):(\S+|"[^"]*")`)
+)
+
+// convertTimelines replaces EasyTimeline <timeline> blocks with a table of
+// their PlotData bars, which carry the underlying date ranges.
+func convertTimelines(text string) string {
+	return timelineRe.ReplaceAllStringFunc(text, func(m string) string {
+		body := timelineRe.FindStringSubmatch(m)[1]
+		var rows []string
+		inPlot := false
+		for _, line := range strings.Split(body, "\n") {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" {
+				continue
+			}
+			if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+				inPlot = strings.HasPrefix(strings.ToLower(trimmed), "plotdata")
+				continue
+			}
+			if !inPlot || !strings.Contains(trimmed, "from:") {
+				continue
+			}
+			fields := make(map[string]string)
+			for _, kv := range timelineKV.FindAllStringSubmatch(trimmed, -1) {
+				fields[strings.ToLower(kv[1])] = strings.Trim(kv[2], `"`)
+			}
+			label := firstNonEmpty(fields["text"], fields["bar"])
+			rows = append(rows, fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%s</td></tr>",
+				escapeText(strings.ReplaceAll(label, "_", " ")), escapeText(fields["from"]), escapeText(fields["till"])))
+		}
+		if len(rows) == 0 {
+			return ""
+		}
+		return "\n" + `<div class="data-chart"><table class="wikitable"><tr><th>Timeline</th><th>From</th><th>Until</th></tr>` +
+			strings.Join(rows, "") + "</table></div>\n"
+	})
+}
+
+// svgBarChart draws a simple horizontal bar chart.
+func svgBarChart(labels []string, values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	max := 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	if max <= 0 {
+		return ""
+	}
+
+	const barHeight, labelWidth, chartWidth = 18, 70, 300
+	height := len(values) * (barHeight + 4)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="bar-chart" role="img" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		labelWidth+chartWidth+10, height, labelWidth+chartWidth+10, height)
+	for i, v := range values {
+		y := i * (barHeight + 4)
+		width := int(v / max * chartWidth)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" font-size="12">%s</text>`, labelWidth-6, y+barHeight-5, escapeText(labels[i]))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="#6b8fc7"></rect>`, labelWidth, y, width, barHeight)
+	}
+	b.WriteString("</svg>")
+	return b.String()
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+func parseNumber(s string) (float64, error) {
+	s = strings.NewReplacer(",", "", " ", "", " ", "").Replace(s)
+	return strconv.ParseFloat(s, 64)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
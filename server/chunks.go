@@ -0,0 +1,216 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// Chunk sizes accepted by /api/page/{title}/chunks, in words.
+const (
+	defaultChunkWords = 500
+	minChunkWords     = 50
+	maxChunkWords     = 5000
+)
+
+// textChunk is a run of plain text from one section of an article
+type textChunk struct {
+	Index   int    `json:"index"`
+	Section string `json:"section"`
+	Words   int    `json:"words"`
+	Text    string `json:"text"`
+}
+
+type apiChunksResponse struct {
+	Title          string      `json:"title"`
+	PageID         int         `json:"page_id"`
+	RedirectedFrom string      `json:"redirected_from,omitempty"`
+	ChunkWords     int         `json:"chunk_words"`
+	Chunks         []textChunk `json:"chunks"`
+}
+
+// articleSection is the plain text under one heading. Label is the path of
+// headings leading to it, e.g. "History > Early years"; the lead has none.
+type articleSection struct {
+	Label      string
+	Paragraphs []string
+}
+
+// splitSections converts wikitext to plain text paragraphs grouped by the
+// section they appear in.
+func splitSections(wikitext string) []articleSection {
+	wikitext, _ = extractMagicWords(wikitext)
+	var sections []articleSection
+	current := articleSection{}
+	var path []string
+	var body strings.Builder
+
+	flush := func() {
+		for _, para := range strings.Split(plainText(body.String()), "\n\n") {
+			var lines []string
+			for _, line := range strings.Split(para, "\n") {
+				line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "*#:;!|"))
+				if line != "" {
+					lines = append(lines, line)
+				}
+			}
+			if len(lines) > 0 {
+				current.Paragraphs = append(current.Paragraphs, strings.Join(lines, " "))
+			}
+		}
+		if len(current.Paragraphs) > 0 {
+			sections = append(sections, current)
+		}
+		body.Reset()
+	}
+
+	for _, line := range strings.Split(wikitext, "\n") {
+		if !isHeader(line) {
+			body.WriteString(line)
+			body.WriteByte('\n')
+			continue
+		}
+		flush()
+		level, title := parseHeader(line, 0)
+		title = strings.TrimSpace(plainText(title))
+		// Level 2 headings are the top of the path
+		depth := max(level-2, 0)
+		if depth > len(path) {
+			depth = len(path)
+		}
+		path = append(path[:depth], title)
+		current = articleSection{Label: strings.Join(path, " > ")}
+	}
+	flush()
+	return sections
+}
+
+// chunkSections splits sections into chunks of about size words. Chunks
+// never span sections and break between paragraphs where possible; longer
+// paragraphs are split between words.
+func chunkSections(sections []articleSection, size int) []textChunk {
+	var chunks []textChunk
+	for _, section := range sections {
+		var words []string
+		emit := func() {
+			if len(words) == 0 {
+				return
+			}
+			chunks = append(chunks, textChunk{Index: len(chunks), Section: section.Label, Words: len(words), Text: strings.Join(words, " ")})
+			words = nil
+		}
+		for _, para := range section.Paragraphs {
+			paraWords := strings.Fields(para)
+			if len(words) > 0 && len(words)+len(paraWords) > size {
+				emit()
+			}
+			for len(paraWords) > size {
+				words = append(words, paraWords[:size]...)
+				paraWords = paraWords[size:]
+				emit()
+			}
+			if len(words) > 0 {
+				// Keep paragraph breaks inside a chunk
+				words[len(words)-1] += "\n\n"
+			}
+			words = append(words, paraWords...)
+		}
+		emit()
+	}
+	for i := range chunks {
+		chunks[i].Text = strings.ReplaceAll(chunks[i].Text, "\n\n ", "\n\n")
+	}
+	return chunks
+}
+
+// pageWikiText returns the wikitext of an article, following redirects,
+// reading the dump through streams. The second result is the title that
+// redirected, if any.
+func pageWikiText(index []IndexEntry, titles titleSet, inputFile string, streams *streamCaches, entry *IndexEntry) (*IndexEntry, string, string, error) {
+	var from string
+	for hops := 0; ; hops++ {
+		data, err := streams.extract(inputFile, entry.Offsets)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("extracting page: %v", err)
+		}
+		text, err := dump.ExtractPageText(data, entry.PageID)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("extracting page: %v", err)
+		}
+		if !redirectRe.MatchString(text) || hops == maxRedirects {
+			return entry, from, text, nil
+		}
+		m := wikiLinkRe.FindStringSubmatch(text)
+		if m == nil {
+			return entry, from, text, nil
+		}
+		target, _ := splitRedirect(m[1])
+		next := findPageByTitle(index, titles, target)
+		if next == nil || next.PageID == entry.PageID {
+			return entry, from, text, nil
+		}
+		if from == "" {
+			from = entry.Title
+		}
+		entry = next
+	}
+}
+
+// handleAPIChunks serves /api/page/{title}/chunks?words=N: the article as
+// plain text chunks with section labels, for feeding into text-to-speech or
+// language model pipelines with bounded input sizes.
+func handleAPIChunks(w http.ResponseWriter, r *http.Request, index []IndexEntry, titles titleSet, inputFile string, streams *streamCaches) {
+	title, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/page/"), "/chunks")
+	if !ok || title == "" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "expected /api/page/{title}/chunks"})
+		return
+	}
+	if err := validateTitle(strings.ReplaceAll(title, "_", " ")); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	size := defaultChunkWords
+	if s := r.FormValue("words"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < minChunkWords || n > maxChunkWords {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("words must be between %d and %d", minChunkWords, maxChunkWords)})
+			return
+		}
+		size = n
+	}
+
+	entry := findPageByTitle(index, titles, title)
+	if entry == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no article with that title"})
+		return
+	}
+	entry, from, text, err := pageWikiText(index, titles, inputFile, streams, entry)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	resp := apiChunksResponse{
+		Title:          entry.Title,
+		PageID:         entry.PageID,
+		RedirectedFrom: from,
+		ChunkWords:     size,
+		Chunks:         chunkSections(splitSections(text), size),
+	}
+	if resp.Chunks == nil {
+		resp.Chunks = []textChunk{}
+	}
+	if negotiateFormat(r) == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for _, chunk := range resp.Chunks {
+			enc.Encode(chunk)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
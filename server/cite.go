@@ -0,0 +1,43 @@
+package server
+
+import "strings"
+
+func init() {
+	for _, name := range []string{"cite web", "cite news", "cite book", "cite journal", "cite magazine", "citation"} {
+		defaultTemplates.Register(name, citeTemplate)
+	}
+}
+
+// citeTemplate renders the {{cite ...}} family as a one-line reference:
+// author, linked title, publication and date.
+func citeTemplate(args []string) string {
+	_, named := templateArgs(args)
+
+	var parts []string
+	author := named["author"]
+	if author == "" && named["last"] != "" {
+		author = strings.TrimSpace(named["last"] + ", " + named["first"])
+		author = strings.TrimSuffix(author, ",")
+	}
+	if author != "" {
+		parts = append(parts, author)
+	}
+
+	title := firstNonEmpty(named["title"], named["chapter"])
+	if url := named["url"]; url != "" && title != "" {
+		title = "[" + url + " " + title + "]"
+	}
+	if title != "" {
+		parts = append(parts, "''"+title+"''")
+	}
+	if pub := firstNonEmpty(named["work"], named["website"], named["newspaper"], named["journal"], named["magazine"], named["publisher"]); pub != "" {
+		parts = append(parts, pub)
+	}
+	if date := firstNonEmpty(named["date"], named["year"]); date != "" {
+		parts = append(parts, date)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, ". ") + "."
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Chinese and Japanese are written without spaces between words, so the
+// word tokenizer turns a whole sentence into one token that no query
+// matches. The CJK tokenizer indexes runs of Han, Hiragana and Katakana
+// as overlapping character pairs instead: 東京都庁 becomes 東京, 京都 and
+// 都庁 at consecutive positions. A query is split the same way and
+// matches where all its pairs follow one another, which finds it as a
+// substring of the text. A run of one character is kept as it is, so a
+// single-character query only finds the character standing alone.
+
+// Tokenizer names recorded in the full-text manifest.
+const (
+	tokenizerWords = "" // indexes built before the CJK tokenizer have none
+	tokenizerCJK   = "cjk"
+)
+
+// cjkLanguages are the content languages for which index fulltext
+// -cjk auto uses the CJK tokenizer.
+var cjkLanguages = []string{"zh", "ja", "yue", "wuu", "lzh", "gan", "zh-classical", "zh-yue"}
+
+// isCJKLanguage reports whether a dump language is written without
+// spaces between words.
+func isCJKLanguage(lang string) bool {
+	lang = strings.ToLower(lang)
+	return slices.Contains(cjkLanguages, lang) || strings.HasPrefix(lang, "zh-")
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) || r == 'ー'
+}
+
+// tokenizePhrases splits text into phrases, lists of tokens that must occur
+// at consecutive positions: one word each, or the character pairs of a CJK
+// run with cjk set. Flattened, they are the tokens an index holds.
+func tokenizePhrases(text string, cjk bool) [][]string {
+	if !cjk {
+		var phrases [][]string
+		for _, t := range tokenize(text) {
+			phrases = append(phrases, []string{t})
+		}
+		return phrases
+	}
+	var phrases [][]string
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		for _, run := range splitCJKRuns(w) {
+			runes := []rune(run)
+			switch {
+			case !isCJK(runes[0]):
+				if len(run) <= maxTokenLength {
+					phrases = append(phrases, []string{run})
+				}
+			case len(runes) == 1:
+				phrases = append(phrases, []string{run})
+			default:
+				pairs := make([]string, len(runes)-1)
+				for i := range pairs {
+					pairs[i] = string(runes[i : i+2])
+				}
+				phrases = append(phrases, pairs)
+			}
+		}
+	}
+	return phrases
+}
+
+// tokenizeCJK returns the tokens of text under the CJK tokenizer.
+func tokenizeCJK(text string) []string {
+	var tokens []string
+	for _, p := range tokenizePhrases(text, true) {
+		tokens = append(tokens, p...)
+	}
+	return tokens
+}
+
+// splitCJKRuns splits a word where it changes between CJK and other
+// characters, as in iPhone手机.
+func splitCJKRuns(word string) []string {
+	var runs []string
+	start, inCJK := 0, false
+	for i, r := range word {
+		if i > 0 && isCJK(r) != inCJK {
+			runs = append(runs, word[start:i])
+			start = i
+		}
+		inCJK = isCJK(r)
+	}
+	return append(runs, word[start:])
+}
+
+// phraseCount counts the places where the token lists, in order, have
+// consecutive positions. Positions are sorted, as the builder records them.
+func phraseCount(lists [][]uint32) int {
+	count := 0
+	for _, p := range lists[0] {
+		found := true
+		for k, list := range lists[1:] {
+			if _, ok := slices.BinarySearch(list, p+uint32(k)+1); !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			count++
+		}
+	}
+	return count
+}
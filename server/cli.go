@@ -0,0 +1,286 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Commands that read articles from the dump without starting the server:
+// search, extract and export.
+
+// indexFlags registers the -file and -index flags most commands take.
+func indexFlags(fs *flag.FlagSet) (inputFile, indexPath *string) {
+	inputFile = fs.String("file", "", "Path to multistream bzip2 file")
+	indexPath = fs.String("index", "", "Path to index file")
+	return inputFile, indexPath
+}
+
+// requireIndexFlags exits with usage when -file or -index is missing.
+func requireIndexFlags(fs *flag.FlagSet, inputFile, indexPath string) {
+	if inputFile == "" || indexPath == "" {
+		fmt.Println("Error: both -file and -index arguments are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// renderFlagValues holds the flags renderFlags registers.
+type renderFlagValues struct {
+	project, citations *string
+	headerOffset       *int
+}
+
+// renderFlags registers -project, -citations and -header-offset, which
+// shape rendered HTML, on a command's flags, as the server's flags of the
+// same names do.
+func renderFlags(fs *flag.FlagSet) renderFlagValues {
+	return renderFlagValues{
+		project:      fs.String("project", "auto", flags.Lookup("project").Usage),
+		citations:    fs.String("citations", citationPopup, flags.Lookup("citations").Usage),
+		headerOffset: fs.Int("header-offset", 0, flags.Lookup("header-offset").Usage),
+	}
+}
+
+// loadCommandIndex loads the index for a command, exiting on failure.
+// Loading messages go to standard error, so they don't mix with the
+// results of commands that print them.
+func loadCommandIndex(inputFile, indexPath string) *indexCache {
+	loaded, err := loadIndex(indexPath, loadNamespaces(inputFile, os.Stderr), os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading index: %v\n", err)
+		os.Exit(1)
+	}
+	return loaded
+}
+
+// runSearch implements "wikiseek search": a title search, as the search
+// page runs it.
+func runSearch(args []string) {
+	fs := newCommandFlags("search")
+	inputFile, indexPath := indexFlags(fs)
+	limit := fs.Int("limit", 20, "Maximum results printed for each kind of match (0 for all)")
+	asJSON := fs.Bool("json", false, "Print every result as JSON, as /api/search returns them")
+	fs.Parse(args)
+	requireIndexFlags(fs, *inputFile, *indexPath)
+	query := strings.Join(fs.Args(), " ")
+	if query == "" {
+		fmt.Println("Error: no search query given")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	loaded := loadCommandIndex(*inputFile, *indexPath)
+	meta := loadMetaStore(loaded, nil)
+	groups := searchIndex(loaded.Entries, query, meta, nil, nil)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(newAPISearchResponse(query, groups, meta))
+		return
+	}
+	if countResults(groups) == 0 {
+		fmt.Printf("No titles match %q\n", query)
+		os.Exit(1)
+	}
+	for i, g := range groups {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%d)\n", g.Label, len(g.Entries))
+		shown := g.Entries
+		if *limit > 0 && len(shown) > *limit {
+			shown = shown[:*limit]
+		}
+		for _, e := range shown {
+			fmt.Printf("  %s\n", e.Title)
+		}
+		if more := len(g.Entries) - len(shown); more > 0 {
+			fmt.Printf("  ... and %d more\n", more)
+		}
+	}
+}
+
+// articleFormats are the formats extract and export write, with the file
+// extension export gives each.
+var articleFormats = map[string]string{"wikitext": ".wiki", "html": ".html", "text": ".txt"}
+
+// articleReader renders articles for extract and export.
+type articleReader struct {
+	index     []IndexEntry
+	titles    titleSet
+	inputFile string
+	format    string
+	pipeline  *Pipeline
+}
+
+// newArticleReader checks the format and, for HTML, sets up the project
+// profile, the renderer and the render pipeline the way the server does.
+// Setup messages go to standard error.
+func newArticleReader(loaded *indexCache, inputFile, format, rendererName, stages string, render renderFlagValues) (*articleReader, error) {
+	if _, ok := articleFormats[format]; !ok {
+		return nil, fmt.Errorf("unknown format %q (want wikitext, html or text)", format)
+	}
+	a := &articleReader{index: loaded.Entries, titles: loaded.titleSet(), inputFile: inputFile, format: format}
+	if format != "html" {
+		return a, nil
+	}
+	if !validCitationStyle(*render.citations) {
+		return nil, fmt.Errorf("unknown citation style %q (want popup, footnote or hidden)", *render.citations)
+	}
+	project, err := selectProject(*render.project, loadDumpInfo(inputFile, os.Stderr))
+	if err != nil {
+		return nil, err
+	}
+	templates := project.templates(defaultTemplates)
+	renderers, _, err := setupRenderers(rendererName, "", convertOptions{templates: templates, headerOffset: *render.headerOffset, citations: *render.citations}, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	settings := renderSettings{templates: templates, renderers: renderers, media: "commons", log: os.Stderr}
+	if a.pipeline, err = newPipeline(stages, inputFile, a.titles, loadMetaStore(loaded, nil), settings); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// read returns an article in the reader's format, following redirects, and
+// the entry it was read from.
+func (a *articleReader) read(title string) (string, *IndexEntry, error) {
+	entry := findPageByTitle(a.index, a.titles, title)
+	if entry == nil {
+		return "", nil, fmt.Errorf("no article titled %q", title)
+	}
+	entry, _, text, err := pageWikiText(a.index, a.titles, a.inputFile, nil, entry)
+	if err != nil {
+		return "", nil, err
+	}
+	switch a.format {
+	case "text":
+		return plainText(text), entry, nil
+	case "html":
+		ctx, err := a.pipeline.Run(entry, nil, nil)
+		if err != nil {
+			return "", nil, fmt.Errorf("rendering %q: %v", entry.Title, err)
+		}
+		return ctx.HTML, entry, nil
+	}
+	return text, entry, nil
+}
+
+// runExtract implements "wikiseek extract": one article to standard output.
+func runExtract(args []string) {
+	fs := newCommandFlags("extract")
+	inputFile, indexPath := indexFlags(fs)
+	format := fs.String("format", "wikitext", "Output format: wikitext, html or text")
+	rendererName := fs.String("renderer", "native", "Renderer for HTML: pandoc, native, or auto to use pandoc when installed")
+	stages := fs.String("stages", defaultStages, "Render pipeline stages for HTML")
+	render := renderFlags(fs)
+	fs.Parse(args)
+	requireIndexFlags(fs, *inputFile, *indexPath)
+	title := strings.Join(fs.Args(), " ")
+	if title == "" {
+		fmt.Println("Error: no article title given")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	loaded := loadCommandIndex(*inputFile, *indexPath)
+	reader, err := newArticleReader(loaded, *inputFile, *format, *rendererName, *stages, render)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	text, entry, err := reader.read(title)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if entry.Title != strings.ReplaceAll(title, "_", " ") {
+		fmt.Fprintf(os.Stderr, "Showing %q\n", entry.Title)
+	}
+	fmt.Print(text)
+	if !strings.HasSuffix(text, "\n") {
+		fmt.Println()
+	}
+}
+
+// runExport implements "wikiseek export": articles named on the command
+// line or in a file, each written to its own file.
+func runExport(args []string) {
+	fs := newCommandFlags("export")
+	inputFile, indexPath := indexFlags(fs)
+	outDir := fs.String("out", "", "Directory to write the articles to")
+	titleFile := fs.String("titles", "", "File of titles to export, one per line, or vital for the built-in list of core articles")
+	format := fs.String("format", "html", "Output format: wikitext, html or text")
+	rendererName := fs.String("renderer", "native", "Renderer for HTML: pandoc, native, or auto to use pandoc when installed")
+	stages := fs.String("stages", defaultStages, "Render pipeline stages for HTML")
+	render := renderFlags(fs)
+	fs.Parse(args)
+	requireIndexFlags(fs, *inputFile, *indexPath)
+	if *outDir == "" {
+		fmt.Println("Error: -out is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	titles := fs.Args()
+	if *titleFile != "" {
+		listed, err := prerenderTitles(*titleFile, nil)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		titles = append(titles, listed...)
+	}
+	if len(titles) == 0 {
+		fmt.Println("Error: no titles given")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	loaded := loadCommandIndex(*inputFile, *indexPath)
+	reader, err := newArticleReader(loaded, *inputFile, *format, *rendererName, *stages, render)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	failed := 0
+	for _, title := range titles {
+		text, entry, err := reader.read(title)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			failed++
+			continue
+		}
+		if *format == "html" {
+			text = standaloneHTML(entry.Title, text)
+		}
+		name := filepath.Join(*outDir, url.PathEscape(strings.ReplaceAll(entry.Title, " ", "_"))+articleFormats[*format])
+		if err := os.WriteFile(name, []byte(text), 0644); err != nil {
+			fmt.Printf("Error writing %s: %v\n", name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Wrote %s\n", name)
+	}
+	fmt.Printf("Exported %d of %d articles to %s\n", len(titles)-failed, len(titles), *outDir)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// standaloneHTML wraps rendered article HTML in a page of its own.
+func standaloneHTML(title, body string) string {
+	return "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + html.EscapeString(title) +
+		"</title>\n</head>\n<body>\n<h1>" + html.EscapeString(title) + "</h1>\n" + body + "\n</body>\n</html>\n"
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xanderstrike/wikiseek/store"
+)
+
+// Collections are named, ordered lists of articles assembled from the
+// "Add to collection" form under each article, and exported as an EPUB
+// book or a zip of static HTML pages. They are shared by everyone using
+// the server, like the admin page, and kept in the data directory so they
+// survive restarts.
+
+// maxCollectionArticles caps the size of a collection, which bounds the
+// work of an export.
+const maxCollectionArticles = 500
+
+// maxCollectionName is the longest collection name, in bytes.
+const maxCollectionName = 100
+
+// maxCollections caps the number of collections, which bounds what
+// anonymous edits can add to memory and the data directory.
+const maxCollections = 200
+
+// Collection is a named list of article titles, in reading order.
+type Collection struct {
+	Name    string    `json:"name"`
+	Titles  []string  `json:"titles"`
+	Updated time.Time `json:"updated"`
+}
+
+// collectionStore holds the collections, saving each change to its bucket.
+type collectionStore struct {
+	mu     sync.Mutex
+	items  map[string]*Collection
+	bucket *store.Bucket
+}
+
+func newCollectionStore(bucket *store.Bucket) *collectionStore {
+	c := &collectionStore{items: make(map[string]*Collection), bucket: bucket}
+	for _, name := range bucket.Keys() {
+		data, _ := bucket.Get(name)
+		var col Collection
+		if err := json.Unmarshal(data, &col); err == nil {
+			c.items[name] = &col
+		}
+	}
+	return c
+}
+
+// Names returns the names of every collection, sorted.
+func (c *collectionStore) Names() []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.items))
+	for name := range c.items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns a copy of a collection.
+func (c *collectionStore) Get(name string) (Collection, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	col, ok := c.items[name]
+	if !ok {
+		return Collection{}, false
+	}
+	copied := *col
+	copied.Titles = append([]string(nil), col.Titles...)
+	return copied, true
+}
+
+// Add appends an article to a collection, creating the collection if it
+// is new. Adding an article already in it changes nothing.
+func (c *collectionStore) Add(name, title string) error {
+	if err := validateCollectionName(name); err != nil {
+		return err
+	}
+	return c.update(name, true, func(col *Collection) error {
+		for _, t := range col.Titles {
+			if t == title {
+				return nil
+			}
+		}
+		if len(col.Titles) >= maxCollectionArticles {
+			return fmt.Errorf("a collection holds at most %d articles", maxCollectionArticles)
+		}
+		col.Titles = append(col.Titles, title)
+		return nil
+	})
+}
+
+// Remove takes an article out of a collection.
+func (c *collectionStore) Remove(name, title string) error {
+	return c.update(name, false, func(col *Collection) error {
+		for i, t := range col.Titles {
+			if t == title {
+				col.Titles = append(col.Titles[:i], col.Titles[i+1:]...)
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Move shifts an article by delta places within its collection.
+func (c *collectionStore) Move(name, title string, delta int) error {
+	return c.update(name, false, func(col *Collection) error {
+		for i, t := range col.Titles {
+			if t == title {
+				j := max(0, min(i+delta, len(col.Titles)-1))
+				col.Titles[i], col.Titles[j] = col.Titles[j], col.Titles[i]
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes a whole collection.
+func (c *collectionStore) Delete(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[name]; !ok {
+		return errNoCollection
+	}
+	delete(c.items, name)
+	return c.bucket.Delete(name)
+}
+
+var errNoCollection = errors.New("no such collection")
+
+// update applies fn to a collection and saves it, creating the collection
+// first when create is set.
+func (c *collectionStore) update(name string, create bool, fn func(*Collection) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	col, ok := c.items[name]
+	if !ok {
+		if !create {
+			return errNoCollection
+		}
+		if len(c.items) >= maxCollections {
+			return fmt.Errorf("there are already %d collections; delete one to start another", maxCollections)
+		}
+		col = &Collection{Name: name}
+	}
+	updated := *col
+	updated.Titles = append([]string(nil), col.Titles...)
+	if err := fn(&updated); err != nil {
+		return err
+	}
+	updated.Updated = time.Now().UTC()
+	data, err := json.Marshal(&updated)
+	if err != nil {
+		return err
+	}
+	if err := c.bucket.Put(name, data); err != nil {
+		return fmt.Errorf("saving collection: %v", err)
+	}
+	c.items[name] = &updated
+	return nil
+}
+
+// validateCollectionName checks a name can be used as a path segment.
+func validateCollectionName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("collection name is empty")
+	case len(name) > maxCollectionName:
+		return fmt.Errorf("collection name is longer than %d bytes", maxCollectionName)
+	case strings.ContainsAny(name, "/\\") || strings.ContainsFunc(name, func(r rune) bool { return r < ' ' }):
+		return errors.New("collection name may not contain slashes or control characters")
+	}
+	return nil
+}
+
+// collectionPath is the URL path of a collection's page, without the base
+// path.
+func collectionPath(name string) string {
+	return "/collections/" + url.PathEscape(name)
+}
+
+// sameOrigin reports whether a request was sent by a page of this server
+// rather than a form on another site. Browsers name the page's origin in
+// Origin, or at least say whether it was cross-site in Sec-Fetch-Site;
+// requests with neither don't come from a browser, so can't be forged by
+// a page a visitor happens to open.
+func sameOrigin(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Scheme == requestScheme(r) && strings.EqualFold(u.Host, requestHost(r))
+	}
+	site := r.Header.Get("Sec-Fetch-Site")
+	return site == "" || site == "same-origin" || site == "none"
+}
+
+// handleCollectionEdit applies a form posted to /collections: action add,
+// remove, up, down or delete, on the named collection and title. It then
+// sends the browser to the collection, or with return=article back to the
+// article, which names the collection it was added to. Forms posted from
+// other sites are refused.
+func handleCollectionEdit(w http.ResponseWriter, r *http.Request, collections *collectionStore, index []IndexEntry, titles titleSet) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "collections can only be edited from this site's pages", http.StatusForbidden)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("collection"))
+	title := strings.TrimSpace(r.FormValue("title"))
+	target := collectionPath(name)
+	var err error
+	switch action := r.FormValue("action"); action {
+	case "add":
+		entry := findPageByTitle(index, titles, title)
+		if entry == nil {
+			http.Error(w, "no article titled "+title, http.StatusNotFound)
+			return
+		}
+		title = entry.Title
+		err = collections.Add(name, title)
+		if r.FormValue("return") == "article" {
+			target = canonicalPath("/wiki/", title) + "?added=" + url.QueryEscape(name)
+		}
+	case "remove":
+		err = collections.Remove(name, title)
+	case "up":
+		err = collections.Move(name, title, -1)
+	case "down":
+		err = collections.Move(name, title, 1)
+	case "delete":
+		err = collections.Delete(name)
+		target = "/collections"
+	default:
+		err = fmt.Errorf("unknown action %q (want add, remove, up, down or delete)", action)
+	}
+	switch {
+	case errors.Is(err, errNoCollection):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(requestServer(r).log, "[%s] Collection %q: %s %q\n", time.Now().Format("2006-01-02 15:04:05"), name, r.FormValue("action"), title)
+	http.Redirect(w, r, absoluteURL(r, target), http.StatusSeeOther)
+}
+
+// handleCollectionList shows every collection.
+func handleCollectionList(w http.ResponseWriter, r *http.Request, tmpl *template.Template, collections *collectionStore) {
+	s := requestServer(r)
+	var b strings.Builder
+	names := collections.Names()
+	if len(names) == 0 {
+		b.WriteString("<p>There are no collections yet. Use the <em>Add to collection</em> form under any article to start one.</p>")
+	} else {
+		b.WriteString(`<table class="stats"><tr><th>Collection</th><th>Articles</th><th>Updated</th></tr>`)
+		for _, name := range names {
+			col, _ := collections.Get(name)
+			fmt.Fprintf(&b, `<tr><td><a href="%s%s">%s</a></td><td>%d</td><td>%s</td></tr>`, s.cfg.BasePath, collectionPath(name),
+				html.EscapeString(name), len(col.Titles), col.Updated.Format("2006-01-02 15:04"))
+		}
+		b.WriteString("</table>")
+	}
+	tmpl.Execute(w, ArticleView{
+		Layout:  Layout{Dump: s.info},
+		Title:   "Collections",
+		Content: template.HTML(b.String()),
+	})
+}
+
+// handleCollection serves /collections/{name}, the collection's page, and
+// its exports at /collections/{name}/epub and /collections/{name}/html.
+func handleCollection(w http.ResponseWriter, r *http.Request, tmpl *template.Template, collections *collectionStore, wiki *wikiSource) {
+	name, export, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/collections/"), "/")
+	col, ok := collections.Get(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch export {
+	case "":
+	case "epub", "html":
+		handleCollectionExport(w, r, col, export, wiki)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	basePath := requestServer(r).cfg.BasePath
+	path := basePath + collectionPath(name)
+	field := func(name, value string) string {
+		return `<input type="hidden" name="` + name + `" value="` + html.EscapeString(value) + `">`
+	}
+	button := func(action, title, label, aria string) string {
+		return `<form method="post" action="` + basePath + `/collections" class="collection-action">` +
+			field("collection", col.Name) + field("title", title) + field("action", action) +
+			`<button type="submit" aria-label="` + html.EscapeString(aria) + `">` + label + `</button></form>`
+	}
+
+	var b strings.Builder
+	if len(col.Titles) == 0 {
+		b.WriteString("<p>This collection is empty.</p>")
+	} else {
+		fmt.Fprintf(&b, `<p class="collection-export">Download as <a href="%s/epub" download>EPUB</a> or <a href="%s/html" download>HTML (zip)</a></p>`, path, path)
+		b.WriteString(`<ol class="collection-articles">`)
+		for i, title := range col.Titles {
+			fmt.Fprintf(&b, `<li><a href="%s">%s</a> `, html.EscapeString(basePath+canonicalPath(wiki.Prefix, title)), html.EscapeString(title))
+			if i > 0 {
+				b.WriteString(button("up", title, "↑", "Move "+title+" up"))
+			}
+			if i < len(col.Titles)-1 {
+				b.WriteString(button("down", title, "↓", "Move "+title+" down"))
+			}
+			b.WriteString(button("remove", title, "Remove", "Remove "+title) + "</li>")
+		}
+		b.WriteString("</ol>")
+	}
+	b.WriteString(button("delete", "", "Delete collection", "Delete collection "+col.Name))
+	fmt.Fprintf(&b, `<p><a href="%s/collections">All collections</a></p>`, basePath)
+
+	tmpl.Execute(w, ArticleView{
+		Layout:  Layout{Dump: wiki.Info},
+		Title:   col.Name,
+		Content: template.HTML(b.String()),
+	})
+}
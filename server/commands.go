@@ -0,0 +1,218 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// command is a wikiseek subcommand.
+type command struct {
+	name    string // as typed, e.g. "index repair"
+	args    string // synopsis of what follows the name
+	summary string
+	run     func(args []string)
+}
+
+// commands lists the subcommands in the order help shows them. It is
+// filled in by init, since help refers back to it.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "-file DUMP -index INDEX [flags]", "Run the web server (the default when no command is given)", runServe},
+		{"search", "-file DUMP -index INDEX [flags] QUERY", "Search article titles and print the matches", runSearch},
+		{"extract", "-file DUMP -index INDEX [flags] TITLE", "Print one article as wikitext, HTML or plain text", runExtract},
+		{"export", "-file DUMP -index INDEX -out DIR [flags] [TITLE...]", "Write articles to files, one per article", runExport},
+		{"fetch", "[flags]", "Download a multistream dump and its index from a Wikimedia mirror", runFetch},
+		{"bench", "-file DUMP -index INDEX [flags]", "Render a random sample of articles and report timings per stage", runBench},
+		{"verify", "-file DUMP -index INDEX [flags]", "Check that the index matches the dump", runVerify},
+		{"index fulltext", "-file DUMP -index INDEX [flags]", "Build the full-text search index", runIndexFulltext},
+		{"index repair", "-file DUMP -index INDEX [flags]", "Rewrite the index with offsets moved to real stream starts", runIndexRepair},
+		{"index metadata", "-file DUMP -index INDEX [flags]", "Collect page metadata into the index cache", runIndexMetadata},
+		{"index sql", "-file DUMP -index INDEX -page-props SQL -redirects SQL", "Import page_props and redirect SQL dumps into the page metadata", runIndexSQL},
+		{"cache warm", "-file DUMP -index INDEX [flags]", "Build the index caches and metadata, and optionally fill a stream cache", runCacheWarm},
+		{"cache export", "-file DUMP -index INDEX [-stream-cache-dir DIR] [-out ARCHIVE]", "Pack the caches of an index into an archive for other machines", runCacheExport},
+		{"cache import", "-file DUMP -index INDEX [-stream-cache-dir DIR] ARCHIVE", "Unpack caches exported by cache export", runCacheImport},
+		{"status", "[flags]", "Show the status of a running server", runStatus},
+		{"install-service", "[flags] -- -file DUMP -index INDEX [server flags]", "Install the server as a systemd or launchd service", runInstallService},
+		{"golden", "[flags]", "Check the native converter against the golden corpus", runGolden},
+		{"help", "[COMMAND]", "Show help for a command", runHelp},
+	}
+}
+
+// findCommand returns the command named by the start of args and the
+// arguments that follow its name. Group names such as "index" on their own
+// match nothing.
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == commands[i].name {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+// commandGroup returns the commands under a group name such as "index".
+func commandGroup(name string) []command {
+	var group []command
+	for _, c := range commands {
+		if strings.HasPrefix(c.name, name+" ") {
+			group = append(group, c)
+		}
+	}
+	return group
+}
+
+// Main runs the wikiseek command with the arguments after the program
+// name: one of the subcommands, or the server when the arguments start
+// with a flag.
+func Main(args []string) {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return
+	}
+	if strings.HasPrefix(args[0], "-") {
+		runServe(args)
+		return
+	}
+	if cmd, rest := findCommand(args); cmd != nil {
+		cmd.run(rest)
+		return
+	}
+	if group := commandGroup(args[0]); group != nil {
+		if len(args) > 1 {
+			fmt.Printf("Error: unknown command %q\n\n", args[0]+" "+args[1])
+		}
+		printCommandList(os.Stdout, group)
+		os.Exit(2)
+	}
+	fmt.Printf("Error: unknown command %q\n\n", args[0])
+	printUsage(os.Stdout)
+	os.Exit(2)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: wikiseek COMMAND [flags] [arguments]")
+	fmt.Fprintln(w)
+	printCommandList(w, commands)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Server flags given without a command start the server, as with serve.")
+	fmt.Fprintln(w, "Run \"wikiseek help COMMAND\" for a command's flags.")
+}
+
+func printCommandList(w io.Writer, list []command) {
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range list {
+		fmt.Fprintf(w, "  %-16s %s\n", c.name, c.summary)
+	}
+}
+
+// newCommandFlags returns the flag set for a subcommand, with usage text
+// taken from its entry in commands.
+func newCommandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() { printCommandUsage(fs, name) }
+	return fs
+}
+
+func printCommandUsage(fs *flag.FlagSet, name string) {
+	w := fs.Output()
+	cmd, _ := findCommand(strings.Fields(name))
+	if cmd == nil {
+		fmt.Fprintf(w, "Usage: wikiseek %s [flags]\n", name)
+	} else {
+		fmt.Fprintf(w, "Usage: wikiseek %s %s\n\n%s.\n", cmd.name, cmd.args, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags:")
+	fs.PrintDefaults()
+}
+
+// runHelp implements "wikiseek help [COMMAND]".
+func runHelp(args []string) {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return
+	}
+	cmd, _ := findCommand(args)
+	if cmd == nil {
+		if group := commandGroup(args[0]); group != nil {
+			printCommandList(os.Stdout, group)
+			return
+		}
+		fmt.Printf("Error: unknown command %q\n\n", strings.Join(args, " "))
+		printUsage(os.Stdout)
+		os.Exit(2)
+	}
+	if cmd.name == "help" {
+		printUsage(os.Stdout)
+		return
+	}
+	// Every command's flag set prints its usage when asked for -h
+	cmd.run([]string{"-h"})
+}
+
+// runServe implements "wikiseek serve", which is also what a command line
+// of bare flags runs.
+func runServe(args []string) {
+	port := flags.String("port", "8080", "Port to run the server on")
+	daemon := flags.Bool("daemon", false, "Run the server in the background, detached from the terminal")
+	daemonLog := flags.String("daemon-log", "wikiseek.log", "File the background server's output is appended to with -daemon")
+	pidfile := flags.String("pidfile", "", "File to write the server's process ID to; startup fails while it names a running process")
+	flags.Usage = func() { printCommandUsage(flags, "serve") }
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	if *inputFile == "" || *indexFile == "" {
+		fmt.Println("Error: both -file and -index arguments are required")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *daemon {
+		if err := startDaemon(args, *daemonLog); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *pidfile != "" {
+		if err := writePidfile(*pidfile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var server *Server
+	config, err := configFromFlags()
+	if err == nil {
+		config.Log = os.Stdout
+		server, err = newServer(config)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		removePidfile(*pidfile)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Server starting on http://localhost:%s%s/\n", *port, server.cfg.BasePath)
+	if err := http.ListenAndServe(":"+*port, server); err != nil {
+		fmt.Printf("Server error: %v\n", err)
+		removePidfile(*pidfile)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Config describes the server NewServer builds. Each field matches the
+// command line flag named in its comment; start from DefaultConfig, since
+// the zero value of a field is not always the flag's default.
+type Config struct {
+	File     string // multistream bzip2 dump, as -file
+	Index    string // the dump's index, as -index
+	BasePath string // path prefix the handler is mounted under, as -base-path
+
+	// AssetDir holds the templates and static directories. They are read
+	// from the working directory when it is empty.
+	AssetDir string
+
+	// Templates, when set, replaces the built-in template handlers, e.g.
+	// DefaultTemplates().With(map[string]TemplateHandler{"mytemplate": h}).
+	// The project profile's handlers are added to it.
+	Templates *Registry
+
+	// Log receives the startup messages and request log. Nothing is
+	// logged when it is nil.
+	Log io.Writer
+
+	ExtraDumps []string // other dumps as DUMP,INDEX, as -extra-dump
+	Fallback   string   // as -fallback
+
+	TrustProxy       string        // as -trust-proxy
+	RenderCache      int           // as -render-cache
+	Prerender        string        // as -prerender
+	PrerenderWorkers int           // as -prerender-workers
+	Stages           string        // as -stages
+	Postprocessors   string        // as -postprocessors
+	RenderTimeout    time.Duration // as -render-timeout
+	ShadowRender     float64       // as -shadow-render
+	APITokens        string        // as -api-tokens
+	StreamAPI        bool          // as -stream-api
+	StreamCacheDir   string        // as -stream-cache-dir
+	StreamCacheMB    int           // as -stream-cache-mb
+	MemoryLimit      int64         // in bytes, as -memory-limit; 0 for none
+	BackgroundMeta   bool          // as -background-metadata
+	DataDir          string        // as -data-dir
+	Project          string        // as -project
+	HeaderOffset     int           // as -header-offset
+	Trace            bool          // as -trace
+	OTLPEndpoint     string        // as -otlp-endpoint
+	EmbedAncestors   string        // as -embed-ancestors
+	TemplateProfile  bool          // as -template-profile
+	TemplateDir      string        // as -template-dir
+	WatchTemplates   bool          // as -watch-templates
+	UILang           string        // as -ui-lang
+	Citations        string        // as -citations
+	Renderer         string        // as -renderer
+	RendererURL      string        // as -renderer-url
+	HomePanels       string        // as -home-panels
+	HomeCategory     string        // as -home-category
+	PrefetchLinks    int           // as -prefetch-links
+	OfflineSearch    bool          // as -offline-search
+	PWA              bool          // as -pwa
+	DumpTemplates    bool          // as -dump-templates
+	Aliases          string        // as -aliases
+	PageSections     int           // as -page-sections
+	PaginateOverKB   int           // as -paginate-over
+	Media            string        // as -media
+	PopularityWeight float64       // as -popularity-weight
+	Transliterate    bool          // as -transliterate
+}
+
+// DefaultConfig returns a Config with every option at its command line
+// default. Only File and Index must be filled in.
+func DefaultConfig() Config {
+	return Config{
+		RenderCache:      500,
+		PrerenderWorkers: 4,
+		Stages:           defaultStages,
+		RenderTimeout:    time.Minute,
+		StreamCacheMB:    1024,
+		Project:          "auto",
+		UILang:           "en",
+		Citations:        citationPopup,
+		Renderer:         "auto",
+		HomePanels:       "random",
+		PrefetchLinks:    3,
+		PageSections:     10,
+		PaginateOverKB:   1024,
+		Media:            "commons",
+		PopularityWeight: 1,
+	}
+}
+
+// NewServer loads a dump and returns a Server, the handler for every
+// wikiseek route, for mounting in another program's server. With a
+// BasePath, mount it at that path without stripping it:
+//
+//	config := server.DefaultConfig()
+//	config.File, config.Index, config.BasePath = dump, index, "/wiki-mirror"
+//	s, err := server.NewServer(config)
+//	mux.Handle("/wiki-mirror/", s)
+//
+// A program can create several servers, for different dumps or settings.
+// Their background work runs until the process exits.
+func NewServer(config Config) (*Server, error) {
+	if config.File == "" || config.Index == "" {
+		return nil, errors.New("a dump and its index are required")
+	}
+	if config.Log == nil {
+		config.Log = io.Discard
+	}
+	return newServer(config)
+}
+
+// ServeHTTP serves every wikiseek route.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverContextKey{}, s)))
+}
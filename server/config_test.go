@@ -0,0 +1,60 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// TestDefaultConfigMatchesFlags keeps DefaultConfig in step with the
+// defaults of the serve flags.
+func TestDefaultConfigMatchesFlags(t *testing.T) {
+	fromFlags, err := configFromFlags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := DefaultConfig(); !reflect.DeepEqual(fromFlags, want) {
+		t.Errorf("flag defaults give\n%+v\nDefaultConfig returns\n%+v", fromFlags, want)
+	}
+}
+
+func TestNewServerRequiresDump(t *testing.T) {
+	if _, err := NewServer(DefaultConfig()); err == nil {
+		t.Error("NewServer accepted a Config without a dump")
+	}
+}
+
+// TestServersKeepTheirOwnSettings serves the same request from two servers
+// with different settings, as two NewServer calls in one process would.
+func TestServersKeepTheirOwnSettings(t *testing.T) {
+	proxy := netip.MustParsePrefix("10.0.0.0/8")
+	newTestServer := func(basePath string, proxies []netip.Prefix) *Server {
+		s := &Server{cfg: DefaultConfig(), log: io.Discard, proxies: proxies}
+		s.cfg.BasePath = basePath
+		s.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, absoluteURL(r, "/wiki/Apple"))
+		})
+		return s
+	}
+	tests := []struct {
+		server *Server
+		want   string
+	}{
+		{newTestServer("", nil), "http://wiki.example/wiki/Apple"},
+		{newTestServer("/mirror", []netip.Prefix{proxy}), "https://proxy.example/mirror/wiki/Apple"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://wiki.example/wiki/Apple", nil)
+		r.RemoteAddr = "10.1.2.3:4567"
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "proxy.example")
+		w := httptest.NewRecorder()
+		tt.server.ServeHTTP(w, r)
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("base path %q: got %q, want %q", tt.server.cfg.BasePath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,707 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ConvertWikiTextToHTML is a small native MediaWiki converter. It covers the
+// common subset of the markup (headers, lists, tables, emphasis, links and
+// references) and is used whenever pandoc is not available. It expands
+// templates with the built-in handlers and renders citations as popups.
+func ConvertWikiTextToHTML(text string) string {
+	return convertWikiText(text, defaultConvertOptions)
+}
+
+// convertOptions are what the native converter renders with: the template
+// handlers, the -header-offset and the -citations style.
+type convertOptions struct {
+	templates    *Registry
+	headerOffset int
+	citations    string
+}
+
+// defaultConvertOptions are the options of a server started without flags
+var defaultConvertOptions = convertOptions{templates: defaultTemplates, citations: citationPopup}
+
+// convertWikiText converts wikitext with the given options.
+func convertWikiText(text string, options convertOptions) string {
+	templates := options.templates
+	c := &converter{options: options}
+	text = c.protected.render(text)
+	text = commentRe.ReplaceAllString(text, "")
+	text = normalizeEntities(text)
+	text, _ = extractMagicWords(text)
+	text = applyHTMLPolicy(text)
+	text = c.extractRefs(text)
+	text = convertTimelines(text)
+	text = templates.expand(text)
+
+	out := c.convertBlocks(text)
+	if len(c.refs) > 0 {
+		out += c.renderRefs()
+	}
+	return c.protected.restore(out)
+}
+
+var (
+	commentRe   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	refRe       = regexp.MustCompile(`(?is)<ref(\s[^>]*?)?(/>|>(.*?)</ref>)`)
+	boldItalic  = regexp.MustCompile(`'''''(.+?)'''''`)
+	boldRe      = regexp.MustCompile(`'''(.+?)'''`)
+	italicRe    = regexp.MustCompile(`''(.+?)''`)
+	wikiLinkRe  = regexp.MustCompile(`\[\[([^\[\]|]+)(?:\|([^\[\]]*))?\]\]([a-z]*)`)
+	extLinkRe   = regexp.MustCompile(`\[((?:https?:)?//[^\s\]]+)(?:\s+([^\]]*))?\]`)
+	blockHTMLRe = regexp.MustCompile(`^<(table|div|figure|svg|section)[\s>]`)
+	nestedMedia = regexp.MustCompile(`\[\[(?i:file|image|category):[^\[\]]*(\[\[[^\]]*\]\][^\[\]]*)*\]\]`)
+)
+
+// isHeader reports whether a line is a section heading: it starts and
+// ends with "=" and has text between them.
+func isHeader(line string) bool {
+	line = strings.TrimRight(line, " \t")
+	if !strings.HasPrefix(line, "=") || !strings.HasSuffix(line, "=") {
+		return false
+	}
+	_, title := parseHeader(line, 0)
+	return title != ""
+}
+
+// parseHeader maps a heading line to an HTML level the way MediaWiki does:
+// the level is the smaller of the opening and closing "=" runs, capped at
+// 6, and any extra "=" on the longer side stay part of the title. So
+// "== A ==" is h2 and "==A===" is an h2 titled "A=". The -header-offset
+// given is then added and the result clamped to h2..h6, since h1 is the
+// page title.
+func parseHeader(line string, offset int) (int, string) {
+	line = strings.TrimRight(line, " \t")
+	open := len(line) - len(strings.TrimLeft(line, "="))
+	closing := len(line) - len(strings.TrimRight(line, "="))
+	level := min(open, closing, 6)
+	if 2*level >= len(line) {
+		return 0, ""
+	}
+	title := strings.TrimSpace(line[level : len(line)-level])
+	return headingLevel(level, offset), title
+}
+
+// headingLevel applies -header-offset to a wikitext heading level, keeping
+// the result in h2..h6. Both the native converter and pandoc output go
+// through it, so they number headings alike.
+func headingLevel(level, offset int) int {
+	return max(2, min(6, level+offset))
+}
+
+// Citation rendering strategies selectable with -citations
+const (
+	citationPopup    = "popup"
+	citationFootnote = "footnote"
+	citationHidden   = "hidden"
+)
+
+func validCitationStyle(style string) bool {
+	return style == citationPopup || style == citationFootnote || style == citationHidden
+}
+
+type converter struct {
+	options   convertOptions
+	refs      []citation
+	protected protector
+}
+
+// citation is one footnote: its rendered contents and how many markers
+// cite it, since named refs can be cited again as <ref name="x"/>.
+type citation struct {
+	body string
+	uses int
+}
+
+// extractRefs replaces <ref> tags according to the -citations style: an
+// inline marker that shows the citation on hover, a numbered footnote
+// marker with the contents kept for the list at the end of the article, or
+// nothing at all. Refs sharing a name share a number, and may be cited
+// before the ref that holds their contents. Every marker gets its own id,
+// so the reference list can link back to each place a citation is used.
+func (c *converter) extractRefs(text string) string {
+	if c.options.citations == citationHidden {
+		return refRe.ReplaceAllString(text, "")
+	}
+	named := make(map[string]string)
+	for _, sub := range refRe.FindAllStringSubmatch(text, -1) {
+		if name := refName(sub[1]); name != "" && sub[3] != "" && named[name] == "" {
+			named[name] = sub[3]
+		}
+	}
+	numbers := make(map[string]int)
+	return refRe.ReplaceAllStringFunc(text, func(m string) string {
+		sub := refRe.FindStringSubmatch(m)
+		name := refName(sub[1])
+		n, ok := numbers[name]
+		if !ok {
+			body := sub[3]
+			if name != "" {
+				body = named[name]
+			}
+			if body == "" {
+				return ""
+			}
+			c.refs = append(c.refs, citation{body: convertInline(c.options.templates.expand(body))})
+			n = len(c.refs)
+			if name != "" {
+				numbers[name] = n
+			}
+		}
+		ref := &c.refs[n-1]
+		id := citationRefID(n, ref.uses)
+		ref.uses++
+		if c.options.citations == citationPopup {
+			return fmt.Sprintf(`<sup class="citation-popup" id="%s" tabindex="0">[%d]<span class="citation-body">%s</span></sup>`, id, n, ref.body)
+		}
+		return fmt.Sprintf(`<sup class="citation-ref"><a href="#cite%d" id="%s">[%d]</a></sup>`, n, id, n)
+	})
+}
+
+// refName returns the name attribute of a ref tag, given its attributes.
+func refName(attrs string) string {
+	for _, a := range htmlAttrRe.FindAllStringSubmatch(attrs, -1) {
+		if strings.EqualFold(a[1], "name") {
+			return strings.TrimSpace(a[2] + a[3] + a[4])
+		}
+	}
+	return ""
+}
+
+// citationRefID is the id of the use'th marker citing footnote n.
+func citationRefID(n, use int) string {
+	if use == 0 {
+		return fmt.Sprintf("citeref%d", n)
+	}
+	return fmt.Sprintf("citeref%d-%d", n, use)
+}
+
+// backlinkLabel letters the links back to a citation's markers the way
+// Wikipedia does: a to z, then aa, ab and so on.
+func backlinkLabel(use int) string {
+	label := ""
+	for n := use + 1; n > 0; n /= 26 {
+		n--
+		label = string(rune('a'+n%26)) + label
+	}
+	return label
+}
+
+func (c *converter) renderRefs() string {
+	if c.options.citations != citationFootnote {
+		return ""
+	}
+	return citationList(c.refs)
+}
+
+// citationList renders the numbered reference list, each entry linking
+// back to the markers that cite it.
+func citationList(refs []citation) string {
+	var b strings.Builder
+	b.WriteString("<section class=\"citations\">\n<h2>References</h2>\n<ol>\n")
+	for i, ref := range refs {
+		n := i + 1
+		fmt.Fprintf(&b, "<li id=\"cite%d\">", n)
+		if ref.uses == 1 {
+			fmt.Fprintf(&b, "<a href=\"#%s\" class=\"citation-back\" title=\"Jump up\">↑</a> ", citationRefID(n, 0))
+		} else {
+			b.WriteString("<span class=\"citation-back\">↑")
+			for use := range ref.uses {
+				fmt.Fprintf(&b, " <a href=\"#%s\" title=\"Jump up\"><sup>%s</sup></a>", citationRefID(n, use), backlinkLabel(use))
+			}
+			b.WriteString("</span> ")
+		}
+		b.WriteString(ref.body + "</li>\n")
+	}
+	b.WriteString("</ol>\n</section>\n")
+	return b.String()
+}
+
+// expand replaces every {{...}} with the output of its handler, innermost
+// first so nested templates see expanded arguments.
+func (r *Registry) expand(text string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	// Templates inside <nowiki>, <pre> and the like stay as written
+	var p protector
+	text = p.shield(text)
+	for i := 0; i < 20 && strings.Contains(text, "{{"); i++ {
+		next := r.expandInnermost(text)
+		if next == text {
+			break
+		}
+		text = next
+	}
+	return p.restore(text)
+}
+
+func (r *Registry) expandInnermost(text string) string {
+	var b strings.Builder
+	for {
+		open := strings.Index(text, "{{")
+		if open == -1 {
+			b.WriteString(text)
+			return b.String()
+		}
+		close := strings.Index(text[open+2:], "}}")
+		if close == -1 {
+			b.WriteString(text)
+			return b.String()
+		}
+		close += open + 2
+		// Move to the last opening brace before the closing one
+		if inner := strings.LastIndex(text[open:close], "{{"); inner > 0 {
+			b.WriteString(text[:open+inner])
+			text = text[open+inner:]
+			close -= open + inner
+			open = 0
+		}
+		b.WriteString(text[:open])
+		b.WriteString(r.call(text[open+2 : close]))
+		text = text[close+2:]
+	}
+}
+
+// templateArgs separates template arguments into positional values and
+// name=value pairs, trimming whitespace around both.
+func templateArgs(args []string) ([]string, map[string]string) {
+	var positional []string
+	named := make(map[string]string)
+	for _, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok && !strings.ContainsAny(name, "<[{") {
+			named[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+			continue
+		}
+		positional = append(positional, strings.TrimSpace(arg))
+	}
+	return positional, named
+}
+
+func (r *Registry) call(body string) string {
+	args := splitTemplateArgs(body)
+	name := strings.ToLower(strings.TrimSpace(args[0]))
+	name = strings.ReplaceAll(name, "_", " ")
+	h, ok := r.Lookup(name)
+	if r.profile != nil {
+		start := time.Now()
+		defer func() { r.profile.record(name, ok, time.Since(start)) }()
+	}
+	if r.files != nil {
+		params := args[1:]
+		if isInfobox(name) {
+			params = withInfoboxImage(params)
+		}
+		if out, found := r.files.expand(name, params); found {
+			ok = true
+			return out
+		}
+	}
+	if ok {
+		return h(args[1:])
+	}
+	if r.dump != nil {
+		if out, found := r.dump.expand(name, args[1:]); found {
+			ok = true
+			return out
+		}
+	}
+	return ""
+}
+
+// splitTemplateArgs splits a template body on top-level pipes, ignoring
+// pipes inside links and inside nested templates or parameters, which are
+// left when expanding innermost first but not when reformatting.
+func splitTemplateArgs(body string) []string {
+	var args []string
+	depth, braces, start := 0, 0, 0
+	for i := 0; i < len(body); i++ {
+		switch {
+		case strings.HasPrefix(body[i:], "[["):
+			depth++
+			i++
+		case strings.HasPrefix(body[i:], "]]") && depth > 0:
+			depth--
+			i++
+		case strings.HasPrefix(body[i:], "{{"):
+			braces++
+			i++
+		case strings.HasPrefix(body[i:], "}}") && braces > 0:
+			braces--
+			i++
+		case body[i] == '|' && depth == 0 && braces == 0:
+			args = append(args, body[start:i])
+			start = i + 1
+		}
+	}
+	return append(args, body[start:])
+}
+
+func (c *converter) convertBlocks(text string) string {
+	var b strings.Builder
+	var para []string
+	var listStack string
+	var table *tableState
+
+	flushPara := func() {
+		if len(para) > 0 {
+			if inline := strings.TrimSpace(convertInline(strings.Join(para, " "))); inline != "" {
+				b.WriteString("<p>" + inline + "</p>\n")
+			}
+			para = nil
+		}
+	}
+	closeLists := func(keep int) {
+		for len(listStack) > keep {
+			b.WriteString("</li>\n" + listClose(listStack[len(listStack)-1]) + "\n")
+			listStack = listStack[:len(listStack)-1]
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if table != nil || strings.HasPrefix(trimmed, "{|") {
+			flushPara()
+			closeLists(0)
+			if table == nil {
+				table = &tableState{}
+			}
+			if table.line(&b, trimmed) {
+				table = nil
+			}
+			continue
+		}
+
+		switch {
+		case trimmed == "":
+			flushPara()
+			closeLists(0)
+		case c.protected.isBlock(trimmed):
+			flushPara()
+			closeLists(0)
+			b.WriteString(trimmed + "\n")
+		case blockHTMLRe.MatchString(trimmed):
+			// Block markup produced by template handlers is passed through
+			flushPara()
+			closeLists(0)
+			b.WriteString(trimmed + "\n")
+		case isHeader(trimmed):
+			flushPara()
+			closeLists(0)
+			level, title := parseHeader(trimmed, c.options.headerOffset)
+			fmt.Fprintf(&b, "<h%d id=\"%s\">%s</h%d>\n", level, anchorID(title), convertInline(title), level)
+		case strings.HasPrefix(trimmed, "----"):
+			flushPara()
+			closeLists(0)
+			b.WriteString("<hr />\n")
+		case strings.IndexAny(trimmed[:1], "*#:;") == 0:
+			flushPara()
+			prefix := trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, "*#:;"))]
+			common := 0
+			for common < len(prefix) && common < len(listStack) && prefix[common] == listStack[common] {
+				common++
+			}
+			if common == len(prefix) && common == len(listStack) {
+				b.WriteString("</li>\n")
+			} else {
+				closeLists(common)
+				if common == len(prefix) && common > 0 {
+					b.WriteString("</li>\n")
+				}
+			}
+			for len(listStack) < len(prefix) {
+				listStack += prefix[len(listStack) : len(listStack)+1]
+				b.WriteString(listOpen(listStack[len(listStack)-1]) + "\n")
+			}
+			b.WriteString(listItem(listStack[len(listStack)-1]) + convertInline(strings.TrimSpace(trimmed[len(prefix):])))
+		default:
+			closeLists(0)
+			para = append(para, trimmed)
+		}
+	}
+	flushPara()
+	closeLists(0)
+	if table != nil {
+		table.close(&b)
+	}
+	return b.String()
+}
+
+func listOpen(c byte) string {
+	switch c {
+	case '#':
+		return "<ol>"
+	case ';', ':':
+		return "<dl>"
+	}
+	return "<ul>"
+}
+
+func listClose(c byte) string {
+	switch c {
+	case '#':
+		return "</ol>"
+	case ';', ':':
+		return "</dl>"
+	}
+	return "</ul>"
+}
+
+func listItem(c byte) string {
+	switch c {
+	case ';':
+		return "<dt>"
+	case ':':
+		return "<dd>"
+	}
+	return "<li>"
+}
+
+// tableState tracks an open {| ... |} table while lines are consumed.
+type tableState struct {
+	inRow  bool
+	inCell string
+}
+
+// line consumes one line of table markup and reports whether the table ended.
+func (t *tableState) line(b *strings.Builder, line string) bool {
+	switch {
+	case strings.HasPrefix(line, "{|"):
+		b.WriteString("<table" + tableAttributes(line[2:]) + ">\n")
+	case strings.HasPrefix(line, "|}"):
+		t.close(b)
+		return true
+	case strings.HasPrefix(line, "|+"):
+		b.WriteString("<caption>" + convertInline(strings.TrimSpace(line[2:])) + "</caption>\n")
+	case strings.HasPrefix(line, "|-"):
+		t.closeRow(b)
+	case strings.HasPrefix(line, "!"):
+		t.cells(b, "th", strings.Split(line[1:], "!!"))
+	case strings.HasPrefix(line, "|"):
+		t.cells(b, "td", strings.Split(line[1:], "||"))
+	case t.inCell != "" && line != "":
+		b.WriteString(" " + convertInline(line))
+	}
+	return false
+}
+
+func (t *tableState) cells(b *strings.Builder, tag string, cells []string) {
+	if !t.inRow {
+		b.WriteString("<tr>")
+		t.inRow = true
+	}
+	t.closeCell(b)
+	for i, cell := range cells {
+		// Drop cell attributes such as style="..." | content, except the
+		// ones table sorting reads
+		attrs := ""
+		if attr, content, ok := strings.Cut(cell, "|"); ok && !strings.Contains(attr, "[[") {
+			cell = content
+			attrs = cellAttributes(attr)
+		}
+		if i > 0 {
+			t.closeCell(b)
+		}
+		b.WriteString("<" + tag + attrs + ">" + convertInline(strings.TrimSpace(cell)))
+		t.inCell = tag
+	}
+}
+
+func (t *tableState) closeCell(b *strings.Builder) {
+	if t.inCell != "" {
+		b.WriteString("</" + t.inCell + ">")
+		t.inCell = ""
+	}
+}
+
+func (t *tableState) closeRow(b *strings.Builder) {
+	t.closeCell(b)
+	if t.inRow {
+		b.WriteString("</tr>\n")
+		t.inRow = false
+	}
+}
+
+func (t *tableState) close(b *strings.Builder) {
+	t.closeRow(b)
+	b.WriteString("</table>\n")
+}
+
+var tableAttrRe = regexp.MustCompile(`([\w-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"']+))`)
+
+// parseTableAttrs reads the HTML-style attributes of a table or cell line.
+func parseTableAttrs(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range tableAttrRe.FindAllStringSubmatch(s, -1) {
+		attrs[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+	}
+	return attrs
+}
+
+// tableAttributes keeps the table classes that static/tables.js acts on
+// and marks sortable and collapsible tables with data attributes, in
+// place of the MediaWiki scripts. Other attributes are dropped.
+func tableAttributes(s string) string {
+	var classes []string
+	sortable := false
+	collapsible := ""
+	for _, class := range strings.Fields(parseTableAttrs(s)["class"]) {
+		switch class {
+		case "wikitable", "navbox", "plainrowheaders":
+			classes = append(classes, class)
+		case "sortable":
+			sortable = true
+		case "collapsible", "mw-collapsible":
+			if collapsible == "" {
+				collapsible = "expanded"
+			}
+		case "collapsed", "mw-collapsed":
+			collapsible = "collapsed"
+		case "autocollapse":
+			collapsible = "auto"
+		}
+	}
+	var b strings.Builder
+	if sortable {
+		classes = append(classes, "sortable")
+	}
+	if collapsible != "" {
+		classes = append(classes, "mw-collapsible")
+	}
+	if len(classes) > 0 {
+		b.WriteString(` class="` + strings.Join(classes, " ") + `"`)
+	}
+	if sortable {
+		b.WriteString(` data-sortable="true"`)
+	}
+	if collapsible != "" {
+		b.WriteString(` data-collapsible="` + collapsible + `"`)
+	}
+	return b.String()
+}
+
+// cellAttributes keeps what table sorting needs from a cell: an explicit
+// data-sort-value and the unsortable marker on header cells.
+func cellAttributes(s string) string {
+	attrs := parseTableAttrs(s)
+	var b strings.Builder
+	if v, ok := attrs["data-sort-value"]; ok {
+		b.WriteString(` data-sort-value="` + escapeText(v) + `"`)
+	}
+	for _, class := range strings.Fields(attrs["class"]) {
+		if class == "unsortable" {
+			b.WriteString(` data-unsortable="true"`)
+		}
+	}
+	return b.String()
+}
+
+// convertInline handles emphasis and links within a single block.
+func convertInline(s string) string {
+	s = nestedMedia.ReplaceAllString(s, "")
+	s = wikiLinkRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := wikiLinkRe.FindStringSubmatch(m)
+		target := strings.TrimSpace(sub[1])
+		label := sub[2]
+		if label == "" {
+			label = strings.TrimPrefix(target, ":")
+		}
+		label += sub[3]
+		target = strings.TrimPrefix(target, ":")
+		target = strings.ReplaceAll(html.UnescapeString(target), "\u00a0", " ")
+		href := strings.ReplaceAll(ucfirst(target), " ", "_")
+		return fmt.Sprintf(`<a href="%s" title="wikilink">%s</a>`, html.EscapeString(href), label)
+	})
+	s = extLinkRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := extLinkRe.FindStringSubmatch(m)
+		label := sub[2]
+		if label == "" {
+			label = sub[1]
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(html.UnescapeString(sub[1])), label)
+	})
+	s = boldItalic.ReplaceAllString(s, "<strong><em>$1</em></strong>")
+	s = boldRe.ReplaceAllString(s, "<strong>$1</strong>")
+	s = italicRe.ReplaceAllString(s, "<em>$1</em>")
+	return balanceInlineTags(s)
+}
+
+// anchorID turns a header into the lowercase fragment id used by
+// lowercaseAnchors when rewriting section links.
+func anchorID(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, " ", "_")
+	return html.EscapeString(html.UnescapeString(s))
+}
+
+var (
+	tagRe       = regexp.MustCompile(`<[^>]+>`)
+	tableLineRe = regexp.MustCompile(`(?m)^\s*(\{\||\|\}|\|-|\|\+).*$`)
+	headerLine  = regexp.MustCompile(`(?m)^=+\s*(.*?)\s*=+\s*$`)
+)
+
+// plainText reduces wikitext to readable text without markup, for indexing
+// and text analysis.
+func plainText(text string) string {
+	text = commentRe.ReplaceAllString(text, "")
+	text = refRe.ReplaceAllString(text, "")
+	text = defaultTemplates.expand(text)
+	var p protector
+	text = p.shield(text)
+	text = nestedMedia.ReplaceAllString(text, "")
+	text = wikiLinkRe.ReplaceAllStringFunc(text, func(m string) string {
+		sub := wikiLinkRe.FindStringSubmatch(m)
+		if sub[2] != "" {
+			return sub[2] + sub[3]
+		}
+		return strings.TrimPrefix(sub[1], ":") + sub[3]
+	})
+	text = extLinkRe.ReplaceAllString(text, "$2")
+	text = tableLineRe.ReplaceAllString(text, "")
+	text = headerLine.ReplaceAllString(text, "$1")
+	text = p.restore(text)
+	text = tagRe.ReplaceAllString(text, "")
+	text = strings.NewReplacer("'''", "", "''", "", "||", " ", "!!", " ").Replace(text)
+	return html.UnescapeString(text)
+}
+
+var shortDescRe = regexp.MustCompile(`(?i)\{\{\s*short[ _]description\s*\|\s*([^{}|]*?)\s*(\|[^{}]*)?\}\}`)
+
+// maxDescription caps the length of page descriptions derived from the
+// lead paragraph.
+const maxDescription = 200
+
+// describeWikiText returns a one-line description of an article: its
+// {{Short description}} if it has one, otherwise the start of the lead
+// paragraph.
+func describeWikiText(text string) string {
+	if m := shortDescRe.FindStringSubmatch(text); m != nil && !strings.EqualFold(m[1], "none") {
+		return m[1]
+	}
+	lead := text
+	if i := strings.Index(lead, "\n="); i >= 0 {
+		lead = lead[:i]
+	}
+	for _, line := range strings.Split(plainText(lead), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.ContainsAny(line[:1], "*#:;|!{") {
+			continue
+		}
+		if len(line) > maxDescription {
+			cut := strings.LastIndex(line[:maxDescription], " ")
+			if cut <= 0 {
+				cut = maxDescription
+			}
+			line = line[:cut] + "…"
+		}
+		return line
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the converter golden files with the current output")
+
+// TestConverterGolden renders every snippet in testdata/converter and
+// compares it with its golden HTML. Run with -update after an intended
+// converter change and review the diff of the corpus.
+func TestConverterGolden(t *testing.T) {
+	inputs, err := goldenInputs(filepath.Join("..", goldenDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range inputs {
+		t.Run(goldenName(input), func(t *testing.T) {
+			if *update {
+				if err := updateGolden(input); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			diff, err := checkGolden(input)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if diff != "" {
+				t.Errorf("output differs from %s:\n%s", goldenFile(input), diff)
+			}
+		})
+	}
+}
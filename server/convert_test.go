@@ -0,0 +1,82 @@
+package server
+
+import "testing"
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		line      string
+		offset    int
+		level     int
+		title     string
+		isHeading bool
+	}{
+		{"== A ==", 0, 2, "A", true},
+		{"=A=", 0, 2, "A", true}, // h1 is the page title
+		{"==A===", 0, 2, "A=", true},
+		{"===A==", 0, 2, "=A", true},
+		{"=== A ===  ", 0, 3, "A", true},
+		{"=======A=======", 0, 6, "=A=", true},
+		{"==", 0, 0, "", false},
+		{"=", 0, 0, "", false},
+		{"====", 0, 0, "", false},
+		{"== ==", 0, 2, "", false},
+		{"A ==", 0, 0, "", false},
+
+		{"== A ==", 1, 3, "A", true},
+		{"====A====", 3, 6, "A", true}, // clamped to h6
+		{"=====A=====", 1, 6, "A", true},
+		{"===A===", -2, 2, "A", true}, // clamped to h2
+		{"=A=", -5, 2, "A", true},
+		{"======A======", -2, 4, "A", true},
+	}
+	for _, tt := range tests {
+		if got := isHeader(tt.line); got != tt.isHeading {
+			t.Errorf("isHeader(%q) = %v, want %v", tt.line, got, tt.isHeading)
+		}
+		if !tt.isHeading {
+			continue
+		}
+		level, title := parseHeader(tt.line, tt.offset)
+		if level != tt.level || title != tt.title {
+			t.Errorf("parseHeader(%q) with offset %d = %d, %q; want %d, %q", tt.line, tt.offset, level, title, tt.level, tt.title)
+		}
+	}
+}
+
+// TestShiftHeadings checks pandoc's headings are numbered as the native
+// converter numbers them.
+func TestShiftHeadings(t *testing.T) {
+	pandoc := `<h1 id="title">Title</h1>
+<h2 id="a">A</h2>
+<h6 id="deep">Deep</h6>
+<p>Text with <hr /> and <header>.</p>`
+	tests := []struct {
+		offset int
+		want   string
+	}{
+		{0, `<h2 id="title">Title</h2>
+<h2 id="a">A</h2>
+<h6 id="deep">Deep</h6>
+<p>Text with <hr /> and <header>.</p>`},
+		{1, `<h2 id="title">Title</h2>
+<h3 id="a">A</h3>
+<h6 id="deep">Deep</h6>
+<p>Text with <hr /> and <header>.</p>`},
+		{-1, `<h2 id="title">Title</h2>
+<h2 id="a">A</h2>
+<h5 id="deep">Deep</h5>
+<p>Text with <hr /> and <header>.</p>`},
+	}
+	for _, tt := range tests {
+		if got := shiftHeadings(pandoc, tt.offset); got != tt.want {
+			t.Errorf("offset %d:\n%s\nwant\n%s", tt.offset, got, tt.want)
+		}
+	}
+
+	// The native converter agrees on the levels
+	for line, level := range map[string]int{"= Title =": 2, "== A ==": 2, "====== Deep ======": 6} {
+		if got, _ := parseHeader(line, 0); got != level {
+			t.Errorf("parseHeader(%q) = %d, want %d as in the shifted pandoc output", line, got, level)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// startDaemon runs the server again in the background with the same flags
+// minus -daemon, detached from the terminal, with its output appended to
+// logFile.
+func startDaemon(args []string, logFile string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("finding executable: %v", err)
+	}
+	var childArgs []string
+	for _, arg := range args {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && name == "daemon" {
+			continue
+		}
+		childArgs = append(childArgs, arg)
+	}
+
+	out, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening daemon log: %v", err)
+	}
+	defer out.Close()
+	cmd := exec.Command(exe, childArgs...)
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting daemon: %v", err)
+	}
+	fmt.Printf("Server running in the background (pid %d), logging to %s\n", cmd.Process.Pid, logFile)
+	return cmd.Process.Release()
+}
+
+// writePidfile records this process's ID in path, refusing to start when
+// it names a process that is still running, and removes it again when the
+// process is interrupted or terminated.
+func writePidfile(path string) error {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() && processAlive(pid) {
+			return fmt.Errorf("already running with pid %d (from %s)", pid, path)
+		}
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("writing pidfile: %v", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		removePidfile(path)
+		fmt.Printf("Received %v, shutting down\n", sig)
+		os.Exit(0)
+	}()
+	return nil
+}
+
+// removePidfile removes a pidfile written by writePidfile, if any.
+func removePidfile(path string) {
+	if path != "" {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachedProcAttr leaves the daemon's process attributes at their
+// defaults where sessions aren't available.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// processAlive reports whether a process with the given ID exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// dumpStream is one bzip2 stream of the dump and the articles the index
+// places in it.
+type dumpStream struct {
+	Start   int64 `json:"start"`
+	End     int64 `json:"end"` // 0 for the last stream, which runs to the end of the file
+	Size    int64 `json:"compressed_bytes"`
+	Pages   int   `json:"pages"`
+	entries []int // index positions, by page ID
+}
+
+// streamList groups the index by stream for /debug/streams, on first use.
+type streamList struct {
+	index     []IndexEntry
+	inputFile string
+
+	once    sync.Once
+	streams []dumpStream
+}
+
+func newStreamList(index []IndexEntry, inputFile string) *streamList {
+	return &streamList{index: index, inputFile: inputFile}
+}
+
+// get returns the streams in file order.
+func (l *streamList) get() []dumpStream {
+	l.once.Do(func() {
+		var fileSize int64
+		if info, err := os.Stat(l.inputFile); err == nil {
+			fileSize = info.Size()
+		}
+		byStart := make(map[int64]int)
+		for i, e := range l.index {
+			n, ok := byStart[e.Offsets.Start]
+			if !ok {
+				n = len(l.streams)
+				byStart[e.Offsets.Start] = n
+				l.streams = append(l.streams, dumpStream{Start: e.Offsets.Start, End: e.Offsets.End})
+			}
+			l.streams[n].entries = append(l.streams[n].entries, i)
+		}
+		sort.Slice(l.streams, func(i, j int) bool { return l.streams[i].Start < l.streams[j].Start })
+		for i := range l.streams {
+			s := &l.streams[i]
+			end := s.End
+			if end == 0 {
+				end = fileSize
+			}
+			s.Size = end - s.Start
+			s.Pages = len(s.entries)
+			sort.Slice(s.entries, func(a, b int) bool { return l.index[s.entries[a]].PageID < l.index[s.entries[b]].PageID })
+		}
+	})
+	return l.streams
+}
+
+// streamPage is an article in a stream, as /debug/stream/{n} reports it.
+type streamPage struct {
+	Title   string `json:"title"`
+	PageID  int    `json:"page_id"`
+	Indexed bool   `json:"indexed"`         // listed in the index at this stream
+	Found   bool   `json:"found"`           // present in the decompressed stream
+	Bytes   int    `json:"bytes,omitempty"` // length of the wikitext
+}
+
+type debugStreamResponse struct {
+	Number       int          `json:"number"`
+	Start        int64        `json:"start"`
+	End          int64        `json:"end"`
+	Compressed   int64        `json:"compressed_bytes"`
+	Decompressed int          `json:"decompressed_bytes"`
+	Duration     string       `json:"decompress_time"`
+	Error        string       `json:"error,omitempty"`
+	Pages        []streamPage `json:"pages"`
+}
+
+// handleDebugStreams serves /debug/streams: every stream of the dump with
+// its offsets, compressed size and number of indexed articles.
+func handleDebugStreams(w http.ResponseWriter, r *http.Request, streams *streamList) {
+	list := streams.get()
+	if r.FormValue("format") == "json" {
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var pages, bytes int64
+	for _, s := range list {
+		pages += int64(s.Pages)
+		bytes += s.Size
+	}
+	fmt.Fprintf(w, "%d streams, %d indexed articles, %d compressed bytes\n", len(list), pages, bytes)
+	fmt.Fprintf(w, "Articles in each stream are listed at /debug/stream/{n}\n\n")
+	fmt.Fprintf(w, "%8s %14s %14s %12s %6s\n", "n", "start", "end", "bytes", "pages")
+	for i, s := range list {
+		fmt.Fprintf(w, "%8d %14d %14d %12d %6d\n", i, s.Start, s.End, s.Size, s.Pages)
+	}
+}
+
+// handleDebugStream serves /debug/stream/{n}: the articles the index puts
+// in a stream, checked against the pages found by decompressing it, to
+// diagnose articles that fail to extract. Pages in the stream that the
+// index doesn't list, such as those in other namespaces, are shown too.
+func handleDebugStream(w http.ResponseWriter, r *http.Request, streams *streamList) {
+	list := streams.get()
+	n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/debug/stream/"))
+	if err != nil || n < 0 || n >= len(list) {
+		http.Error(w, fmt.Sprintf("expected /debug/stream/{n} with n from 0 to %d", len(list)-1), http.StatusNotFound)
+		return
+	}
+	s := list[n]
+	resp := debugStreamResponse{Number: n, Start: s.Start, End: s.End, Compressed: s.Size, Pages: []streamPage{}}
+
+	// Decompress directly rather than through the stream caches, so the
+	// dump itself is what gets checked
+	start := time.Now()
+	data, err := dump.ExtractBzip2Range(streams.inputFile, s.Start, s.End)
+	resp.Duration = time.Since(start).Round(time.Microsecond).String()
+	resp.Decompressed = len(data)
+	found := make(map[int]int)
+	var extra []streamPage
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		pages, err := dump.ExtractPages(data)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		for _, p := range pages {
+			found[p.ID] = len(p.Revision.Text)
+			extra = append(extra, streamPage{Title: p.Title, PageID: p.ID, Found: true, Bytes: len(p.Revision.Text)})
+		}
+	}
+	indexed := make(map[int]bool)
+	for _, i := range s.entries {
+		e := streams.index[i]
+		size, ok := found[e.PageID]
+		indexed[e.PageID] = true
+		resp.Pages = append(resp.Pages, streamPage{Title: e.Title, PageID: e.PageID, Indexed: true, Found: ok, Bytes: size})
+	}
+	for _, p := range extra {
+		if !indexed[p.PageID] {
+			resp.Pages = append(resp.Pages, p)
+		}
+	}
+
+	if r.FormValue("format") == "json" {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	end := "end of file"
+	if s.End != 0 {
+		end = strconv.FormatInt(s.End, 10)
+	}
+	fmt.Fprintf(w, "Stream %d: bytes %d to %s (%d compressed, %d decompressed in %s)\n", n, s.Start, end, s.Size, resp.Decompressed, resp.Duration)
+	if resp.Error != "" {
+		fmt.Fprintf(w, "Error: %s\n", resp.Error)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%10s %10s  %-18s %s\n", "page id", "bytes", "status", "title")
+	for _, p := range resp.Pages {
+		status := "ok"
+		switch {
+		case !p.Indexed:
+			status = "not indexed"
+		case !p.Found:
+			status = "missing from stream"
+		}
+		fmt.Fprintf(w, "%10d %10d  %-18s %s\n", p.PageID, p.Bytes, status, p.Title)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// rtlLanguages are the content languages MediaWiki writes right to left.
+var rtlLanguages = tagSet("ar arc arz azb bcc bgn bqi ckb dv fa glk he khw ks lki lrc luz mzn nqo pnb ps sd sdh skr ug ur yi")
+
+// languageDirection returns "rtl" for right-to-left languages and "ltr"
+// for the rest, going by the primary subtag of a code such as "fa-af".
+func languageDirection(lang string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(lang), "-")
+	if rtlLanguages[primary] {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+// wikiProjectSuffixes end the database names of Wikimedia wikis, such as
+// "arwiki" or "hewiktionary", after the language code.
+var wikiProjectSuffixes = []string{"wiktionary", "wikiquote", "wikivoyage", "wikibooks", "wikinews", "wikisource", "wikiversity", "wiki"}
+
+var languageCodeRe = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]+)*$`)
+
+// dumpLanguage returns the content language of a dump: the language its
+// header declares or, for dumps without one, the code its database name
+// starts with. It returns "" when neither says.
+func dumpLanguage(declared, dbName string) string {
+	if declared != "" {
+		return declared
+	}
+	for _, suffix := range wikiProjectSuffixes {
+		if code, ok := strings.CutSuffix(dbName, suffix); ok {
+			code = strings.ReplaceAll(code, "_", "-")
+			if code == "simple" {
+				return "en"
+			}
+			if languageCodeRe.MatchString(code) {
+				return code
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// directionSampleLetters is how many letters textDirection looks at.
+const directionSampleLetters = 2000
+
+// rtlScripts are the scripts written right to left.
+var rtlScripts = []*unicode.RangeTable{unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko, unicode.Samaritan, unicode.Mandaic}
+
+// textDirection guesses the direction of rendered HTML from the letters of
+// its text: "rtl" when most of the first directionSampleLetters are in a
+// right-to-left script, and "ltr" otherwise. It is used for dumps that
+// don't declare their language.
+func textDirection(body string) string {
+	rtl, ltr := 0, 0
+	inTag := false
+	for _, r := range body {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>':
+			inTag = false
+		case inTag || !unicode.IsLetter(r):
+		case unicode.In(r, rtlScripts...):
+			rtl++
+		default:
+			ltr++
+		}
+		if rtl+ltr >= directionSampleLetters {
+			break
+		}
+	}
+	if rtl > ltr {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+// articleLanguage returns the lang and dir attributes of an article from a
+// wiki: the wiki's language and its direction when the dump declares one,
+// and otherwise the direction guessed from the article's text. Articles on
+// a right-to-left wiki often quote long English titles in their
+// references, so a guess never overrides a declared language.
+func articleLanguage(info *DumpInfo, dir string) (string, string) {
+	if info.Lang != "" || dir == "" {
+		return info.Lang, info.Dir
+	}
+	return "", dir
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// templateSkeletonVersion is the layout version of the template sidecar.
+// A sidecar in another layout is discarded and refilled as pages render.
+const templateSkeletonVersion = 1
+
+// templateSkeletonMagic starts every template sidecar file.
+var templateSkeletonMagic = [8]byte{'W', 'S', 'T', 'M', 'P', 'L', 'S', 'K'}
+
+// templateSaveDelay is how long newly compiled templates wait to be
+// written to the sidecar, so a page using many is saved once.
+const templateSaveDelay = 5 * time.Second
+
+// maxTemplateRedirects bounds the redirect chain followed to a template.
+const maxTemplateRedirects = 5
+
+// templateSkeletonHeader precedes the compressed skeletons. Source is the
+// fingerprint of the dump the templates were read from.
+type templateSkeletonHeader struct {
+	Magic   [8]byte
+	Version uint32
+	Source  [16]byte
+}
+
+// templateSkeleton is a template page compiled for transclusion: the part
+// a transclusion sees, split into literal text and parameter slots, so
+// expanding it is a walk over the parts instead of another parse.
+type templateSkeleton struct {
+	Redirect string // lowercase name of the target, for redirect pages
+	Parts    []skeletonPart
+}
+
+// skeletonPart is literal text, or a {{{parameter|default}}} slot when
+// Text is empty.
+type skeletonPart struct {
+	Text       string
+	Param      string // name as written
+	HasDefault bool
+	Default    []skeletonPart
+}
+
+// templateStore expands templates from their pages in the dump. Pages are
+// read once, compiled to skeletons and kept by page ID, in memory and in a
+// sidecar next to the index cache, so later renders and restarts don't
+// read and parse them again.
+type templateStore struct {
+	inputFile string
+	file      string
+	source    [16]byte
+	byName    map[string]IndexEntry // lowercase name without namespace prefix
+	streams   *streamCaches
+	log       io.Writer
+
+	mu        sync.RWMutex
+	skeletons map[int]*templateSkeleton
+	unsaved   int
+}
+
+// templateSidecarFile returns the template sidecar path for an index file.
+func templateSidecarFile(indexFilename string) string {
+	return indexFilename + ".templates"
+}
+
+// newTemplateStore indexes the template pages by name and loads the
+// skeletons saved by earlier runs. Template pages are read through streams,
+// and failures to read or save them are logged to log.
+func newTemplateStore(inputFile, indexFilename string, templates []IndexEntry, streams *streamCaches, log io.Writer) (*templateStore, error) {
+	fp, err := fileFingerprint(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	t := &templateStore{
+		inputFile: inputFile,
+		file:      templateSidecarFile(indexFilename),
+		byName:    make(map[string]IndexEntry, len(templates)),
+		skeletons: make(map[int]*templateSkeleton),
+		streams:   streams,
+		log:       log,
+	}
+	copy(t.source[:], fp)
+	for _, e := range templates {
+		_, name, _ := strings.Cut(e.Title, ":")
+		t.byName[strings.ToLower(name)] = e
+	}
+	if err := t.load(); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(t.log, "Ignoring template sidecar: %v\n", err)
+	}
+	return t, nil
+}
+
+// Len returns the number of template pages in the dump.
+func (t *templateStore) Len() int {
+	return len(t.byName)
+}
+
+// Cached returns the number of compiled templates.
+func (t *templateStore) Cached() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.skeletons)
+}
+
+// expand returns the output of a template from the dump, or false if the
+// dump has no such template or its page can't be read.
+func (t *templateStore) expand(name string, args []string) (string, bool) {
+	for i := 0; i <= maxTemplateRedirects; i++ {
+		skeleton, ok := t.skeleton(name)
+		if !ok {
+			return "", false
+		}
+		if skeleton.Redirect == "" {
+			return expandSkeleton(skeleton.Parts, templateParams(args)), true
+		}
+		name = skeleton.Redirect
+	}
+	return "", false
+}
+
+// skeleton returns the compiled template with the given name, reading and
+// compiling its page on first use.
+func (t *templateStore) skeleton(name string) (*templateSkeleton, bool) {
+	entry, ok := t.byName[name]
+	if !ok {
+		return nil, false
+	}
+	t.mu.RLock()
+	skeleton, ok := t.skeletons[entry.PageID]
+	t.mu.RUnlock()
+	if ok {
+		return skeleton, true
+	}
+
+	xmlData, err := t.streams.extract(t.inputFile, entry.Offsets)
+	if err != nil {
+		fmt.Fprintf(t.log, "Warning: reading %s: %v\n", entry.Title, err)
+		return nil, false
+	}
+	text, err := dump.ExtractPageText(xmlData, entry.PageID)
+	if err != nil {
+		fmt.Fprintf(t.log, "Warning: reading %s: %v\n", entry.Title, err)
+		return nil, false
+	}
+	skeleton = compileTemplate(text)
+
+	t.mu.Lock()
+	t.skeletons[entry.PageID] = skeleton
+	t.unsaved++
+	if t.unsaved == 1 {
+		time.AfterFunc(templateSaveDelay, t.saveLater)
+	}
+	t.mu.Unlock()
+	return skeleton, true
+}
+
+var templateRedirectRe = regexp.MustCompile(`(?i)^\s*#redirect\s*\[\[\s*(?:[^:\]|]+:)?([^\]|#]+)`)
+
+// compileTemplate turns the wikitext of a template page into a skeleton.
+func compileTemplate(text string) *templateSkeleton {
+	if m := templateRedirectRe.FindStringSubmatch(text); m != nil {
+		name := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(m[1], "_", " ")))
+		return &templateSkeleton{Redirect: name}
+	}
+	return &templateSkeleton{Parts: parseSkeleton(transcludedPart(text))}
+}
+
+// parseSkeleton splits template wikitext into literal text and parameter
+// slots. Defaults may hold parameters and templates of their own.
+func parseSkeleton(text string) []skeletonPart {
+	var parts []skeletonPart
+	var literal strings.Builder
+	for len(text) > 0 {
+		open := strings.Index(text, "{{{")
+		if open < 0 {
+			literal.WriteString(text)
+			break
+		}
+		end, bar := matchParam(text[open:])
+		if end < 0 {
+			literal.WriteString(text[:open+3])
+			text = text[open+3:]
+			continue
+		}
+		literal.WriteString(text[:open])
+		if literal.Len() > 0 {
+			parts = append(parts, skeletonPart{Text: literal.String()})
+			literal.Reset()
+		}
+		inner := text[open+3 : open+end-3]
+		part := skeletonPart{Param: strings.TrimSpace(inner)}
+		if bar >= 0 {
+			part.Param = strings.TrimSpace(inner[:bar-4])
+			part.HasDefault = true
+			part.Default = parseSkeleton(inner[bar-3:])
+		}
+		parts = append(parts, part)
+		text = text[open+end:]
+	}
+	if literal.Len() > 0 {
+		parts = append(parts, skeletonPart{Text: literal.String()})
+	}
+	return parts
+}
+
+// matchParam finds the end of the parameter starting text, which begins
+// with {{{, skipping nested parameters and templates. It returns the
+// length of the parameter and the position just past the pipe starting
+// its default, or -1 for either.
+func matchParam(text string) (int, int) {
+	var open []int // brace counts of the nested parameters and templates
+	bar := -1
+	for i := 3; i < len(text); {
+		top := 0
+		if len(open) > 0 {
+			top = open[len(open)-1]
+		}
+		switch {
+		case strings.HasPrefix(text[i:], "{{{"):
+			open = append(open, 3)
+			i += 3
+		case strings.HasPrefix(text[i:], "{{"):
+			open = append(open, 2)
+			i += 2
+		case top == 2 && strings.HasPrefix(text[i:], "}}"):
+			open = open[:len(open)-1]
+			i += 2
+		case strings.HasPrefix(text[i:], "}}}"):
+			if top == 0 {
+				return i + 3, bar
+			}
+			open = open[:len(open)-1]
+			i += 3
+		case text[i] == '|' && top == 0 && bar < 0:
+			bar = i + 1
+			i++
+		default:
+			i++
+		}
+	}
+	return -1, -1
+}
+
+// expandSkeleton fills a skeleton's parameter slots the way
+// substituteParams does: with the argument, else the default, else the
+// parameter as written, encoded so it isn't expanded as a template.
+func expandSkeleton(parts []skeletonPart, params map[string]string) string {
+	var b strings.Builder
+	for _, p := range parts {
+		if p.Text != "" {
+			b.WriteString(p.Text)
+			continue
+		}
+		if v, ok := params[strings.ToLower(p.Param)]; ok {
+			b.WriteString(v)
+		} else if p.HasDefault {
+			b.WriteString(expandSkeleton(p.Default, params))
+		} else {
+			b.WriteString("&#123;&#123;&#123;" + p.Param + "&#125;&#125;&#125;")
+		}
+	}
+	return b.String()
+}
+
+// load reads the skeletons saved for this dump.
+func (t *templateStore) load() error {
+	f, err := os.Open(t.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var header templateSkeletonHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("reading header: %v", err)
+	}
+	if header.Magic != templateSkeletonMagic || header.Version != templateSkeletonVersion {
+		return fmt.Errorf("unknown template sidecar format")
+	}
+	if header.Source != t.source {
+		return fmt.Errorf("template sidecar was built from a different dump")
+	}
+	var skeletons map[int]*templateSkeleton
+	if err := decodeGobGzip(r, &skeletons); err != nil {
+		return fmt.Errorf("decoding template sidecar: %v", err)
+	}
+	t.skeletons = skeletons
+	return nil
+}
+
+// save atomically writes the skeletons compiled so far, if any are new.
+func (t *templateStore) save() error {
+	t.mu.RLock()
+	if t.unsaved == 0 {
+		t.mu.RUnlock()
+		return nil
+	}
+	var buf bytes.Buffer
+	header := templateSkeletonHeader{Magic: templateSkeletonMagic, Version: templateSkeletonVersion, Source: t.source}
+	err := binary.Write(&buf, binary.LittleEndian, header)
+	if err == nil {
+		err = encodeGobGzip(&buf, t.skeletons)
+	}
+	saved := t.unsaved
+	t.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("encoding template sidecar: %v", err)
+	}
+
+	tmp := t.file + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing template sidecar: %v", err)
+	}
+	if err := os.Rename(tmp, t.file); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.unsaved -= saved
+	if t.unsaved > 0 {
+		time.AfterFunc(templateSaveDelay, t.saveLater)
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// saveLater is called a while after templates are compiled to save them.
+func (t *templateStore) saveLater() {
+	if err := t.save(); err != nil {
+		fmt.Fprintf(t.log, "Warning: %v\n", err)
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// embedCSP is sent with embedded articles. They carry no scripts or
+// plugins, so everything but inline styles and images is refused.
+const embedCSP = "default-src 'none'; style-src 'unsafe-inline'; img-src * data:; base-uri 'none'; form-action 'none'"
+
+var embedLinkRe = regexp.MustCompile(`\b(href|src)="([^"]*)"`)
+
+// absoluteLinks rewrites the relative links in rendered article HTML so
+// they still point at this server once the HTML is placed on another site.
+// Article links are relative to prefix, the path the wiki is served under.
+func absoluteLinks(r *http.Request, prefix, content string) string {
+	return embedLinkRe.ReplaceAllStringFunc(content, func(m string) string {
+		parts := embedLinkRe.FindStringSubmatch(m)
+		attr, link := parts[1], html.UnescapeString(parts[2])
+		switch {
+		case link == "" || strings.HasPrefix(link, "#") || strings.HasPrefix(link, "mailto:") ||
+			strings.Contains(link, "//") || strings.HasPrefix(link, "data:"):
+			// Fragments and external links are left alone.
+			return m
+		case strings.HasPrefix(link, "/"):
+			link = absoluteURL(r, strings.TrimPrefix(link, requestServer(r).cfg.BasePath))
+		default:
+			link = absoluteURL(r, prefix+link)
+		}
+		return attr + `="` + html.EscapeString(link) + `"`
+	})
+}
+
+// handleEmbed serves /embed/{title}: the rendered article body alone,
+// without the page chrome, for intranet portals that frame or include
+// articles. Redirects stay under /embed/.
+func handleEmbed(w http.ResponseWriter, r *http.Request, index []IndexEntry, titles titleSet, pipeline *Pipeline, cache *renderCache) {
+	title, err := titleFromPath(r.URL.Path, "/embed/")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s := requestServer(r)
+	start := time.Now()
+	defer func() {
+		fmt.Fprintf(s.log, "[%s] %s %s %v\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.Path, time.Since(start))
+	}()
+	tr := s.tracer.start(r.Method + " " + r.URL.Path)
+	defer tr.Finish()
+
+	w.Header().Set("Content-Security-Policy", embedCSP+frameAncestors(s.cfg.EmbedAncestors))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	entry := findPageByTitle(index, titles, title)
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+	page, err := renderEntry(pipeline, entry, cache, nil, tr)
+	if err == nil && page.Redirect != "" {
+		target, fragment, loopErr := followRedirects(index, titles, pipeline, cache, nil, tr, entry, page.Redirect)
+		if loopErr != nil {
+			http.Error(w, loopErr.Error(), http.StatusLoopDetected)
+			return
+		}
+		location := absoluteURL(r, "/embed/"+strings.ReplaceAll(target, " ", "_"))
+		if fragment != "" {
+			location += "#" + fragment
+		}
+		http.Redirect(w, r, location, http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	canonical := absoluteURL(r, "/wiki/"+strings.ReplaceAll(entry.Title, " ", "_"))
+	fmt.Fprintf(w, "<article class=\"wikiseek-embed\" data-title=\"%s\" data-source=\"%s\">\n",
+		html.EscapeString(entry.Title), html.EscapeString(canonical))
+	fmt.Fprint(w, absoluteLinks(r, "/wiki/", page.Content))
+	fmt.Fprint(w, "\n</article>\n")
+}
+
+// frameAncestors limits which sites may frame embedded articles to
+// ancestors, from -embed-ancestors. Without it any site may.
+func frameAncestors(ancestors string) string {
+	if ancestors == "" {
+		return ""
+	}
+	return "; frame-ancestors " + ancestors
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// entityRe matches a named or numeric character reference. MediaWiki only
+// recognizes references that end with a semicolon.
+var entityRe = regexp.MustCompile(`^&(#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+
+// markupEntities are the canonical references for characters that mean
+// something in HTML or wikitext. Authors write them as references so they
+// show literally (&#91;&#91; for "[["), so they are kept encoded.
+var markupEntities = map[rune]string{
+	'<': "&lt;", '>': "&gt;", '&': "&amp;", '"': "&quot;", '\'': "&#39;",
+	'[': "&#91;", ']': "&#93;", '{': "&#123;", '}': "&#125;", '|': "&#124;",
+	'=': "&#61;", '*': "&#42;", '#': "&#35;", ':': "&#58;", ';': "&#59;", '~': "&#126;",
+}
+
+// normalizeEntities puts the character references of wikitext into one
+// form before conversion: references to ordinary characters (&ndash;,
+// &#8211;, &nbsp;) are decoded, references to markup characters are
+// rewritten in their canonical form, and stray ampersands and less-than
+// signs that don't start a reference or a tag are escaped. The result can
+// be copied into HTML as is, and later passes that escape text should
+// unescape it first so nothing is escaped twice.
+func normalizeEntities(text string) string {
+	if !strings.ContainsAny(text, "&<") {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '&':
+			ref := entityRe.FindString(text[i:])
+			decoded := html.UnescapeString(ref)
+			if ref == "" || decoded == ref {
+				b.WriteString("&amp;")
+				i++
+				continue
+			}
+			if r, size := utf8.DecodeRuneInString(decoded); size == len(decoded) {
+				if canonical, ok := markupEntities[r]; ok {
+					b.WriteString(canonical)
+					i += len(ref)
+					continue
+				}
+			}
+			b.WriteString(decoded)
+			i += len(ref)
+		case c == '<' && !startsTag(text[i+1:]):
+			b.WriteString("&lt;")
+			i++
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// startsTag reports whether the text after a "<" is the rest of an HTML
+// tag, comment or closing tag rather than a literal less-than sign.
+func startsTag(rest string) bool {
+	if rest == "" {
+		return false
+	}
+	c := rest[0]
+	return c == '/' || c == '!' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// escapeText escapes text for HTML that may already contain character
+// references, such as template parameters, without escaping them twice.
+func escapeText(s string) string {
+	return html.EscapeString(html.UnescapeString(s))
+}
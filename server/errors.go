@@ -0,0 +1,95 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// Errors an article request can end in. Causes are wrapped around them
+// with %w, so errors.Is picks the status code and the hint of the error
+// page.
+var (
+	ErrPageNotFound    = errors.New("no article with this title")
+	ErrCorruptStream   = errors.New("the dump stream holding this article is damaged")
+	ErrDumpUnavailable = errors.New("the dump file can't be read")
+	ErrRenderTimeout   = errors.New("rendering this article took too long")
+)
+
+// dumpRetryAfter is the Retry-After sent with ErrDumpUnavailable, which
+// is often passing, like a disk being remounted.
+const dumpRetryAfter = "30"
+
+// ErrorView is the page shown instead of an article that can't be shown.
+type ErrorView struct {
+	Layout
+	Title       string // of the article asked for
+	Status      int
+	Heading     string
+	Message     string
+	Hint        string
+	Suggestions []string // spelling corrections of a missing title
+}
+
+// errorStatus returns the HTTP status code for an article error.
+func errorStatus(err error) int {
+	var loop *redirectLoopError
+	switch {
+	case errors.Is(err, ErrPageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrRenderTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrCorruptStream):
+		// The dump is where the article comes from, and it sent bad data
+		return http.StatusBadGateway
+	case errors.Is(err, ErrDumpUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &loop):
+		return http.StatusLoopDetected
+	}
+	return http.StatusInternalServerError
+}
+
+// newErrorView explains an article error, with a hint at what to do
+// about it.
+func newErrorView(wiki *wikiSource, title string, err error) ErrorView {
+	view := ErrorView{Layout: Layout{Dump: wiki.Info, Query: title}, Title: title, Status: errorStatus(err), Message: err.Error()}
+	var loop *redirectLoopError
+	switch {
+	case errors.Is(err, ErrPageNotFound):
+		view.Heading = "Article not found"
+		view.Message = fmt.Sprintf("There is no article titled “%s” in this dump.", title)
+		view.Hint = "Check the spelling, or search for the title to find articles with similar names."
+		view.Suggestions = wiki.Speller.suggest(title)
+	case errors.Is(err, ErrCorruptStream):
+		view.Heading = "Damaged dump"
+		view.Hint = "The dump file may be damaged, or the index may not belong to it. Check them with “wikiseek verify”, and rebuild the stream offsets with “wikiseek index repair” if the index is at fault."
+	case errors.Is(err, ErrDumpUnavailable):
+		view.Heading = "Dump unavailable"
+		view.Hint = "The dump file couldn't be opened or read. Check it is still at the path given with -file, readable by the server, and on a mounted disk, then try again."
+	case errors.Is(err, ErrRenderTimeout):
+		view.Heading = "Rendering timed out"
+		view.Hint = "The article goes on rendering in the background, so reloading in a moment may show it. Otherwise try the native renderer with ?renderer=native, or raise -render-timeout."
+	case errors.As(err, &loop):
+		view.Heading = "Redirect loop"
+		view.Hint = "The redirects lead back to one another, so there is no article to land on. Open one of the pages in the chain from search to read it."
+	default:
+		view.Heading = "This article can't be shown"
+		view.Hint = "If another renderer is installed, ?renderer=native or ?renderer=pandoc may work."
+	}
+	return view
+}
+
+// writeErrorPage answers an article request with the error page.
+func writeErrorPage(w http.ResponseWriter, r *http.Request, tmpl *template.Template, wiki *wikiSource, title string, err error) {
+	view := newErrorView(wiki, title, err)
+	fmt.Fprintf(requestServer(r).log, "[%s] %d %s: %s: %v\n", time.Now().Format("2006-01-02 15:04:05"), view.Status, http.StatusText(view.Status), r.URL.Path, err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if view.Status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", dumpRetryAfter)
+	}
+	w.WriteHeader(view.Status)
+	tmpl.Execute(w, view)
+}
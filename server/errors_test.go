@@ -0,0 +1,29 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{ErrPageNotFound, http.StatusNotFound},
+		{fmt.Errorf("%w: extracting data range: bad bzip2 data", ErrCorruptStream), http.StatusBadGateway},
+		{fmt.Errorf("%w: %v", ErrDumpUnavailable, fmt.Errorf("%w: opening file: permission denied", dump.ErrDumpUnreadable)), http.StatusServiceUnavailable},
+		{fmt.Errorf("%w after 1m0s", ErrRenderTimeout), http.StatusGatewayTimeout},
+		{&redirectLoopError{}, http.StatusLoopDetected},
+		{errors.New("pandoc exited with status 1"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := errorStatus(tt.err); got != tt.want {
+			t.Errorf("errorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// apiETags gives /api responses strong ETags and answers If-None-Match with
+// 304 Not Modified, so clients syncing many pages only download what
+// changed. Every tag starts with the dump's fingerprint, so loading a new
+// snapshot invalidates them all.
+type apiETags struct {
+	dumpKey string // fileFingerprint of the dump, "" when it couldn't be read
+}
+
+// stable wraps a handler whose output depends only on the dump and the
+// request, such as raw wikitext. The tag is derived from the path and
+// query, so a matching request is answered before the handler runs.
+func (e apiETags) stable(h http.HandlerFunc) http.HandlerFunc {
+	if e.dumpKey == "" {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h(w, r)
+			return
+		}
+		etag := e.tag(r.URL.Path + "?" + requestParams(r))
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		// Set up front for http.ServeContent's If-Range handling
+		w.Header().Set("ETag", etag)
+		h(&etagWriter{ResponseWriter: w}, r)
+	}
+}
+
+// content wraps a handler whose output can change while the dump doesn't,
+// for instance as page metadata is collected. The response is buffered and
+// the tag derived from its body as well.
+func (e apiETags) content(h http.HandlerFunc) http.HandlerFunc {
+	if e.dumpKey == "" {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h(w, r)
+			return
+		}
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		h(buf, r)
+		if buf.status == http.StatusOK {
+			sum := sha256.Sum256(buf.body.Bytes())
+			etag := e.tag(hex.EncodeToString(sum[:]))
+			w.Header().Set("ETag", etag)
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+	}
+}
+
+// tag formats a strong ETag from the dump fingerprint and a key.
+func (e apiETags) tag(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return `"` + e.dumpKey + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// requestParams is the request's query in a canonical order, leaving out
+// the API token, which doesn't change the response.
+func requestParams(r *http.Request) string {
+	query := r.URL.Query()
+	query.Del("token")
+	params := query.Encode()
+	// Negotiated formats make the Accept header a parameter too
+	if accept := r.Header.Get("Accept"); accept != "" {
+		params += "#" + accept
+	}
+	return params
+}
+
+// etagMatches reports whether an If-None-Match header lists etag or is
+// "*". Weak validators match their strong counterpart, as RFC 9110 asks for
+// If-None-Match.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter drops the ETag of a stable response that failed, so errors
+// aren't cached.
+type etagWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status != http.StatusOK && status != http.StatusPartialContent && status != http.StatusNotModified {
+		w.Header().Del("ETag")
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// bufferedResponse holds a response until its ETag is known.
+type bufferedResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if !b.wroteHeader {
+		b.status = status
+		b.wroteHeader = true
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wroteHeader = true
+	return b.body.Write(p)
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fetchUserAgent identifies downloads to the mirror, as Wikimedia asks of
+// automated clients.
+const fetchUserAgent = "wikiseek (https://github.com/xanderstrike/wikiseek)"
+
+// fetchProgressStep is how many bytes are downloaded between progress
+// lines.
+const fetchProgressStep = 256 << 20
+
+// runFetch implements "wikiseek fetch": it downloads a multistream dump
+// and its index, resuming partial downloads, and checks them against the
+// mirror's SHA-1 sums.
+func runFetch(args []string) {
+	fs := newCommandFlags("fetch")
+	wiki := fs.String("wiki", "enwiki", "Database name of the wiki, e.g. enwiki, simplewiki or dewiktionary")
+	date := fs.String("date", "latest", "Dump date as YYYYMMDD, or latest")
+	dir := fs.String("dir", ".", "Directory to save the files in")
+	mirror := fs.String("mirror", "https://dumps.wikimedia.org", "Base URL of the dump mirror")
+	skipCheck := fs.Bool("no-verify", false, "Skip the SHA-1 check of the downloaded files")
+	fs.Parse(args)
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	base := strings.TrimRight(*mirror, "/") + "/" + *wiki + "/" + *date + "/"
+	prefix := *wiki + "-" + *date + "-"
+	names := []string{
+		prefix + "pages-articles-multistream-index.txt.bz2",
+		prefix + "pages-articles-multistream.xml.bz2",
+	}
+	client := &http.Client{}
+
+	var sums map[string]string
+	if !*skipCheck {
+		var err error
+		if sums, err = fetchSHA1Sums(client, base+prefix+"sha1sums.txt"); err != nil {
+			fmt.Printf("Warning: not verifying downloads: %v\n", err)
+		}
+	}
+	for _, name := range names {
+		path := filepath.Join(*dir, name)
+		if _, err := os.Stat(path); err == nil {
+			fmt.Printf("%s already downloaded\n", path)
+		} else if err := fetchFile(client, base+name, path); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if want, ok := sums[name]; ok {
+			fmt.Printf("Verifying %s\n", name)
+			if err := checkSHA1(path, want); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	fmt.Println("Start the server with:")
+	fmt.Printf("  wikiseek serve -file %s -index %s\n", filepath.Join(*dir, names[1]), filepath.Join(*dir, names[0]))
+}
+
+// fetchSHA1Sums downloads a dump's sha1sums.txt, mapping file names to
+// their hex digests.
+func fetchSHA1Sums(client *http.Client, url string) (map[string]string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching checksums: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching checksums: %s", resp.Status)
+	}
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 {
+			sums[fields[1]] = strings.ToLower(fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading checksums: %v", err)
+	}
+	return sums, nil
+}
+
+// fetchFile downloads url to path through path.part, resuming from the
+// end of a .part file left by an interrupted download.
+func fetchFile(client *http.Client, url, path string) error {
+	partial := path + ".part"
+	out, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("creating %s: %v", partial, err)
+	}
+	defer out.Close()
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", partial, err)
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("downloading %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		fmt.Printf("Resuming %s at %s\n", url, formatBytes(offset))
+	case http.StatusOK:
+		// The mirror ignored the range, or there was nothing to resume
+		if offset > 0 {
+			if err := out.Truncate(0); err != nil {
+				return fmt.Errorf("truncating %s: %v", partial, err)
+			}
+			if _, err := out.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("truncating %s: %v", partial, err)
+			}
+		}
+		offset = 0
+		fmt.Printf("Downloading %s\n", url)
+	default:
+		return fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	written := offset
+	next := (written/fetchProgressStep + 1) * fetchProgressStep
+	start := time.Now()
+	buf := make([]byte, 1<<20)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := out.Write(buf[:n]); err != nil {
+				return fmt.Errorf("writing %s: %v", partial, err)
+			}
+			written += int64(n)
+			if written >= next {
+				next += fetchProgressStep
+				printFetchProgress(written, offset, total, start)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("downloading %s: %v (run fetch again to resume)", url, err)
+		}
+	}
+	if total >= 0 && written != total {
+		return fmt.Errorf("downloading %s: got %d of %d bytes (run fetch again to resume)", url, written, total)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("writing %s: %v", partial, err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return fmt.Errorf("saving %s: %v", path, err)
+	}
+	fmt.Printf("Saved %s (%s)\n", path, formatBytes(written))
+	return nil
+}
+
+func printFetchProgress(written, resumed, total int64, start time.Time) {
+	rate := float64(written-resumed) / time.Since(start).Seconds()
+	if total > 0 {
+		fmt.Printf("  %s of %s (%.0f%%), %s/s\n", formatBytes(written), formatBytes(total), 100*float64(written)/float64(total), formatBytes(int64(rate)))
+		return
+	}
+	fmt.Printf("  %s, %s/s\n", formatBytes(written), formatBytes(int64(rate)))
+}
+
+// checkSHA1 compares a file's SHA-1 digest with the expected hex digest.
+func checkSHA1(path, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening %s: %v", path, err)
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("reading %s: %v", path, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%s has SHA-1 %s, expected %s; delete it and fetch again", path, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,546 @@
+package server
+
+import (
+	"compress/gzip"
+	"container/list"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// Posting records where a term occurs within one page
+type Posting struct {
+	PageID    int
+	Positions []uint32
+}
+
+// fullTextManifest tracks which streams have been flushed to disk so an
+// interrupted build can resume where it left off.
+type fullTextManifest struct {
+	Shards    int
+	Segments  []fullTextSegment
+	Complete  bool
+	Tokenizer string // tokenizerWords or tokenizerCJK
+}
+
+type fullTextSegment struct {
+	ID      int
+	Streams []int64
+}
+
+const (
+	fullTextShards   = 64
+	maxTokenLength   = 40
+	manifestFileName = "manifest.gob"
+
+	// maxLoadedShards is how many shards a FullTextIndex keeps decoded in
+	// memory, an eighth of the index
+	maxLoadedShards = 8
+)
+
+// fullTextDir returns the directory holding the full-text index for an
+// index file.
+func fullTextDir(indexFilename string) string {
+	return indexFilename + ".fulltext"
+}
+
+// tokenize splits text into lowercase words, dropping punctuation.
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := words[:0]
+	for _, w := range words {
+		if len(w) <= maxTokenLength {
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+func termShard(term string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(term))
+	return int(h.Sum32() % uint32(shards))
+}
+
+func runIndexFulltext(args []string) {
+	fs := newCommandFlags("index fulltext")
+	inputFile := fs.String("file", "", "Path to multistream bzip2 file")
+	indexPath := fs.String("index", "", "Path to index file")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of streams to decompress in parallel")
+	memoryMB := fs.Int("memory-mb", 512, "Approximate postings memory before flushing a segment to disk")
+	cjkMode := fs.String("cjk", "auto", "Index Chinese and Japanese text as character pairs: on, off, or auto to follow the dump language")
+	rebuild := fs.Bool("rebuild", false, "Discard a complete index and build it again. A complete index isn't updated incrementally, so use this after the dump changes")
+	fs.Parse(args)
+
+	if *inputFile == "" || *indexPath == "" {
+		fmt.Println("Error: both -file and -index arguments are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	loaded, err := loadIndex(*indexPath, loadNamespaces(*inputFile, os.Stdout), os.Stdout)
+	if err != nil {
+		fmt.Printf("Error loading index: %v\n", err)
+		os.Exit(1)
+	}
+	index := loaded.Entries
+
+	tokenizer := tokenizerWords
+	switch *cjkMode {
+	case "on":
+		tokenizer = tokenizerCJK
+	case "off":
+	case "auto":
+		if info, err := dump.ReadSiteInfo(*inputFile); err == nil && isCJKLanguage(info.Lang) {
+			fmt.Printf("Using the CJK tokenizer for %s text\n", info.Lang)
+			tokenizer = tokenizerCJK
+		}
+	default:
+		fmt.Printf("Error: unknown -cjk mode %q (want on, off or auto)\n", *cjkMode)
+		os.Exit(1)
+	}
+
+	dir := fullTextDir(*indexPath)
+	if *rebuild {
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Printf("Error removing the old full-text index: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := buildFullTextIndex(*inputFile, index, dir, tokenizer, *workers, int64(*memoryMB)<<20); err != nil {
+		fmt.Printf("Error building full-text index: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// buildFullTextIndex tokenizes every indexed page and writes a sharded
+// positional inverted index to dir. Postings are flushed to numbered
+// segments whenever memoryLimit is reached and merged into the final shards
+// at the end.
+func buildFullTextIndex(inputFile string, index []IndexEntry, dir, tokenizer string, workers int, memoryLimit int64) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating index directory: %v", err)
+	}
+
+	manifest, err := loadFullTextManifest(dir)
+	if err == nil && manifest.Tokenizer != tokenizer {
+		fmt.Printf("Full-text index in %s was built with another tokenizer, rebuilding\n", dir)
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		manifest = &fullTextManifest{Shards: fullTextShards, Tokenizer: tokenizer}
+	}
+	if manifest.Complete {
+		fmt.Println("Full-text index is already complete; run with -rebuild to index the dump again")
+		return nil
+	}
+
+	done := make(map[int64]bool)
+	for _, seg := range manifest.Segments {
+		for _, start := range seg.Streams {
+			done[start] = true
+		}
+	}
+
+	wanted := make(map[int]bool, len(index))
+	var streams []*OffsetPair
+	seen := make(map[*OffsetPair]bool)
+	for i := range index {
+		wanted[index[i].PageID] = true
+		if p := index[i].Offsets; !seen[p] && !done[p.Start] {
+			seen[p] = true
+			streams = append(streams, p)
+		}
+	}
+	if len(done) > 0 {
+		fmt.Printf("Resuming full-text build: %d streams done, %d remaining\n", len(done), len(streams))
+	}
+
+	type streamDocs struct {
+		start int64
+		pages map[int][]string
+		err   error
+	}
+
+	jobs := make(chan *OffsetPair)
+	results := make(chan streamDocs)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				docs := streamDocs{start: p.Start, pages: make(map[int][]string)}
+				data, err := dump.ExtractBzip2Range(inputFile, p.Start, p.End)
+				if err != nil {
+					docs.err = err
+					results <- docs
+					continue
+				}
+				pages, err := dump.ExtractPages(data)
+				if err != nil {
+					docs.err = err
+					results <- docs
+					continue
+				}
+				for _, page := range pages {
+					if wanted[page.ID] {
+						docs.pages[page.ID] = manifest.tokenize(plainText(page.Revision.Text))
+					}
+				}
+				results <- docs
+			}
+		}()
+	}
+	go func() {
+		for _, p := range streams {
+			jobs <- p
+		}
+		close(jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	postings := make(map[string][]Posting)
+	var pending []int64
+	var size int64
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		id := len(manifest.Segments)
+		if err := writeFullTextShards(dir, fmt.Sprintf("segment-%04d", id), manifest.Shards, postings); err != nil {
+			return err
+		}
+		manifest.Segments = append(manifest.Segments, fullTextSegment{ID: id, Streams: pending})
+		if err := saveFullTextManifest(dir, manifest); err != nil {
+			return err
+		}
+		postings = make(map[string][]Posting)
+		pending, size = nil, 0
+		return nil
+	}
+
+	// Failed streams are left out of the segments, so the next run retries
+	// them instead of recording their pages as indexed
+	count, failed := 0, 0
+	for docs := range results {
+		count++
+		if count%1000 == 0 {
+			fmt.Print(".")
+		}
+		if docs.err != nil {
+			fmt.Printf("\nWarning: skipping stream at %d: %v\n", docs.start, docs.err)
+			failed++
+			continue
+		}
+		for pageID, tokens := range docs.pages {
+			positions := make(map[string][]uint32)
+			for pos, tok := range tokens {
+				positions[tok] = append(positions[tok], uint32(pos))
+			}
+			for tok, pos := range positions {
+				if _, ok := postings[tok]; !ok {
+					size += int64(len(tok)) + 48
+				}
+				postings[tok] = append(postings[tok], Posting{PageID: pageID, Positions: pos})
+				size += int64(len(pos))*4 + 32
+			}
+		}
+		pending = append(pending, docs.start)
+		if size >= memoryLimit {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d streams could not be read; run again to retry them", failed, count)
+	}
+	fmt.Printf("\nTokenized %d streams into %d segments, merging\n", count, len(manifest.Segments))
+
+	if err := mergeFullTextSegments(dir, manifest); err != nil {
+		return err
+	}
+	manifest.Complete = true
+	if err := saveFullTextManifest(dir, manifest); err != nil {
+		return err
+	}
+	for _, seg := range manifest.Segments {
+		for shard := 0; shard < manifest.Shards; shard++ {
+			os.Remove(shardFileName(dir, fmt.Sprintf("segment-%04d", seg.ID), shard))
+		}
+	}
+	return nil
+}
+
+func shardFileName(dir, prefix string, shard int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s-shard-%02d.gob.gz", prefix, shard))
+}
+
+func writeFullTextShards(dir, prefix string, shards int, postings map[string][]Posting) error {
+	split := make([]map[string][]Posting, shards)
+	for i := range split {
+		split[i] = make(map[string][]Posting)
+	}
+	for term, list := range postings {
+		split[termShard(term, shards)][term] = list
+	}
+	for i, part := range split {
+		if err := writeGobGzip(shardFileName(dir, prefix, i), part); err != nil {
+			return fmt.Errorf("writing shard %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// mergeFullTextSegments combines segment shards into the final shard files
+// one shard at a time, keeping memory bounded by the largest shard.
+func mergeFullTextSegments(dir string, manifest *fullTextManifest) error {
+	for shard := 0; shard < manifest.Shards; shard++ {
+		merged := make(map[string][]Posting)
+		for _, seg := range manifest.Segments {
+			var part map[string][]Posting
+			if err := readGobGzip(shardFileName(dir, fmt.Sprintf("segment-%04d", seg.ID), shard), &part); err != nil {
+				return fmt.Errorf("reading segment %d shard %d: %v", seg.ID, shard, err)
+			}
+			for term, list := range part {
+				merged[term] = append(merged[term], list...)
+			}
+		}
+		for _, list := range merged {
+			sort.Slice(list, func(i, j int) bool { return list[i].PageID < list[j].PageID })
+		}
+		if err := writeGobGzip(shardFileName(dir, "final", shard), merged); err != nil {
+			return fmt.Errorf("writing shard %d: %v", shard, err)
+		}
+	}
+	return nil
+}
+
+// tokenize splits text into the tokens the index holds.
+func (m *fullTextManifest) tokenize(text string) []string {
+	if m.Tokenizer == tokenizerCJK {
+		return tokenizeCJK(text)
+	}
+	return tokenize(text)
+}
+
+func loadFullTextManifest(dir string) (*fullTextManifest, error) {
+	var m fullTextManifest
+	if err := readGobGzip(filepath.Join(dir, manifestFileName), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func saveFullTextManifest(dir string, m *fullTextManifest) error {
+	return writeGobGzip(filepath.Join(dir, manifestFileName), m)
+}
+
+// writeGobGzip atomically writes v as gzip-compressed gob.
+func writeGobGzip(filename string, v interface{}) error {
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(f)
+	if err := gob.NewEncoder(gw).Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func readGobGzip(filename string, v interface{}) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	return gob.NewDecoder(gr).Decode(v)
+}
+
+// FullTextIndex reads postings from a completed on-disk index, loading
+// shards lazily as terms are looked up. Only the maxLoadedShards most
+// recently used shards are kept in memory.
+type FullTextIndex struct {
+	dir    string
+	shards int
+	cjk    bool
+
+	mu     sync.Mutex
+	ll     *list.List // shard numbers, most recently used first
+	loaded map[int]*list.Element
+}
+
+type loadedShard struct {
+	shard    int
+	postings map[string][]Posting
+}
+
+func openFullTextIndex(dir string) (*FullTextIndex, error) {
+	m, err := loadFullTextManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !m.Complete {
+		return nil, fmt.Errorf("full-text index in %s is incomplete", dir)
+	}
+	return &FullTextIndex{dir: dir, shards: m.Shards, cjk: m.Tokenizer == tokenizerCJK, ll: list.New(), loaded: make(map[int]*list.Element)}, nil
+}
+
+// Postings returns the postings list for a single term.
+func (ft *FullTextIndex) Postings(term string) ([]Posting, error) {
+	shard := termShard(term, ft.shards)
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if el, ok := ft.loaded[shard]; ok {
+		ft.ll.MoveToFront(el)
+		return el.Value.(*loadedShard).postings[term], nil
+	}
+	var part map[string][]Posting
+	if err := readGobGzip(shardFileName(ft.dir, "final", shard), &part); err != nil {
+		return nil, err
+	}
+	ft.loaded[shard] = ft.ll.PushFront(&loadedShard{shard: shard, postings: part})
+	for ft.ll.Len() > maxLoadedShards {
+		oldest := ft.ll.Back()
+		ft.ll.Remove(oldest)
+		delete(ft.loaded, oldest.Value.(*loadedShard).shard)
+	}
+	return part[term], nil
+}
+
+// queryPhrases splits a full-text query into the token lists that must
+// each occur at consecutive positions. Text between double quotes is one
+// list; an unclosed quote runs to the end of the query.
+func queryPhrases(query string, cjk bool) [][]string {
+	var phrases [][]string
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 0 {
+			phrases = append(phrases, tokenizePhrases(part, cjk)...)
+			continue
+		}
+		var phrase []string
+		for _, p := range tokenizePhrases(part, cjk) {
+			phrase = append(phrase, p...)
+		}
+		if len(phrase) > 0 {
+			phrases = append(phrases, phrase)
+		}
+	}
+	return phrases
+}
+
+// phraseCounts returns how often each page holds the tokens of a phrase at
+// consecutive positions.
+func (ft *FullTextIndex) phraseCounts(phrase []string) (map[int]int, error) {
+	first, err := ft.Postings(phrase[0])
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int, len(first))
+	positions := make(map[int][][]uint32, len(first))
+	for _, p := range first {
+		counts[p.PageID] = len(p.Positions)
+		positions[p.PageID] = [][]uint32{p.Positions}
+	}
+	if len(phrase) == 1 {
+		return counts, nil
+	}
+	for _, term := range phrase[1:] {
+		list, err := ft.Postings(term)
+		if err != nil {
+			return nil, err
+		}
+		next := make(map[int][][]uint32, len(list))
+		for _, p := range list {
+			if prev, ok := positions[p.PageID]; ok {
+				next[p.PageID] = append(prev, p.Positions)
+			}
+		}
+		positions = next
+	}
+	counts = make(map[int]int, len(positions))
+	for pageID, lists := range positions {
+		if n := phraseCount(lists); n > 0 {
+			counts[pageID] = n
+		}
+	}
+	return counts, nil
+}
+
+// FullTextHit is a page matching every term of a full-text query
+type FullTextHit struct {
+	PageID int
+	Score  int
+}
+
+// Search returns pages containing all query terms, ordered by how often the
+// terms occur. The words of a "quoted phrase" must occur in sequence, as
+// must the character pairs of a term in a CJK index.
+func (ft *FullTextIndex) Search(query string, limit int) ([]FullTextHit, error) {
+	phrases := queryPhrases(query, ft.cjk)
+	if len(phrases) == 0 {
+		return nil, nil
+	}
+	scores := make(map[int]int)
+	for i, phrase := range phrases {
+		counts, err := ft.phraseCounts(phrase)
+		if err != nil {
+			return nil, err
+		}
+		next := make(map[int]int, len(counts))
+		for pageID, n := range counts {
+			if prev, ok := scores[pageID]; ok || i == 0 {
+				next[pageID] = prev + n
+			}
+		}
+		scores = next
+	}
+
+	hits := make([]FullTextHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, FullTextHit{PageID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].PageID < hits[j].PageID
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
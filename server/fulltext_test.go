@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestFullTextIndexKeepsFewShards looks up terms from every shard and
+// checks only the most recently used shards stay loaded.
+func TestFullTextIndexKeepsFewShards(t *testing.T) {
+	dir := t.TempDir()
+	postings := make(map[string][]Posting)
+	for i := range 200 {
+		postings[fmt.Sprint("term", i)] = []Posting{{PageID: i, Positions: []uint32{0}}}
+	}
+	if err := writeFullTextShards(dir, "final", fullTextShards, postings); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveFullTextManifest(dir, &fullTextManifest{Shards: fullTextShards, Complete: true, Tokenizer: tokenizerWords}); err != nil {
+		t.Fatal(err)
+	}
+	ft, err := openFullTextIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 200 {
+		list, err := ft.Postings(fmt.Sprint("term", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].PageID != i {
+			t.Fatalf("postings of term%d = %v", i, list)
+		}
+		if n := len(ft.loaded); n > maxLoadedShards {
+			t.Fatalf("%d shards loaded, want at most %d", n, maxLoadedShards)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// goldenDir holds the converter corpus: each NAME.wiki snippet is rendered
+// with the native converter and compared with NAME.html.
+const goldenDir = "testdata/converter"
+
+// goldenContext is how many lines around the first difference are printed.
+const goldenContext = 3
+
+// runGolden implements "wikiseek golden", the corpus check of
+// convert_golden_test.go for installs without a Go toolchain: it renders
+// every snippet and reports those whose HTML no longer matches the golden
+// file, or rewrites the golden files with -update.
+func runGolden(args []string) {
+	fs := newCommandFlags("golden")
+	dir := fs.String("dir", goldenDir, "Directory of .wiki snippets and their golden .html files")
+	update := fs.Bool("update", false, "Rewrite the golden files with the current output")
+	run := fs.String("run", "", "Only check snippets whose name contains this string")
+	fs.Parse(args)
+
+	inputs, err := goldenInputs(*dir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	checked, failed := 0, 0
+	for _, input := range inputs {
+		name := goldenName(input)
+		if !strings.Contains(name, *run) {
+			continue
+		}
+		checked++
+		if *update {
+			if err := updateGolden(input); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			continue
+		}
+		if diff, err := checkGolden(input); err != nil {
+			fmt.Printf("FAIL %s: %v (run with -update to create it)\n", name, err)
+			failed++
+		} else if diff != "" {
+			fmt.Printf("FAIL %s\n%s", name, diff)
+			failed++
+		}
+	}
+
+	if *update {
+		fmt.Printf("Updated %d golden files in %s\n", checked, *dir)
+		return
+	}
+	fmt.Printf("%d of %d snippets match\n", checked-failed, checked)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// goldenInputs returns the .wiki snippets of a corpus directory in order.
+func goldenInputs(dir string) ([]string, error) {
+	inputs, err := filepath.Glob(filepath.Join(dir, "*.wiki"))
+	if err != nil || len(inputs) == 0 {
+		return nil, fmt.Errorf("no .wiki snippets found in %s", dir)
+	}
+	sort.Strings(inputs)
+	return inputs, nil
+}
+
+func goldenName(input string) string {
+	return strings.TrimSuffix(filepath.Base(input), ".wiki")
+}
+
+func goldenFile(input string) string {
+	return strings.TrimSuffix(input, ".wiki") + ".html"
+}
+
+// renderGolden renders a snippet with the native converter.
+func renderGolden(input string) (string, error) {
+	wikitext, err := os.ReadFile(input)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %v", input, err)
+	}
+	return ConvertWikiTextToHTML(string(wikitext)), nil
+}
+
+// checkGolden compares a snippet's output with its golden file, returning
+// the difference or "" when they match.
+func checkGolden(input string) (string, error) {
+	got, err := renderGolden(input)
+	if err != nil {
+		return "", err
+	}
+	want, err := os.ReadFile(goldenFile(input))
+	if err != nil {
+		return "", err
+	}
+	return goldenDiff(string(want), got), nil
+}
+
+// updateGolden rewrites a snippet's golden file with its current output.
+func updateGolden(input string) error {
+	got, err := renderGolden(input)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(goldenFile(input), []byte(got), 0644); err != nil {
+		return fmt.Errorf("writing %s: %v", goldenFile(input), err)
+	}
+	return nil
+}
+
+// goldenDiff describes the first differing line of two outputs with some
+// surrounding context, or returns "" when they are equal.
+func goldenDiff(want, got string) string {
+	if want == got {
+		return ""
+	}
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	first := 0
+	for first < len(wantLines) && first < len(gotLines) && wantLines[first] == gotLines[first] {
+		first++
+	}
+
+	var b strings.Builder
+	from := max(first-goldenContext, 0)
+	for i := from; i < first; i++ {
+		fmt.Fprintf(&b, "  %4d   %s\n", i+1, wantLines[i])
+	}
+	for i := first; i < first+goldenContext && i < len(wantLines); i++ {
+		fmt.Fprintf(&b, "- %4d   %s\n", i+1, wantLines[i])
+	}
+	for i := first; i < first+goldenContext && i < len(gotLines); i++ {
+		fmt.Fprintf(&b, "+ %4d   %s\n", i+1, gotLines[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+const (
+	maxGraphDepth = 3
+	maxGraphNodes = 200
+	// maxGraphCache bounds how many pages' outgoing links are remembered.
+	maxGraphCache = 10000
+)
+
+// linkGraph answers questions about links between articles. Outgoing links
+// are read from each page's wikitext on demand and cached.
+type linkGraph struct {
+	index     []IndexEntry
+	titles    titleSet
+	inputFile string
+	streams   *streamCaches
+
+	mu    sync.Mutex
+	links map[int][]int
+}
+
+func newLinkGraph(index []IndexEntry, titles titleSet, inputFile string, streams *streamCaches) *linkGraph {
+	return &linkGraph{index: index, titles: titles, inputFile: inputFile, streams: streams, links: make(map[int][]int)}
+}
+
+// outLinks returns the index positions of the existing articles a page
+// links to. A redirect links only to its target.
+func (g *linkGraph) outLinks(i int) ([]int, error) {
+	g.mu.Lock()
+	cached, ok := g.links[i]
+	g.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	entry := &g.index[i]
+	data, err := g.streams.extract(g.inputFile, entry.Offsets)
+	if err != nil {
+		return nil, err
+	}
+	text, err := dump.ExtractPageText(data, entry.PageID)
+	if err != nil {
+		return nil, err
+	}
+	text = commentRe.ReplaceAllString(text, "")
+	text = nestedMedia.ReplaceAllString(text, "")
+	if redirectRe.MatchString(text) {
+		if m := wikiLinkRe.FindStringSubmatch(text); m != nil {
+			text = m[0]
+		}
+	}
+
+	var out []int
+	seen := map[int]bool{i: true}
+	for _, m := range wikiLinkRe.FindAllStringSubmatch(text, -1) {
+		title, _, _ := strings.Cut(m[1], "#")
+		title = strings.TrimSpace(strings.ReplaceAll(strings.TrimPrefix(title, ":"), "_", " "))
+		if j, ok := g.titles.Lookup(title); ok && !seen[j] {
+			seen[j] = true
+			out = append(out, j)
+		}
+	}
+
+	g.mu.Lock()
+	if len(g.links) >= maxGraphCache {
+		g.links = make(map[int][]int)
+	}
+	g.links[i] = out
+	g.mu.Unlock()
+	return out, nil
+}
+
+type graphNode struct {
+	Title  string `json:"title"`
+	PageID int    `json:"page_id"`
+	Depth  int    `json:"depth"`
+}
+
+type graphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+type graphResponse struct {
+	Root      string      `json:"root"`
+	Depth     int         `json:"depth"`
+	Nodes     []graphNode `json:"nodes"`
+	Edges     []graphEdge `json:"edges"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// neighborhood walks outgoing links breadth-first from root up to depth
+// hops. Each page is visited once, so cycles end the walk, and no more than
+// maxNodes pages are included; links between included pages are still
+// reported once the limit is hit.
+func (g *linkGraph) neighborhood(root int, depth, maxNodes int) graphResponse {
+	resp := graphResponse{Root: g.index[root].Title, Depth: depth}
+	level := map[int]int{root: 0}
+	resp.Nodes = append(resp.Nodes, graphNode{Title: g.index[root].Title, PageID: g.index[root].PageID})
+	queue := []int{root}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		if level[i] >= depth {
+			continue
+		}
+		links, err := g.outLinks(i)
+		if err != nil {
+			continue
+		}
+		for _, j := range links {
+			if _, ok := level[j]; !ok {
+				if len(resp.Nodes) >= maxNodes {
+					resp.Truncated = true
+					continue
+				}
+				level[j] = level[i] + 1
+				resp.Nodes = append(resp.Nodes, graphNode{Title: g.index[j].Title, PageID: g.index[j].PageID, Depth: level[j]})
+				queue = append(queue, j)
+			}
+			resp.Edges = append(resp.Edges, graphEdge{Source: g.index[i].Title, Target: g.index[j].Title})
+		}
+	}
+	return resp
+}
+
+// handleAPIGraph serves /api/graph/{title}?depth=N: the articles within N
+// links of a page and the links between them.
+func handleAPIGraph(w http.ResponseWriter, r *http.Request, graph *linkGraph) {
+	title, err := titleFromPath(r.URL.Path, "/api/graph/")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	title = strings.ReplaceAll(title, "_", " ")
+	root, ok := graph.titles.Lookup(title)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no article with that title"})
+		return
+	}
+	depth := 2
+	if d := r.FormValue("depth"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 1 || n > maxGraphDepth {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "depth must be between 1 and " + strconv.Itoa(maxGraphDepth)})
+			return
+		}
+		depth = n
+	}
+	writeJSON(w, http.StatusOK, graph.neighborhood(root, depth, maxGraphNodes))
+}
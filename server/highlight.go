@@ -0,0 +1,91 @@
+package server
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxHighlightLength bounds the ?highlight= term.
+const maxHighlightLength = 100
+
+// highlightEscapes are the forms a character of the term may take in
+// rendered HTML text.
+var highlightEscapes = map[rune]string{
+	'&':  `(?:&|&amp;)`,
+	'<':  `&lt;`,
+	'>':  `&gt;`,
+	'"':  `(?:"|&quot;|&#34;)`,
+	'\'': `(?:'|&#39;|&apos;)`,
+}
+
+// highlightPattern returns a case-insensitive pattern matching term in
+// HTML text, where it may be escaped, or nil for a blank term.
+func highlightPattern(term string) *regexp.Regexp {
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("(?i)")
+	for _, r := range term {
+		if esc, ok := highlightEscapes[r]; ok {
+			b.WriteString(esc)
+		} else {
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return regexp.MustCompile(b.String())
+}
+
+// highlightMatches wraps every occurrence of term in the text of rendered
+// HTML in <mark>, numbering them match-1, match-2 and so on so the page
+// can link to the first. Tags, attributes and the contents of scripts and
+// styles are left alone. It returns the new HTML and the number of
+// matches.
+//
+// It runs on each request after the render cache, so highlighted views of
+// a page share its cached rendering.
+func highlightMatches(body, term string) (string, int) {
+	re := highlightPattern(term)
+	if re == nil {
+		return body, 0
+	}
+	var b strings.Builder
+	count := 0
+	skip := "" // closing tag ending a script or style
+	for len(body) > 0 {
+		lt := strings.IndexByte(body, '<')
+		if lt < 0 {
+			lt = len(body)
+		}
+		text := body[:lt]
+		if skip == "" {
+			text = re.ReplaceAllStringFunc(text, func(m string) string {
+				count++
+				return `<mark id="match-` + strconv.Itoa(count) + `">` + m + "</mark>"
+			})
+		}
+		b.WriteString(text)
+		body = body[lt:]
+		if body == "" {
+			break
+		}
+		gt := strings.IndexByte(body, '>')
+		if gt < 0 {
+			b.WriteString(body)
+			break
+		}
+		tag := strings.ToLower(body[:gt+1])
+		switch {
+		case skip != "" && strings.HasPrefix(tag, skip):
+			skip = ""
+		case skip == "" && (strings.HasPrefix(tag, "<script") || strings.HasPrefix(tag, "<style")):
+			skip = "</" + strings.TrimLeft(strings.Fields(tag)[0], "<")
+			skip = strings.TrimSuffix(skip, ">")
+		}
+		b.WriteString(body[:gt+1])
+		body = body[gt+1:]
+	}
+	return b.String(), count
+}
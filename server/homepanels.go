@@ -0,0 +1,372 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xanderstrike/wikiseek/dump"
+	"github.com/xanderstrike/wikiseek/store"
+)
+
+const (
+	// homePanelSize is the number of articles each home page panel lists
+	homePanelSize = 25
+	// maxRecentViews bounds the recently viewed list
+	maxRecentViews = 100
+	// featuredRescan is how often the featured panel looks for newly
+	// collected metadata.
+	featuredRescan = time.Minute
+)
+
+// homePanel supplies the articles of one section of the home page
+type homePanel interface {
+	Name() string
+	Title() string
+	Pages(limit int) []IndexEntry
+	// Empty explains a panel without articles
+	Empty() string
+}
+
+// homePanelView is what home.html needs to show one panel.
+type homePanelView struct {
+	Name  string
+	Title string
+	Pages []IndexEntry
+	Empty string
+}
+
+// homePanelDeps is everything the panels draw on.
+type homePanelDeps struct {
+	index     []IndexEntry
+	titles    titleSet
+	inputFile string
+	streams   *streamCaches
+	meta      *metaStore
+	views     *viewTracker
+	category  string
+	data      *store.Store
+	hub       *progressHub
+	log       io.Writer
+}
+
+// homePanelRegistry maps -home-panels names to their constructors, which
+// only run for the panels asked for.
+func homePanelRegistry(deps homePanelDeps) map[string]func() (homePanel, error) {
+	return map[string]func() (homePanel, error){
+		"random": func() (homePanel, error) {
+			return randomPanel{index: deps.index}, nil
+		},
+		"featured": func() (homePanel, error) {
+			return &featuredPanel{index: deps.index, meta: deps.meta}, nil
+		},
+		"popular": func() (homePanel, error) {
+			return popularPanel{index: deps.index, titles: deps.titles, views: deps.views}, nil
+		},
+		"recent": func() (homePanel, error) {
+			return recentPanel{index: deps.index, titles: deps.titles, views: deps.views}, nil
+		},
+		"category": func() (homePanel, error) {
+			if deps.category == "" {
+				return nil, fmt.Errorf("the category panel needs -home-category")
+			}
+			return newCategoryPanel(deps), nil
+		},
+	}
+}
+
+// newHomePanels builds the panels named in a comma-separated list, in order.
+func newHomePanels(names string, deps homePanelDeps) ([]homePanel, error) {
+	registry := homePanelRegistry(deps)
+	var panels []homePanel
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		build, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown home panel %q", name)
+		}
+		panel, err := build()
+		if err != nil {
+			return nil, err
+		}
+		panels = append(panels, panel)
+	}
+	return panels, nil
+}
+
+// homePanelViews fills every panel for one home page view.
+func homePanelViews(panels []homePanel) []homePanelView {
+	views := make([]homePanelView, len(panels))
+	for i, p := range panels {
+		views[i] = homePanelView{Name: p.Name(), Title: p.Title(), Pages: p.Pages(homePanelSize)}
+		if len(views[i].Pages) == 0 {
+			views[i].Empty = p.Empty()
+		}
+	}
+	return views
+}
+
+// randomPanel lists random articles, the original home page
+type randomPanel struct {
+	index []IndexEntry
+}
+
+func (randomPanel) Name() string  { return "random" }
+func (randomPanel) Title() string { return "Random Articles" }
+func (randomPanel) Empty() string { return "The index is empty." }
+
+func (p randomPanel) Pages(limit int) []IndexEntry {
+	return getRandomEntries(p.index, limit)
+}
+
+// featuredPanel lists a random selection of featured articles, as far as
+// page metadata knows them.
+type featuredPanel struct {
+	index []IndexEntry
+	meta  *metaStore
+
+	mu       sync.Mutex
+	featured []IndexEntry
+	metaLen  int
+	scanned  time.Time
+}
+
+func (*featuredPanel) Name() string  { return "featured" }
+func (*featuredPanel) Title() string { return "Featured Articles" }
+func (*featuredPanel) Empty() string {
+	return "No featured articles are known yet. Collect page metadata to find them."
+}
+
+func (p *featuredPanel) Pages(limit int) []IndexEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Metadata grows as pages are viewed or collected; rescan when it has,
+	// but not on every view during a metadata pass.
+	if n := p.meta.Len(); n != p.metaLen && time.Since(p.scanned) >= featuredRescan {
+		p.featured = p.featured[:0]
+		for _, e := range p.index {
+			if m, ok := p.meta.Get(e.PageID); ok && m.Quality == "featured" {
+				p.featured = append(p.featured, e)
+			}
+		}
+		p.metaLen, p.scanned = n, time.Now()
+	}
+	return getRandomEntries(p.featured, limit)
+}
+
+// popularPanel lists the most viewed articles
+type popularPanel struct {
+	index  []IndexEntry
+	titles titleSet
+	views  *viewTracker
+}
+
+func (popularPanel) Name() string  { return "popular" }
+func (popularPanel) Title() string { return "Popular Articles" }
+func (popularPanel) Empty() string { return "No articles have been viewed yet." }
+
+func (p popularPanel) Pages(limit int) []IndexEntry {
+	return entriesByTitle(p.index, p.titles, p.views.popular(limit))
+}
+
+// recentPanel lists the articles viewed last, most recent first
+type recentPanel struct {
+	index  []IndexEntry
+	titles titleSet
+	views  *viewTracker
+}
+
+func (recentPanel) Name() string  { return "recent" }
+func (recentPanel) Title() string { return "Recently Viewed" }
+func (recentPanel) Empty() string { return "No articles have been viewed yet." }
+
+func (p recentPanel) Pages(limit int) []IndexEntry {
+	return entriesByTitle(p.index, p.titles, p.views.recentTitles(limit))
+}
+
+// entriesByTitle looks up titles in the index, skipping any it lacks.
+func entriesByTitle(index []IndexEntry, titles titleSet, names []string) []IndexEntry {
+	var entries []IndexEntry
+	for _, name := range names {
+		if i, ok := titles.Lookup(name); ok {
+			entries = append(entries, index[i])
+		}
+	}
+	return entries
+}
+
+// viewTracker counts article views for the popular and recently viewed
+// panels. Counts are kept in the data store when there is one; the recent
+// list only lasts until a restart. weight is -popularity-weight.
+type viewTracker struct {
+	mu     sync.Mutex
+	counts map[string]int // title -> views
+	recent []string       // distinct titles, most recent first
+	bucket *store.Bucket
+	weight float64
+}
+
+func newViewTracker(bucket *store.Bucket, weight float64) *viewTracker {
+	v := &viewTracker{counts: make(map[string]int), bucket: bucket, weight: weight}
+	for _, title := range bucket.Keys() {
+		data, _ := bucket.Get(title)
+		if n, err := strconv.Atoi(string(data)); err == nil {
+			v.counts[title] = n
+		}
+	}
+	return v
+}
+
+// record counts a view of an article.
+func (v *viewTracker) record(title string) {
+	v.mu.Lock()
+	v.counts[title]++
+	n := v.counts[title]
+	recent := []string{title}
+	for _, t := range v.recent {
+		if t != title && len(recent) < maxRecentViews {
+			recent = append(recent, t)
+		}
+	}
+	v.recent = recent
+	v.mu.Unlock()
+	v.bucket.Put(title, []byte(strconv.Itoa(n)))
+}
+
+// popular returns up to n titles with the most views, ties by title.
+func (v *viewTracker) popular(n int) []string {
+	v.mu.Lock()
+	titles := make([]string, 0, len(v.counts))
+	for title := range v.counts {
+		titles = append(titles, title)
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		a, b := v.counts[titles[i]], v.counts[titles[j]]
+		if a != b {
+			return a > b
+		}
+		return titles[i] < titles[j]
+	})
+	v.mu.Unlock()
+	return titles[:min(n, len(titles))]
+}
+
+// recentTitles returns up to n titles viewed last.
+func (v *viewTracker) recentTitles(n int) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]string(nil), v.recent[:min(n, len(v.recent))]...)
+}
+
+// categoryPanel spotlights a random selection of the articles in one
+// category. The dump has no category tables, so members are found by a
+// background scan of every article's wikitext for the category link, and
+// saved in the data store so later starts skip it.
+type categoryPanel struct {
+	category string
+
+	mu      sync.Mutex
+	members []IndexEntry
+	done    bool
+}
+
+func (*categoryPanel) Name() string    { return "category" }
+func (p *categoryPanel) Title() string { return p.category }
+
+func (p *categoryPanel) Empty() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.done {
+		return "Looking for articles in this category…"
+	}
+	return "No articles are in this category."
+}
+
+func (p *categoryPanel) Pages(limit int) []IndexEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return getRandomEntries(p.members, limit)
+}
+
+// categoryLinkRe matches a link to the named category, spaces and
+// underscores alike and the first letter in either case.
+func categoryLinkRe(category string) *regexp.Regexp {
+	first, size := utf8.DecodeRuneInString(category)
+	head := regexp.QuoteMeta(string(first))
+	if upper, lower := unicode.ToUpper(first), unicode.ToLower(first); upper != lower {
+		head = "[" + string(upper) + string(lower) + "]"
+	}
+	rest := strings.ReplaceAll(regexp.QuoteMeta(category[size:]), " ", "[ _]+")
+	return regexp.MustCompile(`\[\[\s*[Cc]ategory\s*:\s*` + head + rest + `\s*[|\]]`)
+}
+
+func newCategoryPanel(deps homePanelDeps) *categoryPanel {
+	category := ucfirst(strings.TrimPrefix(strings.ReplaceAll(deps.category, "_", " "), "Category:"))
+	p := &categoryPanel{category: category}
+	bucket := deps.data.Bucket("category-spotlight")
+	if saved, ok := bucket.Get(category); ok {
+		names := strings.Split(string(saved), "\n")
+		p.members = entriesByTitle(deps.index, deps.titles, names)
+		p.done = true
+		return p
+	}
+	go p.scan(deps, bucket)
+	return p
+}
+
+// scan walks the dump for members of the category, adding them to the
+// panel as they are found.
+func (p *categoryPanel) scan(deps homePanelDeps, bucket *store.Bucket) {
+	re := categoryLinkRe(p.category)
+	op := deps.hub.Start("Category spotlight: "+p.category, 0)
+	last := 0
+	err := walkStreams(context.Background(), deps.inputFile, deps.index, deps.streams, max(1, runtime.NumCPU()/2), func(done, total int) {
+		op.SetTotal(total)
+		op.Add(done - last)
+		last = done
+	}, deps.log, func(pages []dump.Page) {
+		var found []IndexEntry
+		for _, page := range pages {
+			if !re.MatchString(page.Revision.Text) {
+				continue
+			}
+			if i, ok := deps.titles.Lookup(page.Title); ok {
+				found = append(found, deps.index[i])
+			}
+		}
+		if len(found) > 0 {
+			p.mu.Lock()
+			p.members = append(p.members, found...)
+			p.mu.Unlock()
+		}
+	})
+
+	p.mu.Lock()
+	p.done = true
+	names := make([]string, len(p.members))
+	for i, e := range p.members {
+		names[i] = e.Title
+	}
+	p.mu.Unlock()
+	if err != nil {
+		op.Finish("", err)
+		return
+	}
+	sort.Strings(names)
+	if err := bucket.Put(p.category, []byte(strings.Join(names, "\n"))); err != nil {
+		fmt.Fprintf(deps.log, "Warning: failed to save category spotlight: %v\n", err)
+	}
+	op.Finish(fmt.Sprintf("Found %d articles in %s", len(names), p.category), nil)
+}
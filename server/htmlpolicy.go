@@ -0,0 +1,198 @@
+package server
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Inline HTML policy. Wikitext may hold HTML tags, which used to be passed
+// through as written; a stray <div> or an unclosed <small> could then break
+// the layout of the whole page. Now tags fall into four groups:
+//
+//   - inline tags (sup, sub, small, big, br, span and other text-level
+//     markup) are kept with a few safe attributes, and closed at the end of
+//     the paragraph they open in
+//   - block tags (div, tables, lists, headings, figures) keep their
+//     attributes minus event handlers and script URLs, and are left to the
+//     block parser as before
+//   - MediaWiki extension tags that a later stage handles, such as <ref>
+//     and <timeline>, keep only the attributes that stage reads
+//   - extension tags nothing handles are removed: <gallery>, <graph> and
+//     the others whose content is data go with their content, and <poem>
+//     and the others whose content is text keep it
+//   - anything else is removed, keeping its text, or with its content for
+//     tags like <script> whose content isn't text
+//
+// The template handlers only write tags from the first two groups, so
+// applying the policy again to their output changes nothing.
+var (
+	policyInlineTags = tagSet("sup sub small big br span abbr b i u s del ins strike em strong q cite dfn " +
+		"kbd var samp tt mark bdi bdo wbr ruby rb rt rp data time")
+	policyBlockTags = tagSet("div center blockquote p hr h1 h2 h3 h4 h5 h6 ul ol li dl dt dd " +
+		"table caption thead tbody tfoot tr td th colgroup col figure figcaption section nav " +
+		"svg g rect text line circle path polyline polygon tspan title desc")
+	policyVoidTags = tagSet("br wbr hr col")
+
+	// policyExtensionTags are the extension tags a later stage handles,
+	// with the attributes each keeps (nil keeps none)
+	policyExtensionTags = map[string]map[string]bool{
+		"ref":             tagSet("name group follow"),
+		"references":      tagSet("group"),
+		"timeline":        nil,
+		"noinclude":       nil,
+		"includeonly":     nil,
+		"onlyinclude":     nil,
+		"nowiki":          nil,
+		"pre":             nil,
+		"code":            nil,
+		"math":            tagSet("display"),
+		"syntaxhighlight": tagSet("lang inline"),
+		"source":          tagSet("lang inline"),
+	}
+
+	// policyInlineAttrs are the attributes inline tags keep
+	policyInlineAttrs = tagSet("class id title lang dir style datetime value cite")
+)
+
+func tagSet(names string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range strings.Fields(names) {
+		set[name] = true
+	}
+	return set
+}
+
+var (
+	htmlTagRe   = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9]*)(\s[^<>]*?)?\s*(/?)>`)
+	htmlAttrRe  = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	unsafeStyle = regexp.MustCompile(`(?i)url\s*\(|expression|javascript:|behavior|@import|position\s*:\s*(fixed|absolute)`)
+
+	// droppedContentRe matches tags whose content is removed with them
+	droppedContentRe = func() *regexp.Regexp {
+		var alternatives []string
+		tags := "script style iframe object embed noscript textarea select template applet frameset " +
+			// extension tags whose content is data rather than text
+			"gallery templatedata score graph mapframe maplink imagemap categorytree inputbox hiero"
+		for _, tag := range strings.Fields(tags) {
+			alternatives = append(alternatives, `<`+tag+`(?:\s[^>]*)?>.*?</`+tag+`\s*>|<`+tag+`(?:\s[^>]*)?/?>`)
+		}
+		return regexp.MustCompile(`(?is)` + strings.Join(alternatives, "|"))
+	}()
+)
+
+// applyHTMLPolicy enforces the inline HTML policy on wikitext. Text in
+// <nowiki>, <pre> and the other protected tags is shown literally and left
+// alone; only the attributes of their opening tags are filtered.
+func applyHTMLPolicy(text string) string {
+	if !strings.Contains(text, "<") {
+		return text
+	}
+	var p protector
+	text = p.shield(text)
+	for i, part := range p.parts {
+		end := strings.IndexByte(part, '>') + 1
+		p.parts[i] = htmlTagRe.ReplaceAllStringFunc(part[:end], policyTag) + part[end:]
+	}
+	text = droppedContentRe.ReplaceAllString(text, "")
+	text = htmlTagRe.ReplaceAllStringFunc(text, policyTag)
+	return p.restore(text)
+}
+
+// policyTag rewrites one tag matched by htmlTagRe according to the policy.
+func policyTag(tag string) string {
+	m := htmlTagRe.FindStringSubmatch(tag)
+	closing, name, attrs, selfClosing := m[1] == "/", strings.ToLower(m[2]), m[3], m[4]
+	extensionAttrs, extension := policyExtensionTags[name]
+	switch {
+	case extension:
+		if closing {
+			return "</" + name + ">"
+		}
+		if extensionAttrs == nil {
+			attrs = ""
+		}
+		if selfClosing != "" {
+			selfClosing = " /"
+		}
+		return "<" + name + sanitizeAttrs(attrs, extensionAttrs) + selfClosing + ">"
+	case policyInlineTags[name]:
+		if closing {
+			return "</" + name + ">"
+		}
+		return "<" + name + sanitizeAttrs(attrs, policyInlineAttrs) + selfClosingSuffix(name, selfClosing) + ">"
+	case policyBlockTags[name]:
+		if closing {
+			return "</" + name + ">"
+		}
+		return "<" + name + sanitizeAttrs(attrs, nil) + selfClosingSuffix(name, selfClosing) + ">"
+	}
+	return ""
+}
+
+func selfClosingSuffix(name, slash string) string {
+	if slash != "" && policyVoidTags[name] {
+		return " /"
+	}
+	return ""
+}
+
+// sanitizeAttrs rewrites an attribute list, dropping event handlers,
+// script URLs and styles that load resources or cover the page. Unless
+// allowed is nil, only the attributes in it are kept.
+func sanitizeAttrs(attrs string, allowed map[string]bool) string {
+	var b strings.Builder
+	for _, m := range htmlAttrRe.FindAllStringSubmatch(attrs, -1) {
+		name := strings.ToLower(m[1])
+		value := html.UnescapeString(m[2] + m[3] + m[4])
+		if strings.HasPrefix(name, "on") || (allowed != nil && !allowed[name]) {
+			continue
+		}
+		if strings.Contains(strings.ToLower(strings.Join(strings.Fields(value), "")), "javascript:") {
+			continue
+		}
+		if name == "style" && unsafeStyle.MatchString(value) {
+			continue
+		}
+		b.WriteString(" " + name + `="` + html.EscapeString(value) + `"`)
+	}
+	return b.String()
+}
+
+// balanceInlineTags closes the inline tags a paragraph leaves open and
+// drops closing tags that match nothing, so markup like an unclosed
+// <small> can't spill into the rest of the page.
+func balanceInlineTags(s string) string {
+	if !strings.Contains(s, "<") {
+		return s
+	}
+	var open []string
+	s = htmlTagRe.ReplaceAllStringFunc(s, func(tag string) string {
+		m := htmlTagRe.FindStringSubmatch(tag)
+		name := strings.ToLower(m[2])
+		if !policyInlineTags[name] || policyVoidTags[name] || m[4] != "" {
+			return tag
+		}
+		if m[1] != "/" {
+			open = append(open, name)
+			return tag
+		}
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i] != name {
+				continue
+			}
+			// Close tags left open inside this one first
+			var b strings.Builder
+			for j := len(open) - 1; j > i; j-- {
+				b.WriteString("</" + open[j] + ">")
+			}
+			open = open[:i]
+			return b.String() + tag
+		}
+		return ""
+	})
+	for i := len(open) - 1; i >= 0; i-- {
+		s += "</" + open[i] + ">"
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestExtensionTagAttributes feeds event handlers and styles through every
+// extension tag, handled or not.
+func TestExtensionTagAttributes(t *testing.T) {
+	unhandled := strings.Fields("gallery poem templatedata score chem ce graph mapframe maplink imagemap " +
+		"indicator categorytree inputbox hiero")
+	tags := unhandled
+	for name := range policyExtensionTags {
+		tags = append(tags, name)
+	}
+	for _, name := range tags {
+		for _, tag := range []string{
+			`<` + name + ` onmouseover="alert(1)" style="color:red">x</` + name + `>`,
+			`<` + name + ` ONCLICK='alert(1)' style=color:red>x`,
+			`<` + name + ` onload=alert(1) />`,
+		} {
+			got := applyHTMLPolicy(tag)
+			if lower := strings.ToLower(got); strings.Contains(lower, "alert") || strings.Contains(lower, "style") {
+				t.Errorf("applyHTMLPolicy(%q) = %q", tag, got)
+			}
+		}
+	}
+	for _, name := range unhandled {
+		if got := applyHTMLPolicy("<" + name + ">x</" + name + ">"); strings.Contains(got, "<") {
+			t.Errorf("unhandled <%s> kept: %q", name, got)
+		}
+	}
+}
+
+func TestExtensionTagAllowedAttributes(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`<ref name="a" onclick="x">b</ref>`, `<ref name="a">b</ref>`},
+		{`<ref name=a/>`, `<ref name="a" />`},
+		{`<references group="notes" style="x"/>`, `<references group="notes" />`},
+		{`<poem class="x">a</poem>`, `a`},
+		{`<gallery mode="packed">File:A.jpg</gallery>`, ``},
+	}
+	for _, tt := range tests {
+		if got := applyHTMLPolicy(tt.in); got != tt.want {
+			t.Errorf("applyHTMLPolicy(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"iter"
+	"os"
+	"sort"
+	"unsafe"
+)
+
+// indexBinVersion is the layout version of the binary index. Unlike the
+// gob cache it is never migrated: a file in another layout is ignored and
+// rewritten from the gob cache or the index.
+//
+// History:
+//  1. entries, title order, namespaces and metadata
+//  2. template entries and titles
+//  3. imported page properties and redirect targets in metadata
+//  4. metadata sorted by page ID, with an offset table to search it in place
+const indexBinVersion = 4
+
+// indexBinMagic starts every binary index file.
+var indexBinMagic = [8]byte{'W', 'S', 'I', 'D', 'X', 'B', 'I', 'N'}
+
+// indexBinHeader starts the binary index. The sections follow it in this
+// order, all little endian:
+//
+//	streams     Streams × (start int64, end int64)
+//	entries     Entries × (page ID uint32, stream uint32, title end uint64)
+//	titles      TitleBytes of titles, one after the other in entry order
+//	order       Entries × uint32, entry positions sorted by title
+//	namespaces  NamespaceBytes of (uvarint length, name, uvarint count)
+//	meta        MetaBytes of MetaCount page metadata records, by page ID
+//	metaoffsets MetaCount × uint64, the offset of each record in meta
+//	templates   Templates × (page ID uint32, stream uint32, title end uint64)
+//	tmpltitles  TemplateTitleBytes of template titles, as for entries
+//
+// Every entry is found at a fixed offset and its title ends where the next
+// one starts, so the file is used in place: it is mapped into memory and
+// titles point into the mapping instead of being copied. Page metadata is
+// read in place too, by binary search over the record offsets, so a large
+// index doesn't decode millions of records into a map at startup. The
+// title order saves the sort building the title set would otherwise need.
+type indexBinHeader struct {
+	Magic          [8]byte
+	Version        uint32
+	_              uint32
+	Source         [16]byte
+	Streams        uint64
+	Entries        uint64
+	TitleBytes     uint64
+	NamespaceBytes uint64
+	MetaCount      uint64
+	MetaBytes      uint64
+
+	Templates          uint64
+	TemplateTitleBytes uint64
+}
+
+const (
+	indexBinHeaderSize = 96
+	indexBinStreamSize = 16
+	indexBinEntrySize  = 16
+)
+
+// page metadata flags in the meta section
+const (
+	metaFlagStub = 1 << iota
+	metaFlagRedirect
+	metaFlagDisambig
+	metaFlagProps
+)
+
+// indexBinFile returns the binary index path for an index file.
+func indexBinFile(indexFilename string) string {
+	return indexFilename + ".bin"
+}
+
+// saveIndexBin atomically writes the binary index for an index file.
+func saveIndexBin(cache *indexCache, binFile, indexFilename string) error {
+	source, err := indexSourceID(indexFilename)
+	if err != nil {
+		return err
+	}
+
+	streams := make(map[*OffsetPair]uint32)
+	var streamList []*OffsetPair
+	var titleBytes, templateTitleBytes uint64
+	for _, e := range cache.Entries {
+		if _, ok := streams[e.Offsets]; !ok {
+			streams[e.Offsets] = uint32(len(streamList))
+			streamList = append(streamList, e.Offsets)
+		}
+		titleBytes += uint64(len(e.Title))
+	}
+	for _, e := range cache.Templates {
+		if _, ok := streams[e.Offsets]; !ok {
+			streams[e.Offsets] = uint32(len(streamList))
+			streamList = append(streamList, e.Offsets)
+		}
+		templateTitleBytes += uint64(len(e.Title))
+	}
+	order := cache.titleOrder
+	if order == nil {
+		order = sortedTitleOrder(cache.Entries)
+	}
+	var namespaces []byte
+	for name, count := range cache.Namespaces {
+		namespaces = binary.AppendUvarint(namespaces, uint64(len(name)))
+		namespaces = append(namespaces, name...)
+		namespaces = binary.AppendUvarint(namespaces, uint64(count))
+	}
+	pages := cache.pageMeta()
+	pageIDs := make([]int, 0, len(pages))
+	for pageID := range pages {
+		pageIDs = append(pageIDs, pageID)
+	}
+	sort.Ints(pageIDs)
+	var meta []byte
+	metaOffsets := make([]byte, 0, 8*len(pageIDs))
+	for _, pageID := range pageIDs {
+		metaOffsets = binary.LittleEndian.AppendUint64(metaOffsets, uint64(len(meta)))
+		meta = appendMetaRecord(meta, pageID, pages[pageID])
+	}
+
+	header := indexBinHeader{
+		Magic:          indexBinMagic,
+		Version:        indexBinVersion,
+		Source:         source,
+		Streams:        uint64(len(streamList)),
+		Entries:        uint64(len(cache.Entries)),
+		TitleBytes:     titleBytes,
+		NamespaceBytes: uint64(len(namespaces)),
+		MetaCount:      uint64(len(pageIDs)),
+		MetaBytes:      uint64(len(meta)),
+
+		Templates:          uint64(len(cache.Templates)),
+		TemplateTitleBytes: templateTitleBytes,
+	}
+
+	tmp := binFile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating binary index: %v", err)
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	err = binary.Write(w, binary.LittleEndian, header)
+	var buf [indexBinEntrySize]byte
+	for _, s := range streamList {
+		binary.LittleEndian.PutUint64(buf[0:], uint64(s.Start))
+		binary.LittleEndian.PutUint64(buf[8:], uint64(s.End))
+		w.Write(buf[:indexBinStreamSize])
+	}
+	writeEntries := func(entries []IndexEntry) {
+		var titleEnd uint64
+		for _, e := range entries {
+			titleEnd += uint64(len(e.Title))
+			binary.LittleEndian.PutUint32(buf[0:], uint32(e.PageID))
+			binary.LittleEndian.PutUint32(buf[4:], streams[e.Offsets])
+			binary.LittleEndian.PutUint64(buf[8:], titleEnd)
+			w.Write(buf[:indexBinEntrySize])
+		}
+	}
+	writeEntries(cache.Entries)
+	for _, e := range cache.Entries {
+		w.WriteString(e.Title)
+	}
+	for _, pos := range order {
+		binary.LittleEndian.PutUint32(buf[0:], uint32(pos))
+		w.Write(buf[:4])
+	}
+	w.Write(namespaces)
+	w.Write(meta)
+	w.Write(metaOffsets)
+	writeEntries(cache.Templates)
+	for _, e := range cache.Templates {
+		w.WriteString(e.Title)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing binary index: %v", err)
+	}
+	return os.Rename(tmp, binFile)
+}
+
+// loadIndexBin maps the binary index of an index file into memory. The
+// mapping is never released: entry titles point into it.
+func loadIndexBin(binFile, indexFilename string) (*indexCache, error) {
+	data, err := mapFile(binFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < indexBinHeaderSize {
+		return nil, fmt.Errorf("binary index is truncated")
+	}
+	var header indexBinHeader
+	if _, err := binary.Decode(data, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("reading binary index header: %v", err)
+	}
+	if header.Magic != indexBinMagic {
+		return nil, fmt.Errorf("not a binary index")
+	}
+	if header.Version != indexBinVersion {
+		return nil, fmt.Errorf("binary index version %d, this build reads %d", header.Version, indexBinVersion)
+	}
+	source, err := indexSourceID(indexFilename)
+	if err != nil {
+		return nil, err
+	}
+	if header.Source != source {
+		return nil, fmt.Errorf("binary index was built from a different index file")
+	}
+	size := indexBinHeaderSize + header.Streams*indexBinStreamSize + header.Entries*(indexBinEntrySize+4) +
+		header.TitleBytes + header.NamespaceBytes + header.MetaBytes + header.MetaCount*8 + header.Templates*indexBinEntrySize + header.TemplateTitleBytes
+	if uint64(len(data)) != size {
+		return nil, fmt.Errorf("binary index is %d bytes, expected %d", len(data), size)
+	}
+
+	rest := data[indexBinHeaderSize:]
+	section := func(n uint64) []byte {
+		s := rest[:n]
+		rest = rest[n:]
+		return s
+	}
+	streamData := section(header.Streams * indexBinStreamSize)
+	entryData := section(header.Entries * indexBinEntrySize)
+	titles := section(header.TitleBytes)
+	orderData := section(header.Entries * 4)
+	namespaceData := section(header.NamespaceBytes)
+	metaData := section(header.MetaBytes)
+	metaOffsets := section(header.MetaCount * 8)
+	templateData := section(header.Templates * indexBinEntrySize)
+	templateTitles := section(header.TemplateTitleBytes)
+
+	streams := make([]OffsetPair, header.Streams)
+	for i := range streams {
+		s := streamData[i*indexBinStreamSize:]
+		streams[i] = OffsetPair{Start: int64(binary.LittleEndian.Uint64(s)), End: int64(binary.LittleEndian.Uint64(s[8:]))}
+	}
+
+	readEntries := func(entryData, titles []byte, count uint64) ([]IndexEntry, error) {
+		entries := make([]IndexEntry, count)
+		var titleStart uint64
+		for i := range entries {
+			e := entryData[i*indexBinEntrySize:]
+			stream := binary.LittleEndian.Uint32(e[4:])
+			titleEnd := binary.LittleEndian.Uint64(e[8:])
+			if uint64(stream) >= header.Streams || titleEnd < titleStart || titleEnd > uint64(len(titles)) {
+				return nil, fmt.Errorf("binary index entry %d is corrupt", i)
+			}
+			entries[i] = IndexEntry{
+				Offsets: &streams[stream],
+				PageID:  int(binary.LittleEndian.Uint32(e)),
+				Title:   mappedString(titles[titleStart:titleEnd]),
+			}
+			titleStart = titleEnd
+		}
+		return entries, nil
+	}
+	cache := &indexCache{Version: indexCacheVersion}
+	if cache.Entries, err = readEntries(entryData, titles, header.Entries); err != nil {
+		return nil, err
+	}
+	if header.Templates > 0 {
+		if cache.Templates, err = readEntries(templateData, templateTitles, header.Templates); err != nil {
+			return nil, err
+		}
+	}
+
+	cache.titleOrder = make([]int32, header.Entries)
+	for i := range cache.titleOrder {
+		pos := binary.LittleEndian.Uint32(orderData[i*4:])
+		if uint64(pos) >= header.Entries {
+			return nil, fmt.Errorf("binary index title order is corrupt")
+		}
+		cache.titleOrder[i] = int32(pos)
+	}
+
+	if len(namespaceData) > 0 {
+		cache.Namespaces = make(map[string]int)
+	}
+	for len(namespaceData) > 0 {
+		name, n := readUvarintBytes(namespaceData)
+		if n <= 0 {
+			return nil, fmt.Errorf("binary index namespaces are corrupt")
+		}
+		count, m := binary.Uvarint(namespaceData[n:])
+		if m <= 0 {
+			return nil, fmt.Errorf("binary index namespaces are corrupt")
+		}
+		cache.Namespaces[string(name)] = int(count)
+		namespaceData = namespaceData[n+m:]
+	}
+
+	var last uint64
+	for i := uint64(0); i < header.MetaCount; i++ {
+		offset := binary.LittleEndian.Uint64(metaOffsets[i*8:])
+		if offset >= header.MetaBytes || (i > 0 && offset <= last) {
+			return nil, fmt.Errorf("binary index metadata is corrupt")
+		}
+		last = offset
+	}
+	if header.MetaCount > 0 {
+		cache.mappedMeta = &mappedMeta{records: metaData, offsets: metaOffsets}
+	}
+	return cache, nil
+}
+
+// mappedMeta is the metadata section of a mapped binary index, read in
+// place. Records are sorted by page ID.
+type mappedMeta struct {
+	records []byte
+	offsets []byte // uint64 offset of each record in records
+}
+
+// Len returns the number of pages with metadata.
+func (mm *mappedMeta) Len() int {
+	if mm == nil {
+		return 0
+	}
+	return len(mm.offsets) / 8
+}
+
+// record decodes the i-th record. ok is false if it is malformed.
+func (mm *mappedMeta) record(i int) (pageID int, m PageMeta, ok bool) {
+	offset := binary.LittleEndian.Uint64(mm.offsets[i*8:])
+	pageID, m, n := readMetaRecord(mm.records[offset:])
+	return pageID, m, n > 0
+}
+
+// Get returns the metadata of a page.
+func (mm *mappedMeta) Get(pageID int) (PageMeta, bool) {
+	if mm == nil {
+		return PageMeta{}, false
+	}
+	i := sort.Search(mm.Len(), func(i int) bool {
+		offset := binary.LittleEndian.Uint64(mm.offsets[i*8:])
+		id, _ := binary.Uvarint(mm.records[offset:])
+		return id >= uint64(pageID)
+	})
+	if i == mm.Len() {
+		return PageMeta{}, false
+	}
+	id, m, ok := mm.record(i)
+	if !ok || id != pageID {
+		return PageMeta{}, false
+	}
+	return m, true
+}
+
+// All yields the metadata of every page in page ID order, skipping
+// malformed records.
+func (mm *mappedMeta) All() iter.Seq2[int, PageMeta] {
+	return func(yield func(int, PageMeta) bool) {
+		for i := range mm.Len() {
+			if pageID, m, ok := mm.record(i); ok && !yield(pageID, m) {
+				return
+			}
+		}
+	}
+}
+
+// appendMetaRecord encodes one page's metadata: page ID, size and flags,
+// then the description, image, quality and redirect target as
+// length-prefixed strings.
+func appendMetaRecord(b []byte, pageID int, m PageMeta) []byte {
+	var flags byte
+	if m.Stub {
+		flags |= metaFlagStub
+	}
+	if m.Redirect {
+		flags |= metaFlagRedirect
+	}
+	if m.Disambig {
+		flags |= metaFlagDisambig
+	}
+	if m.Props {
+		flags |= metaFlagProps
+	}
+	b = binary.AppendUvarint(b, uint64(pageID))
+	b = binary.AppendUvarint(b, uint64(m.Bytes))
+	b = append(b, flags)
+	for _, s := range []string{m.Description, m.Image, m.Quality, m.RedirectTarget} {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+// readMetaRecord decodes a record written by appendMetaRecord, returning
+// its length in bytes or 0 if it is malformed. Strings point into b.
+func readMetaRecord(b []byte) (int, PageMeta, int) {
+	var m PageMeta
+	pageID, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, m, 0
+	}
+	size, k := binary.Uvarint(b[n:])
+	if k <= 0 || n+k >= len(b) {
+		return 0, m, 0
+	}
+	n += k
+	flags := b[n]
+	n++
+	m.Bytes = int(size)
+	m.Stub = flags&metaFlagStub != 0
+	m.Redirect = flags&metaFlagRedirect != 0
+	m.Disambig = flags&metaFlagDisambig != 0
+	m.Props = flags&metaFlagProps != 0
+	for _, s := range []*string{&m.Description, &m.Image, &m.Quality, &m.RedirectTarget} {
+		text, k := readUvarintBytes(b[n:])
+		if k <= 0 {
+			return 0, m, 0
+		}
+		*s = mappedString(text)
+		n += k
+	}
+	return int(pageID), m, n
+}
+
+// readUvarintBytes reads a length-prefixed byte string, returning it and
+// the bytes consumed, or 0 if b is too short.
+func readUvarintBytes(b []byte) ([]byte, int) {
+	length, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < length {
+		return nil, 0
+	}
+	return b[n : n+int(length)], n + int(length)
+}
+
+// mappedString returns b as a string without copying. Only for bytes of a
+// mapped file, which are never written or unmapped.
+func mappedString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
+
+// sortedTitleOrder returns entry positions stably sorted by title.
+func sortedTitleOrder(entries []IndexEntry) []int32 {
+	order := make([]int32, len(entries))
+	for i := range order {
+		order[i] = int32(i)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return entries[order[a]].Title < entries[order[b]].Title
+	})
+	return order
+}
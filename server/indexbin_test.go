@@ -0,0 +1,189 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var benchEntries = flag.Int("bench-entries", 1000000, "Entries in the synthetic index BenchmarkLoadIndexBin loads")
+
+// syntheticIndex builds an index of n articles, a hundred to a stream,
+// with metadata for every one, and writes the index file its caches are
+// keyed to.
+func syntheticIndex(t testing.TB, n int) (*indexCache, string) {
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.txt.bz2")
+	if err := os.WriteFile(indexFile, []byte("synthetic"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := &indexCache{Version: indexCacheVersion, Namespaces: map[string]int{"Template": 3, "File": 7}, Meta: make(map[int]PageMeta, n)}
+	var pair *OffsetPair
+	for i := 0; i < n; i++ {
+		if i%100 == 0 {
+			pair = &OffsetPair{Start: int64(i) * 1000, End: int64(i+100) * 1000}
+		}
+		title := fmt.Sprintf("List of things numbered %d", i)
+		if i%3 == 0 {
+			title = fmt.Sprintf("%d in music", i)
+		}
+		cache.Entries = append(cache.Entries, IndexEntry{Offsets: pair, PageID: i + 1, Title: title})
+		cache.Meta[i+1] = PageMeta{Bytes: 1000 + i%5000, Stub: i%7 == 0, Description: "A synthetic article", Quality: "good"}
+	}
+	cache.Templates = []IndexEntry{{Offsets: pair, PageID: n + 1, Title: "Template:Infobox"}}
+	return cache, indexFile
+}
+
+func TestIndexBinRoundTrip(t *testing.T) {
+	cache, indexFile := syntheticIndex(t, 1000)
+	binFile := indexBinFile(indexFile)
+	if err := saveIndexBin(cache, binFile, indexFile); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadIndexBin(binFile, indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Entries) != len(cache.Entries) || len(loaded.Templates) != 1 {
+		t.Fatalf("loaded %d entries and %d templates, want %d and 1", len(loaded.Entries), len(loaded.Templates), len(cache.Entries))
+	}
+	for i, e := range loaded.Entries {
+		want := cache.Entries[i]
+		if e.Title != want.Title || e.PageID != want.PageID || *e.Offsets != *want.Offsets {
+			t.Fatalf("entry %d = %+v, want %+v", i, e, want)
+		}
+	}
+	if !reflect.DeepEqual(loaded.Namespaces, cache.Namespaces) {
+		t.Errorf("namespaces = %v, want %v", loaded.Namespaces, cache.Namespaces)
+	}
+
+	// Metadata is read from the mapping until a command decodes it
+	if loaded.Meta != nil {
+		t.Error("metadata was decoded at load")
+	}
+	for _, pageID := range []int{1, 2, 500, 1000} {
+		if got, ok := loaded.mappedMeta.Get(pageID); !ok || got != cache.Meta[pageID] {
+			t.Errorf("mapped metadata of page %d = %+v, %v; want %+v", pageID, got, ok, cache.Meta[pageID])
+		}
+	}
+	for _, pageID := range []int{0, 1001, -1} {
+		if _, ok := loaded.mappedMeta.Get(pageID); ok {
+			t.Errorf("mapped metadata has page %d", pageID)
+		}
+	}
+	if !hasTextMetadata(loaded.allMeta()) {
+		t.Error("hasTextMetadata is false for mapped metadata")
+	}
+
+	// The store puts pages seen while serving over the mapped records
+	store := loadMetaStore(loaded, nil)
+	store.Set(2, PageMeta{Bytes: 7})
+	store.Set(5000, PageMeta{Bytes: 9})
+	if got, _ := store.Get(2); got.Bytes != 7 {
+		t.Errorf("page 2 after Set = %+v", got)
+	}
+	if got, _ := store.Get(3); got != cache.Meta[3] {
+		t.Errorf("page 3 = %+v, want %+v", got, cache.Meta[3])
+	}
+	if store.Len() != 1001 {
+		t.Errorf("store holds %d pages, want 1001", store.Len())
+	}
+
+	if !reflect.DeepEqual(loaded.pageMeta(), cache.Meta) {
+		t.Error("decoded metadata differs after the round trip")
+	}
+	if !reflect.DeepEqual(loaded.titleOrder, sortedTitleOrder(cache.Entries)) {
+		t.Error("title order differs from sorting the titles")
+	}
+
+	// Another index file's binary index is refused
+	other := filepath.Join(t.TempDir(), "other.txt.bz2")
+	os.WriteFile(other, []byte("other"), 0644)
+	if _, err := loadIndexBin(binFile, other); err == nil {
+		t.Error("loaded a binary index built for a different index file")
+	}
+}
+
+// BenchmarkLoadIndexBin measures startup from the binary index: mapping
+// it, building the entries and title set and opening the metadata. Run it with
+// -bench-entries=6000000 for an index the size of English Wikipedia.
+func BenchmarkLoadIndexBin(b *testing.B) {
+	cache, indexFile := syntheticIndex(b, *benchEntries)
+	binFile := indexBinFile(indexFile)
+	if err := saveIndexBin(cache, binFile, indexFile); err != nil {
+		b.Fatal(err)
+	}
+	cache = nil
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		loaded, err := loadIndexBin(binFile, indexFile)
+		if err != nil {
+			b.Fatal(err)
+		}
+		loadTime := time.Since(start)
+		titles := loaded.titleSet()
+		meta := loadMetaStore(loaded, nil)
+		b.ReportMetric(loadTime.Seconds(), "load-s")
+		b.ReportMetric(time.Since(start).Seconds(), "startup-s")
+		if titles.Len() != *benchEntries || meta.Len() != *benchEntries {
+			b.Fatalf("loaded %d titles and metadata for %d pages, want %d", titles.Len(), meta.Len(), *benchEntries)
+		}
+	}
+}
+
+// BenchmarkServerStartup measures, from the NewServer call of a server
+// started from the binary index, the time until it answers its first
+// request, the home page, then a link to a missing article and a palette
+// query, which wait for the tables built in the background. Run it with
+// -bench-entries=6000000 for an index the size of English Wikipedia.
+func BenchmarkServerStartup(b *testing.B) {
+	cache, indexFile := syntheticIndex(b, *benchEntries)
+	if err := saveIndexBin(cache, indexBinFile(indexFile), indexFile); err != nil {
+		b.Fatal(err)
+	}
+	cache = nil
+	config := DefaultConfig()
+	config.File = filepath.Join(filepath.Dir(indexFile), "dump.xml.bz2")
+	config.Index = indexFile
+	config.AssetDir = ".."
+	if err := os.WriteFile(config.File, nil, 0644); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		s, err := NewServer(config)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ready := time.Since(start)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusOK {
+			b.Fatalf("home page: got %d", w.Code)
+		}
+		firstResponse := time.Since(start)
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/wiki/no_such_article", nil))
+		if w.Code != http.StatusNotFound {
+			b.Fatalf("missing article: got %d, want 404", w.Code)
+		}
+		missing := time.Since(start)
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/api/quickopen?q=List+of+things", nil))
+		if w.Code != http.StatusOK {
+			b.Fatalf("quick open: got %d", w.Code)
+		}
+		b.ReportMetric(ready.Seconds(), "newserver-s")
+		b.ReportMetric(firstResponse.Seconds(), "first-response-s")
+		b.ReportMetric(missing.Seconds(), "missing-s")
+		b.ReportMetric(time.Since(start).Seconds(), "quickopen-s")
+	}
+}
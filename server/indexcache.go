@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"os"
+)
+
+// indexCacheVersion is the schema version of indexCache. Bump it whenever
+// indexCache or the types inside it change shape, and teach
+// migrateIndexCache how to read the old layout if that is possible.
+//
+// History:
+//  1. bare gzip-compressed gob of []IndexEntry, no header
+//  2. gzip-compressed gob of indexCache with a Version field, no header
+//  3. header with magic bytes, schema version and index checksum
+//  4. Namespaces: entry counts of the namespaces left out of Entries
+//  5. Templates: entries of the template namespace
+const indexCacheVersion = 5
+
+// indexCacheMagic starts every cache file written since version 3.
+var indexCacheMagic = [8]byte{'W', 'S', 'I', 'D', 'X', 'C', 'A', 'C'}
+
+// indexCacheHeader precedes the compressed payload. Source is the
+// fingerprint of the index file the cache was built from.
+type indexCacheHeader struct {
+	Magic   [8]byte
+	Version uint32
+	Source  [16]byte
+}
+
+// indexCache is the parsed index saved next to the index file. Meta is
+// filled in by a metadata pass and may be empty; when the cache comes from
+// the binary index it stays in the mapping, in mappedMeta, until
+// pageMeta decodes it. Namespaces counts the
+// index lines of each non-article namespace; it is nil for caches
+// migrated from before version 4. Templates locates the template pages,
+// which aren't part of Entries; it is nil for caches migrated from before
+// version 5.
+type indexCache struct {
+	Version    int
+	Entries    []IndexEntry
+	Meta       map[int]PageMeta
+	Namespaces map[string]int
+	Templates  []IndexEntry
+
+	titleOrder []int32     // entry positions sorted by title, from the binary index
+	mappedMeta *mappedMeta // page metadata, from the binary index
+}
+
+// pageMeta returns the page metadata as a map, decoding it from the binary
+// index if the cache was loaded from one. Commands that rewrite the
+// metadata use it; the server reads the mapped records in place.
+func (cache *indexCache) pageMeta() map[int]PageMeta {
+	if cache.Meta == nil && cache.mappedMeta != nil {
+		cache.Meta = make(map[int]PageMeta, cache.mappedMeta.Len())
+		for pageID, m := range cache.mappedMeta.All() {
+			cache.Meta[pageID] = m
+		}
+	}
+	cache.mappedMeta = nil
+	return cache.Meta
+}
+
+// allMeta yields the metadata of every page without decoding it into a map.
+func (cache *indexCache) allMeta() iter.Seq2[int, PageMeta] {
+	if cache.Meta == nil && cache.mappedMeta != nil {
+		return cache.mappedMeta.All()
+	}
+	return maps.All(cache.Meta)
+}
+
+// titleSet builds the title set of the entries, reusing the sorted order
+// the binary index stores when the cache came from one.
+func (cache *indexCache) titleSet() titleSet {
+	if cache.titleOrder != nil {
+		order := cache.titleOrder
+		cache.titleOrder = nil
+		return titleSetFromOrder(cache.Entries, order)
+	}
+	return newTitleSet(cache.Entries)
+}
+
+// indexCacheFile returns the cache path for an index file.
+func indexCacheFile(indexFilename string) string {
+	return indexFilename + ".cache"
+}
+
+func indexSourceID(indexFilename string) ([16]byte, error) {
+	var id [16]byte
+	fp, err := fileFingerprint(indexFilename)
+	if err != nil {
+		return id, err
+	}
+	copy(id[:], fp)
+	return id, nil
+}
+
+// saveIndexCache atomically writes the cache for an index file, and the
+// binary index with it, warning on log if that fails.
+func saveIndexCache(cache *indexCache, cacheFile, indexFilename string, log io.Writer) error {
+	source, err := indexSourceID(indexFilename)
+	if err != nil {
+		return err
+	}
+	cache.Version = indexCacheVersion
+	cache.pageMeta()
+
+	tmp := cacheFile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating cache file: %v", err)
+	}
+	w := bufio.NewWriter(f)
+	header := indexCacheHeader{Magic: indexCacheMagic, Version: indexCacheVersion, Source: source}
+	err = binary.Write(w, binary.LittleEndian, header)
+	if err == nil {
+		gw := gzip.NewWriter(w)
+		if err = gob.NewEncoder(gw).Encode(cache); err == nil {
+			err = gw.Close()
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing cache: %v", err)
+	}
+	if err := os.Rename(tmp, cacheFile); err != nil {
+		return err
+	}
+	// The binary index is rebuilt alongside, as it holds the same data
+	if err := saveIndexBin(cache, indexBinFile(indexFilename), indexFilename); err != nil {
+		fmt.Fprintf(log, "Warning: failed to save binary index: %v\n", err)
+	}
+	return nil
+}
+
+// loadIndexCache reads the cache for an index file. Caches built from a
+// different index file, or with a newer schema, are refused; caches in an
+// older layout are migrated and rewritten.
+func loadIndexCache(cacheFile, indexFilename string, log io.Writer) (*indexCache, error) {
+	f, err := os.Open(cacheFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	prefix, err := r.Peek(len(indexCacheMagic))
+	if err != nil {
+		return nil, fmt.Errorf("reading cache header: %v", err)
+	}
+	if !bytes.Equal(prefix, indexCacheMagic[:]) {
+		cache, err := migrateIndexCache(r)
+		if err != nil {
+			return nil, err
+		}
+		cache.shareOffsets()
+		fmt.Fprintln(log, "Migrating index cache to the current format")
+		if err := saveIndexCache(cache, cacheFile, indexFilename, log); err != nil {
+			fmt.Fprintf(log, "Warning: failed to save migrated index cache: %v\n", err)
+		}
+		return cache, nil
+	}
+
+	var header indexCacheHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("reading cache header: %v", err)
+	}
+	// Versions 3 and 4 only lack fields that gob leaves empty, so they are
+	// read as is and rewritten.
+	if header.Version != indexCacheVersion && header.Version != 3 && header.Version != 4 {
+		return nil, fmt.Errorf("cache schema version %d, this build reads %d", header.Version, indexCacheVersion)
+	}
+	source, err := indexSourceID(indexFilename)
+	if err != nil {
+		return nil, err
+	}
+	if header.Source != source {
+		return nil, fmt.Errorf("cache was built from a different index file")
+	}
+
+	var cache indexCache
+	if err := decodeGobGzip(r, &cache); err != nil {
+		return nil, fmt.Errorf("decoding cache: %v", err)
+	}
+	cache.shareOffsets()
+	if header.Version != indexCacheVersion {
+		fmt.Fprintln(log, "Migrating index cache to the current format")
+		if err := saveIndexCache(&cache, cacheFile, indexFilename, log); err != nil {
+			fmt.Fprintf(log, "Warning: failed to save migrated index cache: %v\n", err)
+		}
+	}
+	return &cache, nil
+}
+
+// shareOffsets points entries in the same stream back at one OffsetPair.
+// gob decodes every pointer into its own copy, which costs memory and
+// breaks code that groups entries by stream.
+func (cache *indexCache) shareOffsets() {
+	offsets := newOffsetCache()
+	for i := range cache.Entries {
+		e := &cache.Entries[i]
+		e.Offsets = offsets.getOrCreate(e.Offsets.Start, e.Offsets.End)
+	}
+	for i := range cache.Templates {
+		e := &cache.Templates[i]
+		e.Offsets = offsets.getOrCreate(e.Offsets.Start, e.Offsets.End)
+	}
+}
+
+// migrateIndexCache reads a cache written before the header existed:
+// version 2 (indexCache) or version 1 (bare entry list). Those caches carry
+// no checksum, so they are trusted as belonging to the index beside them.
+func migrateIndexCache(r io.Reader) (*indexCache, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading cache: %v", err)
+	}
+	var cache indexCache
+	if err := decodeGobGzip(bytes.NewReader(data), &cache); err == nil && cache.Version == 2 {
+		return &cache, nil
+	}
+	var entries []IndexEntry
+	if err := decodeGobGzip(bytes.NewReader(data), &entries); err == nil && len(entries) > 0 {
+		return &indexCache{Entries: entries}, nil
+	}
+	return nil, fmt.Errorf("unrecognized cache format")
+}
+
+func decodeGobGzip(r io.Reader, v interface{}) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	return gob.NewDecoder(gr).Decode(v)
+}
+
+func encodeGobGzip(w io.Writer, v interface{}) error {
+	gw := gzip.NewWriter(w)
+	if err := gob.NewEncoder(gw).Encode(v); err != nil {
+		return err
+	}
+	return gw.Close()
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Infoboxes have no handler of their own: every {{Infobox ...}} template
+// without a -template-dir definition is drawn as a generic box of its
+// parameters, with the image parameters turned into an image block at the
+// top. Wikipedia builds infoboxes with Lua modules, which -dump-templates
+// can't run, so the generic box is used ahead of the dump's definitions.
+//
+// The inline HTML policy has no place for <img>, so the image block is a
+// figure naming its file, and the "media" render stage fills it in from
+// the -media source after the sanitize stage has dropped the sources of
+// the images pandoc writes.
+
+// defaultInfoboxImageWidth is the width of infobox images, in pixels, when
+// the infobox doesn't give one, matching MediaWiki's frameless default.
+const defaultInfoboxImageWidth = 220
+
+// infoboxImageParams are the parameters that make up the image block,
+// rather than rows of the box.
+var infoboxImageParams = map[string]bool{
+	"image": true, "image1": true, "image name": true, "image file": true,
+	"alt": true, "image alt": true, "caption": true, "image caption": true,
+	"image size": true, "imagesize": true, "image width": true, "upright": true,
+}
+
+// infoboxHiddenParams configure the box itself and aren't shown as rows.
+var infoboxHiddenParams = map[string]bool{
+	"name": true, "title": true, "above": true, "embed": true, "child": true,
+	"subbox": true, "italic title": true,
+}
+
+var imageSizeRe = regexp.MustCompile(`^\s*(\d+)\s*(?:x\s*\d+\s*)?(?:px)?\s*$`)
+
+// isInfobox reports whether a template name, lowercased with spaces for
+// underscores, is an infobox.
+func isInfobox(name string) bool {
+	return name == "infobox" || strings.HasPrefix(name, "infobox ")
+}
+
+// infoboxParam is one name=value parameter, in the order written.
+type infoboxParam struct {
+	name, value string
+}
+
+// infoboxParams returns the named parameters of a template call in order,
+// with names lowercased and underscores read as spaces.
+func infoboxParams(args []string) []infoboxParam {
+	var params []infoboxParam
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || strings.ContainsAny(name, "<[{") {
+			continue
+		}
+		params = append(params, infoboxParam{infoboxParamName(name), strings.TrimSpace(value)})
+	}
+	return params
+}
+
+// infoboxParamName normalizes a parameter name, so image_size and
+// "Image size" are the same parameter.
+func infoboxParamName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(name, "_", " ")), " "))
+}
+
+// infoboxTemplate renders an infobox as a table of its non-empty
+// parameters under its name, with the image block first.
+func infoboxTemplate(args []string) string {
+	params := infoboxParams(args)
+	named := make(map[string]string, len(params))
+	for _, p := range params {
+		named[p.name] = p.value
+	}
+
+	var b strings.Builder
+	b.WriteString(`<table class="infobox">`)
+	if title := firstNonEmpty(named["title"], named["name"], named["above"]); title != "" {
+		b.WriteString(`<caption>` + convertInline(title) + `</caption>`)
+	}
+	if image := infoboxImage(named); image != "" {
+		b.WriteString(`<tr><td colspan="2" class="infobox-image">` + image + `</td></tr>`)
+	}
+	for _, p := range params {
+		if p.value == "" || infoboxImageParams[p.name] || infoboxHiddenParams[p.name] ||
+			strings.HasSuffix(p.name, "style") || strings.HasSuffix(p.name, "class") {
+			continue
+		}
+		fmt.Fprintf(&b, `<tr><th scope="row">%s</th><td>%s</td></tr>`, escapeText(ucfirst(p.name)), convertInline(p.value))
+	}
+	b.WriteString(`</table>`)
+	return "\n" + b.String() + "\n"
+}
+
+// infoboxImage renders the image parameters of an infobox as a figure for
+// mediaStage, captioned with the caption or, failing that, the alt text.
+// It returns "" when the infobox has no image.
+func infoboxImage(named map[string]string) string {
+	file := mediaFileName(firstNonEmpty(named["image"], named["image1"], named["image name"], named["image file"]))
+	if file == "" {
+		return ""
+	}
+	alt := firstNonEmpty(named["alt"], named["image alt"])
+	caption := firstNonEmpty(named["caption"], named["image caption"], alt)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<figure class="infobox-figure" data-file="%s" data-width="%d"`, escapeText(strings.ReplaceAll(file, "_", " ")), infoboxImageWidth(named))
+	if alt != "" {
+		b.WriteString(` data-alt="` + escapeText(plainText(alt)) + `"`)
+	}
+	b.WriteString(`>`)
+	if caption != "" {
+		b.WriteString(`<figcaption>` + convertInline(caption) + `</figcaption>`)
+	}
+	b.WriteString(`</figure>`)
+	return b.String()
+}
+
+var mediaFigureRe = regexp.MustCompile(`(?s)<figure class="infobox-figure" data-file="([^"]*)" data-width="(\d+)"(?: data-alt="([^"]*)")?>(.*?)</figure>`)
+
+// mediaStage puts the images of infobox figures in place from the -media
+// source. Without one, figures become placeholders showing their caption,
+// and figures without a caption are removed.
+type mediaStage struct {
+	source string
+}
+
+func (mediaStage) Name() string { return "media" }
+
+func (s mediaStage) Process(ctx *RenderContext) error {
+	if !strings.Contains(ctx.HTML, "infobox-figure") {
+		return nil
+	}
+	ctx.HTML = mediaFigureRe.ReplaceAllStringFunc(ctx.HTML, func(figure string) string {
+		m := mediaFigureRe.FindStringSubmatch(figure)
+		file, alt, caption := html.UnescapeString(m[1]), m[3], m[4]
+		width, _ := strconv.Atoi(m[2])
+		src := thumbnailURL(s.source, file, width)
+		if src == "" {
+			if caption == "" {
+				return ""
+			}
+			return `<figure class="infobox-figure media-unavailable" title="` + escapeText(file) + `">` + caption + `</figure>`
+		}
+		img := `<img src="` + html.EscapeString(src) + `"`
+		if alt != "" {
+			img += ` alt="` + alt + `"`
+		}
+		return `<figure class="infobox-figure">` + img + ` loading="lazy">` + caption + `</figure>`
+	})
+	ctx.HTML = strings.ReplaceAll(ctx.HTML, `<tr><td colspan="2" class="infobox-image"></td></tr>`, "")
+	return nil
+}
+
+// infoboxImageWidth reads the image width from |image_size=250px or
+// |upright=1.2, falling back to defaultInfoboxImageWidth.
+func infoboxImageWidth(named map[string]string) int {
+	if m := imageSizeRe.FindStringSubmatch(firstNonEmpty(named["image size"], named["imagesize"], named["image width"])); m != nil {
+		if w, err := strconv.Atoi(m[1]); err == nil && w > 0 {
+			return min(w, 1000)
+		}
+	}
+	if f, err := strconv.ParseFloat(strings.TrimSpace(named["upright"]), 64); err == nil && f > 0 {
+		return min(int(defaultInfoboxImageWidth*f+0.5), 1000)
+	}
+	return defaultInfoboxImageWidth
+}
+
+// withInfoboxImage replaces the image parameter of an infobox call with
+// the finished image block, caption included, for -template-dir
+// definitions, so {{{image}}} shows the image rather than its file name.
+func withInfoboxImage(args []string) []string {
+	named := make(map[string]string)
+	for _, p := range infoboxParams(args) {
+		named[p.name] = p.value
+	}
+	image := infoboxImage(named)
+	out := make([]string, 0, len(args)+1)
+	for _, arg := range args {
+		if name, _, ok := strings.Cut(arg, "="); ok && infoboxImageParams[infoboxParamName(name)] {
+			continue
+		}
+		out = append(out, arg)
+	}
+	if image != "" {
+		out = append(out, "image="+image)
+	}
+	return out
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+const (
+	maxGrepResults    = 1000
+	maxGrepLines      = 5
+	maxGrepLineLen    = 300
+	maxGrepPattern    = 500
+	maxKeptJobs       = 20 // jobs remembered, finished ones pruned first
+	maxPendingJobs    = 4  // jobs queued or running at once
+	maxConcurrentJobs = 1
+)
+
+// errJobQueueFull is returned by submitGrep when maxPendingJobs jobs are
+// queued or running.
+var errJobQueueFull = errors.New("too many grep jobs queued; try again when one finishes")
+
+// grepMatch is one article whose text matched a grep job
+type grepMatch struct {
+	Title  string   `json:"title"`
+	PageID int      `json:"page_id"`
+	Lines  []string `json:"lines"`
+}
+
+// grepJob is a background regex scan over every stream of the dump
+type grepJob struct {
+	ID      string `json:"id"`
+	Pattern string `json:"pattern"`
+
+	mu        sync.Mutex
+	status    string // queued, running, done, cancelled or failed
+	message   string
+	results   []grepMatch
+	truncated bool
+	op        *operation
+	cancel    context.CancelFunc
+}
+
+// jobQueue runs grep jobs one at a time in the background
+type jobQueue struct {
+	mu    sync.Mutex
+	jobs  map[string]*grepJob
+	order []string
+	slots chan struct{}
+
+	index     []IndexEntry
+	titles    titleSet
+	inputFile string
+	streams   *streamCaches
+	hub       *progressHub
+	log       io.Writer
+}
+
+func newJobQueue(index []IndexEntry, titles titleSet, inputFile string, streams *streamCaches, hub *progressHub, log io.Writer) *jobQueue {
+	return &jobQueue{
+		jobs:      make(map[string]*grepJob),
+		slots:     make(chan struct{}, maxConcurrentJobs),
+		index:     index,
+		titles:    titles,
+		inputFile: inputFile,
+		streams:   streams,
+		hub:       hub,
+		log:       log,
+	}
+}
+
+// submitGrep queues a scan for pattern and returns its job.
+func (q *jobQueue) submitGrep(pattern string) (*grepJob, error) {
+	if pattern == "" || len(pattern) > maxGrepPattern {
+		return nil, fmt.Errorf("pattern must be 1 to %d characters", maxGrepPattern)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+
+	q.mu.Lock()
+	if q.pendingLocked() >= maxPendingJobs {
+		q.mu.Unlock()
+		return nil, errJobQueueFull
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &grepJob{ID: randomHex(8), Pattern: pattern, status: "queued", cancel: cancel}
+	q.jobs[job.ID] = job
+	q.order = append(q.order, job.ID)
+	q.pruneLocked()
+	q.mu.Unlock()
+
+	go q.run(ctx, job, re)
+	return job, nil
+}
+
+// pendingLocked counts the jobs queued or running. The caller must hold
+// q.mu.
+func (q *jobQueue) pendingLocked() int {
+	n := 0
+	for _, job := range q.jobs {
+		if !job.finished() {
+			n++
+		}
+	}
+	return n
+}
+
+// pruneLocked forgets the oldest finished jobs once more than maxKeptJobs
+// are kept. The caller must hold q.mu.
+func (q *jobQueue) pruneLocked() {
+	for len(q.order) > maxKeptJobs {
+		pruned := false
+		for i, id := range q.order {
+			if q.jobs[id].finished() {
+				delete(q.jobs, id)
+				q.order = append(q.order[:i], q.order[i+1:]...)
+				pruned = true
+				break
+			}
+		}
+		if !pruned {
+			return
+		}
+	}
+}
+
+func (q *jobQueue) get(id string) *grepJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.jobs[id]
+}
+
+func (q *jobQueue) run(ctx context.Context, job *grepJob, re *regexp.Regexp) {
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		job.finish("cancelled", "")
+		return
+	}
+	defer func() { <-q.slots }()
+
+	op := q.hub.Start("Grep: "+job.Pattern, 0)
+	job.mu.Lock()
+	job.status = "running"
+	job.op = op
+	job.mu.Unlock()
+
+	start := time.Now()
+	workers := max(1, runtime.NumCPU()/2)
+	last := 0
+	err := walkStreams(ctx, q.inputFile, q.index, q.streams, workers, func(done, total int) {
+		op.SetTotal(total)
+		op.Add(done - last)
+		last = done
+	}, q.log, func(pages []dump.Page) {
+		for _, page := range pages {
+			if _, ok := q.titles.Lookup(page.Title); !ok {
+				continue
+			}
+			if lines := grepLines(re, page.Revision.Text); lines != nil {
+				if !job.add(grepMatch{Title: page.Title, PageID: page.ID, Lines: lines}) {
+					job.cancel()
+					return
+				}
+			}
+		}
+	})
+
+	job.mu.Lock()
+	found, truncated := len(job.results), job.truncated
+	job.mu.Unlock()
+	summary := fmt.Sprintf("%d matching articles in %v", found, time.Since(start).Round(time.Second))
+	switch {
+	case truncated:
+		job.finish("done", summary+fmt.Sprintf(" (stopped at %d)", maxGrepResults))
+		op.Finish(summary, nil)
+	case err != nil:
+		job.finish("cancelled", summary)
+		op.Finish("", fmt.Errorf("cancelled after %s", summary))
+	default:
+		job.finish("done", summary)
+		op.Finish(summary, nil)
+	}
+}
+
+// grepLines returns up to maxGrepLines lines of text matching re.
+func grepLines(re *regexp.Regexp, text string) []string {
+	if !re.MatchString(text) {
+		return nil
+	}
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if re.MatchString(line) {
+			if len(line) > maxGrepLineLen {
+				line = line[:maxGrepLineLen] + "…"
+			}
+			lines = append(lines, line)
+			if len(lines) == maxGrepLines {
+				break
+			}
+		}
+	}
+	if lines == nil {
+		// The match spans lines
+		lines = []string{}
+	}
+	return lines
+}
+
+// add records a match, returning false once the result limit is reached.
+func (job *grepJob) add(m grepMatch) bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if len(job.results) >= maxGrepResults {
+		job.truncated = true
+		return false
+	}
+	job.results = append(job.results, m)
+	return true
+}
+
+// finished reports whether the job is done, cancelled or failed.
+func (job *grepJob) finished() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.status != "queued" && job.status != "running"
+}
+
+func (job *grepJob) finish(status, message string) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status = status
+	job.message = message
+}
+
+type grepJobStatus struct {
+	ID        string      `json:"id"`
+	Pattern   string      `json:"pattern"`
+	Status    string      `json:"status"`
+	Message   string      `json:"message,omitempty"`
+	Done      int         `json:"streams_done"`
+	Total     int         `json:"streams_total"`
+	Found     int         `json:"found"`
+	Truncated bool        `json:"truncated,omitempty"`
+	After     int         `json:"after"`
+	Results   []grepMatch `json:"results"`
+}
+
+// snapshot reports the job's state with the results found after the
+// first `after` ones, so pollers only fetch what is new.
+func (job *grepJob) snapshot(after int) grepJobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	s := grepJobStatus{
+		ID:        job.ID,
+		Pattern:   job.Pattern,
+		Status:    job.status,
+		Message:   job.message,
+		Found:     len(job.results),
+		Truncated: job.truncated,
+		After:     after,
+		Results:   []grepMatch{},
+	}
+	if job.op != nil {
+		job.op.hub.mu.Lock()
+		s.Done, s.Total = job.op.Done, job.op.Total
+		job.op.hub.mu.Unlock()
+	}
+	if after >= 0 && after < len(job.results) {
+		s.Results = append(s.Results, job.results[after:]...)
+	}
+	return s
+}
+
+// handleAPIGrepSubmit starts a grep job from POST /api/jobs/grep with a
+// "pattern" form value or JSON body.
+func handleAPIGrepSubmit(w http.ResponseWriter, r *http.Request, q *jobQueue) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+		return
+	}
+	pattern := r.FormValue("pattern")
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Pattern string `json:"pattern"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		pattern = body.Pattern
+	}
+	job, err := q.submitGrep(pattern)
+	if errors.Is(err, errJobQueueFull) {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Location", requestServer(r).cfg.BasePath+"/api/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job.snapshot(0))
+}
+
+// handleAPIJob reports a job's progress and new results on GET
+// /api/jobs/{id}?after=N, and cancels it on DELETE.
+func handleAPIJob(w http.ResponseWriter, r *http.Request, q *jobQueue) {
+	job := q.get(strings.TrimPrefix(r.URL.Path, "/api/jobs/"))
+	if job == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such job"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		after, _ := strconv.Atoi(r.FormValue("after"))
+		writeJSON(w, http.StatusOK, job.snapshot(after))
+	case http.MethodDelete:
+		job.cancel()
+		writeJSON(w, http.StatusOK, job.snapshot(0))
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET or DELETE"})
+	}
+}
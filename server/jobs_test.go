@@ -0,0 +1,36 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestGrepQueueIsBounded(t *testing.T) {
+	q := newJobQueue(nil, titleSet{}, "", nil, newProgressHub(), io.Discard)
+	q.slots <- struct{}{} // hold the slot so every job stays queued
+	var jobs []*grepJob
+	for range maxPendingJobs {
+		job, err := q.submitGrep("apple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+	if _, err := q.submitGrep("apple"); !errors.Is(err, errJobQueueFull) {
+		t.Fatalf("submission past the limit: got %v, want errJobQueueFull", err)
+	}
+	jobs[0].cancel()
+	for !jobs[0].finished() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := q.submitGrep("apple"); err != nil {
+		t.Errorf("submission after a cancel: %v", err)
+	}
+	q.mu.Lock()
+	for _, job := range q.jobs {
+		job.cancel()
+	}
+	q.mu.Unlock()
+}
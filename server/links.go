@@ -0,0 +1,156 @@
+package server
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var anchorHrefRe = regexp.MustCompile(`<a href="([^"]*)"`)
+
+// wikiLinkTitle returns the page title an internal article href points to,
+// or false for external links, fragments and other schemes.
+func wikiLinkTitle(href string) (string, bool) {
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "mailto:") ||
+		strings.Contains(href, "//") || strings.HasPrefix(href, "/") {
+		return "", false
+	}
+	href, _, _ = strings.Cut(html.UnescapeString(href), "#")
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return strings.ReplaceAll(href, "_", " "), href != ""
+}
+
+// markRedLinks styles links to pages missing from the index as red links
+// and points them at a search for the title instead of a 404, under
+// basePath.
+func markRedLinks(html string, titles titleSet, basePath string) string {
+	return anchorHrefRe.ReplaceAllStringFunc(html, func(m string) string {
+		href := anchorHrefRe.FindStringSubmatch(m)[1]
+		title, ok := wikiLinkTitle(href)
+		if !ok || titles.Has(title) {
+			return m
+		}
+		return `<a class="new" href="` + basePath + `/search?q=` + url.QueryEscape(title) + `"`
+	})
+}
+
+// prefetchTitles returns up to limit distinct existing pages linked from an
+// article, in the order they are linked.
+func prefetchTitles(html string, titles titleSet, self string, limit int) []string {
+	if limit <= 0 {
+		return nil
+	}
+	var found []string
+	seen := map[string]bool{self: true}
+	for _, m := range anchorHrefRe.FindAllStringSubmatch(html, -1) {
+		title, ok := wikiLinkTitle(m[1])
+		if !ok {
+			continue
+		}
+		title, ok = titles.Resolve(title)
+		if !ok || seen[title] {
+			continue
+		}
+		seen[title] = true
+		found = append(found, title)
+		if len(found) == limit {
+			break
+		}
+	}
+	return found
+}
+
+// outboundLinks returns the existing pages an article links to, sorted and
+// without duplicates or the article itself.
+func outboundLinks(html string, titles titleSet, self string) []string {
+	var found []string
+	seen := map[string]bool{self: true}
+	for _, m := range anchorHrefRe.FindAllStringSubmatch(html, -1) {
+		title, ok := wikiLinkTitle(m[1])
+		if !ok {
+			continue
+		}
+		title, ok = titles.Resolve(title)
+		if !ok || seen[title] {
+			continue
+		}
+		seen[title] = true
+		found = append(found, title)
+	}
+	sort.Strings(found)
+	return found
+}
+
+// prefetchHeader formats pages served under basePath and prefix for a Link
+// response header so browsers can fetch them ahead of the next click.
+func prefetchHeader(basePath, prefix string, titles []string) string {
+	links := make([]string, len(titles))
+	for i, title := range titles {
+		links[i] = "<" + basePath + prefix + url.PathEscape(strings.ReplaceAll(title, " ", "_")) + ">; rel=prefetch"
+	}
+	return strings.Join(links, ", ")
+}
+
+type apiLink struct {
+	Title  string `json:"title"`
+	PageID int    `json:"page_id"`
+}
+
+type apiLinksResponse struct {
+	Title          string    `json:"title"`
+	PageID         int       `json:"page_id"`
+	RedirectedFrom string    `json:"redirected_from,omitempty"`
+	Count          int       `json:"count"`
+	Links          []apiLink `json:"links"`
+}
+
+// handleAPILinks serves /api/links/{title}: the articles a page links to
+// once rendered, templates included, sorted by title. Redirects are
+// followed. Pages are rendered through the render cache, so asking for the
+// links of a page just viewed is cheap.
+func handleAPILinks(w http.ResponseWriter, r *http.Request, index []IndexEntry, titles titleSet, pipeline *Pipeline, cache *renderCache) {
+	title, err := titleFromPath(r.URL.Path, "/api/links/")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	entry := findPageByTitle(index, titles, title)
+	if entry == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no article with that title"})
+		return
+	}
+	page, err := renderEntry(pipeline, entry, cache, nil, nil)
+	var from string
+	if err == nil && page.Redirect != "" {
+		var target string
+		target, _, err = followRedirects(index, titles, pipeline, cache, nil, nil, entry, page.Redirect)
+		if err != nil {
+			writeJSON(w, http.StatusLoopDetected, map[string]string{"error": err.Error()})
+			return
+		}
+		from, entry = entry.Title, findPageByTitle(index, titles, target)
+		if entry == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "redirect target " + target + " is not in the index"})
+			return
+		}
+		page, err = renderEntry(pipeline, entry, cache, nil, nil)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	resp := apiLinksResponse{Title: entry.Title, PageID: entry.PageID, RedirectedFrom: from, Links: []apiLink{}}
+	for _, linked := range page.Links {
+		if i, ok := titles.Lookup(linked); ok {
+			resp.Links = append(resp.Links, apiLink{Title: linked, PageID: index[i].PageID})
+		}
+	}
+	resp.Count = len(resp.Links)
+	writeJSON(w, http.StatusOK, resp)
+}
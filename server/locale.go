@@ -0,0 +1,261 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date orders for English dates, set per page by {{Use dmy dates}} and
+// {{Use mdy dates}}
+const (
+	dateOrderDMY = "dmy"
+	dateOrderMDY = "mdy"
+)
+
+// locale holds how template handlers write numbers and dates in one
+// language. Date layouts use {d}, {m} (month name) and {y}; layoutDMY is
+// used instead of layout when a page asks for day-first dates.
+type locale struct {
+	group, decimal string
+	months         [12]string
+	layout         string
+	layoutDMY      string
+	monthYear      string
+}
+
+var locales = map[string]*locale{
+	"en": {
+		group: ",", decimal: ".",
+		months:    [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		layout:    "{m} {d}, {y}",
+		layoutDMY: "{d} {m} {y}",
+		monthYear: "{m} {y}",
+	},
+	"de": {
+		group: ".", decimal: ",",
+		months:    [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		layout:    "{d}. {m} {y}",
+		monthYear: "{m} {y}",
+	},
+	"fr": {
+		group: " ", decimal: ",",
+		months:    [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		layout:    "{d} {m} {y}",
+		monthYear: "{m} {y}",
+	},
+	"es": {
+		group: ".", decimal: ",",
+		months:    [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		layout:    "{d} de {m} de {y}",
+		monthYear: "{m} de {y}",
+	},
+	"it": {
+		group: ".", decimal: ",",
+		months:    [12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		layout:    "{d} {m} {y}",
+		monthYear: "{m} {y}",
+	},
+	"nl": {
+		group: ".", decimal: ",",
+		months:    [12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		layout:    "{d} {m} {y}",
+		monthYear: "{m} {y}",
+	},
+	"pt": {
+		group: ".", decimal: ",",
+		months:    [12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		layout:    "{d} de {m} de {y}",
+		monthYear: "{m} de {y}",
+	},
+}
+
+// lookupLocale returns the locale for a -ui-lang language.
+func lookupLocale(lang string) (*locale, error) {
+	l, ok := locales[strings.ToLower(lang)]
+	if !ok {
+		var names []string
+		for name := range locales {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown language %q (want one of %s)", lang, strings.Join(names, ", "))
+	}
+	return l, nil
+}
+
+// formatNumber writes a number with the locale's digit grouping and
+// decimal mark, keeping up to decimals fractional digits (trailing zeros
+// dropped). A negative decimals keeps the shortest exact form.
+func (l *locale) formatNumber(v float64, decimals int) string {
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	frac = strings.TrimRight(frac, "0")
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(l.group)
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteString(l.decimal + frac)
+	}
+	return b.String()
+}
+
+// formatNumberText reformats a number written in wikitext, such as
+// "1234567" or "1,234,567.5", and returns the text unchanged when it is
+// not a plain number.
+func (l *locale) formatNumberText(s string) string {
+	v, err := parseNumber(s)
+	if err != nil {
+		return s
+	}
+	decimals := 0
+	if _, frac, ok := strings.Cut(strings.TrimSpace(s), "."); ok {
+		decimals = len(frac)
+	}
+	return l.formatNumber(v, decimals)
+}
+
+// formatDate writes a full or partial date: month and day may be zero.
+// dayFirst picks the day-month-year layout for locales that have both.
+func (l *locale) formatDate(year, month, day int, dayFirst bool) string {
+	if month < 1 || month > 12 {
+		return strconv.Itoa(year)
+	}
+	layout := l.layout
+	if dayFirst && l.layoutDMY != "" {
+		layout = l.layoutDMY
+	}
+	if day == 0 {
+		layout = l.monthYear
+	}
+	return strings.NewReplacer("{d}", strconv.Itoa(day), "{m}", l.months[month-1], "{y}", strconv.Itoa(year)).Replace(layout)
+}
+
+var (
+	useDatesRe     = regexp.MustCompile(`(?i)\{\{\s*use[ _](dmy|mdy)[ _]dates\s*[|}]`)
+	dateTemplateRe = regexp.MustCompile(`(?i)(\{\{\s*(?:start|end|birth|death)[ _]date(?:[ _]and[ _]age)?\s*)\|`)
+)
+
+// pageDateOrder returns the date order a page asks for with {{Use dmy
+// dates}} or {{Use mdy dates}}, or "" if it has neither.
+func pageDateOrder(text string) string {
+	if m := useDatesRe.FindStringSubmatch(text); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return ""
+}
+
+// applyDateOrder passes a page's date order to its date templates as the
+// df parameter they already take, so a df given on the template itself
+// still wins.
+func applyDateOrder(text, order string) string {
+	if order == "" {
+		return text
+	}
+	df := "df=n|"
+	if order == dateOrderDMY {
+		df = "df=y|"
+	}
+	return dateTemplateRe.ReplaceAllString(text, "${1}|"+df)
+}
+
+func init() {
+	for _, name := range []string{"start date", "end date", "birth date", "death date"} {
+		defaultTemplates.registerLocalized(name, dateTemplate)
+	}
+	defaultTemplates.registerLocalized("start date and age", dateAndAgeTemplate)
+	defaultTemplates.registerLocalized("birth date and age", dateAndAgeTemplate)
+	defaultTemplates.registerLocalized("death date and age", deathDateAndAge)
+}
+
+// dateParts reads year, month and day from the first positional arguments
+// of a date template.
+func dateParts(positional []string) (year, month, day int, ok bool) {
+	if len(positional) == 0 {
+		return 0, 0, 0, false
+	}
+	year, err := strconv.Atoi(positional[0])
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	if len(positional) > 1 {
+		month, _ = strconv.Atoi(positional[1])
+	}
+	if len(positional) > 2 {
+		day, _ = strconv.Atoi(positional[2])
+	}
+	return year, month, day, true
+}
+
+// dayFirst reports whether a date template asked for day-first dates with
+// df=y.
+func dayFirst(named map[string]string) bool {
+	switch strings.ToLower(named["df"]) {
+	case "y", "yes", "1", "true":
+		return true
+	}
+	return false
+}
+
+// dateTemplate renders {{Start date}}, {{Birth date}} and friends.
+func dateTemplate(l *locale, args []string) string {
+	positional, named := templateArgs(args)
+	year, month, day, ok := dateParts(positional)
+	if !ok {
+		return strings.Join(positional, " ")
+	}
+	return l.formatDate(year, month, day, dayFirst(named))
+}
+
+// dateAndAgeTemplate renders {{Birth date and age}} and {{Start date and
+// age}}, giving the age in whole years as of today.
+func dateAndAgeTemplate(l *locale, args []string) string {
+	positional, named := templateArgs(args)
+	year, month, day, ok := dateParts(positional)
+	if !ok {
+		return strings.Join(positional, " ")
+	}
+	now := time.Now()
+	date := l.formatDate(year, month, day, dayFirst(named))
+	return fmt.Sprintf("%s (age %d)", date, yearsBetween(year, month, day, now.Year(), int(now.Month()), now.Day()))
+}
+
+// deathDateAndAge renders {{Death date and age|death y|m|d|birth y|m|d}}.
+func deathDateAndAge(l *locale, args []string) string {
+	positional, named := templateArgs(args)
+	year, month, day, ok := dateParts(positional)
+	if !ok {
+		return strings.Join(positional, " ")
+	}
+	date := l.formatDate(year, month, day, dayFirst(named))
+	if len(positional) < 4 {
+		return date
+	}
+	by, bm, bd, ok := dateParts(positional[3:])
+	if !ok {
+		return date
+	}
+	return fmt.Sprintf("%s (aged %d)", date, yearsBetween(by, bm, bd, year, month, day))
+}
+
+// yearsBetween counts whole years from one date to another.
+func yearsBetween(y1, m1, d1, y2, m2, d2 int) int {
+	years := y2 - y1
+	if m2 < m1 || (m2 == m1 && d2 < d1) {
+		years--
+	}
+	return years
+}
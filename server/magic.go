@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// magicWords holds what the behavior switches and page-level magic words
+// of an article asked for.
+type magicWords struct {
+	DisplayTitle string
+	NoTOC        bool
+	ForceTOC     bool
+	TOCHere      bool
+	DateOrder    string // dmy or mdy from {{Use dmy dates}} or {{Use mdy dates}}
+}
+
+var (
+	behaviorSwitchRe = regexp.MustCompile(`__(NOTOC|FORCETOC|TOC|NOEDITSECTION|NEWSECTIONLINK|NONEWSECTIONLINK|NOGALLERY|HIDDENCAT|EXPECTUNUSEDCATEGORY|NOCONTENTCONVERT|NOCC|NOTITLECONVERT|NOTC|INDEX|NOINDEX|STATICREDIRECT|DISAMBIG|NOGLOBAL|EXPECTUNUSEDTEMPLATE)__`)
+	displayTitleRe   = regexp.MustCompile(`(?i)\{\{\s*DISPLAYTITLE\s*:\s*([^{}|]*?)\s*(\|[^{}]*)?\}\}`)
+	defaultSortRe    = regexp.MustCompile(`(?i)\{\{\s*(DEFAULTSORT|DEFAULTSORTKEY|DEFAULTCATEGORYSORT)\s*:[^{}]*\}\}`)
+)
+
+// tocPlaceholder marks where __TOC__ asked for the table of contents.
+const tocPlaceholder = `<div class="toc-placeholder"></div>`
+
+// extractMagicWords strips behavior switches, {{DISPLAYTITLE:}} and
+// {{DEFAULTSORT:}} from wikitext and reports what they asked for. The
+// first __TOC__ is replaced with a placeholder for insertTOC.
+func extractMagicWords(text string) (string, magicWords) {
+	var mw magicWords
+	text = behaviorSwitchRe.ReplaceAllStringFunc(text, func(m string) string {
+		switch m {
+		case "__NOTOC__":
+			mw.NoTOC = true
+		case "__FORCETOC__":
+			mw.ForceTOC = true
+		case "__TOC__":
+			if !mw.TOCHere {
+				mw.TOCHere = true
+				return "\n" + tocPlaceholder + "\n"
+			}
+		}
+		return ""
+	})
+	text = displayTitleRe.ReplaceAllStringFunc(text, func(m string) string {
+		mw.DisplayTitle = strings.TrimSpace(plainText(displayTitleRe.FindStringSubmatch(m)[1]))
+		return ""
+	})
+	text = defaultSortRe.ReplaceAllString(text, "")
+	mw.DateOrder = pageDateOrder(text)
+	text = applyDateOrder(text, mw.DateOrder)
+	return text, mw
+}
+
+// displayTitle returns the title to show for a page: the DISPLAYTITLE if
+// it only changes formatting or capitalization, as MediaWiki requires,
+// and the real title otherwise.
+func displayTitle(title, requested string) string {
+	if requested != "" && strings.EqualFold(strings.ReplaceAll(requested, "_", " "), title) {
+		return requested
+	}
+	return title
+}
+
+var tocHeadingRe = regexp.MustCompile(`(?s)<h([2-6])[^>]*\sid="([^"]*)"[^>]*>(.*?)</h[2-6]>`)
+
+// minTOCHeadings is how many headings an article needs before it gets a
+// table of contents without __FORCETOC__ or __TOC__.
+const minTOCHeadings = 4
+
+// insertTOC adds a numbered table of contents built from the headings of
+// the rendered HTML. It goes where __TOC__ was, or before the first
+// heading. __TOC__ wins over __NOTOC__, as in MediaWiki.
+func insertTOC(body string, mw magicWords) string {
+	headings := tocHeadingRe.FindAllStringSubmatch(body, -1)
+	show := mw.TOCHere || (!mw.NoTOC && (mw.ForceTOC || len(headings) >= minTOCHeadings))
+	if !show || len(headings) == 0 {
+		return strings.Replace(body, tocPlaceholder, "", 1)
+	}
+
+	var b strings.Builder
+	b.WriteString(`<nav class="toc" aria-labelledby="toc-title"><h2 class="toc-title" id="toc-title">Contents</h2>`)
+	var counters []int
+	depth := 0
+	top := int(headings[0][1][0] - '0')
+	for _, h := range headings {
+		top = min(top, int(h[1][0]-'0'))
+	}
+	for _, h := range headings {
+		level := int(h[1][0]-'0') - top + 1
+		for depth < level {
+			b.WriteString("<ol>")
+			counters = append(counters, 0)
+			depth++
+		}
+		for depth > level {
+			b.WriteString("</li></ol>")
+			counters = counters[:len(counters)-1]
+			depth--
+		}
+		if counters[depth-1] > 0 {
+			b.WriteString("</li>")
+		}
+		counters[depth-1]++
+		var number []string
+		for _, n := range counters {
+			number = append(number, fmt.Sprint(max(n, 1)))
+		}
+		label := html.EscapeString(html.UnescapeString(tagRe.ReplaceAllString(h[3], "")))
+		fmt.Fprintf(&b, `<li><a href="#%s"><span class="toc-number">%s</span> %s</a>`, h[2], strings.Join(number, "."), label)
+	}
+	for ; depth > 0; depth-- {
+		b.WriteString("</li></ol>")
+	}
+	b.WriteString("</nav>\n")
+
+	if strings.Contains(body, tocPlaceholder) {
+		return strings.Replace(body, tocPlaceholder, b.String(), 1)
+	}
+	loc := tocHeadingRe.FindStringIndex(body)
+	return body[:loc[0]] + b.String() + body[loc[0]:]
+}
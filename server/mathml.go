@@ -0,0 +1,552 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// mathSpanRe matches TeX math as pandoc and the native converter mark it
+// up.
+var mathSpanRe = regexp.MustCompile(`(?s)<span class="math (inline|display)">\\[(\[](.*?)\\[)\]]</span>`)
+
+// mathMLStage replaces TeX formulas with MathML, which browsers typeset
+// without scripts. Formulas using TeX the converter doesn't know are left
+// as they are.
+func mathMLStage(ctx *RenderContext) error {
+	ctx.HTML = mathSpanRe.ReplaceAllStringFunc(ctx.HTML, func(m string) string {
+		sub := mathSpanRe.FindStringSubmatch(m)
+		out, err := texToMathML(html.UnescapeString(sub[2]), sub[1] == "display")
+		if err != nil {
+			return m
+		}
+		return out
+	})
+	return nil
+}
+
+// texToMathML converts a TeX formula to a MathML element. It covers the
+// math found in most articles: scripts, fractions, roots, Greek letters,
+// operators and relations, functions, accents, font styles, delimiters and
+// spacing. Environments, alignment and macros are not supported and return
+// an error.
+func texToMathML(tex string, display bool) (string, error) {
+	p := &texParser{s: tex, display: display}
+	body, err := p.row(0)
+	if err != nil {
+		return "", err
+	}
+	mode := "inline"
+	if display {
+		mode = "block"
+	}
+	return `<math display="` + mode + `"><semantics>` + mrow(body) +
+		`<annotation encoding="application/x-tex">` + html.EscapeString(strings.TrimSpace(tex)) + `</annotation></semantics></math>`, nil
+}
+
+// texParser reads a TeX formula from s, writing MathML.
+type texParser struct {
+	s       string
+	pos     int
+	display bool
+}
+
+// mrow groups elements into one, as scripts and fractions need.
+func mrow(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "<mrow>" + strings.Join(items, "") + "</mrow>"
+}
+
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\n\r", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// row reads elements up to the closing byte stop, or to the end of the
+// formula when stop is 0.
+func (p *texParser) row(stop byte) ([]string, error) {
+	var items []string
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			if stop != 0 {
+				return nil, fmt.Errorf("missing %q", stop)
+			}
+			return items, nil
+		}
+		if p.s[p.pos] == stop {
+			p.pos++
+			return items, nil
+		}
+		if p.s[p.pos] == '}' {
+			return nil, fmt.Errorf("unbalanced }")
+		}
+		item, err := p.scripted()
+		if err != nil {
+			return nil, err
+		}
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+}
+
+// scripted reads an element with any sub- and superscripts attached.
+func (p *texParser) scripted() (string, error) {
+	base, limits, err := p.atom(false)
+	if err != nil {
+		return "", err
+	}
+	var sub string
+	var sup []string
+	primes := 0
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			break
+		}
+		c := p.s[p.pos]
+		if c == '\'' {
+			p.pos++
+			primes++
+			sup = append(sup, "<mo>′</mo>")
+			continue
+		}
+		if c != '^' && c != '_' {
+			break
+		}
+		p.pos++
+		arg, err := p.arg()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case c == '_' && sub != "":
+			return "", fmt.Errorf("double subscript")
+		case c == '_':
+			sub = arg
+		case len(sup) > primes:
+			return "", fmt.Errorf("double superscript")
+		default:
+			sup = append(sup, arg)
+		}
+	}
+	if sub == "" && len(sup) == 0 {
+		return base, nil
+	}
+	if base == "" {
+		base = "<mrow></mrow>"
+	}
+	under, over, both := "msub", "msup", "msubsup"
+	if limits && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case len(sup) == 0:
+		return "<" + under + ">" + base + sub + "</" + under + ">", nil
+	case sub == "":
+		return "<" + over + ">" + base + mrow(sup) + "</" + over + ">", nil
+	}
+	return "<" + both + ">" + base + sub + mrow(sup) + "</" + both + ">", nil
+}
+
+// arg reads the argument of a command or script: a braced group, or a
+// single character or command.
+func (p *texParser) arg() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return "", fmt.Errorf("missing argument")
+	}
+	out, _, err := p.atom(true)
+	if err == nil && out == "" {
+		out = "<mrow></mrow>"
+	}
+	return out, err
+}
+
+// rawGroup reads a braced group, or a single character, as text.
+func (p *texParser) rawGroup() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return "", fmt.Errorf("missing argument")
+	}
+	if p.s[p.pos] != '{' {
+		_, size := utf8.DecodeRuneInString(p.s[p.pos:])
+		p.pos += size
+		return p.s[p.pos-size : p.pos], nil
+	}
+	depth := 0
+	for i := p.pos; i < len(p.s); i++ {
+		switch p.s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				text := p.s[p.pos+1 : i]
+				p.pos = i + 1
+				return text, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("missing }")
+}
+
+// atom reads one element. single limits numbers to one digit, as in x^23.
+// limits reports an operator whose scripts go above and below it in
+// display math.
+func (p *texParser) atom(single bool) (out string, limits bool, err error) {
+	s := p.s[p.pos:]
+	r, size := utf8.DecodeRuneInString(s)
+	switch {
+	case r == '{':
+		p.pos++
+		items, err := p.row('}')
+		if err != nil {
+			return "", false, err
+		}
+		if len(items) == 0 {
+			return "<mrow></mrow>", false, nil
+		}
+		return mrow(items), false, nil
+	case r == '\\':
+		return p.command()
+	case r >= '0' && r <= '9' || r == '.' && len(s) > 1 && s[1] >= '0' && s[1] <= '9':
+		n := 1
+		for !single && n < len(s) && (s[n] >= '0' && s[n] <= '9' || s[n] == '.' && n+1 < len(s) && s[n+1] >= '0' && s[n+1] <= '9') {
+			n++
+		}
+		p.pos += n
+		return "<mn>" + s[:n] + "</mn>", false, nil
+	case r == '~':
+		p.pos++
+		return `<mspace width="0.25em"></mspace>`, false, nil
+	case r == '&' || r == '#' || r == '$' || r == '%' || r == '^' || r == '_' || r == '}':
+		return "", false, fmt.Errorf("unexpected %q", r)
+	}
+	p.pos += size
+	if op, ok := texCharOperators[r]; ok {
+		return "<mo>" + op + "</mo>", false, nil
+	}
+	if unicode.IsLetter(r) {
+		return "<mi>" + string(r) + "</mi>", false, nil
+	}
+	return "<mo>" + html.EscapeString(string(r)) + "</mo>", false, nil
+}
+
+// command reads a command starting with a backslash and its arguments.
+func (p *texParser) command() (string, bool, error) {
+	p.pos++
+	if p.pos >= len(p.s) {
+		return "", false, fmt.Errorf("trailing backslash")
+	}
+	start := p.pos
+	for p.pos < len(p.s) && (p.s[p.pos] >= 'a' && p.s[p.pos] <= 'z' || p.s[p.pos] >= 'A' && p.s[p.pos] <= 'Z') {
+		p.pos++
+	}
+	if p.pos == start {
+		// A single non-letter: escaped characters and spacing
+		c := p.s[p.pos]
+		p.pos++
+		if width, ok := texSpaces[string(c)]; ok {
+			return `<mspace width="` + width + `"></mspace>`, false, nil
+		}
+		if escaped, ok := texEscapes[c]; ok {
+			return "<mo>" + escaped + "</mo>", false, nil
+		}
+		return "", false, fmt.Errorf("unsupported \\%c", c)
+	}
+	name := p.s[start:p.pos]
+
+	if v, ok := texIdentifiers[name]; ok {
+		return v, false, nil
+	}
+	if v, ok := texOperators[name]; ok {
+		return "<mo>" + v + "</mo>", false, nil
+	}
+	if v, ok := texBigOperators[name]; ok {
+		return `<mo largeop="true">` + v.symbol + "</mo>", v.limits, nil
+	}
+	if texFunctions[name] {
+		return "<mi>" + name + "</mi>", texLimitFunctions[name], nil
+	}
+	if width, ok := texSpaces[name]; ok {
+		return `<mspace width="` + width + `"></mspace>`, false, nil
+	}
+	if accent, ok := texAccents[name]; ok {
+		base, err := p.arg()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "underline" {
+			return `<munder accentunder="true">` + base + "<mo>" + accent + "</mo></munder>", false, nil
+		}
+		return `<mover accent="true">` + base + "<mo>" + accent + "</mo></mover>", false, nil
+	}
+	if style, ok := texFontStyles[name]; ok {
+		text, err := p.rawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		out, err := styledText(style, text)
+		return out, false, err
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac":
+		num, err := p.arg()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.arg()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mfrac>" + num + den + "</mfrac>", false, nil
+	case "binom":
+		top, err := p.arg()
+		if err != nil {
+			return "", false, err
+		}
+		bottom, err := p.arg()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mrow><mo>(</mo><mfrac linethickness="0">` + top + bottom + `</mfrac><mo>)</mo></mrow>`, false, nil
+	case "sqrt":
+		p.skipSpace()
+		var index []string
+		if p.pos < len(p.s) && p.s[p.pos] == '[' {
+			p.pos++
+			var err error
+			if index, err = p.row(']'); err != nil {
+				return "", false, err
+			}
+		}
+		radicand, err := p.arg()
+		if err != nil {
+			return "", false, err
+		}
+		if len(index) > 0 {
+			return "<mroot>" + radicand + mrow(index) + "</mroot>", false, nil
+		}
+		return "<msqrt>" + radicand + "</msqrt>", false, nil
+	case "text", "textrm", "mbox", "textit", "textbf":
+		text, err := p.rawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mtext>" + html.EscapeString(text) + "</mtext>", false, nil
+	case "operatorname":
+		text, err := p.rawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mi>" + html.EscapeString(strings.TrimSpace(text)) + "</mi>", false, nil
+	case "left", "right", "middle", "big", "Big", "bigg", "Bigg", "bigl", "bigr", "Bigl", "Bigr":
+		return p.delimiter(name)
+	case "displaystyle", "textstyle", "scriptstyle", "limits", "nolimits":
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("unsupported \\%s", name)
+}
+
+// delimiter reads the delimiter after \left, \right and the like.
+func (p *texParser) delimiter(name string) (string, bool, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return "", false, fmt.Errorf("missing delimiter after \\%s", name)
+	}
+	if p.s[p.pos] == '.' {
+		p.pos++
+		return "", false, nil
+	}
+	out, _, err := p.atom(true)
+	if err != nil || !strings.HasPrefix(out, "<mo") {
+		return "", false, fmt.Errorf("bad delimiter after \\%s", name)
+	}
+	return out, false, nil
+}
+
+// styledText writes the text of a font command such as \mathbb{R} in the
+// Unicode mathematical letters of that style, which MathML renders as is.
+func styledText(style, text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "<mrow></mrow>", nil
+	}
+	var b strings.Builder
+	digits := true
+	for _, r := range text {
+		switch {
+		case r >= '0' && r <= '9':
+		case r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z':
+			digits = false
+		case r == ' ':
+			digits = false
+			continue
+		default:
+			return "", fmt.Errorf("unsupported character in styled text")
+		}
+		b.WriteRune(mathAlphanumeric(style, r))
+	}
+	if digits {
+		return "<mn>" + b.String() + "</mn>", nil
+	}
+	if style == "normal" && utf8.RuneCountInString(b.String()) == 1 {
+		return `<mi mathvariant="normal">` + b.String() + "</mi>", nil
+	}
+	if style == "italic" && utf8.RuneCountInString(b.String()) > 1 {
+		var items []string
+		for _, r := range b.String() {
+			items = append(items, "<mi>"+string(r)+"</mi>")
+		}
+		return mrow(items), nil
+	}
+	return "<mi>" + b.String() + "</mi>", nil
+}
+
+// mathAlphabet is where a style's letters and digits start in the
+// Mathematical Alphanumeric Symbols block; 0 means the style has none.
+type mathAlphabet struct {
+	upper, lower, digits rune
+}
+
+var mathAlphabets = map[string]mathAlphabet{
+	"bold":          {0x1D400, 0x1D41A, 0x1D7CE},
+	"double-struck": {0x1D538, 0x1D552, 0x1D7D8},
+	"script":        {0x1D49C, 0x1D4B6, 0},
+	"fraktur":       {0x1D504, 0x1D51E, 0},
+	"sans-serif":    {0x1D5A0, 0x1D5BA, 0x1D7E2},
+	"monospace":     {0x1D670, 0x1D68A, 0x1D7F6},
+}
+
+// mathAlphabetHoles are letters encoded outside the block because they
+// were in Unicode before it.
+var mathAlphabetHoles = map[string]map[rune]rune{
+	"double-struck": {'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'},
+	"script": {'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ',
+		'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'},
+	"fraktur": {'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'},
+}
+
+// mathAlphanumeric returns the letter or digit r in a style; styles
+// without their own glyphs, like normal and italic, keep r.
+func mathAlphanumeric(style string, r rune) rune {
+	if hole, ok := mathAlphabetHoles[style][r]; ok {
+		return hole
+	}
+	a, ok := mathAlphabets[style]
+	if !ok {
+		return r
+	}
+	switch {
+	case r >= 'A' && r <= 'Z':
+		return a.upper + r - 'A'
+	case r >= 'a' && r <= 'z':
+		return a.lower + r - 'a'
+	case r >= '0' && r <= '9' && a.digits != 0:
+		return a.digits + r - '0'
+	}
+	return r
+}
+
+var texFontStyles = map[string]string{
+	"mathrm": "normal", "mathup": "normal", "mathit": "italic", "mathbf": "bold", "boldsymbol": "bold",
+	"mathbb": "double-struck", "mathcal": "script", "mathscr": "script", "mathfrak": "fraktur",
+	"mathsf": "sans-serif", "mathtt": "monospace",
+}
+
+var texCharOperators = map[rune]string{
+	'-': "−", '*': "∗", '<': "&lt;", '>': "&gt;",
+}
+
+// texEscapes are the characters written with a backslash to be literal.
+var texEscapes = map[byte]string{
+	'{': "{", '}': "}", '%': "%", '$': "$", '#': "#", '&': "&amp;", '_': "_", '|': "‖",
+}
+
+var texSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ">": "0.2222em", ";": "0.2778em", "!": "-0.1667em", " ": "0.25em",
+	"quad": "1em", "qquad": "2em", "thinspace": "0.1667em", "medspace": "0.2222em", "thickspace": "0.2778em",
+}
+
+// texIdentifiers are letters and symbols written as identifiers.
+var texIdentifiers = func() map[string]string {
+	ids := map[string]string{
+		"infty": "<mi>∞</mi>", "partial": "<mi>∂</mi>", "nabla": "<mi>∇</mi>", "emptyset": "<mi>∅</mi>",
+		"varnothing": "<mi>∅</mi>", "ell": "<mi>ℓ</mi>", "hbar": "<mi>ℏ</mi>", "aleph": "<mi>ℵ</mi>",
+		"Re": "<mi>ℜ</mi>", "Im": "<mi>ℑ</mi>", "wp": "<mi>℘</mi>", "imath": "<mi>ı</mi>", "jmath": "<mi>ȷ</mi>",
+	}
+	lower := "alpha α beta β gamma γ delta δ epsilon ϵ varepsilon ε zeta ζ eta η theta θ vartheta ϑ iota ι " +
+		"kappa κ lambda λ mu μ nu ν xi ξ pi π varpi ϖ rho ρ varrho ϱ sigma σ varsigma ς tau τ upsilon υ " +
+		"phi ϕ varphi φ chi χ psi ψ omega ω"
+	upper := "Gamma Γ Delta Δ Theta Θ Lambda Λ Xi Ξ Pi Π Sigma Σ Upsilon Υ Phi Φ Psi Ψ Omega Ω"
+	fields := strings.Fields(lower)
+	for i := 0; i < len(fields); i += 2 {
+		ids[fields[i]] = "<mi>" + fields[i+1] + "</mi>"
+	}
+	// Capital Greek is upright, as TeX sets it
+	fields = strings.Fields(upper)
+	for i := 0; i < len(fields); i += 2 {
+		ids[fields[i]] = `<mi mathvariant="normal">` + fields[i+1] + "</mi>"
+	}
+	return ids
+}()
+
+// texOperators are binary operators, relations, arrows and punctuation.
+var texOperators = func() map[string]string {
+	ops := make(map[string]string)
+	list := "times × cdot ⋅ div ÷ pm ± mp ∓ ast ∗ star ⋆ circ ∘ bullet ∙ oplus ⊕ ominus ⊖ otimes ⊗ " +
+		"cup ∪ cap ∩ setminus ∖ wedge ∧ land ∧ vee ∨ lor ∨ neg ¬ lnot ¬ " +
+		"leq ≤ le ≤ geq ≥ ge ≥ neq ≠ ne ≠ approx ≈ equiv ≡ sim ∼ simeq ≃ cong ≅ propto ∝ " +
+		"ll ≪ gg ≫ in ∈ notin ∉ ni ∋ subset ⊂ supset ⊃ subseteq ⊆ supseteq ⊇ mid ∣ parallel ∥ perp ⊥ " +
+		"to → rightarrow → leftarrow ← gets ← leftrightarrow ↔ Rightarrow ⇒ Leftarrow ⇐ Leftrightarrow ⇔ " +
+		"implies ⟹ iff ⟺ mapsto ↦ uparrow ↑ downarrow ↓ forall ∀ exists ∃ nexists ∄ " +
+		"ldots … dots … cdots ⋯ vdots ⋮ ddots ⋱ prime ′ angle ∠ triangle △ degree ° " +
+		"langle ⟨ rangle ⟩ lfloor ⌊ rfloor ⌋ lceil ⌈ rceil ⌉ vert | Vert ‖ lvert | rvert | lbrace { rbrace } " +
+		"colon : backslash ∖ therefore ∴ because ∵"
+	fields := strings.Fields(list)
+	for i := 0; i < len(fields); i += 2 {
+		ops[fields[i]] = fields[i+1]
+	}
+	return ops
+}()
+
+type texBigOperator struct {
+	symbol string
+	limits bool
+}
+
+var texBigOperators = map[string]texBigOperator{
+	"sum": {"∑", true}, "prod": {"∏", true}, "coprod": {"∐", true}, "bigcup": {"⋃", true},
+	"bigcap": {"⋂", true}, "bigoplus": {"⨁", true}, "bigotimes": {"⨂", true}, "bigvee": {"⋁", true},
+	"bigwedge": {"⋀", true}, "int": {"∫", false}, "iint": {"∬", false}, "iiint": {"∭", false}, "oint": {"∮", false},
+}
+
+var texFunctions = func() map[string]bool {
+	names := "sin cos tan cot sec csc arcsin arccos arctan sinh cosh tanh coth log ln lg exp " +
+		"det dim ker gcd deg arg hom lim liminf limsup max min sup inf Pr"
+	funcs := make(map[string]bool)
+	for _, name := range strings.Fields(names) {
+		funcs[name] = true
+	}
+	return funcs
+}()
+
+// texLimitFunctions take their subscripts below in display math.
+var texLimitFunctions = map[string]bool{
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true, "sup": true, "inf": true,
+	"det": true, "gcd": true, "Pr": true,
+}
+
+var texAccents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→", "dot": "˙", "ddot": "¨",
+	"tilde": "~", "widetilde": "~", "check": "ˇ", "breve": "˘", "acute": "´", "grave": "`", "underline": "_",
+}
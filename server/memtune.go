@@ -0,0 +1,281 @@
+package server
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With -memory-limit the server sizes its caches itself: a tuner watches
+// the live heap every few seconds, shrinking the render caches and the
+// in-memory stream cache when the heap nears the limit and growing them
+// while there is room and they are full. The same flag then suits a
+// Raspberry Pi and a large server, where fixed -render-cache sizes would
+// either run out of memory or leave most of it unused.
+
+const (
+	tuneInterval = 5 * time.Second
+	// tuneHigh and tuneLow are the fractions of the limit above which the
+	// caches shrink and below which they may grow.
+	tuneHigh = 0.80
+	tuneLow  = 0.60
+	// minRenderCache and maxRenderCache bound the tuned render cache size,
+	// in pages.
+	minRenderCache = 20
+	maxRenderCache = 100000
+	// streamGrowStep is the least the stream cache grows by at a time.
+	streamGrowStep = 16 << 20
+)
+
+// parseByteSize reads a size such as 512MB, 1.5GiB or 800M. Units are
+// powers of 1024 whether or not they're written with an i; a bare number
+// is bytes.
+func parseByteSize(s string) (int64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	num := strings.TrimRight(t, "KMGTIB ")
+	unit := strings.TrimSpace(t[len(num):])
+	shift := 0
+	switch strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I") {
+	case "":
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	case "T":
+		shift = 40
+	default:
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q: want a positive number with an optional unit such as 512MB or 2GB", s)
+	}
+	return int64(n * float64(int64(1)<<shift)), nil
+}
+
+// memoryStreamCache is an LRU of decompressed streams bounded in bytes,
+// kept in front of the disk cache. A server only has one with
+// -memory-limit, whose tuner sizes it.
+// Callers share the cached slices and must not modify them.
+type memoryStreamCache struct {
+	inputFile string
+
+	mu    sync.Mutex
+	max   int64
+	size  int64
+	ll    *list.List
+	items map[int64]*list.Element
+
+	hits, misses int64
+}
+
+type memoryStream struct {
+	start int64
+	data  []byte
+}
+
+func newMemoryStreamCache(inputFile string, max int64) *memoryStreamCache {
+	return &memoryStreamCache{inputFile: inputFile, max: max, ll: list.New(), items: make(map[int64]*list.Element)}
+}
+
+// Get returns a cached stream, or false if it isn't in memory.
+func (c *memoryStreamCache) Get(start int64) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[start]; ok {
+		c.hits++
+		c.ll.MoveToFront(el)
+		return el.Value.(*memoryStream).data, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// Add keeps a stream, evicting old streams if needed.
+func (c *memoryStreamCache) Add(start int64, data []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int64(len(data)) > c.max {
+		return
+	}
+	if el, ok := c.items[start]; ok {
+		c.size -= int64(len(el.Value.(*memoryStream).data))
+		c.ll.Remove(el)
+	}
+	c.items[start] = c.ll.PushFront(&memoryStream{start: start, data: data})
+	c.size += int64(len(data))
+	c.evict()
+}
+
+// SetMax changes the byte budget, dropping the least recently used
+// streams when it shrinks.
+func (c *memoryStreamCache) SetMax(max int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.max = max
+	c.evict()
+}
+
+// evict drops the least recently used streams until the cache fits. The
+// caller must hold c.mu.
+func (c *memoryStreamCache) evict() {
+	for c.size > c.max && c.ll.Len() > 0 {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		s := oldest.Value.(*memoryStream)
+		delete(c.items, s.start)
+		c.size -= int64(len(s.data))
+	}
+}
+
+// Stats returns the cache's size, budget, stream count, hits and misses.
+func (c *memoryStreamCache) Stats() (size, max int64, streams int, hits, misses int64) {
+	if c == nil {
+		return 0, 0, 0, 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size, c.max, c.ll.Len(), c.hits, c.misses
+}
+
+// cacheTuner resizes the caches to keep the live heap under a limit.
+type cacheTuner struct {
+	limit   int64
+	renders []*renderCache
+	streams *memoryStreamCache
+	log     io.Writer
+
+	mu          sync.Mutex
+	adjustments int64
+	last        string
+	lastAt      time.Time
+}
+
+// startCacheTuner sets the Go runtime's soft memory limit, so garbage is
+// collected harder near it, and starts tuning the given caches. Caches
+// created disabled, with -render-cache 0, are left alone. Adjustments are
+// logged to log.
+func startCacheTuner(limit int64, renders []*renderCache, streams *memoryStreamCache, log io.Writer) *cacheTuner {
+	debug.SetMemoryLimit(limit)
+	t := &cacheTuner{limit: limit, streams: streams, log: log}
+	for _, c := range renders {
+		if c.Max() > 0 {
+			t.renders = append(t.renders, c)
+		}
+	}
+	go func() {
+		for range time.Tick(tuneInterval) {
+			t.tune(liveHeap())
+		}
+	}()
+	return t
+}
+
+// liveHeap returns the bytes of heap objects that survived the last
+// garbage collection, which unlike the total heap doesn't swing with
+// garbage not yet collected.
+func liveHeap() int64 {
+	sample := []metrics.Sample{{Name: "/gc/heap/live:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return int64(sample[0].Value.Uint64())
+}
+
+// tune shrinks every cache by a quarter when the heap is above tuneHigh of
+// the limit, and grows the full ones by a quarter when it is below
+// tuneLow.
+func (t *cacheTuner) tune(heap int64) {
+	var changes []string
+	switch {
+	case heap > int64(float64(t.limit)*tuneHigh):
+		for _, c := range t.renders {
+			if old := c.Max(); old > minRenderCache {
+				c.SetMax(max(minRenderCache, old*3/4))
+				changes = append(changes, fmt.Sprintf("render cache %d→%d pages", old, c.Max()))
+			}
+		}
+		if _, old, _, _, _ := t.streams.Stats(); old > 0 {
+			t.streams.SetMax(old / 2)
+			changes = append(changes, fmt.Sprintf("stream cache %s→%s", formatBytes(old), formatBytes(old/2)))
+		}
+		if len(changes) > 0 {
+			t.record("shrank "+strings.Join(changes, ", "), heap)
+		}
+	case heap < int64(float64(t.limit)*tuneLow):
+		for _, c := range t.renders {
+			if old := c.Max(); c.Len() >= old && old < maxRenderCache {
+				c.SetMax(min(maxRenderCache, old+max(old/4, 1)))
+				changes = append(changes, fmt.Sprintf("render cache %d→%d pages", old, c.Max()))
+			}
+		}
+		if size, old, _, _, _ := t.streams.Stats(); t.streams != nil && size >= old*9/10 && old < t.limit/4 {
+			grown := min(t.limit/4, old+max(old/4, streamGrowStep))
+			t.streams.SetMax(grown)
+			changes = append(changes, fmt.Sprintf("stream cache %s→%s", formatBytes(old), formatBytes(grown)))
+		}
+		if len(changes) > 0 {
+			t.record("grew "+strings.Join(changes, ", "), heap)
+		}
+	}
+}
+
+func (t *cacheTuner) record(change string, heap int64) {
+	t.mu.Lock()
+	t.adjustments++
+	t.last = change
+	t.lastAt = time.Now()
+	t.mu.Unlock()
+	fmt.Fprintf(t.log, "[%s] Memory tuner: heap %s of %s, %s\n", time.Now().Format("2006-01-02 15:04:05"), formatBytes(heap), formatBytes(t.limit), change)
+}
+
+// memoryStatus is the memory section of /admin/status, present with
+// -memory-limit.
+type memoryStatus struct {
+	Limit          int64      `json:"limit_bytes"`
+	HeapLive       int64      `json:"heap_live_bytes"`
+	StreamCache    int64      `json:"stream_cache_bytes"`
+	StreamCapacity int64      `json:"stream_cache_capacity_bytes"`
+	Streams        int        `json:"stream_cache_streams"`
+	StreamHits     int64      `json:"stream_cache_hits"`
+	StreamMisses   int64      `json:"stream_cache_misses"`
+	Adjustments    int64      `json:"adjustments"`
+	LastAdjustment string     `json:"last_adjustment,omitempty"`
+	LastAdjusted   *time.Time `json:"last_adjusted,omitempty"`
+}
+
+// Status reports the tuner's state for /admin/status; it is nil when the
+// server runs without -memory-limit.
+func (t *cacheTuner) Status() *memoryStatus {
+	if t == nil {
+		return nil
+	}
+	s := &memoryStatus{Limit: t.limit, HeapLive: liveHeap()}
+	s.StreamCache, s.StreamCapacity, s.Streams, s.StreamHits, s.StreamMisses = t.streams.Stats()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.Adjustments, s.LastAdjustment = t.adjustments, t.last
+	if !t.lastAt.IsZero() {
+		at := t.lastAt
+		s.LastAdjusted = &at
+	}
+	return s
+}
@@ -0,0 +1,385 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xanderstrike/wikiseek/dump"
+	"github.com/xanderstrike/wikiseek/store"
+)
+
+// PageMeta holds per-page facts gathered from the wikitext
+type PageMeta struct {
+	Bytes       int
+	Stub        bool
+	Redirect    bool
+	Disambig    bool
+	Description string
+	Image       string // file name of the lead image, without namespace
+	Quality     string // featured, good, stub or empty
+	// Imported from the SQL dumps by "wikiseek index sql"
+	Props          bool   // description, image and Disambig are from page_props
+	RedirectTarget string // title#fragment, from the redirect table
+}
+
+// KB returns the wikitext size in kilobytes, rounded up.
+func (m PageMeta) KB() int {
+	return (m.Bytes + 1023) / 1024
+}
+
+var (
+	redirectRe     = regexp.MustCompile(`(?i)^\s*#redirect`)
+	stubRe         = regexp.MustCompile(`(?i)\{\{\s*[^{}|]*stub\s*(\||\}\})`)
+	infoboxImageRe = regexp.MustCompile(`(?im)^\s*\|\s*image\d?\s*=\s*([^|\n<{]+)`)
+	disambigRe     = regexp.MustCompile(`(?i)\{\{\s*(disambiguation|disambig|disamb|dab|hndis|geodis|numberdis)\s*(\||\}\})|__DISAMBIG__`)
+	fileLinkRe     = regexp.MustCompile(`(?i)\[\[\s*(?:file|image)\s*:\s*([^|\]]+)`)
+)
+
+// analyzeWikiText derives page metadata from raw wikitext.
+func analyzeWikiText(text string) PageMeta {
+	meta := PageMeta{
+		Bytes:    len(text),
+		Stub:     stubRe.MatchString(text),
+		Redirect: redirectRe.MatchString(text),
+		Disambig: disambigRe.MatchString(text),
+	}
+	if !meta.Redirect {
+		meta.Description = describeWikiText(text)
+		meta.Image = leadImage(text)
+		meta.Quality = articleQuality(text)
+	}
+	return meta
+}
+
+// leadImage returns the infobox image of an article, or failing that the
+// first image it embeds.
+func leadImage(text string) string {
+	var name string
+	if m := infoboxImageRe.FindStringSubmatch(text); m != nil {
+		name = m[1]
+	} else if m := fileLinkRe.FindStringSubmatch(text); m != nil {
+		name = m[1]
+	}
+	return mediaFileName(name)
+}
+
+// mediaFileName returns the file named by an image parameter, which may be
+// a bare file name, carry a File: or Image: prefix, or be a whole
+// [[File:...]] link.
+func mediaFileName(value string) string {
+	if m := fileLinkRe.FindStringSubmatch(value); m != nil {
+		value = m[1]
+	}
+	name := strings.TrimSpace(value)
+	if prefix, rest, ok := strings.Cut(name, ":"); ok && (strings.EqualFold(prefix, "file") || strings.EqualFold(prefix, "image")) {
+		name = strings.TrimSpace(rest)
+	}
+	return name
+}
+
+// thumbnailURL points at a scaled copy of a file from a -media source,
+// or is empty when there is none. Images aren't part of the dump, so with
+// the default Commons source thumbnails only load when the browser is
+// online.
+func thumbnailURL(source, file string, width int) string {
+	file = strings.ReplaceAll(strings.TrimSpace(file), " ", "_")
+	if file == "" {
+		return ""
+	}
+	switch source {
+	case "none":
+		return ""
+	case "commons":
+		return fmt.Sprintf("https://commons.wikimedia.org/wiki/Special:FilePath/%s?width=%d", url.PathEscape(file), width)
+	default:
+		return fmt.Sprintf("%s/%s?width=%d", strings.TrimRight(source, "/"), url.PathEscape(file), width)
+	}
+}
+
+// checkMediaSource validates -media.
+func checkMediaSource(source string) error {
+	switch {
+	case source == "none", source == "commons":
+		return nil
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"), strings.HasPrefix(source, "/"):
+		return nil
+	}
+	return fmt.Errorf("-media must be commons, none, or a URL or path that file names are appended to, not %q", source)
+}
+
+// metaStore holds metadata for pages, loaded from the sidecar file and
+// filled in as pages are extracted. Pages seen while serving are also
+// saved to the data directory, when there is one, so they survive restarts.
+// Metadata from a binary index is read from the mapping, in base; pages
+// holds everything recorded since, which takes precedence.
+type metaStore struct {
+	mu     sync.RWMutex
+	pages  map[int]PageMeta
+	base   *mappedMeta
+	added  int // pages in pages but not in base
+	bucket *store.Bucket
+}
+
+func newMetaStore() *metaStore {
+	return &metaStore{pages: make(map[int]PageMeta)}
+}
+
+func (m *metaStore) Get(pageID int) (PageMeta, bool) {
+	if m == nil {
+		return PageMeta{}, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.get(pageID)
+}
+
+// get looks a page up with the lock held.
+func (m *metaStore) get(pageID int) (PageMeta, bool) {
+	if meta, ok := m.pages[pageID]; ok {
+		return meta, true
+	}
+	return m.base.Get(pageID)
+}
+
+// put records a page with the write lock held.
+func (m *metaStore) put(pageID int, meta PageMeta) {
+	if _, ok := m.pages[pageID]; !ok {
+		if _, inBase := m.base.Get(pageID); !inBase {
+			m.added++
+		}
+	}
+	m.pages[pageID] = meta
+}
+
+// each calls fn for every page with the read lock held.
+func (m *metaStore) each(fn func(pageID int, meta PageMeta)) {
+	for id, meta := range m.pages {
+		fn(id, meta)
+	}
+	for id, meta := range m.base.All() {
+		if _, ok := m.pages[id]; !ok {
+			fn(id, meta)
+		}
+	}
+}
+
+func (m *metaStore) Set(pageID int, meta PageMeta) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	old, seen := m.get(pageID)
+	m.put(pageID, meta)
+	m.mu.Unlock()
+
+	if m.bucket != nil && (!seen || old != meta) {
+		if data, err := json.Marshal(meta); err == nil {
+			m.bucket.Put(strconv.Itoa(pageID), data)
+		}
+	}
+}
+
+func (m *metaStore) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.base.Len() + m.added
+}
+
+// loadMetaStore starts from the metadata saved in the index cache, if
+// any, and adds the pages recorded in the data store bucket.
+func loadMetaStore(loaded *indexCache, bucket *store.Bucket) *metaStore {
+	m := newMetaStore()
+	if loaded.Meta == nil {
+		m.base = loaded.mappedMeta
+	}
+	for id, meta := range loaded.Meta {
+		m.pages[id] = meta
+	}
+	m.added = len(m.pages)
+	for _, key := range bucket.Keys() {
+		pageID, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		data, _ := bucket.Get(key)
+		var meta PageMeta
+		if json.Unmarshal(data, &meta) == nil {
+			old, _ := m.get(pageID)
+			m.put(pageID, meta.withImported(old))
+		}
+	}
+	m.bucket = bucket
+	return m
+}
+
+// merge adds metadata gathered by a background pass. Pages already
+// known, from being viewed, are kept; facts imported from the SQL dumps
+// are put over the new records.
+func (m *metaStore) merge(pages map[int]PageMeta) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, meta := range pages {
+		if old, ok := m.get(id); !ok || old.Bytes == 0 {
+			m.put(id, meta.withImported(old))
+		}
+	}
+}
+
+// collectMetadata reads every indexed page and returns its metadata.
+// streams and log are passed to walkStreams.
+func collectMetadata(ctx context.Context, inputFile string, index []IndexEntry, streams *streamCaches, workers int, progress func(done, total int), log io.Writer) (map[int]PageMeta, error) {
+	wanted := make(map[int]bool, len(index))
+	for _, e := range index {
+		wanted[e.PageID] = true
+	}
+	var mu sync.Mutex
+	pages := make(map[int]PageMeta, len(index))
+	err := walkStreams(ctx, inputFile, index, streams, workers, progress, log, func(batch []dump.Page) {
+		for _, page := range batch {
+			if wanted[page.ID] {
+				meta := analyzeWikiText(page.Revision.Text)
+				mu.Lock()
+				pages[page.ID] = meta
+				mu.Unlock()
+			}
+		}
+	})
+	return pages, err
+}
+
+// backgroundMetadata fills in metadata for every page while the server
+// runs and saves it to the index cache for the next start.
+func backgroundMetadata(inputFile, indexFilename string, loaded *indexCache, meta *metaStore, streams *streamCaches, hub *progressHub, log io.Writer) {
+	op := hub.Start("Page metadata", 0)
+	last := 0
+	pages, err := collectMetadata(context.Background(), inputFile, loaded.Entries, streams, max(1, runtime.NumCPU()/2), func(done, total int) {
+		op.SetTotal(total)
+		op.Add(done - last)
+		last = done
+	}, log)
+	if err != nil {
+		op.Finish("", err)
+		return
+	}
+	keepImported(pages, loaded.pageMeta())
+	meta.merge(pages)
+	loaded.Meta = pages
+	if err := saveIndexCache(loaded, indexCacheFile(indexFilename), indexFilename, log); err != nil {
+		op.Finish("", fmt.Errorf("saving index cache: %v", err))
+		return
+	}
+	op.Finish(fmt.Sprintf("Collected metadata for %d pages", len(pages)), nil)
+}
+
+// walkStreams decompresses every stream referenced by the index using a
+// pool of workers and calls fn with the pages of each, using the streams
+// already in streams. fn is called concurrently from the workers;
+// progress, if not nil, is called as streams are handed out, and streams
+// that fail to read are logged to log. The walk stops early when ctx is
+// cancelled.
+func walkStreams(ctx context.Context, inputFile string, index []IndexEntry, streams *streamCaches, workers int, progress func(done, total int), log io.Writer, fn func(pages []dump.Page)) error {
+	var offsets []*OffsetPair
+	seen := make(map[*OffsetPair]bool)
+	for i := range index {
+		if p := index[i].Offsets; !seen[p] {
+			seen[p] = true
+			offsets = append(offsets, p)
+		}
+	}
+
+	jobs := make(chan *OffsetPair)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				// Use cached streams but don't add to the cache, so a full
+				// walk doesn't evict the streams of recently read pages
+				data, ok := streams.cached(inputFile, p.Start)
+				var err error
+				if !ok {
+					data, err = dump.ExtractBzip2Range(inputFile, p.Start, p.End)
+				}
+				if err != nil {
+					fmt.Fprintf(log, "\nWarning: skipping stream at %d: %v\n", p.Start, err)
+					continue
+				}
+				pages, _ := dump.ExtractPages(data)
+				fn(pages)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+	for i, p := range offsets {
+		if progress != nil {
+			progress(i, len(offsets))
+		}
+		select {
+		case jobs <- p:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if progress != nil {
+		progress(len(offsets), len(offsets))
+	}
+	return nil
+}
+
+func runIndexMetadata(args []string) {
+	fs := newCommandFlags("index metadata")
+	inputFile := fs.String("file", "", "Path to multistream bzip2 file")
+	indexPath := fs.String("index", "", "Path to index file")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of streams to decompress in parallel")
+	propsFile := fs.String("page-props", "", "Also import a page_props SQL dump, as index sql does")
+	redirectFile := fs.String("redirects", "", "Also import a redirect SQL dump, as index sql does")
+	fs.Parse(args)
+
+	if *inputFile == "" || *indexPath == "" {
+		fmt.Println("Error: both -file and -index arguments are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	loaded, err := loadIndex(*indexPath, loadNamespaces(*inputFile, os.Stdout), os.Stdout)
+	if err != nil {
+		fmt.Printf("Error loading index: %v\n", err)
+		os.Exit(1)
+	}
+	index := loaded.Entries
+
+	fmt.Print("Collecting page metadata")
+	pages, err := collectMetadata(context.Background(), *inputFile, index, nil, *workers, func(done, total int) {
+		if done > 0 && done%1000 == 0 {
+			fmt.Print(".")
+		}
+	}, os.Stdout)
+	if err != nil {
+		fmt.Printf("\nError collecting metadata: %v\n", err)
+		os.Exit(1)
+	}
+
+	keepImported(pages, loaded.pageMeta())
+	fmt.Println()
+	if err := importSQLMetadata(pages, index, *inputFile, *propsFile, *redirectFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	loaded.Meta = pages
+	if err := saveIndexCache(loaded, indexCacheFile(*indexPath), *indexPath, os.Stdout); err != nil {
+		fmt.Printf("Error saving metadata: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Saved metadata for %d pages to %s\n", len(pages), indexCacheFile(*indexPath))
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package server
+
+import "os"
+
+// mapFile reads a whole file where memory mapping isn't available.
+func mapFile(filename string) ([]byte, error) {
+	return os.ReadFile(filename)
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// dumpNamespaces holds the namespaces whose pages are left out of the
+// index, and the local name of the template namespace, whose pages are
+// indexed separately.
+type dumpNamespaces struct {
+	dump.NamespaceSet
+	Template string
+}
+
+// loadNamespaces reads the namespace list from the dump header, falling back
+// to the standard English Wikipedia namespaces.
+func loadNamespaces(inputFile string, log io.Writer) dumpNamespaces {
+	info, err := dump.ReadSiteInfo(inputFile)
+	if err != nil || len(info.Namespaces) == 0 {
+		fmt.Fprintf(log, "Warning: could not read namespaces from dump, using defaults: %v\n", err)
+		return dumpNamespaces{NamespaceSet: dump.DefaultNamespaceSet(), Template: "Template"}
+	}
+	ns := dumpNamespaces{NamespaceSet: info.NamespaceSet(), Template: info.NamespaceName(dump.TemplateNamespace)}
+	if ns.Template == "" {
+		ns.Template = "Template"
+	}
+	return ns
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNormalizeBytes caps the wikitext accepted by /api/normalize.
+const maxNormalizeBytes = 5 << 20
+
+// normalizeOptions picks the optional rewrites of normalizeWikiText.
+type normalizeOptions struct {
+	Sort  bool `json:"sort"`  // named parameters in name order, after positional ones
+	Align bool `json:"align"` // pad names in multi-line templates so the = line up
+}
+
+// normalizeWikiText reformats the template calls in wikitext to one
+// layout. A call written on one line stays on one line, as
+// {{name|a|key=value}}; one spread over several lines gets a line per
+// argument, as "| key = value", with the closing braces on a line of their
+// own. Parser functions such as {{#if:}} and magic words keep their
+// layout, since whitespace in them can matter. Nothing outside template
+// calls changes, nor anything inside comments, <nowiki> or <pre>.
+func normalizeWikiText(text string, opts normalizeOptions) string {
+	var p protector
+	text = commentRe.ReplaceAllStringFunc(text, func(m string) string {
+		return p.add(m, false)
+	})
+	text = p.shield(text)
+	return p.restore(normalizeTemplates(text, opts))
+}
+
+// normalizeTemplates reformats every template call in text, nested ones
+// included.
+func normalizeTemplates(text string, opts normalizeOptions) string {
+	var b strings.Builder
+	for {
+		open := strings.Index(text, "{{")
+		if open < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		b.WriteString(text[:open])
+		text = text[open:]
+		if strings.HasPrefix(text, "{{{") {
+			// A template parameter, as written in template pages
+			end, _ := matchParam(text)
+			if end < 0 {
+				end = 3
+			}
+			b.WriteString(text[:end])
+			text = text[end:]
+			continue
+		}
+		end := matchTemplate(text)
+		if end < 0 {
+			b.WriteString(text[:2])
+			text = text[2:]
+			continue
+		}
+		b.WriteString(formatTemplate(text[2:end-2], opts))
+		text = text[end:]
+	}
+}
+
+// matchTemplate returns the length of the template call text starts with,
+// up to and including its closing braces, or -1 if they are missing.
+func matchTemplate(text string) int {
+	var open []int // brace counts of the nested parameters and templates
+	for i := 2; i < len(text); {
+		top := 0
+		if len(open) > 0 {
+			top = open[len(open)-1]
+		}
+		switch {
+		case strings.HasPrefix(text[i:], "{{{"):
+			open = append(open, 3)
+			i += 3
+		case strings.HasPrefix(text[i:], "{{"):
+			open = append(open, 2)
+			i += 2
+		case top == 3 && strings.HasPrefix(text[i:], "}}}"):
+			open = open[:len(open)-1]
+			i += 3
+		case strings.HasPrefix(text[i:], "}}"):
+			if top == 0 {
+				return i + 2
+			}
+			open = open[:len(open)-1]
+			i += 2
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+// templateArg is one argument of a template call being reformatted.
+type templateArg struct {
+	name  string // "" for positional arguments
+	value string
+}
+
+// formatTemplate lays out one call, given the text between its braces.
+func formatTemplate(body string, opts normalizeOptions) string {
+	parts := splitTemplateArgs(body)
+	name := strings.TrimSpace(parts[0])
+	if isParserFunction(name) {
+		return "{{" + normalizeTemplates(body, opts) + "}}"
+	}
+	args := make([]templateArg, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		// The same test for a named argument as templateArgs makes
+		if key, value, ok := strings.Cut(part, "="); ok && !strings.ContainsAny(key, "<[{") {
+			args = append(args, templateArg{name: strings.TrimSpace(key), value: strings.TrimSpace(normalizeTemplates(value, opts))})
+			continue
+		}
+		args = append(args, templateArg{value: strings.TrimSpace(normalizeTemplates(part, opts))})
+	}
+	if opts.Sort {
+		sort.SliceStable(args, func(i, j int) bool { return paramLess(args[i].name, args[j].name) })
+	}
+
+	var b strings.Builder
+	b.WriteString("{{" + name)
+	if !strings.Contains(body, "\n") {
+		for _, arg := range args {
+			b.WriteString("|")
+			if arg.name != "" {
+				b.WriteString(arg.name + "=")
+			}
+			b.WriteString(arg.value)
+		}
+		b.WriteString("}}")
+		return b.String()
+	}
+	width := 0
+	if opts.Align {
+		for _, arg := range args {
+			width = max(width, utf8.RuneCountInString(arg.name))
+		}
+	}
+	for _, arg := range args {
+		b.WriteString("\n|")
+		if arg.name != "" {
+			pad := max(0, width-utf8.RuneCountInString(arg.name))
+			b.WriteString(" " + arg.name + strings.Repeat(" ", pad) + " =")
+		}
+		if arg.value != "" {
+			b.WriteString(" " + arg.value)
+		}
+	}
+	b.WriteString("\n}}")
+	return b.String()
+}
+
+// isParserFunction reports whether a call is a parser function such as
+// #if: or a magic word such as DISPLAYTITLE:, rather than a template.
+func isParserFunction(name string) bool {
+	if strings.HasPrefix(name, "#") {
+		return true
+	}
+	prefix, _, ok := strings.Cut(name, ":")
+	return ok && prefix == strings.ToUpper(prefix)
+}
+
+// paramLess orders positional arguments first, then numbered parameters
+// by number, then the other names alphabetically.
+func paramLess(a, b string) bool {
+	if a == "" || b == "" {
+		return a == "" && b != ""
+	}
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return na < nb
+	case errA == nil || errB == nil:
+		return errA == nil
+	}
+	return strings.ToLower(a) < strings.ToLower(b)
+}
+
+type apiNormalizeResponse struct {
+	Text    string `json:"text"`
+	Changed bool   `json:"changed"`
+}
+
+// handleAPINormalize reformats the wikitext POSTed to /api/normalize: a
+// JSON body {"text": ..., "sort": true, "align": true}, a form with a
+// "text" field, or the raw text as the body, with ?sort=1&align=1. The
+// result is JSON unless ?format=text asks for the wikitext alone.
+func handleAPINormalize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxNormalizeBytes))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("wikitext must be at most %d MB", maxNormalizeBytes>>20)})
+		return
+	}
+	query := r.URL.Query()
+	opts := normalizeOptions{Sort: formBool(query.Get("sort")), Align: formBool(query.Get("align"))}
+	text := string(data)
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/json") {
+		var body struct {
+			Text string `json:"text"`
+			normalizeOptions
+		}
+		body.normalizeOptions = opts
+		if err := json.Unmarshal(data, &body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		text, opts = body.Text, body.normalizeOptions
+	} else if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		// curl --data-binary sends raw text with this type too, so only a
+		// body with a text field is read as a form
+		if form, err := url.ParseQuery(text); err == nil && form.Has("text") {
+			text = form.Get("text")
+			opts.Sort = opts.Sort || formBool(form.Get("sort"))
+			opts.Align = opts.Align || formBool(form.Get("align"))
+		}
+	}
+	if !utf8.ValidString(text) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "wikitext must be UTF-8"})
+		return
+	}
+
+	normalized := normalizeWikiText(text, opts)
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, normalized)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiNormalizeResponse{Text: normalized, Changed: normalized != text})
+}
+
+// formBool reads a boolean query or form value such as 1, true or yes.
+func formBool(s string) bool {
+	switch strings.ToLower(s) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// otherShard holds titles that don't start with an ASCII letter or digit.
+const otherShard = "other"
+
+// offlineTitles holds the gzipped title lists served to the offline search
+// service worker. They are generated from the index on first request and
+// versioned by the index file's fingerprint, so clients refetch them only
+// after the index changes.
+type offlineTitles struct {
+	index   []IndexEntry
+	version string
+
+	once   sync.Once
+	shards map[string][]byte
+	err    error
+}
+
+func newOfflineTitles(index []IndexEntry, indexFilename string) (*offlineTitles, error) {
+	fp, err := fileFingerprint(indexFilename)
+	if err != nil {
+		return nil, err
+	}
+	return &offlineTitles{index: index, version: fp[:16]}, nil
+}
+
+// titleShard names the shard a title or query belongs to: its first letter
+// or digit, lowercased, or otherShard.
+func titleShard(title string) string {
+	r, _ := utf8.DecodeRuneInString(strings.TrimSpace(title))
+	r = unicode.ToLower(r)
+	if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+		return string(r)
+	}
+	return otherShard
+}
+
+// build groups the titles by shard as JSON arrays of [title, url] pairs and
+// compresses each shard.
+func (o *offlineTitles) build() {
+	groups := make(map[string][][2]string)
+	for _, e := range o.index {
+		shard := titleShard(e.Title)
+		groups[shard] = append(groups[shard], [2]string{e.Title, "/wiki/" + strings.ReplaceAll(e.Title, " ", "_")})
+	}
+	o.shards = make(map[string][]byte, len(groups))
+	for shard, titles := range groups {
+		sort.Slice(titles, func(i, j int) bool { return titles[i][0] < titles[j][0] })
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if err := json.NewEncoder(gw).Encode(titles); err != nil {
+			o.err = fmt.Errorf("encoding shard %s: %v", shard, err)
+			return
+		}
+		if err := gw.Close(); err != nil {
+			o.err = fmt.Errorf("compressing shard %s: %v", shard, err)
+			return
+		}
+		o.shards[shard] = buf.Bytes()
+	}
+}
+
+// offlineManifest lists the title shards for the service worker
+type offlineManifest struct {
+	Version string            `json:"version"`
+	Shards  map[string]string `json:"shards"`
+}
+
+// serve handles /static/titles-manifest.json and
+// /static/titles-{shard}.json.gz, reporting false for other paths.
+func (o *offlineTitles) serve(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !strings.HasPrefix(name, "titles-") {
+		return false
+	}
+	o.once.Do(o.build)
+	if o.err != nil {
+		http.Error(w, o.err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	if name == "titles-manifest.json" {
+		m := offlineManifest{Version: o.version, Shards: make(map[string]string, len(o.shards))}
+		for shard := range o.shards {
+			m.Shards[shard] = fmt.Sprintf("%s/static/titles-%s.json.gz?v=%s", requestServer(r).cfg.BasePath, shard, o.version)
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		writeJSON(w, http.StatusOK, m)
+		return true
+	}
+
+	shard, ok := strings.CutSuffix(strings.TrimPrefix(name, "titles-"), ".json.gz")
+	data, found := o.shards[shard]
+	if !ok || !found {
+		http.NotFound(w, r)
+		return true
+	}
+	etag := `"` + o.version + "-" + shard + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept-Encoding")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(data)
+		return true
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	io.Copy(w, gr)
+	return true
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Match counts accepted by /api/page/{title}/match.
+const (
+	defaultPageMatches = 100
+	maxPageMatches     = 1000
+)
+
+// pageMatch is one match of a pattern in an article's wikitext. Offsets
+// are in bytes of the UTF-8 text; Line counts from 1.
+type pageMatch struct {
+	Start  int               `json:"start"`
+	End    int               `json:"end"`
+	Line   int               `json:"line"`
+	Text   string            `json:"text"`
+	Groups []string          `json:"groups,omitempty"`
+	Named  map[string]string `json:"named,omitempty"`
+}
+
+type apiMatchResponse struct {
+	Title          string      `json:"title"`
+	PageID         int         `json:"page_id"`
+	RedirectedFrom string      `json:"redirected_from,omitempty"`
+	Pattern        string      `json:"pattern"`
+	Count          int         `json:"count"`
+	Truncated      bool        `json:"truncated,omitempty"`
+	Matches        []pageMatch `json:"matches"`
+}
+
+// findPageMatches returns up to limit matches of re in text and whether
+// there were more. Groups that took no part in a match are "".
+func findPageMatches(re *regexp.Regexp, text string, limit int) ([]pageMatch, bool) {
+	found := re.FindAllStringSubmatchIndex(text, limit+1)
+	truncated := len(found) > limit
+	if truncated {
+		found = found[:limit]
+	}
+	names := re.SubexpNames()
+	matches := make([]pageMatch, 0, len(found))
+	line, counted := 1, 0
+	for _, loc := range found {
+		line += strings.Count(text[counted:loc[0]], "\n")
+		counted = loc[0]
+		m := pageMatch{Start: loc[0], End: loc[1], Line: line, Text: text[loc[0]:loc[1]]}
+		for i := 1; i < len(names); i++ {
+			var group string
+			if loc[2*i] >= 0 {
+				group = text[loc[2*i]:loc[2*i+1]]
+			}
+			m.Groups = append(m.Groups, group)
+			if names[i] != "" {
+				if m.Named == nil {
+					m.Named = make(map[string]string)
+				}
+				m.Named[names[i]] = group
+			}
+		}
+		matches = append(matches, m)
+	}
+	return matches, truncated
+}
+
+// handleAPIMatch serves /api/page/{title}/match?pattern=RE: the matches of
+// a regular expression in one article's wikitext, with their offsets, so
+// scripts extracting data get what they need without the whole text.
+func handleAPIMatch(w http.ResponseWriter, r *http.Request, index []IndexEntry, titles titleSet, inputFile string, streams *streamCaches) {
+	title, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/page/"), "/match")
+	if !ok || title == "" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "expected /api/page/{title}/match"})
+		return
+	}
+	if err := validateTitle(strings.ReplaceAll(title, "_", " ")); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	pattern := r.FormValue("pattern")
+	if pattern == "" || len(pattern) > maxGrepPattern {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("pattern must be 1 to %d characters", maxGrepPattern)})
+		return
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid pattern: %v", err)})
+		return
+	}
+	limit := defaultPageMatches
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageMatches {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageMatches)})
+			return
+		}
+		limit = n
+	}
+
+	entry := findPageByTitle(index, titles, title)
+	if entry == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no article with that title"})
+		return
+	}
+	entry, from, text, err := pageWikiText(index, titles, inputFile, streams, entry)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	matches, truncated := findPageMatches(re, text, limit)
+	if negotiateFormat(r) == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for _, m := range matches {
+			enc.Encode(m)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, apiMatchResponse{
+		Title:          entry.Title,
+		PageID:         entry.PageID,
+		RedirectedFrom: from,
+		Pattern:        pattern,
+		Count:          len(matches),
+		Truncated:      truncated,
+		Matches:        matches,
+	})
+}
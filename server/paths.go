@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxTitleBytes is MediaWiki's limit on the length of a page title
+const maxTitleBytes = 255
+
+// titleIllegalChars can never appear in a MediaWiki page title
+const titleIllegalChars = "#<>[]|{}"
+
+// validateTitle rejects titles no wiki page can have: invalid UTF-8,
+// control characters, MediaWiki's forbidden characters, overlong titles and
+// relative path segments such as "..".
+func validateTitle(title string) error {
+	switch {
+	case title == "":
+		return errors.New("empty title")
+	case len(title) > maxTitleBytes:
+		return fmt.Errorf("title longer than %d bytes", maxTitleBytes)
+	case !utf8.ValidString(title):
+		return errors.New("title is not valid UTF-8")
+	case strings.ContainsAny(title, titleIllegalChars):
+		return fmt.Errorf("title contains one of %s", titleIllegalChars)
+	}
+	for _, r := range title {
+		if unicode.IsControl(r) {
+			return errors.New("title contains control characters")
+		}
+	}
+	for _, segment := range strings.Split(title, "/") {
+		if segment == "." || segment == ".." {
+			return errors.New("title contains a relative path segment")
+		}
+	}
+	return nil
+}
+
+// titleFromPath takes the page title from a request path after prefix and
+// validates it. Underscores are left for findPageByTitle to handle. A
+// title starting with "/" is refused, as it would read as an absolute
+// path; the mux redirects such requests before they get here anyway.
+func titleFromPath(path, prefix string) (string, error) {
+	title, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		return "", errors.New("unexpected path")
+	}
+	if strings.HasPrefix(title, "/") {
+		return "", errors.New("title starts with a slash")
+	}
+	return title, validateTitle(strings.ReplaceAll(title, "_", " "))
+}
+
+// canonicalPath is the route path of an article: the title after prefix,
+// with underscores for spaces, as links to it are written.
+func canonicalPath(prefix, title string) string {
+	return prefix + strings.ReplaceAll(title, " ", "_")
+}
+
+// canonicalRedirect returns the URL a request for an article should be
+// redirected to when it names the article another way, such as
+// /wiki/ada_lovelace or /wiki/Ada%20Lovelace for /wiki/Ada_Lovelace, so
+// browsers and caches in front of the server keep one copy of each page.
+// It returns "" when the request already uses the canonical path. The
+// query string is kept.
+func canonicalRedirect(r *http.Request, prefix, title string) string {
+	path := canonicalPath(prefix, title)
+	if r.URL.Path == path {
+		return ""
+	}
+	location := absoluteURL(r, (&url.URL{Path: path}).EscapedPath())
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	return location
+}
+
+// staticHandler serves the regular files found in dir at startup and
+// nothing else: no directory listings, no dotfiles and nothing reached
+// through symlinks or ".." segments. Files can still be edited in place
+// while the server runs; new files need a restart.
+func staticHandler(dir string) (http.Handler, error) {
+	allowed := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		allowed[filepath.ToSlash(rel)] = path
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing static files: %v", err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := allowed[strings.TrimPrefix(r.URL.Path, "/static/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	}), nil
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzTitleFromPath(f *testing.F) {
+	for _, seed := range []string{
+		"/wiki/Ada_Lovelace", "/wiki/AC/DC", "/wiki/../etc/passwd", "/wiki/a/../../b",
+		"/wiki//etc/passwd", "/wiki/%2e%2e", "/wiki/..", "/wiki/._.", "/wiki/a\x00b",
+		"/wiki/\xff", "/wiki/", "/wiki/C:\\Windows", "/other/Title",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, path string) {
+		title, err := titleFromPath(path, "/wiki/")
+		if err != nil {
+			return
+		}
+		for _, segment := range strings.Split(strings.ReplaceAll(title, "_", " "), "/") {
+			if segment == ".." || segment == "." {
+				t.Errorf("titleFromPath(%q) = %q, with a relative segment", path, title)
+			}
+		}
+		if strings.ContainsRune(title, 0) {
+			t.Errorf("titleFromPath(%q) = %q, with a NUL byte", path, title)
+		}
+		if filepath.IsAbs(title) || strings.HasPrefix(title, "/") {
+			t.Errorf("titleFromPath(%q) = %q, an absolute path", path, title)
+		}
+		if title == "" {
+			t.Errorf("titleFromPath(%q) accepted an empty title", path)
+		}
+	})
+}
+
+func TestStaticHandler(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "style.css"), "body{}")
+	write(filepath.Join(dir, "icons", "logo.svg"), "<svg/>")
+	write(filepath.Join(dir, ".env"), "SECRET=1")
+	write(filepath.Join(dir, ".git", "config"), "[core]")
+	write(filepath.Join(dir, "icons", ".hidden.svg"), "<svg/>")
+	write(filepath.Join(outside, "secret.txt"), "secret")
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	os.Symlink(outside, filepath.Join(dir, "linkdir"))
+	os.Symlink(filepath.Join(dir, "style.css"), filepath.Join(dir, "alias.css"))
+
+	h, err := staticHandler(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/static/style.css", http.StatusOK, "body{}"},
+		{"/static/icons/logo.svg", http.StatusOK, "<svg/>"},
+		{"/static/.env", http.StatusNotFound, ""},
+		{"/static/.git/config", http.StatusNotFound, ""},
+		{"/static/icons/.hidden.svg", http.StatusNotFound, ""},
+		{"/static/", http.StatusNotFound, ""},
+		{"/static/icons", http.StatusNotFound, ""},
+		{"/static/icons/", http.StatusNotFound, ""},
+		{"/static/link.txt", http.StatusNotFound, ""},
+		{"/static/linkdir/secret.txt", http.StatusNotFound, ""},
+		{"/static/alias.css", http.StatusNotFound, ""},
+		{"/static/../paths.go", http.StatusNotFound, ""},
+		{"/static/icons/../style.css", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = tt.path
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("GET %s body = %q, want %q", tt.path, rec.Body.String(), tt.body)
+		}
+		if rec.Code == http.StatusOK && rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("GET %s is missing X-Content-Type-Options", tt.path)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// RenderContext carries one article through the render pipeline. Stages
+// before "parse" work on WikiText, later ones on HTML.
+type RenderContext struct {
+	Entry       *IndexEntry
+	WikiText    string
+	HTML        string
+	Redirect    string
+	Description string
+	Quality     string
+	Prefetch    []string // linked pages worth fetching ahead of a click
+	Links       []string // every existing page linked, sorted
+	Sections    []int    // offsets in HTML of the top-level headings
+	Templates   []string // templates used, tracked with -template-dir
+	Magic       magicWords
+	Renderer    Renderer
+	Trace       *trace
+}
+
+// Stage is one step of the render pipeline
+type Stage interface {
+	Name() string
+	Process(ctx *RenderContext) error
+}
+
+// defaultStages is the standard render order
+const defaultStages = "extract,preprocess,templates,parse,links,sanitize,media,accessibility,postprocess,postprocessors,sections"
+
+// Pipeline runs an ordered list of stages to turn an index entry into
+// article HTML.
+type Pipeline struct {
+	stages   []Stage
+	meta     *metaStore
+	renderer Renderer // used when a render doesn't pick one
+	renders  *renderFlight
+	log      io.Writer
+}
+
+// renderSettings are what the stages of a server's pipelines need besides
+// the wiki they render: the template handlers, renderers and stream caches,
+// and the -postprocessors, -prefetch-links, -media and -shadow-render
+// options. Render panics and shadow renders are reported to log.
+type renderSettings struct {
+	templates      *Registry
+	renderers      *rendererSet
+	streams        *streamCaches
+	postprocessors postprocessorChain
+	basePath       string
+	prefetchLinks  int
+	media          string
+	shadowRate     float64
+	log            io.Writer
+}
+
+// newPipeline builds a pipeline from a comma-separated list of stage names.
+// Stages are looked up in the registry built by stageRegistry.
+func newPipeline(names string, inputFile string, titles titleSet, meta *metaStore, settings renderSettings) (*Pipeline, error) {
+	registry := stageRegistry(inputFile, titles, meta, settings)
+	p := &Pipeline{meta: meta, renderer: settings.renderers.def, renders: newRenderFlight(), log: settings.log}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		stage, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown render stage %q", name)
+		}
+		p.stages = append(p.stages, stage)
+	}
+	return p, nil
+}
+
+// stageRegistry lists every stage that can be named in -stages.
+func stageRegistry(inputFile string, titles titleSet, meta *metaStore, settings renderSettings) map[string]Stage {
+	stages := []Stage{
+		extractStage{inputFile: inputFile, meta: meta, streams: settings.streams},
+		funcStage{"preprocess", preprocessWikiText},
+		templateStage{templates: settings.templates},
+		parseStage{native: settings.renderers.byName["native"], shadowRate: settings.shadowRate, log: settings.log},
+		linkStage{titles: titles, basePath: settings.basePath, prefetch: settings.prefetchLinks},
+		funcStage{"sanitize", func(ctx *RenderContext) error {
+			ctx.HTML = stripImgDimensions(ctx.HTML)
+			return nil
+		}},
+		mediaStage{source: settings.media},
+		funcStage{"accessibility", accessibilityStage},
+		funcStage{"postprocess", func(ctx *RenderContext) error {
+			ctx.HTML = insertTOC(ctx.HTML, ctx.Magic)
+			return nil
+		}},
+		funcStage{"postprocessors", func(ctx *RenderContext) error {
+			ctx.HTML = settings.postprocessors.apply(ctx.HTML)
+			return nil
+		}},
+		funcStage{"strip-images", stripImages},
+		funcStage{"mathml", mathMLStage},
+		funcStage{"sections", sectionStage},
+	}
+	registry := make(map[string]Stage, len(stages))
+	for _, s := range stages {
+		registry[s.Name()] = s
+	}
+	return registry
+}
+
+// Names returns the stage names in order.
+func (p *Pipeline) Names() []string {
+	names := make([]string, len(p.stages))
+	for i, s := range p.stages {
+		names[i] = s.Name()
+	}
+	return names
+}
+
+// Run renders an entry. If a stage panics on malformed input, the escaped
+// raw wikitext is returned as the HTML along with a *renderPanicError so
+// the page can still be read.
+func (p *Pipeline) Run(entry *IndexEntry, renderer Renderer, tr *trace) (ctx *RenderContext, err error) {
+	if renderer == nil {
+		renderer = p.renderer
+	}
+	ctx = &RenderContext{Entry: entry, Renderer: renderer, Trace: tr}
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(p.log, "[%s] Render panic for %q: %v\n%s", time.Now().Format("2006-01-02 15:04:05"), entry.Title, r, debug.Stack())
+			ctx.HTML = "<pre class=\"raw-wikitext\">" + html.EscapeString(ctx.WikiText) + "</pre>"
+			ctx.Redirect = ""
+			err = &renderPanicError{Title: entry.Title, Value: r}
+		}
+	}()
+
+	for _, stage := range p.stages {
+		end := tr.Start("stage:" + stage.Name())
+		err := stage.Process(ctx)
+		end()
+		if err != nil {
+			return ctx, err
+		}
+		if ctx.Redirect != "" {
+			break
+		}
+	}
+	return ctx, nil
+}
+
+// funcStage adapts a plain function to the Stage interface
+type funcStage struct {
+	name string
+	fn   func(ctx *RenderContext) error
+}
+
+func (s funcStage) Name() string                     { return s.name }
+func (s funcStage) Process(ctx *RenderContext) error { return s.fn(ctx) }
+
+// extractStage reads the article's wikitext from the dump and records its
+// metadata
+type extractStage struct {
+	inputFile string
+	meta      *metaStore
+	streams   *streamCaches
+}
+
+func (extractStage) Name() string { return "extract" }
+
+func (s extractStage) Process(ctx *RenderContext) error {
+	end := ctx.Trace.Start("decompress")
+	xmlData, err := s.streams.extract(s.inputFile, ctx.Entry.Offsets)
+	end()
+	if errors.Is(err, dump.ErrDumpUnreadable) {
+		return fmt.Errorf("%w: %v", ErrDumpUnavailable, err)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: extracting data range: %v", ErrCorruptStream, err)
+	}
+	end = ctx.Trace.Start("xml-parse")
+	text, err := dump.ExtractPageText(xmlData, ctx.Entry.PageID)
+	end()
+	if err != nil {
+		return fmt.Errorf("%w: extracting page text: %v", ErrCorruptStream, err)
+	}
+	ctx.WikiText = text
+	old, _ := s.meta.Get(ctx.Entry.PageID)
+	meta := analyzeWikiText(text).withImported(old)
+	ctx.Description = meta.Description
+	ctx.Quality = meta.Quality
+	s.meta.Set(ctx.Entry.PageID, meta)
+	return nil
+}
+
+// preprocessWikiText handles markup that must be rewritten before template
+// expansion, such as EasyTimeline blocks and magic words, and applies the
+// inline HTML policy to the HTML written in the article.
+func preprocessWikiText(ctx *RenderContext) error {
+	ctx.WikiText = applyHTMLPolicy(ctx.WikiText)
+	ctx.WikiText, ctx.Magic = extractMagicWords(ctx.WikiText)
+	ctx.WikiText = convertTimelines(ctx.WikiText)
+	return nil
+}
+
+// templateStage expands templates with the handlers of one registry.
+type templateStage struct {
+	templates *Registry
+}
+
+func (templateStage) Name() string { return "templates" }
+
+func (s templateStage) Process(ctx *RenderContext) error {
+	if s.templates.files != nil {
+		ctx.Templates = templateNames(ctx.WikiText)
+	}
+	ctx.WikiText = s.templates.expand(ctx.WikiText)
+	return nil
+}
+
+// parseStage converts wikitext to HTML with the selected renderer. With
+// -shadow-render, a share of pandoc renders is also run through the native
+// renderer and compared.
+type parseStage struct {
+	native     Renderer
+	shadowRate float64
+	log        io.Writer
+}
+
+func (parseStage) Name() string { return "parse" }
+
+func (s parseStage) Process(ctx *RenderContext) error {
+	output, err := ctx.Renderer.Render(ctx.WikiText, ctx.Entry.Title)
+	if err != nil {
+		return err
+	}
+	if shouldShadow(ctx.Renderer, s.shadowRate) {
+		go shadowCompare(s.native, s.log, ctx.Entry.Title, ctx.WikiText, output)
+	}
+	ctx.HTML = output
+	return nil
+}
+
+// linkStage detects redirects and rewrites internal links. Red links
+// search under basePath.
+type linkStage struct {
+	titles   titleSet
+	basePath string
+	prefetch int // -prefetch-links
+}
+
+func (linkStage) Name() string { return "links" }
+
+func (s linkStage) Process(ctx *RenderContext) error {
+	if target, ok := isRedirect(ctx.HTML); ok {
+		ctx.Redirect = ucfirst(target)
+		return nil
+	}
+	ctx.HTML = lowercaseAnchors(ctx.HTML)
+	ctx.HTML = markRedLinks(ctx.HTML, s.titles, s.basePath)
+	ctx.Prefetch = prefetchTitles(ctx.HTML, s.titles, ctx.Entry.Title, s.prefetch)
+	ctx.Links = outboundLinks(ctx.HTML, s.titles, ctx.Entry.Title)
+	return nil
+}
+
+var imgTagRe = regexp.MustCompile(`(?is)<figure[^>]*>.*?</figure>|<img[^>]*>`)
+
+// stripImages removes images and figures entirely, for text-only displays.
+func stripImages(ctx *RenderContext) error {
+	ctx.HTML = imgTagRe.ReplaceAllString(ctx.HTML, "")
+	return nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"math"
+	"sort"
+)
+
+// Search results that match a query equally well are ordered by how often
+// their articles have been read, so the article most people want comes
+// first. Title matches within a group are sorted by view count, and
+// full-text scores are multiplied by 1 + -popularity-weight·ln(1+views).
+// The counts are those of the popular home panel, kept across restarts
+// with -data-dir.
+
+// ranking reports whether view counts order search results.
+func (v *viewTracker) ranking() bool {
+	return v != nil && v.weight > 0
+}
+
+// sortEntries orders title matches by view count, most read first, and
+// articles read equally often by page ID, so results don't depend on the
+// order of the index file.
+func (v *viewTracker) sortEntries(entries []IndexEntry) {
+	if len(entries) < 2 {
+		return
+	}
+	views := make([]int, len(entries))
+	if v.ranking() {
+		v.mu.Lock()
+		for i, e := range entries {
+			views[i] = v.counts[e.Title]
+		}
+		v.mu.Unlock()
+	}
+	sort.Sort(entriesByViews{entries, views})
+}
+
+type entriesByViews struct {
+	entries []IndexEntry
+	views   []int
+}
+
+func (s entriesByViews) Len() int { return len(s.entries) }
+func (s entriesByViews) Less(i, j int) bool {
+	if s.views[i] != s.views[j] {
+		return s.views[i] > s.views[j]
+	}
+	return s.entries[i].PageID < s.entries[j].PageID
+}
+func (s entriesByViews) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.views[i], s.views[j] = s.views[j], s.views[i]
+}
+
+// sortHits reorders full-text hits by their scores boosted by view count.
+func (v *viewTracker) sortHits(hits []FullTextHit, pages map[int]*IndexEntry) {
+	if !v.ranking() || len(hits) < 2 {
+		return
+	}
+	boosted := make(map[int]float64, len(hits))
+	v.mu.Lock()
+	for _, hit := range hits {
+		score := float64(hit.Score)
+		if entry, ok := pages[hit.PageID]; ok {
+			score *= 1 + v.weight*math.Log1p(float64(v.counts[entry.Title]))
+		}
+		boosted[hit.PageID] = score
+	}
+	v.mu.Unlock()
+	sort.SliceStable(hits, func(i, j int) bool { return boosted[hits[i].PageID] > boosted[hits[j].PageID] })
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Output postprocessors tailor finished article HTML to the display it is
+// read on, such as a kiosk without network access or an e-ink reader.
+// -postprocessors names a chain of them, applied in order by the
+// "postprocessors" render stage, e.g.
+//
+//	-postprocessors strip-external-links,remove-citations,max-image-width=480
+//
+// Each is a plain func(string) string, so they compose in any order.
+
+// postprocessor rewrites article HTML.
+type postprocessor func(html string) string
+
+// postprocessorChain applies its postprocessors in order. A nil chain
+// leaves HTML unchanged.
+type postprocessorChain []postprocessor
+
+func (c postprocessorChain) apply(html string) string {
+	for _, p := range c {
+		html = p(html)
+	}
+	return html
+}
+
+// postprocessorFactories builds each named postprocessor from the value
+// after "=" in the chain, "" when there is none.
+var postprocessorFactories = map[string]func(arg string) (postprocessor, error){
+	"strip-external-links": noArg(stripExternalLinks),
+	"remove-citations":     noArg(removeCitations),
+	"simplify-tables":      noArg(simplifyTables),
+	"strip-images": noArg(func(html string) string {
+		return imgTagRe.ReplaceAllString(html, "")
+	}),
+	"max-image-width": func(arg string) (postprocessor, error) {
+		width, err := strconv.Atoi(strings.TrimSuffix(arg, "px"))
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("needs a width in pixels, such as max-image-width=480")
+		}
+		return maxImageWidth(width), nil
+	},
+}
+
+func noArg(p postprocessor) func(string) (postprocessor, error) {
+	return func(arg string) (postprocessor, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("takes no value")
+		}
+		return p, nil
+	}
+}
+
+// parsePostprocessors builds a chain from a comma-separated list of names,
+// each optionally followed by =value.
+func parsePostprocessors(spec string) (postprocessorChain, error) {
+	var chain postprocessorChain
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(item, "=")
+		factory, ok := postprocessorFactories[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown postprocessor %q", name)
+		}
+		p, err := factory(strings.TrimSpace(arg))
+		if err != nil {
+			return nil, fmt.Errorf("postprocessor %s: %v", name, err)
+		}
+		chain = append(chain, p)
+	}
+	return chain, nil
+}
+
+var externalLinkRe = regexp.MustCompile(`(?is)<a\s[^>]*?href="(?:[a-z]+:)?//[^"]*"[^>]*>(.*?)</a>`)
+
+// stripExternalLinks keeps the text of links out of the wiki and drops the
+// links themselves, for readers that can't follow them.
+func stripExternalLinks(html string) string {
+	return externalLinkRe.ReplaceAllString(html, "$1")
+}
+
+var citationMarkupRes = []*regexp.Regexp{
+	// Markers and the reference list of the native converter
+	regexp.MustCompile(`(?is)<sup class="citation-(?:ref|popup)"[^>]*>.*?</sup>`),
+	regexp.MustCompile(`(?is)<section class="citations">.*?</section>\n?`),
+	// Footnotes as pandoc writes them
+	regexp.MustCompile(`(?is)<a [^>]*class="footnote-ref"[^>]*>.*?</a>`),
+	regexp.MustCompile(`(?is)<section [^>]*class="footnotes[^"]*"[^>]*>.*?</section>\n?`),
+	// The placeholder where the wikitext asks for the list
+	regexp.MustCompile(`(?i)<p>\s*<references[^>]*/>\s*</p>\n?`),
+}
+
+// referenceHeadingRe matches the headings of sections that hold nothing
+// but the reference list.
+var referenceHeadingRe = regexp.MustCompile(`(?i)<h2 id="(?:references|notes|footnotes|citations)"[^>]*>[^<]*</h2>\s*`)
+
+// removeCitations drops citation markers and reference lists, and the
+// headings left with nothing under them.
+func removeCitations(html string) string {
+	for _, re := range citationMarkupRes {
+		html = re.ReplaceAllString(html, "")
+	}
+	for _, loc := range slices.Backward(referenceHeadingRe.FindAllStringIndex(html, -1)) {
+		if rest := html[loc[1]:]; rest == "" || strings.HasPrefix(rest, "<h2") {
+			html = html[:loc[0]] + rest
+		}
+	}
+	return html
+}
+
+var (
+	tableTagRe = regexp.MustCompile(`(?i)<(table|caption|colgroup|col|thead|tbody|tfoot|tr|th|td)(\s[^>]*)>`)
+
+	// presentationalAttrs are the table attributes simplify-tables drops
+	presentationalAttrs = tagSet("style bgcolor background width height align valign border cellpadding cellspacing nowrap")
+)
+
+// simplifyTables drops the inline styles, colors and sizes of tables and
+// their cells, leaving their layout to the stylesheet. Spans, classes and
+// scopes are kept, since the table's structure depends on them.
+func simplifyTables(html string) string {
+	return tableTagRe.ReplaceAllStringFunc(html, func(tag string) string {
+		m := tableTagRe.FindStringSubmatch(tag)
+		var kept strings.Builder
+		for _, a := range htmlAttrRe.FindAllStringSubmatch(m[2], -1) {
+			if !presentationalAttrs[strings.ToLower(a[1])] {
+				kept.WriteString(" " + a[0])
+			}
+		}
+		return "<" + m[1] + kept.String() + ">"
+	})
+}
+
+var (
+	imgStyleRe   = regexp.MustCompile(`\sstyle="[^"]*"`)
+	thumbWidthRe = regexp.MustCompile(`([?&;]width=)(\d+)`)
+	imgSrcAttrRe = regexp.MustCompile(`\ssrc="[^"]*"`)
+	imgOpenTagRe = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+)
+
+// maxImageWidth keeps images at most width pixels wide, requesting
+// thumbnails no wider than that so small displays download less.
+func maxImageWidth(width int) postprocessor {
+	style := fmt.Sprintf(` style="max-width:%dpx;height:auto"`, width)
+	return func(html string) string {
+		return imgOpenTagRe.ReplaceAllStringFunc(html, func(img string) string {
+			img = imgSrcAttrRe.ReplaceAllStringFunc(img, func(src string) string {
+				return thumbWidthRe.ReplaceAllStringFunc(src, func(param string) string {
+					m := thumbWidthRe.FindStringSubmatch(param)
+					if w, _ := strconv.Atoi(m[2]); w > width {
+						return m[1] + strconv.Itoa(width)
+					}
+					return param
+				})
+			})
+			img = imgStyleRe.ReplaceAllString(img, "")
+			return strings.Replace(img, "<img", "<img"+style, 1)
+		})
+	}
+}
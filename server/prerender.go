@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vitalArticles is a short list of the most broadly read articles, used by
+// -prerender=vital.
+var vitalArticles = []string{
+	"Earth", "World War II", "United States", "India", "China", "Human", "Life",
+	"Water", "Sun", "Moon", "Science", "Mathematics", "History", "Music", "Art",
+	"Language", "Philosophy", "Religion", "Physics", "Chemistry", "Biology",
+	"Medicine", "Computer", "Internet", "Europe", "Africa", "Asia",
+	"North America", "South America", "Australia", "Antarctica", "Ocean",
+	"Climate change", "Evolution", "DNA", "Atom", "Universe", "Solar System",
+	"Albert Einstein", "Isaac Newton", "Charles Darwin", "William Shakespeare",
+	"Leonardo da Vinci", "Ancient Egypt", "Roman Empire", "World War I",
+	"Industrial Revolution", "Democracy", "Economics", "Food", "Wikipedia",
+}
+
+// defaultPopularCount is how many articles -prerender=popular renders
+// when no count is given.
+const defaultPopularCount = 500
+
+// prerenderTitles returns the titles named by a -prerender mode: vital,
+// popular or popular:N for the most viewed articles, or a file of titles.
+// views is nil where no view counts are kept.
+func prerenderTitles(mode string, views *viewTracker) ([]string, error) {
+	if mode == "vital" {
+		return vitalArticles, nil
+	}
+	if mode == "popular" || strings.HasPrefix(mode, "popular:") {
+		n := defaultPopularCount
+		if count, ok := strings.CutPrefix(mode, "popular:"); ok {
+			var err error
+			if n, err = strconv.Atoi(count); err != nil || n < 1 {
+				return nil, fmt.Errorf("popular:N needs a positive number of articles, got %q", count)
+			}
+		}
+		if views == nil {
+			return nil, fmt.Errorf("popular needs the view counts of a server")
+		}
+		return views.popular(n), nil
+	}
+
+	f, err := os.Open(mode)
+	if err != nil {
+		return nil, fmt.Errorf("opening prerender list: %v", err)
+	}
+	defer f.Close()
+
+	var titles []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			titles = append(titles, line)
+		}
+	}
+	return titles, scanner.Err()
+}
+
+// remoteWarmupMode reports whether a warm-up mode may be requested over
+// HTTP: vital, popular or popular:N, but never a file on the server.
+func remoteWarmupMode(mode string) bool {
+	if mode == "vital" || mode == "popular" {
+		return true
+	}
+	count, ok := strings.CutPrefix(mode, "popular:")
+	n, err := strconv.Atoi(count)
+	return ok && err == nil && n > 0
+}
+
+// prerenderPages renders a list of articles into the render cache using a
+// bounded worker pool, logging failures to log. It is meant to run in the
+// background at startup.
+func prerenderPages(mode string, index []IndexEntry, titles titleSet, pipeline *Pipeline, cache *renderCache, views *viewTracker, workers int, hub *progressHub, log io.Writer) {
+	list, err := prerenderTitles(mode, views)
+	if err != nil {
+		fmt.Fprintf(log, "Warning: prerender disabled: %v\n", err)
+		hub.Start("Warm-up: "+mode, 0).Finish("", err)
+		return
+	}
+	op := hub.Start("Warm-up: "+mode, len(list))
+	if workers < 1 {
+		workers = 1
+	}
+
+	start := time.Now()
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	rendered, missing := 0, 0
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for title := range jobs {
+				op.Add(1)
+				entry := findPageByTitle(index, titles, title)
+				if entry == nil {
+					mu.Lock()
+					missing++
+					mu.Unlock()
+					continue
+				}
+				if _, err := renderEntry(pipeline, entry, cache, nil, nil); err != nil {
+					fmt.Fprintf(log, "Warning: prerendering %q: %v\n", title, err)
+					continue
+				}
+				mu.Lock()
+				rendered++
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, title := range list {
+		jobs <- title
+	}
+	close(jobs)
+	wg.Wait()
+
+	summary := fmt.Sprintf("Prerendered %d articles in %v (%d not found)", rendered, time.Since(start).Round(time.Millisecond), missing)
+	fmt.Fprintln(log, summary)
+	op.Finish(summary, nil)
+}
@@ -0,0 +1,22 @@
+package server
+
+import "testing"
+
+func TestRemoteWarmupMode(t *testing.T) {
+	for mode, want := range map[string]bool{
+		"vital":         true,
+		"popular":       true,
+		"popular:100":   true,
+		"popular:0":     false,
+		"popular:x":     false,
+		"":              false,
+		"/etc/passwd":   false,
+		"titles.txt":    false,
+		"../vital":      false,
+		"popular:1/../": false,
+	} {
+		if got := remoteWarmupMode(mode); got != want {
+			t.Errorf("remoteWarmupMode(%q) = %v, want %v", mode, got, want)
+		}
+	}
+}
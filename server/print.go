@@ -0,0 +1,66 @@
+package server
+
+import (
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// /wiki/{title}?action=print serves an article laid out for paper, as
+// Wikipedia's printable version does, so "Print to PDF" from any browser
+// gives a clean document: no navigation or forms, every collapsed table
+// and section open, citations as numbered footnotes with the addresses of
+// their links written out, absolute links throughout, and each top-level
+// section starting on a new page (static/print.css).
+
+var (
+	popupCitationRe = regexp.MustCompile(`(?s)<sup class="citation-popup" id="([^"]*)" tabindex="0">\[(\d+)\]<span class="citation-body">(.*?)</span></sup>`)
+	redLinkRe       = regexp.MustCompile(`(?s)<a class="new" [^>]*>(.*?)</a>`)
+	collapsedRe     = regexp.MustCompile(`data-collapsible="(?:collapsed|auto)"`)
+	footnoteListRe  = regexp.MustCompile(`(?s)<section [^>]*class="(?:citations|footnotes[^"]*)"[^>]*>.*?</section>`)
+	footnoteLinkRe  = regexp.MustCompile(`(?s)<a href="([^"#][^"]*)"[^>]*>(.*?)</a>`)
+)
+
+// printableHTML prepares rendered article HTML for printing. prefix is the
+// path the article's wiki is served under.
+func printableHTML(r *http.Request, prefix, content string) string {
+	content = redLinkRe.ReplaceAllString(content, "$1")
+	content = popupsToFootnotes(content)
+	content = collapsedRe.ReplaceAllString(content, `data-collapsible="expanded"`)
+	content = strings.ReplaceAll(content, "<details>", "<details open>")
+	content = absoluteLinks(r, prefix, content)
+	return footnoteListRe.ReplaceAllStringFunc(content, func(list string) string {
+		return footnoteLinkRe.ReplaceAllStringFunc(list, func(link string) string {
+			m := footnoteLinkRe.FindStringSubmatch(link)
+			href := html.UnescapeString(m[1])
+			if html.UnescapeString(m[2]) == href {
+				return link
+			}
+			return link + ` <span class="print-url">` + html.EscapeString(href) + `</span>`
+		})
+	})
+}
+
+// popupsToFootnotes turns the hover citations of -citations popup into
+// footnote markers and a reference list, since a popup can't be printed.
+func popupsToFootnotes(content string) string {
+	var refs []citation
+	content = popupCitationRe.ReplaceAllStringFunc(content, func(marker string) string {
+		m := popupCitationRe.FindStringSubmatch(marker)
+		n, _ := strconv.Atoi(m[2])
+		for len(refs) < n {
+			refs = append(refs, citation{})
+		}
+		if refs[n-1].uses == 0 {
+			refs[n-1].body = m[3]
+		}
+		refs[n-1].uses++
+		return `<sup class="citation-ref"><a href="#cite` + m[2] + `" id="` + m[1] + `">[` + m[2] + `]</a></sup>`
+	})
+	if len(refs) == 0 {
+		return content
+	}
+	return content + citationList(refs)
+}
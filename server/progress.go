@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxOperations is how many finished operations the hub remembers.
+const maxOperations = 20
+
+// operation is one long-running job, such as a warm-up run
+type operation struct {
+	ID       int        `json:"id"`
+	Name     string     `json:"name"`
+	Done     int        `json:"done"`
+	Total    int        `json:"total"`
+	Status   string     `json:"status"` // running, done or failed
+	Message  string     `json:"message,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	hub         *progressHub
+	lastPublish time.Time
+}
+
+// progressInterval limits how often Add publishes an update.
+const progressInterval = 250 * time.Millisecond
+
+// progressHub tracks long-running operations and fans their progress out
+// to Server-Sent Events subscribers.
+type progressHub struct {
+	mu     sync.Mutex
+	nextID int
+	ops    []*operation
+	subs   map[chan []byte]bool
+}
+
+func newProgressHub() *progressHub {
+	return &progressHub{subs: make(map[chan []byte]bool)}
+}
+
+// Start registers a new running operation with the given amount of work.
+func (h *progressHub) Start(name string, total int) *operation {
+	h.mu.Lock()
+	h.nextID++
+	op := &operation{ID: h.nextID, Name: name, Total: total, Status: "running", Started: time.Now(), hub: h}
+	h.ops = append(h.ops, op)
+	if len(h.ops) > maxOperations {
+		h.ops = h.ops[len(h.ops)-maxOperations:]
+	}
+	h.mu.Unlock()
+	h.publish(op)
+	return op
+}
+
+// SetTotal updates the amount of work once it is known.
+func (op *operation) SetTotal(total int) {
+	op.hub.mu.Lock()
+	op.Total = total
+	op.hub.mu.Unlock()
+}
+
+// Add records n more units of work done.
+func (op *operation) Add(n int) {
+	op.hub.mu.Lock()
+	op.Done += n
+	now := time.Now()
+	due := now.Sub(op.lastPublish) >= progressInterval || op.Done == op.Total
+	if due {
+		op.lastPublish = now
+	}
+	op.hub.mu.Unlock()
+	if due {
+		op.hub.publish(op)
+	}
+}
+
+// Finish marks the operation done, or failed when err is not nil.
+func (op *operation) Finish(message string, err error) {
+	op.hub.mu.Lock()
+	op.Status = "done"
+	op.Message = message
+	if err != nil {
+		op.Status = "failed"
+		op.Message = err.Error()
+	}
+	now := time.Now()
+	op.Finished = &now
+	op.hub.mu.Unlock()
+	op.hub.publish(op)
+}
+
+// publish sends a snapshot of op to every subscriber. Slow subscribers
+// miss intermediate updates rather than blocking the operation.
+func (h *progressHub) publish(op *operation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	data, err := json.Marshal(op)
+	if err != nil {
+		return
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+func (h *progressHub) subscribe() (chan []byte, [][]byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan []byte, 64)
+	h.subs[ch] = true
+	var snapshot [][]byte
+	for _, op := range h.ops {
+		if data, err := json.Marshal(op); err == nil {
+			snapshot = append(snapshot, data)
+		}
+	}
+	return ch, snapshot
+}
+
+func (h *progressHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+}
+
+// handleProgressEvents streams operation updates as Server-Sent Events,
+// starting with the current state of every known operation.
+func handleProgressEvents(w http.ResponseWriter, r *http.Request, hub *progressHub) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	ch, snapshot := hub.subscribe()
+	defer hub.unsubscribe(ch)
+	for _, data := range snapshot {
+		fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-ch:
+			fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// projectProfile adapts rendering to a Wikimedia project other than
+// Wikipedia: extra template handlers and a CSS class for the content area.
+type projectProfile struct {
+	Name      string
+	Templates map[string]TemplateHandler
+}
+
+var projectProfiles = map[string]*projectProfile{
+	"wikipedia": {Name: "wikipedia"},
+	"wikiquote": {
+		Name: "wikiquote",
+		Templates: map[string]TemplateHandler{
+			"quote":     quoteTemplate,
+			"cquote":    quoteTemplate,
+			"wikipedia": dropTemplate,
+			"commons":   dropTemplate,
+		},
+	},
+	"wikivoyage": {
+		Name: "wikivoyage",
+		Templates: map[string]TemplateHandler{
+			"listing":    listingTemplate,
+			"see":        listingTemplate,
+			"do":         listingTemplate,
+			"buy":        listingTemplate,
+			"eat":        listingTemplate,
+			"drink":      listingTemplate,
+			"sleep":      listingTemplate,
+			"go":         listingTemplate,
+			"regionlist": regionListTemplate,
+			"ispartof":   isPartOfTemplate,
+			"pagebanner": dropTemplate,
+			"mapframe":   dropTemplate,
+			"geo":        dropTemplate,
+			"quickbar":   dropTemplate,
+			"routebox":   dropTemplate,
+		},
+	},
+}
+
+// guessProject names the profile for a dump from its database name, e.g.
+// enwikivoyage.
+func guessProject(info *DumpInfo) string {
+	name := "wikipedia"
+	for _, p := range []string{"wikiquote", "wikivoyage"} {
+		if info != nil && strings.HasSuffix(info.DBName, p) {
+			name = p
+		}
+	}
+	return name
+}
+
+// templates returns the base template handlers, the defaults or
+// Config.Templates, with the profile's own added.
+func (p *projectProfile) templates(base *Registry) *Registry {
+	return base.With(p.Templates)
+}
+
+// selectProject resolves -project, guessing from the dump when it is
+// "auto".
+func selectProject(name string, info *DumpInfo) (*projectProfile, error) {
+	if name == "auto" {
+		name = guessProject(info)
+	}
+	p, ok := projectProfiles[name]
+	if !ok {
+		var names []string
+		for n := range projectProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown project %q (want auto or one of %s)", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+func dropTemplate(args []string) string {
+	return ""
+}
+
+// quoteTemplate renders {{Quote|text|author}} as a block quote.
+func quoteTemplate(args []string) string {
+	positional, named := templateArgs(args)
+	text := firstNonEmpty(named["text"], named["quote"], named["1"])
+	author := firstNonEmpty(named["author"], named["sign"], named["2"])
+	if len(positional) > 0 && text == "" {
+		text = positional[0]
+	}
+	if len(positional) > 1 && author == "" {
+		author = positional[1]
+	}
+	if text == "" {
+		return ""
+	}
+	out := `<div class="quote"><blockquote>` + convertInline(text) + `</blockquote>`
+	if author != "" {
+		out += `<p class="quote-author">— ` + convertInline(author) + `</p>`
+	}
+	return out + `</div>`
+}
+
+// listingTemplate renders Wikivoyage {{listing}}, {{see}}, {{eat}} etc. as
+// a single line: bold linked name followed by the practical details.
+func listingTemplate(args []string) string {
+	_, named := templateArgs(args)
+	name := named["name"]
+	if name == "" {
+		return ""
+	}
+	if url := named["url"]; url != "" {
+		name = "[" + url + " " + name + "]"
+	}
+	out := "'''" + name + "'''"
+	if alt := named["alt"]; alt != "" {
+		out += " (''" + alt + "'')"
+	}
+	var details []string
+	for _, field := range []string{"address", "directions", "phone", "hours", "price"} {
+		if v := named[field]; v != "" {
+			details = append(details, v)
+		}
+	}
+	if len(details) > 0 {
+		out += ", " + strings.Join(details, ", ")
+	}
+	if content := named["content"]; content != "" {
+		out += ". " + content
+	}
+	return out
+}
+
+// cssColorRe matches the colors a region list may set: #hex or a color
+// name. Anything else is dropped, since the value lands in a style
+// attribute where it could add other declarations or fetch URLs.
+var cssColorRe = regexp.MustCompile(`^(?:#(?:[0-9a-fA-F]{3,4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})|[a-zA-Z]{3,20})$`)
+
+// regionListTemplate renders {{Regionlist}} as a table of regions, in
+// place of the clickable region map.
+func regionListTemplate(args []string) string {
+	_, named := templateArgs(args)
+	var b strings.Builder
+	b.WriteString(`<table class="wikitable region-list"><tr><th>Region</th><th>Includes</th><th>Description</th></tr>`)
+	rows := 0
+	for i := 1; ; i++ {
+		prefix := fmt.Sprintf("region%d", i)
+		name := named[prefix+"name"]
+		if name == "" {
+			break
+		}
+		swatch := ""
+		if color := strings.TrimSpace(named[prefix+"color"]); cssColorRe.MatchString(color) {
+			swatch = fmt.Sprintf(`<span class="region-swatch" style="background-color:%s"></span>`, color)
+		}
+		fmt.Fprintf(&b, "<tr><td>%s%s</td><td>%s</td><td>%s</td></tr>", swatch, convertInline(name), convertInline(named[prefix+"items"]), convertInline(named[prefix+"description"]))
+		rows++
+	}
+	b.WriteString("</table>")
+	if rows == 0 {
+		return ""
+	}
+	return b.String()
+}
+
+// isPartOfTemplate renders the Wikivoyage breadcrumb {{IsPartOf|Region}}.
+func isPartOfTemplate(args []string) string {
+	positional, _ := templateArgs(args)
+	if len(positional) == 0 || positional[0] == "" {
+		return ""
+	}
+	return `<div class="breadcrumb">Part of [[` + positional[0] + `]]</div>`
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegionListColor(t *testing.T) {
+	tests := []struct {
+		color string
+		style string // "" when no swatch should be drawn
+	}{
+		{"#5f9ea0", "background-color:#5f9ea0"},
+		{"#ABC", "background-color:#ABC"},
+		{"#11223344", "background-color:#11223344"},
+		{"cadetblue", "background-color:cadetblue"},
+		{" teal ", "background-color:teal"},
+		{"red;background-image:url(//host/x)", ""},
+		{"url(//host/x)", ""},
+		{"#12345", ""},
+		{"#zzz", ""},
+		{`red" onmouseover="alert(1)`, ""},
+		{"expression(alert(1))", ""},
+		{"rgb(1,2,3)", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		out := regionListTemplate([]string{"region1name=North", "region1color=" + tt.color, "region1items=A, B"})
+		if !strings.Contains(out, "North") {
+			t.Fatalf("color %q: region missing from %q", tt.color, out)
+		}
+		hasSwatch := strings.Contains(out, "region-swatch")
+		if tt.style == "" {
+			if hasSwatch {
+				t.Errorf("color %q drew a swatch: %q", tt.color, out)
+			}
+			continue
+		}
+		if !strings.Contains(out, `style="`+tt.style+`"`) {
+			t.Errorf("color %q: want style %q in %q", tt.color, tt.style, out)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// protectedTags hold content that is shown literally rather than parsed
+// as wikitext. <code> goes further than MediaWiki, which still parses its
+// content, so code samples survive.
+var protectedTags = []string{"nowiki", "pre", "code", "syntaxhighlight", "source", "math"}
+
+// protectedRe matches a whole protected span, or a self-closing <nowiki />
+// that only separates markup.
+var protectedRe = func() *regexp.Regexp {
+	alternatives := []string{`<nowiki\s*/>`}
+	for _, tag := range protectedTags {
+		alternatives = append(alternatives, `<`+tag+`(?:\s[^>]*)?>.*?</`+tag+`\s*>`)
+	}
+	return regexp.MustCompile(`(?is)` + strings.Join(alternatives, "|"))
+}()
+
+var (
+	protectedTokenRe = regexp.MustCompile("\x7fUNIQ-([0-9]+)-QINU\x7f")
+	langAttrRe       = regexp.MustCompile(`(?i)\blang\s*=\s*"?([\w+#.-]+)`)
+	displayBlockRe   = regexp.MustCompile(`(?i)\bdisplay\s*=\s*"?block`)
+	nowikiTagRe      = regexp.MustCompile(`(?i)</?nowiki\s*/?>`)
+)
+
+// protector swaps protected spans for placeholder tokens so that no
+// conversion pass touches them, and puts them back at the end. Block spans
+// get a line of their own so they aren't wrapped in paragraphs.
+type protector struct {
+	parts  []string
+	blocks map[int]bool
+}
+
+func protectedToken(i int) string {
+	return "\x7fUNIQ-" + strconv.Itoa(i) + "-QINU\x7f"
+}
+
+func (p *protector) add(s string, block bool) string {
+	p.parts = append(p.parts, s)
+	i := len(p.parts) - 1
+	if block {
+		if p.blocks == nil {
+			p.blocks = make(map[int]bool)
+		}
+		p.blocks[i] = true
+		return "\n" + protectedToken(i) + "\n"
+	}
+	return protectedToken(i)
+}
+
+// shield replaces protected spans with tokens that restore to the span
+// unchanged, for passes such as template expansion that must skip them.
+func (p *protector) shield(text string) string {
+	if !strings.Contains(text, "<") {
+		return text
+	}
+	return protectedRe.ReplaceAllStringFunc(text, func(m string) string {
+		return p.add(m, false)
+	})
+}
+
+// render replaces protected spans with tokens that restore to their HTML.
+func (p *protector) render(text string) string {
+	if !strings.Contains(text, "<") {
+		return text
+	}
+	return protectedRe.ReplaceAllStringFunc(text, func(m string) string {
+		tag, attrs, content := splitProtected(m)
+		switch tag {
+		case "pre":
+			// Like MediaWiki, <pre> allows <nowiki> inside and drops it
+			content = nowikiTagRe.ReplaceAllString(content, "")
+			return p.add("<pre>"+escapeText(strings.TrimPrefix(content, "\n"))+"</pre>", true)
+		case "code":
+			return p.add("<code>"+escapeText(content)+"</code>", false)
+		case "syntaxhighlight", "source":
+			class := ""
+			if lang := langAttrRe.FindStringSubmatch(attrs); lang != nil {
+				class = ` class="lang-` + html.EscapeString(strings.ToLower(lang[1])) + `"`
+			}
+			// Highlighted source is literal: references stay as typed
+			content = html.EscapeString(strings.Trim(content, "\n"))
+			if strings.Contains(strings.ToLower(attrs), "inline") {
+				return p.add("<code"+class+">"+content+"</code>", false)
+			}
+			return p.add("<pre"+class+">"+content+"</pre>", true)
+		case "math":
+			// TeX marked up as pandoc does, for the mathml stage or a
+			// script to typeset
+			tex := escapeText(strings.TrimSpace(content))
+			if displayBlockRe.MatchString(attrs) {
+				return p.add(`<span class="math display">\[`+tex+`\]</span>`, false)
+			}
+			return p.add(`<span class="math inline">\(`+tex+`\)</span>`, false)
+		}
+		return p.add(escapeText(content), false)
+	})
+}
+
+// isBlock reports whether a trimmed line is the token of a block span.
+func (p *protector) isBlock(line string) bool {
+	m := protectedTokenRe.FindStringSubmatch(line)
+	if m == nil || len(m[0]) != len(line) {
+		return false
+	}
+	i, _ := strconv.Atoi(m[1])
+	return p.blocks[i]
+}
+
+// restore puts the protected spans back in place of their tokens. Spans
+// can hold tokens of their own when they were shielded more than once.
+func (p *protector) restore(text string) string {
+	if len(p.parts) == 0 {
+		return text
+	}
+	for strings.Contains(text, "\x7fUNIQ-") {
+		next := protectedTokenRe.ReplaceAllStringFunc(text, func(m string) string {
+			i, err := strconv.Atoi(protectedTokenRe.FindStringSubmatch(m)[1])
+			if err != nil || i >= len(p.parts) {
+				return ""
+			}
+			return p.parts[i]
+		})
+		if next == text {
+			break
+		}
+		text = next
+	}
+	return text
+}
+
+// splitProtected returns the lowercase tag name, the attributes and the
+// content of a protected span.
+func splitProtected(span string) (tag, attrs, content string) {
+	end := strings.IndexByte(span, '>')
+	open := span[1:end]
+	tag = strings.ToLower(strings.TrimRight(open, "/ \t\n"))
+	if i := strings.IndexAny(tag, " \t\n"); i >= 0 {
+		tag, attrs = tag[:i], open[i:]
+	}
+	if strings.HasSuffix(open, "/") {
+		return tag, attrs, ""
+	}
+	close := strings.LastIndex(span, "</")
+	return tag, attrs, span[end+1 : close]
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// X-Forwarded-Proto and X-Forwarded-Host name the scheme and host a client
+// used to reach a reverse proxy, so canonical URLs and redirects built
+// from them point back through it. Any client can send them, though, so
+// they are only honored on connections from the proxies listed with
+// -trust-proxy.
+
+// parseTrustedProxies reads a comma-separated list of IP addresses and
+// CIDR ranges.
+func parseTrustedProxies(spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("-trust-proxy: %q is not an IP address or CIDR range", item)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// fromTrustedProxy reports whether a request came straight from one of
+// the server's -trust-proxy addresses. Without any it trusts none.
+func fromTrustedProxy(r *http.Request) bool {
+	trustedProxies := requestServer(r).proxies
+	if len(trustedProxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHeader returns the first value of an X-Forwarded- header set by
+// a trusted proxy, or "".
+func forwardedHeader(r *http.Request, name string) string {
+	if !fromTrustedProxy(r) {
+		return ""
+	}
+	value, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(value)
+}
+
+// requestScheme is the scheme the client used: the proxy's when it is
+// trusted.
+func requestScheme(r *http.Request) string {
+	if proto := forwardedHeader(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestHost is the host the client asked for: the proxy's when it is
+// trusted.
+func requestHost(r *http.Request) string {
+	if host := forwardedHeader(r, "X-Forwarded-Host"); host != "" {
+		return host
+	}
+	return r.Host
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	texttemplate "text/template"
+)
+
+// The service worker at /sw.js is generated from templates/sw.js for the
+// base path. With -offline-search it answers title searches from stored
+// title lists; with -pwa it also keeps the site shell (stylesheet, scripts
+// and home page) and the articles read recently, and /manifest.json makes
+// the site installable, so a tablet that drops off the network can go on
+// reading.
+
+// pwaPageLimit is how many articles the service worker keeps for reading
+// offline, the least recently read going first.
+const pwaPageLimit = 200
+
+// pwaThemeColor is the browser toolbar color of the installed app, the
+// color of the logo.
+const pwaThemeColor = "#2c3e50"
+
+// serviceWorkerData fills in templates/sw.js.
+type serviceWorkerData struct {
+	Base      string
+	Version   string   // of the static files, naming the shell cache
+	Shell     []string // URLs kept for offline use
+	PageLimit int
+	Pages     bool // keep articles read (-pwa)
+	Titles    bool // answer searches from title lists (-offline-search)
+}
+
+// newServiceWorker generates the worker script from assetDir for a server
+// mounted at basePath.
+func newServiceWorker(assetDir, basePath string, pages, titles bool) ([]byte, error) {
+	tmpl, err := texttemplate.ParseFiles(filepath.Join(assetDir, "templates", "sw.js"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing service worker: %v", err)
+	}
+	staticDir := filepath.Join(assetDir, "static")
+	version, err := assetVersion(staticDir)
+	if err != nil {
+		return nil, err
+	}
+	data := serviceWorkerData{Base: basePath, Version: version, PageLimit: pwaPageLimit, Pages: pages, Titles: titles,
+		Shell: []string{basePath + "/static/style.css"}}
+	if pages {
+		data.Shell = []string{basePath + "/", basePath + "/manifest.json"}
+		entries, err := os.ReadDir(staticDir)
+		if err != nil {
+			return nil, fmt.Errorf("listing static files: %v", err)
+		}
+		for _, e := range entries {
+			switch filepath.Ext(e.Name()) {
+			case ".css", ".js", ".svg":
+				data.Shell = append(data.Shell, basePath+"/static/"+e.Name())
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("generating service worker: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// assetVersion fingerprints the names, sizes and modification times of the
+// static files, so browsers replace their copies after an upgrade.
+func assetVersion(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s:%d:%d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("reading static files: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+// handleServiceWorker serves the worker from the site root so that it
+// controls every page, not just /static/.
+func handleServiceWorker(w http.ResponseWriter, r *http.Request, script []byte) {
+	w.Header().Set("Content-Type", "text/javascript")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(script)
+}
+
+// webManifest is /manifest.json, describing the site as an installable app.
+type webManifest struct {
+	Name            string            `json:"name"`
+	ShortName       string            `json:"short_name"`
+	Description     string            `json:"description,omitempty"`
+	StartURL        string            `json:"start_url"`
+	Scope           string            `json:"scope"`
+	Display         string            `json:"display"`
+	BackgroundColor string            `json:"background_color"`
+	ThemeColor      string            `json:"theme_color"`
+	Lang            string            `json:"lang,omitempty"`
+	Dir             string            `json:"dir,omitempty"`
+	Icons           []webManifestIcon `json:"icons"`
+}
+
+type webManifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+func handleWebManifest(w http.ResponseWriter, r *http.Request, info *DumpInfo) {
+	basePath := requestServer(r).cfg.BasePath
+	m := webManifest{
+		Name:            "WikiSeek",
+		ShortName:       "WikiSeek",
+		StartURL:        basePath + "/",
+		Scope:           basePath + "/",
+		Display:         "standalone",
+		BackgroundColor: "#ffffff",
+		ThemeColor:      pwaThemeColor,
+		Icons: []webManifestIcon{
+			{Src: basePath + "/static/icon.svg", Sizes: "any", Type: "image/svg+xml", Purpose: "any"},
+		},
+	}
+	if info != nil {
+		if info.SiteName != "" {
+			m.Name = info.SiteName + " – WikiSeek"
+		}
+		m.Description = "Read " + info.Summary() + ", offline."
+		m.Lang, m.Dir = info.Lang, info.Dir
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Cache-Control", "no-cache")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(m)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Article quality assessments, best first. Stubs are identified by the
+// same templates as PageMeta.Stub.
+const (
+	qualityFeatured = "featured"
+	qualityGood     = "good"
+	qualityStub     = "stub"
+)
+
+var qualityLabels = map[string]string{
+	qualityFeatured: "Featured article",
+	qualityGood:     "Good article",
+	qualityStub:     "Stub",
+}
+
+var (
+	featuredRe = regexp.MustCompile(`(?i)\{\{\s*(featured article|featured list|fa top)\s*(\||\}\})`)
+	goodRe     = regexp.MustCompile(`(?i)\{\{\s*good article\s*(\||\}\})`)
+)
+
+// articleQuality returns the assessment an article carries in its own
+// wikitext, or "" when it has none.
+func articleQuality(text string) string {
+	switch {
+	case featuredRe.MatchString(text):
+		return qualityFeatured
+	case goodRe.MatchString(text):
+		return qualityGood
+	case stubRe.MatchString(text):
+		return qualityStub
+	}
+	return ""
+}
+
+// searchFilters are the field:value terms of a title search.
+type searchFilters struct {
+	Quality string
+}
+
+// parseSearchFilters removes filter terms such as quality:featured from a
+// query, returning the remaining words and the filters. Unknown fields are
+// left in the query.
+func parseSearchFilters(query string) (string, searchFilters) {
+	var filters searchFilters
+	var words []string
+	for _, word := range strings.Fields(query) {
+		field, value, ok := strings.Cut(word, ":")
+		if ok && strings.EqualFold(field, "quality") && value != "" {
+			filters.Quality = strings.ToLower(value)
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " "), filters
+}
+
+// active reports whether any filter is set.
+func (f searchFilters) active() bool {
+	return f.Quality != ""
+}
+
+// match reports whether an entry passes the filters. Only pages with known
+// metadata can match a quality filter.
+func (f searchFilters) match(entry IndexEntry, meta *metaStore) bool {
+	if f.Quality == "" {
+		return true
+	}
+	m, ok := meta.Get(entry.PageID)
+	return ok && m.Quality == f.Quality
+}
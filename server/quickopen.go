@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	quickOpenLimit = 10
+	// quickOpenScan is how many prefix matches are ranked to pick the top
+	// results.
+	quickOpenScan = 200
+)
+
+// quickOpenIndex is a sorted array of lowercased titles, searched by binary
+// search. It behaves like a compact trie for prefix queries without the
+// per-node overhead of a pointer-based one. Sorting millions of titles
+// takes seconds, so it is built in the background at startup and lookups
+// made before then wait for it.
+type quickOpenIndex struct {
+	built    chan struct{} // closed once keys and entries are filled
+	keys     []string
+	entries  []int32
+	basePath string // for result URLs
+}
+
+func newQuickOpenIndex(entries []IndexEntry, basePath string) *quickOpenIndex {
+	qi := &quickOpenIndex{built: make(chan struct{}), basePath: basePath}
+	go qi.build(entries)
+	return qi
+}
+
+func (qi *quickOpenIndex) build(entries []IndexEntry) {
+	defer close(qi.built)
+	qi.keys = make([]string, len(entries))
+	qi.entries = make([]int32, len(entries))
+	order := make([]int32, len(entries))
+	for i := range entries {
+		order[i] = int32(i)
+	}
+	lower := make([]string, len(entries))
+	for i, e := range entries {
+		lower[i] = strings.ToLower(e.Title)
+	}
+	sort.Slice(order, func(a, b int) bool { return lower[order[a]] < lower[order[b]] })
+	for i, idx := range order {
+		qi.keys[i] = lower[idx]
+		qi.entries[i] = idx
+	}
+}
+
+type quickOpenResult struct {
+	Rank   int    `json:"rank"`
+	Title  string `json:"title"`
+	PageID int    `json:"page_id"`
+	URL    string `json:"url"`
+}
+
+// lookup returns up to limit titles starting with prefix, exact matches
+// first and then shorter titles, which are more likely to be the article
+// being looked for.
+func (qi *quickOpenIndex) lookup(entries []IndexEntry, prefix string, limit int) []quickOpenResult {
+	prefix = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(prefix, "_", " ")))
+	if prefix == "" {
+		return nil
+	}
+	<-qi.built
+	start := sort.SearchStrings(qi.keys, prefix)
+	var candidates []int
+	for i := start; i < len(qi.keys) && len(candidates) < quickOpenScan && strings.HasPrefix(qi.keys[i], prefix); i++ {
+		candidates = append(candidates, i)
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		ka, kb := qi.keys[candidates[a]], qi.keys[candidates[b]]
+		if (ka == prefix) != (kb == prefix) {
+			return ka == prefix
+		}
+		return len(ka) < len(kb)
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	results := make([]quickOpenResult, len(candidates))
+	for rank, c := range candidates {
+		e := entries[qi.entries[c]]
+		results[rank] = quickOpenResult{
+			Rank:   rank + 1,
+			Title:  e.Title,
+			PageID: e.PageID,
+			URL:    qi.basePath + "/wiki/" + strings.ReplaceAll(e.Title, " ", "_"),
+		}
+	}
+	return results
+}
+
+func handleAPIQuickOpen(w http.ResponseWriter, r *http.Request, index []IndexEntry, qi *quickOpenIndex) {
+	results := qi.lookup(index, r.FormValue("q"), quickOpenLimit)
+	if results == nil {
+		results = []quickOpenResult{}
+	}
+	w.Header().Set("Cache-Control", "max-age=300")
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// maxRedirects bounds how many redirects are followed for one request.
+const maxRedirects = 5
+
+// redirectLoopError reports a redirect chain that loops back on itself or
+// is longer than maxRedirects.
+type redirectLoopError struct {
+	Chain []string
+}
+
+func (e *redirectLoopError) Error() string {
+	return fmt.Sprintf("redirect loop or chain too long: %s", strings.Join(e.Chain, " → "))
+}
+
+// splitRedirect turns the href of a redirect into a page title and a
+// section fragment in the form used by heading ids.
+func splitRedirect(target string) (string, string) {
+	target = html.UnescapeString(target)
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	title, fragment, _ := strings.Cut(target, "#")
+	title = strings.TrimSpace(strings.ReplaceAll(title, "_", " "))
+	if fragment != "" {
+		fragment = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fragment), " ", "_"))
+	}
+	return title, fragment
+}
+
+// followRedirects resolves a page's redirect through any further redirects
+// it leads to, returning the final title and the section fragment given
+// by the last redirect that named one. A target missing from the index
+// ends the chain there.
+func followRedirects(index []IndexEntry, titles titleSet, pipeline *Pipeline, cache *renderCache, renderer Renderer, tr *trace, entry *IndexEntry, redirect string) (string, string, error) {
+	chain := []string{entry.Title}
+	seen := map[int]bool{entry.PageID: true}
+	var fragment string
+	for {
+		title, frag := splitRedirect(redirect)
+		if frag != "" {
+			fragment = frag
+		}
+		next := findPageByTitle(index, titles, title)
+		if next == nil {
+			return title, fragment, nil
+		}
+		chain = append(chain, next.Title)
+		if seen[next.PageID] || len(chain) > maxRedirects+1 {
+			return "", "", &redirectLoopError{Chain: chain}
+		}
+		seen[next.PageID] = true
+
+		page, err := renderEntry(pipeline, next, cache, renderer, tr)
+		if err != nil || page.Redirect == "" {
+			// The target shows its own error if it can't be rendered
+			return next.Title, fragment, nil
+		}
+		redirect = page.Redirect
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renderedPage is the cacheable result of rendering one article
+type renderedPage struct {
+	Content      string
+	Redirect     string
+	Description  string
+	DisplayTitle string
+	Quality      string
+	Prefetch     []string
+	Links        []string
+	Sections     []int    // offsets of the top-level headings in Content
+	Dir          string   // "rtl" or "ltr", from the script of the text
+	Templates    []string // for clearing pages when -template-dir changes
+	Warning      string   // set when the raw wikitext fallback was used
+}
+
+// renderCache is an LRU of rendered articles keyed by page ID. Its size
+// is fixed unless -memory-limit has the cache tuner resize it.
+type renderCache struct {
+	mu    sync.Mutex
+	max   int
+	ll    *list.List
+	items map[int]*list.Element
+
+	hits, misses int64
+}
+
+type renderCacheItem struct {
+	pageID int
+	page   *renderedPage
+}
+
+func newRenderCache(max int) *renderCache {
+	return &renderCache{max: max, ll: list.New(), items: make(map[int]*list.Element)}
+}
+
+func (c *renderCache) Get(pageID int) (*renderedPage, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.max <= 0 {
+		return nil, false
+	}
+	if el, ok := c.items[pageID]; ok {
+		c.hits++
+		c.ll.MoveToFront(el)
+		return el.Value.(*renderCacheItem).page, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// Stats returns how many lookups were served from the cache and how many
+// had to render.
+func (c *renderCache) Stats() (hits, misses int64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+func (c *renderCache) Add(pageID int, page *renderedPage) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.max <= 0 {
+		return
+	}
+	if el, ok := c.items[pageID]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*renderCacheItem).page = page
+		return
+	}
+	c.items[pageID] = c.ll.PushFront(&renderCacheItem{pageID: pageID, page: page})
+	c.evict()
+}
+
+// evict drops the least recently used pages until the cache fits. The
+// caller must hold c.mu.
+func (c *renderCache) evict() {
+	for c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*renderCacheItem).pageID)
+	}
+}
+
+// Max returns how many pages the cache holds at most.
+func (c *renderCache) Max() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.max
+}
+
+// SetMax changes the capacity, dropping the least recently used pages
+// when it shrinks.
+func (c *renderCache) SetMax(max int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.max = max
+	c.evict()
+}
+
+// RemoveIf drops the cached pages drop reports true for and returns how
+// many were removed.
+func (c *renderCache) RemoveIf(drop func(*renderedPage) bool) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		item := el.Value.(*renderCacheItem)
+		if drop(item.page) {
+			c.ll.Remove(el)
+			delete(c.items, item.pageID)
+			removed++
+		}
+		el = next
+	}
+	return removed
+}
+
+func (c *renderCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// renderEntry runs an article through the render pipeline, serving it
+// from the cache when possible. A nil renderer uses the default one, and
+// tr may be nil.
+func renderEntry(pipeline *Pipeline, entry *IndexEntry, cache *renderCache, renderer Renderer, tr *trace) (*renderedPage, error) {
+	// Only the default renderer's output is cached; other renderers are
+	// picked per request for comparison and always render fresh.
+	if renderer != nil && renderer != pipeline.renderer {
+		cache = nil
+	}
+	if page, ok := cache.Get(entry.PageID); ok {
+		tr.Start("render-cache-hit")()
+		return page, nil
+	}
+	// A redirect imported from the redirect table needs no rendering
+	if target := pipeline.meta.redirectTarget(entry.PageID); target != "" {
+		tr.Start("redirect-table")()
+		title, fragment, _ := strings.Cut(target, "#")
+		redirect := url.PathEscape(title)
+		if fragment != "" {
+			redirect += "#" + url.PathEscape(fragment)
+		}
+		return &renderedPage{Redirect: redirect, DisplayTitle: entry.Title}, nil
+	}
+
+	key := renderKey{pageID: entry.PageID}
+	if renderer != nil {
+		key.renderer = renderer.Name()
+	}
+	page, err, shared := pipeline.renders.do(key, func() (*renderedPage, error) {
+		return renderUncached(pipeline, entry, cache, renderer, tr)
+	})
+	if shared {
+		tr.Start("render-shared")()
+	}
+	return page, err
+}
+
+// renderWithTimeout renders like renderEntry but stops waiting after
+// timeout, as -render-timeout. The render goes on, so its result still
+// reaches the cache for the next request.
+func renderWithTimeout(pipeline *Pipeline, entry *IndexEntry, cache *renderCache, renderer Renderer, tr *trace, timeout time.Duration) (*renderedPage, error) {
+	if timeout <= 0 {
+		return renderEntry(pipeline, entry, cache, renderer, tr)
+	}
+	type result struct {
+		page *renderedPage
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		page, err := renderEntry(pipeline, entry, cache, renderer, tr)
+		done <- result{page, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.page, res.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: still rendering after %v", ErrRenderTimeout, timeout)
+	}
+}
+
+// renderUncached runs the pipeline and stores the result in the cache.
+func renderUncached(pipeline *Pipeline, entry *IndexEntry, cache *renderCache, renderer Renderer, tr *trace) (*renderedPage, error) {
+	ctx, err := pipeline.Run(entry, renderer, tr)
+	page := &renderedPage{Content: ctx.HTML, Redirect: ctx.Redirect, Description: ctx.Description, Quality: ctx.Quality, Prefetch: ctx.Prefetch, Links: ctx.Links, Sections: ctx.Sections, Dir: textDirection(ctx.HTML), Templates: ctx.Templates, DisplayTitle: displayTitle(entry.Title, ctx.Magic.DisplayTitle)}
+	var panicErr *renderPanicError
+	if errors.As(err, &panicErr) {
+		page.Warning = "This article could not be rendered, so its raw wikitext is shown instead."
+	} else if err != nil {
+		return nil, err
+	}
+
+	cache.Add(entry.PageID, page)
+	return page, nil
+}
+
+// renderKey identifies one render of a pipeline: a page through one
+// renderer.
+type renderKey struct {
+	pageID   int
+	renderer string
+}
+
+// renderCall is a render in progress that other requests can wait on
+type renderCall struct {
+	done chan struct{}
+	page *renderedPage
+	err  error
+}
+
+// renderFlight makes concurrent requests for the same uncached page share
+// a single extract and render instead of each doing the work. Each
+// pipeline has its own.
+type renderFlight struct {
+	mu    sync.Mutex
+	calls map[renderKey]*renderCall
+}
+
+func newRenderFlight() *renderFlight {
+	return &renderFlight{calls: make(map[renderKey]*renderCall)}
+}
+
+// do runs fn for key unless a call for key is already running, in which
+// case it waits for that call and returns its result with shared set.
+func (f *renderFlight) do(key renderKey, fn func() (*renderedPage, error)) (page *renderedPage, err error, shared bool) {
+	f.mu.Lock()
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		<-c.done
+		return c.page, c.err, true
+	}
+	// err is replaced when fn returns; it is only seen by waiters if fn
+	// panics.
+	c := &renderCall{done: make(chan struct{}), err: errors.New("render aborted")}
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(c.done)
+	}()
+	c.page, c.err = fn()
+	return c.page, c.err, false
+}
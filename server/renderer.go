@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Renderer converts the wikitext of one article to HTML.
+type Renderer interface {
+	Name() string
+	Render(wikitext, title string) (string, error)
+}
+
+// rendererSet holds every renderer available to a server, keyed by name,
+// and the default one used when a request doesn't pick one. setupRenderers
+// builds it at startup.
+type rendererSet struct {
+	byName map[string]Renderer
+	def    Renderer
+}
+
+// nativeRenderer uses the built-in MediaWiki converter.
+type nativeRenderer struct {
+	options convertOptions
+}
+
+func (nativeRenderer) Name() string { return "native" }
+
+func (n nativeRenderer) Render(wikitext, title string) (string, error) {
+	return convertWikiText(wikitext, n.options), nil
+}
+
+// pandocRenderer runs the pandoc binary.
+type pandocRenderer struct {
+	path         string
+	headerOffset int
+}
+
+func (pandocRenderer) Name() string { return "pandoc" }
+
+func (p pandocRenderer) Render(wikitext, title string) (string, error) {
+	cmd := exec.Command(p.path, "-f", "mediawiki", "-t", "html")
+	cmd.Stdin = strings.NewReader(wikitext)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Error converting with pandoc: %v\nOutput:\n%s", err, string(output))
+	}
+	return shiftHeadings(string(output), p.headerOffset), nil
+}
+
+var headingLevelRe = regexp.MustCompile(`<(/?)h([1-6])\b`)
+
+// shiftHeadings renumbers the headings pandoc writes, which follow the
+// wikitext levels (= is h1), as the native converter does with
+// headingLevel.
+func shiftHeadings(html string, offset int) string {
+	return headingLevelRe.ReplaceAllStringFunc(html, func(tag string) string {
+		m := headingLevelRe.FindStringSubmatch(tag)
+		level := int(m[2][0] - '0')
+		return "<" + m[1] + "h" + strconv.Itoa(headingLevel(level, offset))
+	})
+}
+
+// httpRenderer posts wikitext to an external service and uses the response
+// body as the article HTML. The request is form-encoded with "wikitext" and
+// "title" fields, which is what Parsoid's transform endpoint accepts; a
+// "{title}" placeholder in the URL is replaced with the escaped title.
+type httpRenderer struct {
+	url    string
+	client *http.Client
+}
+
+// maxRendererResponse bounds the HTML read back from an external renderer.
+const maxRendererResponse = 32 << 20
+
+func (httpRenderer) Name() string { return "http" }
+
+func (h httpRenderer) Render(wikitext, title string) (string, error) {
+	target := strings.ReplaceAll(h.url, "{title}", url.PathEscape(strings.ReplaceAll(title, " ", "_")))
+	form := url.Values{"wikitext": {wikitext}, "title": {title}}
+	resp, err := h.client.PostForm(target, form)
+	if err != nil {
+		return "", fmt.Errorf("Error converting with %s: %v", h.url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRendererResponse))
+	if err != nil {
+		return "", fmt.Errorf("Error reading response from %s: %v", h.url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Error converting with %s: %s\nOutput:\n%s", h.url, resp.Status, string(body))
+	}
+	return string(body), nil
+}
+
+// setupRenderers registers the native renderer with options, pandoc when
+// installed and the http renderer when serviceURL is set, then selects the
+// default renderer by name. It also returns a description of the default
+// for the startup log.
+func setupRenderers(name, serviceURL string, options convertOptions, log io.Writer) (*rendererSet, string, error) {
+	set := &rendererSet{byName: map[string]Renderer{"native": nativeRenderer{options: options}}}
+	description := "native"
+	pandocVersion := ""
+	if path, err := exec.LookPath("pandoc"); err == nil {
+		set.byName["pandoc"] = pandocRenderer{path: path, headerOffset: options.headerOffset}
+		pandocVersion = "pandoc"
+		if out, err := exec.Command(path, "--version").Output(); err == nil {
+			version, _, _ := strings.Cut(string(out), "\n")
+			pandocVersion = strings.TrimSpace(version)
+		}
+	}
+	if serviceURL != "" {
+		if u, err := url.Parse(serviceURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, "", fmt.Errorf("invalid -renderer-url %q", serviceURL)
+		}
+		set.byName["http"] = httpRenderer{url: serviceURL, client: &http.Client{Timeout: 60 * time.Second}}
+	}
+
+	switch name {
+	case "auto":
+		if _, ok := set.byName["pandoc"]; !ok {
+			fmt.Fprintln(log, "Warning: pandoc not found in PATH, falling back to the native converter")
+			name = "native"
+		} else {
+			name = "pandoc"
+		}
+	case "http":
+		if serviceURL == "" {
+			return nil, "", fmt.Errorf("the http renderer needs -renderer-url")
+		}
+	}
+	r, ok := set.byName[name]
+	if !ok {
+		if name == "pandoc" {
+			return nil, "", fmt.Errorf("pandoc not found in PATH")
+		}
+		return nil, "", fmt.Errorf("unknown renderer %q (available: %s)", name, strings.Join(set.names(), ", "))
+	}
+	set.def = r
+
+	switch name {
+	case "pandoc":
+		description = pandocVersion
+	case "http":
+		description = "http (" + serviceURL + ")"
+	}
+	if others := len(set.byName) - 1; others > 0 {
+		description += fmt.Sprintf(", per request: %s", strings.Join(set.names(), ", "))
+	}
+	return set, description, nil
+}
+
+// names lists the registered renderers in name order.
+func (s *rendererSet) names() []string {
+	names := make([]string, 0, len(s.byName))
+	for name := range s.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// forRequest returns the renderer picked with ?renderer=, so the same
+// article can be compared between renderers, or the default one.
+func (s *rendererSet) forRequest(r *http.Request) (Renderer, error) {
+	name := r.URL.Query().Get("renderer")
+	if name == "" {
+		return s.def, nil
+	}
+	renderer, ok := s.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown renderer %q (available: %s)", name, strings.Join(s.names(), ", "))
+	}
+	return renderer, nil
+}
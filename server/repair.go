@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// maxRepairHints is how many individual offset corrections are printed.
+const maxRepairHints = 10
+
+// runIndexRepair rescans the dump for bzip2 stream headers and rewrites the
+// index with every offset moved to the nearest real stream start.
+func runIndexRepair(args []string) {
+	fs := newCommandFlags("index repair")
+	inputFile := fs.String("file", "", "Path to multistream bzip2 file")
+	indexPath := fs.String("index", "", "Path to index file")
+	outPath := fs.String("out", "", "Where to write the corrected index (default: <index>.repaired.txt)")
+	fs.Parse(args)
+
+	if *inputFile == "" || *indexPath == "" {
+		fmt.Println("Error: both -file and -index arguments are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *outPath == "" {
+		*outPath = *indexPath + ".repaired.txt"
+	}
+
+	fmt.Print("Scanning dump for stream headers")
+	starts, err := dump.ScanStreams(*inputFile, func(scanned int64) {
+		if scanned%(1<<30) < 4<<20 {
+			fmt.Print(".")
+		}
+	})
+	if err != nil {
+		fmt.Printf("\nError scanning dump: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\nFound %d streams\n", len(starts))
+	if len(starts) == 0 {
+		fmt.Println("Error: no bzip2 streams found; is this a multistream dump?")
+		os.Exit(1)
+	}
+
+	valid := make(map[int64]bool, len(starts))
+	for _, s := range starts {
+		valid[s] = true
+	}
+
+	out, err := os.Create(*outPath)
+	if err != nil {
+		fmt.Printf("Error creating output: %v\n", err)
+		os.Exit(1)
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+
+	fixed := make(map[int64]int64)
+	lines := 0
+	for line, err := range dump.IndexLines(*indexPath) {
+		if err != nil {
+			fmt.Printf("Error reading index: %v\n", err)
+			os.Exit(1)
+		}
+		lines++
+		if !valid[line.Offset] {
+			if _, ok := fixed[line.Offset]; !ok {
+				fixed[line.Offset] = nearestStart(starts, line.Offset)
+			}
+			line.Offset = fixed[line.Offset]
+		}
+		fmt.Fprintf(w, "%d:%d:%s\n", line.Offset, line.PageID, line.Title)
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Printf("Error writing output: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(fixed) == 0 {
+		fmt.Printf("All %d index lines point at valid stream starts\n", lines)
+		os.Remove(*outPath)
+		return
+	}
+
+	bad := make([]int64, 0, len(fixed))
+	for off := range fixed {
+		bad = append(bad, off)
+	}
+	sort.Slice(bad, func(i, j int) bool { return bad[i] < bad[j] })
+	fmt.Printf("%d of %d distinct offsets did not match a stream start:\n", len(bad), len(valid))
+	for i, off := range bad {
+		if i == maxRepairHints {
+			fmt.Printf("  ... and %d more\n", len(bad)-maxRepairHints)
+			break
+		}
+		fmt.Printf("  %d -> %d (%+d bytes)\n", off, fixed[off], fixed[off]-off)
+	}
+	fmt.Printf("Wrote corrected index to %s; it can be used as is or compressed\n", *outPath)
+	fmt.Println("If most offsets were wrong, the index probably belongs to a different dump date; prefer re-downloading the matching index.")
+}
+
+// nearestStart returns the stream start closest to offset.
+func nearestStart(starts []int64, offset int64) int64 {
+	i := sort.Search(len(starts), func(i int) bool { return starts[i] >= offset })
+	if i == len(starts) {
+		return starts[len(starts)-1]
+	}
+	if i > 0 && offset-starts[i-1] < starts[i]-offset {
+		return starts[i-1]
+	}
+	return starts[i]
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// /api/search pages through title matches with continuation tokens rather
+// than offsets. A token holds the position of the last result returned,
+// so articles added or removed when the index is rebuilt don't shift the
+// results after it, and a client paging through never sees one twice or
+// misses one that was there all along. Paged results are ordered by match
+// group and then page ID, leaving out view counts, which change between
+// requests.
+
+const (
+	defaultSearchPageSize = 50
+	maxSearchPageSize     = 1000
+)
+
+var errBadCursor = errors.New("invalid cursor, or one from another query")
+
+// searchCursor is the position after which the next page starts.
+type searchCursor struct {
+	Query  string `json:"q"` // hash of the query the cursor belongs to
+	Group  int    `json:"g"` // index in matchLabels
+	PageID int    `json:"p"`
+}
+
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:6])
+}
+
+// encode returns the opaque token clients pass back as ?cursor=.
+func (c searchCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSearchCursor reads a token, which must have been issued for the
+// same query.
+func decodeSearchCursor(token, query string) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	var c searchCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Query != queryHash(query) || c.Group < 0 || c.Group >= len(matchLabels) {
+		return nil, errBadCursor
+	}
+	return &c, nil
+}
+
+// after reports whether a result of a group comes after the cursor.
+func (c *searchCursor) after(group, pageID int) bool {
+	if c == nil || group != c.Group {
+		return c == nil || group > c.Group
+	}
+	return pageID > c.PageID
+}
+
+// groupRank returns the position of a group label in matchLabels.
+func groupRank(label string) int {
+	return slices.Index(matchLabels, label)
+}
+
+// pageResults returns up to limit title matches after the cursor, with
+// the cursor of the last one if more follow.
+func pageResults(groups []ResultGroup, cursor *searchCursor, limit int, query string) ([]ResultGroup, *searchCursor) {
+	var page []ResultGroup
+	var last *searchCursor
+	taken := 0
+	for _, g := range groups {
+		rank := groupRank(g.Label)
+		entries := slices.Clone(g.Entries)
+		slices.SortFunc(entries, func(a, b IndexEntry) int { return a.PageID - b.PageID })
+		var shown []IndexEntry
+		for _, e := range entries {
+			if !cursor.after(rank, e.PageID) {
+				continue
+			}
+			if taken == limit {
+				page = appendGroup(page, g.Label, shown)
+				return page, last
+			}
+			shown = append(shown, e)
+			taken++
+			last = &searchCursor{Query: queryHash(query), Group: rank, PageID: e.PageID}
+		}
+		page = appendGroup(page, g.Label, shown)
+	}
+	return page, nil
+}
+
+func appendGroup(groups []ResultGroup, label string, entries []IndexEntry) []ResultGroup {
+	if len(entries) == 0 {
+		return groups
+	}
+	return append(groups, ResultGroup{Label: label, Entries: entries, Shown: entries})
+}
+
+// searchPaging reads ?limit= and ?cursor=. Without either, every match is
+// returned in one response.
+func searchPaging(r *http.Request, query string) (paged bool, after *searchCursor, limit int, err error) {
+	limitParam, token := r.FormValue("limit"), r.FormValue("cursor")
+	if limitParam == "" && token == "" {
+		return false, nil, 0, nil
+	}
+	limit = defaultSearchPageSize
+	if limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 {
+			return false, nil, 0, errors.New("limit must be a positive number")
+		}
+		limit = min(limit, maxSearchPageSize)
+	}
+	if token != "" {
+		if after, err = decodeSearchCursor(token, query); err != nil {
+			return false, nil, 0, err
+		}
+	}
+	return true, after, limit, nil
+}
+
+// nextPageURL is the request URL with the cursor of the next page.
+func nextPageURL(r *http.Request, next string) string {
+	q := url.Values{}
+	for k, v := range r.URL.Query() {
+		q[k] = v
+	}
+	q.Set("cursor", next)
+	return requestServer(r).cfg.BasePath + r.URL.Path + "?" + q.Encode()
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// Long articles, such as the biggest list articles, can be served a few
+// top-level sections at a time with ?page=N. The "sections" render stage
+// records where each section starts, so pages are cut from the cached HTML
+// without parsing it again.
+
+var (
+	htmlIDRe      = regexp.MustCompile(`\sid="([^"]+)"`)
+	fragmentRefRe = regexp.MustCompile(`href="#([^"]+)"`)
+)
+
+// sectionStage records the offsets of the article's top-level headings.
+func sectionStage(ctx *RenderContext) error {
+	ctx.Sections = sectionStarts(ctx.HTML)
+	return nil
+}
+
+// sectionStarts returns the offsets of the headings of the highest level
+// used in body, leaving out the table of contents title.
+func sectionStarts(body string) []int {
+	matches := tocHeadingRe.FindAllStringSubmatchIndex(body, -1)
+	top := byte('7')
+	for _, m := range matches {
+		if body[m[4]:m[5]] != "toc-title" {
+			top = min(top, body[m[2]])
+		}
+	}
+	var starts []int
+	for _, m := range matches {
+		if body[m[2]] == top && body[m[4]:m[5]] != "toc-title" {
+			starts = append(starts, m[0])
+		}
+	}
+	return starts
+}
+
+// pageCount is the number of pages an article with these sections takes.
+// The lead goes on the first page with the first perPage sections.
+func pageCount(sections []int, perPage int) int {
+	if perPage < 1 || len(sections) <= perPage {
+		return 1
+	}
+	return (len(sections) + perPage - 1) / perPage
+}
+
+// pageBounds returns the byte range of page n, counted from 1.
+func pageBounds(body string, sections []int, perPage, n int) (int, int) {
+	start, end := 0, len(body)
+	if n > 1 {
+		start = sections[(n-1)*perPage]
+	}
+	if n*perPage < len(sections) {
+		end = sections[n*perPage]
+	}
+	return start, end
+}
+
+// articlePage returns page n of an article. Links to anchors on other
+// pages, such as table of contents entries and footnote markers, are
+// pointed at the page holding them.
+func articlePage(body string, sections []int, perPage, n int) string {
+	pages := pageCount(sections, perPage)
+	anchorPage := make(map[string]int)
+	for p := 1; p <= pages; p++ {
+		if p == n {
+			continue
+		}
+		start, end := pageBounds(body, sections, perPage, p)
+		for _, m := range htmlIDRe.FindAllStringSubmatch(body[start:end], -1) {
+			anchorPage[m[1]] = p
+		}
+	}
+	start, end := pageBounds(body, sections, perPage, n)
+	return fragmentRefRe.ReplaceAllStringFunc(body[start:end], func(ref string) string {
+		id := fragmentRefRe.FindStringSubmatch(ref)[1]
+		if p, ok := anchorPage[id]; ok {
+			return `href="?page=` + strconv.Itoa(p) + `#` + id + `"`
+		}
+		return ref
+	})
+}
+
+// articlePagination is the page navigation of an article split into pages.
+type articlePagination struct {
+	Page, Pages int
+	PrevURL     string
+	NextURL     string
+	AllURL      string
+	Links       []pageLink
+}
+
+type pageLink struct {
+	Number  int
+	URL     string
+	Current bool
+}
+
+// newArticlePagination builds the navigation for page n, keeping the other
+// query parameters of the request, such as ?highlight=.
+func newArticlePagination(query url.Values, n, pages int) *articlePagination {
+	pageURL := func(page string) string {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Set("page", page)
+		return "?" + q.Encode()
+	}
+	p := &articlePagination{Page: n, Pages: pages, AllURL: pageURL("all")}
+	if n > 1 {
+		p.PrevURL = pageURL(strconv.Itoa(n - 1))
+	}
+	if n < pages {
+		p.NextURL = pageURL(strconv.Itoa(n + 1))
+	}
+	for i := 1; i <= pages; i++ {
+		p.Links = append(p.Links, pageLink{Number: i, URL: pageURL(strconv.Itoa(i)), Current: i == n})
+	}
+	return p
+}
+
+// requestPage reads ?page=: a page number from 1, 0 when it is absent, or
+// -1 for "all", which shows the whole article however long it is.
+func requestPage(r *http.Request) (int, error) {
+	switch value := r.FormValue("page"); value {
+	case "":
+		return 0, nil
+	case "all":
+		return -1, nil
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, errors.New("page must be a number from 1, or all")
+		}
+		return n, nil
+	}
+}
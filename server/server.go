@@ -0,0 +1,1267 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xanderstrike/wikiseek/dump"
+	"github.com/xanderstrike/wikiseek/store"
+)
+
+// OffsetPair stores unique start/end offset combinations
+type OffsetPair struct {
+	Start int64
+	End   int64
+}
+
+type IndexEntry struct {
+	Offsets *OffsetPair // Reference to shared offset pair
+	PageID  int
+	Title   string
+}
+
+// offsetCache helps deduplicate common offset pairs
+type offsetCache struct {
+	pairs map[uint64]*OffsetPair
+}
+
+func newOffsetCache() *offsetCache {
+	return &offsetCache{
+		pairs: make(map[uint64]*OffsetPair),
+	}
+}
+
+func (oc *offsetCache) getOrCreate(start, end int64) *OffsetPair {
+	// Create a unique key from the two int64s
+	key := uint64(start)<<32 | uint64(uint32(end))
+
+	if pair, ok := oc.pairs[key]; ok {
+		return pair
+	}
+
+	pair := &OffsetPair{Start: start, End: end}
+	oc.pairs[key] = pair
+	return pair
+}
+
+// loadIndex returns the parsed index: from the binary index or the gob
+// cache when they are current, and by reading the index file otherwise.
+// Progress and warnings are written to log.
+func loadIndex(filename string, namespaces dumpNamespaces, log io.Writer) (*indexCache, error) {
+	// The binary index loads fastest, so try it first
+	start := time.Now()
+	binFile := indexBinFile(filename)
+	cache, binErr := loadIndexBin(binFile, filename)
+	if binErr == nil {
+		fmt.Fprintf(log, "Loaded %d entries from binary index in %v\n", len(cache.Entries), time.Since(start).Round(time.Millisecond))
+		return cache, nil
+	}
+	if !os.IsNotExist(binErr) {
+		fmt.Fprintf(log, "Ignoring binary index: %v\n", binErr)
+	}
+
+	cacheFile := indexCacheFile(filename)
+	cache, err := loadIndexCache(cacheFile, filename, log)
+	if err == nil {
+		fmt.Fprintf(log, "Loaded %d entries from cache in %v\n", len(cache.Entries), time.Since(start).Round(time.Millisecond))
+		// Caches from before the binary index get one for the next start
+		if err := saveIndexBin(cache, binFile, filename); err != nil {
+			fmt.Fprintf(log, "Warning: failed to save binary index: %v\n", err)
+		}
+		return cache, nil
+	}
+	if !os.IsNotExist(err) {
+		fmt.Fprintf(log, "Ignoring index cache: %v\n", err)
+	}
+
+	fmt.Fprint(log, "Loading index from source file")
+	f, err := dump.OpenIndex(filename)
+	if err != nil {
+		fmt.Fprintln(log)
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := dump.NewIndexReader(f)
+	allEntries := make([]IndexEntry, 0, 6000000)
+	var templates []IndexEntry
+	offsets := newOffsetCache()
+	namespaceCounts := make(map[string]int)
+	for {
+		parsed, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading index file after %d lines: %v", reader.Stats.Lines, err)
+		}
+		if reader.Stats.Lines%1000000 == 0 {
+			fmt.Fprint(log, ".")
+		}
+
+		// Skip special namespace entries, keeping templates aside
+		if namespaces.IsNamespaced(parsed.Title) {
+			prefix, _, _ := strings.Cut(parsed.Title, ":")
+			namespaceCounts[prefix]++
+			if prefix == namespaces.Template {
+				templates = append(templates, IndexEntry{
+					Offsets: offsets.getOrCreate(parsed.Offset, 0),
+					PageID:  parsed.PageID,
+					Title:   parsed.Title,
+				})
+			}
+			continue
+		}
+
+		allEntries = append(allEntries, IndexEntry{
+			Offsets: offsets.getOrCreate(parsed.Offset, 0), // EndOffset will be set later
+			PageID:  parsed.PageID,
+			Title:   parsed.Title,
+		})
+	}
+
+	sort.Slice(allEntries, func(i, j int) bool {
+		return allEntries[i].Offsets.Start < allEntries[j].Offsets.Start
+	})
+
+	// Second pass: calculate EndOffsets
+	for i := 0; i < len(allEntries); i++ {
+		entry := &allEntries[i]
+		// Find next higher start offset
+		var nextOffset int64
+		for j := i + 1; j < len(allEntries); j++ {
+			if allEntries[j].Offsets.Start > entry.Offsets.Start {
+				nextOffset = allEntries[j].Offsets.Start
+				break
+			}
+		}
+		// Update the offset pair
+		entry.Offsets = offsets.getOrCreate(entry.Offsets.Start, nextOffset)
+	}
+	// Template pages end where the next stream of either kind starts
+	if len(templates) > 0 {
+		starts := make([]int64, 0, len(offsets.pairs))
+		for _, pair := range offsets.pairs {
+			starts = append(starts, pair.Start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+		for i := range templates {
+			entry := &templates[i]
+			next := sort.Search(len(starts), func(j int) bool { return starts[j] > entry.Offsets.Start })
+			var nextOffset int64
+			if next < len(starts) {
+				nextOffset = starts[next]
+			}
+			entry.Offsets = offsets.getOrCreate(entry.Offsets.Start, nextOffset)
+		}
+	}
+
+	fmt.Fprintf(log, "Index loaded with %d entries in %d streams\n", len(allEntries), len(offsets.pairs))
+	if stats := reader.Stats; stats.Skipped() > 0 {
+		fmt.Fprintf(log, "Skipped %d of %d index lines: %d malformed, %d longer than %d bytes\n",
+			stats.Skipped(), stats.Lines, stats.Malformed, stats.Overlong, dump.MaxIndexLineLength)
+	}
+
+	// Save to cache for next time
+	cache = &indexCache{Entries: allEntries, Namespaces: namespaceCounts, Templates: templates}
+	if err := saveIndexCache(cache, cacheFile, filename, log); err != nil {
+		fmt.Fprintf(log, "Warning: failed to save index cache: %v\n", err)
+	}
+
+	return cache, nil
+}
+
+// Match quality groups, best first
+const (
+	MatchExact = iota
+	MatchPrefix
+	MatchContains
+	MatchFiltered // query made only of filters
+)
+
+var matchLabels = []string{"Exact matches", "Starts with", "Contains", "Matching filters"}
+
+// groupPreviewSize is how many results of a group are shown before the rest
+// are collapsed.
+const groupPreviewSize = 20
+
+// ResultGroup holds the search results of one match quality.
+type ResultGroup struct {
+	Label   string
+	Wiki    string // extra wiki the entries come from, "" for the primary
+	Entries []IndexEntry
+	Shown   []IndexEntry
+	Hidden  []IndexEntry
+}
+
+func searchIndex(entries []IndexEntry, query string, meta *metaStore, views *viewTracker, names *titleNames) []ResultGroup {
+	query, filters := parseSearchFilters(query)
+	query = strings.ToLower(query)
+	translit := names.transliterations()
+	latinQuery := query
+	if translit != nil {
+		latinQuery = transliterate(query)
+	}
+	// A phrase quoted for full-text search also matches titles without
+	// the quotes
+	unquoted := strings.TrimSpace(strings.ReplaceAll(query, `"`, ""))
+	// An alias of the whole query counts as an exact match of its article
+	aliasTarget, _ := names.resolveAlias(query)
+	buckets := make([][]IndexEntry, len(matchLabels))
+	for _, entry := range entries {
+		if filters.active() && !filters.match(entry, meta) {
+			continue
+		}
+		if query == "" {
+			buckets[MatchFiltered] = append(buckets[MatchFiltered], entry)
+			continue
+		}
+		if aliasTarget != "" && entry.Title == aliasTarget {
+			buckets[MatchExact] = append(buckets[MatchExact], entry)
+			continue
+		}
+		title := strings.ToLower(entry.Title)
+		quality, ok := matchQuality(title, query)
+		if !ok && unquoted != query {
+			quality, ok = matchQuality(title, unquoted)
+		}
+		if !ok && translit != nil {
+			if latin, found := translit[entry.PageID]; found {
+				title = latin
+			}
+			quality, ok = matchQuality(title, latinQuery)
+		}
+		if ok {
+			buckets[quality] = append(buckets[quality], entry)
+		}
+	}
+
+	var groups []ResultGroup
+	for quality, bucket := range buckets {
+		if len(bucket) == 0 {
+			continue
+		}
+		views.sortEntries(bucket)
+		group := ResultGroup{Label: matchLabels[quality], Entries: bucket, Shown: bucket}
+		if len(bucket) > groupPreviewSize {
+			group.Shown, group.Hidden = bucket[:groupPreviewSize], bucket[groupPreviewSize:]
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// matchQuality reports how a lowercased title matches a lowercased query.
+func matchQuality(title, query string) (int, bool) {
+	switch {
+	case title == query:
+		return MatchExact, true
+	case strings.HasPrefix(title, query):
+		return MatchPrefix, true
+	case strings.Contains(title, query):
+		return MatchContains, true
+	}
+	return 0, false
+}
+
+// countResults returns the total number of entries across result groups.
+func countResults(groups []ResultGroup) int {
+	n := 0
+	for _, g := range groups {
+		n += len(g.Entries)
+	}
+	return n
+}
+
+// ucfirst applies MediaWiki's first-letter capitalization rule, under which
+// [[apple]] and [[Apple]] name the same page.
+func ucfirst(title string) string {
+	r, size := utf8.DecodeRuneInString(title)
+	if r == utf8.RuneError || !unicode.IsLower(r) {
+		return title
+	}
+	return string(unicode.ToUpper(r)) + title[size:]
+}
+
+func findPageByTitle(entries []IndexEntry, titles titleSet, title string) *IndexEntry {
+	// Convert underscores to spaces in the requested title, then match it
+	// as written, with the first-letter rule, or ignoring case
+	i, ok := titles.LookupFold(strings.ReplaceAll(title, "_", " "))
+	if !ok {
+		return nil
+	}
+	entry := entries[i]
+	return &entry
+}
+
+func stripImgDimensions(html string) string {
+	// Remove width, height and src attributes from img tags
+	re := regexp.MustCompile(`(<img[^>]+)(width|height|src)="[^"]*"`)
+	return re.ReplaceAllString(html, "$1")
+}
+
+func lowercaseAnchors(html string) string {
+	var result strings.Builder
+	start := 0
+	
+	for {
+		// Find next href attribute
+		hrefIndex := strings.Index(html[start:], "href=\"")
+		if hrefIndex == -1 {
+			result.WriteString(html[start:])
+			break
+		}
+		hrefIndex += start
+		
+		// Write everything up to the href
+		result.WriteString(html[start:hrefIndex+6])
+		
+		// Find the end of the href value
+		endQuote := strings.IndexByte(html[hrefIndex+6:], '"')
+		if endQuote == -1 {
+			result.WriteString(html[hrefIndex+6:])
+			break
+		}
+		endQuote += hrefIndex + 6
+		
+		// Process the href value
+		hrefValue := html[hrefIndex+6:endQuote]
+		// Skip category links entirely
+		if strings.HasPrefix(hrefValue, "Category:") {
+			// Find the closing </a> tag
+			aEnd := strings.Index(html[endQuote:], "</a>")
+			if aEnd == -1 {
+				start = endQuote + 1
+				continue
+			}
+			// Skip past the entire link
+			start = endQuote + aEnd + 4
+			continue
+		}
+				
+		// Process non-category links
+		if !strings.HasPrefix(hrefValue, "#") && !strings.HasPrefix(hrefValue, "mailto:") && !strings.Contains(hrefValue, "//") {
+			hrefValue = ucfirst(hrefValue)
+		}
+		if hashIndex := strings.IndexByte(hrefValue, '#'); hashIndex != -1 {
+			// Lowercase everything after the #
+			hrefValue = hrefValue[:hashIndex+1] + strings.ToLower(hrefValue[hashIndex+1:])
+		}
+		result.WriteString(hrefValue)
+		
+		start = endQuote
+	}
+	
+	return result.String()
+}
+
+func isRedirect(content string) (string, bool) {
+	// Look for redirect patterns in the HTML using regex
+	re := regexp.MustCompile(`(?i)<li>\s*redirect\s*<a\s+href="([^"]+)"`)
+	matches := re.FindStringSubmatch(content)
+	if len(matches) < 2 {
+		return "", false
+	}
+	
+	// Extract the target title from the href
+	target := matches[1]
+	return target, true
+}
+
+// renderPanicError reports that rendering an article panicked.
+type renderPanicError struct {
+	Title string
+	Value interface{}
+}
+
+func (e *renderPanicError) Error() string {
+	return fmt.Sprintf("rendering %q panicked: %v", e.Title, e.Value)
+}
+
+// handlePage serves an article of wiki. Titles missing from it are looked
+// up in fallbacks, the extra wikis bare /wiki/ links may lead to.
+func handlePage(w http.ResponseWriter, r *http.Request, tmpl, printTmpl, errorTmpl *template.Template, wiki *wikiSource, fallbacks []*wikiSource) {
+	// Extract the title from the URL path
+	title, err := titleFromPath(r.URL.Path, wiki.Prefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Log the request
+	s := requestServer(r)
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		fmt.Fprintf(s.log, "[%s] %s %s %v\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.Path, duration)
+	}()
+	tr := s.tracer.start(r.Method + " " + r.URL.Path)
+	defer tr.Finish()
+
+	end := tr.Start("lookup")
+	entry := findPageByTitle(wiki.Index, wiki.Titles, title)
+	end()
+	if entry != nil {
+		if location := canonicalRedirect(r, wiki.Prefix, entry.Title); location != "" {
+			fmt.Fprintf(s.log, "[%s] 301 Canonical: %s -> %s\n", time.Now().Format("2006-01-02 15:04:05"), r.URL.Path, location)
+			http.Redirect(w, r, location, http.StatusMovedPermanently)
+			return
+		}
+	}
+	// Articles the wiki lacks are served from the first fallback that has
+	// them, under the same URL and with a banner naming the source.
+	var fallback *fallbackNotice
+	if entry == nil {
+		if target, ok := wiki.Names.resolveAlias(title); ok && findPageByTitle(wiki.Index, wiki.Titles, target) != nil {
+			location := absoluteURL(r, wiki.Prefix+strings.ReplaceAll(target, " ", "_"))
+			fmt.Fprintf(s.log, "[%s] 302 Alias: %s -> %s\n", time.Now().Format("2006-01-02 15:04:05"), r.URL.Path, location)
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+	}
+	if entry == nil {
+		if other, found := fallbackWiki(fallbacks, title); other != nil {
+			fallback = &fallbackNotice{Missing: wiki.Info, Source: other.Info, URL: other.Prefix + strings.ReplaceAll(found, " ", "_")}
+			wiki = other
+			entry = findPageByTitle(other.Index, other.Titles, found)
+		}
+	}
+	if entry == nil {
+		writeErrorPage(w, r, errorTmpl, wiki, title, ErrPageNotFound)
+		return
+	}
+
+	data := ArticleView{
+		Layout:   Layout{Dump: wiki.Info},
+		Title:    entry.Title,
+		Fallback: fallback,
+	}
+
+	renderer, err := s.renderers.forRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Renderer", renderer.Name())
+	highlight := strings.TrimSpace(r.FormValue("highlight"))
+	if len(highlight) > maxHighlightLength {
+		http.Error(w, fmt.Sprintf("highlight must be at most %d bytes", maxHighlightLength), http.StatusBadRequest)
+		return
+	}
+	pageNumber, err := requestPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := renderWithTimeout(wiki.Pipeline, entry, wiki.Cache, renderer, tr, s.cfg.RenderTimeout)
+	if err == nil && page.Redirect != "" {
+		target, fragment, loopErr := followRedirects(wiki.Index, wiki.Titles, wiki.Pipeline, wiki.Cache, renderer, tr, entry, page.Redirect)
+		if loopErr == nil {
+			location := absoluteURL(r, wiki.Prefix+strings.ReplaceAll(target, " ", "_"))
+			if r.FormValue("action") == "print" {
+				location += "?action=print"
+			}
+			// Without a fragment of its own the redirect keeps the one in
+			// the requested URL, since browsers carry it over.
+			if fragment != "" {
+				location += "#" + fragment
+			}
+			fmt.Fprintf(s.log, "[%s] 302 Redirect: %s -> %s\n", time.Now().Format("2006-01-02 15:04:05"), r.URL.Path, location)
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+		err = loopErr
+	}
+	if err != nil {
+		writeErrorPage(w, r, errorTmpl, wiki, entry.Title, err)
+		return
+	}
+	if len(page.Prefetch) > 0 {
+		w.Header().Set("Link", prefetchHeader(s.cfg.BasePath, wiki.Prefix, page.Prefetch))
+	}
+	data.Error = page.Warning
+	data.Lang, data.Dir = articleLanguage(wiki.Info, page.Dir)
+	data.Description = page.Description
+	data.DisplayTitle = page.DisplayTitle
+	data.Quality = page.Quality
+	data.CanonicalURL = absoluteURL(r, canonicalPath(wiki.Prefix, entry.Title))
+	if r.FormValue("action") == "print" {
+		data.Content = template.HTML(printableHTML(r, wiki.Prefix, page.Content))
+		if wiki.Views != nil {
+			wiki.Views.record(entry.Title)
+		}
+		end = tr.Start("template")
+		printTmpl.Execute(w, data)
+		end()
+		return
+	}
+
+	content := page.Content
+	if pageNumber == 0 && s.cfg.PaginateOverKB > 0 && len(content) > s.cfg.PaginateOverKB<<10 {
+		pageNumber = 1
+	}
+	if pages := pageCount(page.Sections, s.cfg.PageSections); pageNumber > 0 && pages > 1 {
+		if pageNumber > pages {
+			http.Error(w, fmt.Sprintf("this article has %d pages", pages), http.StatusNotFound)
+			return
+		}
+		content = articlePage(content, page.Sections, s.cfg.PageSections, pageNumber)
+		data.Pagination = newArticlePagination(r.URL.Query(), pageNumber, pages)
+	}
+	if highlight != "" {
+		var count int
+		content, count = highlightMatches(content, highlight)
+		data.Highlight, data.HighlightCount = highlight, count
+	}
+	data.Content = template.HTML(content)
+	data.Links = page.Links
+	data.LinkPrefix = wiki.Prefix
+	data.Collectable = wiki.Prefix == "/wiki/" && fallback == nil // collections hold articles of the main wiki
+	if added := r.FormValue("added"); added != "" {
+		data.AddedTo, data.AddedToURL = added, s.cfg.BasePath+collectionPath(added)
+	}
+	if wiki.Views != nil {
+		wiki.Views.record(entry.Title)
+	}
+
+	end = tr.Start("template")
+	tmpl.Execute(w, data)
+	end()
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request, searchTmpl *template.Template, primary *wikiSource, extras []*wikiSource, fullText *fullTextSearch, meta *metaStore) {
+	data := SearchView{Layout: Layout{Dump: primary.Info}}
+
+	if query := r.FormValue("q"); query != "" {
+		data.Query = query
+		data.Results = searchIndex(primary.Index, query, meta, primary.Views, primary.Names)
+		data.ResultCount = countResults(data.Results)
+		searchWikis(&data, primary.Titles, extras, query)
+		hits, err := fullText.search(query, fullTextLimit, meta, primary.Views)
+		if err != nil {
+			data.Error = fmt.Sprintf("Error searching article text: %v", err)
+		}
+		data.FullText = hits
+		if len(data.Results) == 0 && len(hits) == 0 {
+			data.Suggestions = primary.Speller.suggest(query)
+		}
+	}
+
+	searchTmpl.Execute(w, data)
+}
+
+func getRandomEntries(entries []IndexEntry, count int) []IndexEntry {
+	if len(entries) <= count {
+		return entries
+	}
+
+	// Pick random indices directly
+	result := make([]IndexEntry, count)
+	seen := make(map[int]bool, count)
+
+	for i := 0; i < count; {
+		idx := rand.Intn(len(entries))
+		if !seen[idx] {
+			result[i] = entries[idx]
+			seen[idx] = true
+			i++
+		}
+	}
+
+	return result
+}
+
+func handleExtract(w http.ResponseWriter, r *http.Request, tmpl *template.Template, panels []homePanel, articleCount int) {
+	s := requestServer(r)
+	data := HomeView{
+		Layout:       Layout{Dump: s.info},
+		Panels:       homePanelViews(panels),
+		IndexFile:    filepath.Base(s.cfg.Index),
+		ArticleCount: articleCount,
+		Description:  fmt.Sprintf("Browse %d articles from %s offline.", articleCount, s.info.Summary()),
+	}
+	tmpl.Execute(w, data)
+}
+
+// extraDumps holds the -extra-dump flags
+var extraDumps extraDumpList
+
+// flags holds the server's command line flags. They live in their own set
+// rather than the process-wide one so embedding the package doesn't add
+// them to the host program's flags.
+var flags = flag.NewFlagSet("wikiseek", flag.ContinueOnError)
+
+func init() {
+	flags.Var(&extraDumps, "extra-dump", "Another dump to serve under /w/{name}/ and search alongside the main one, as DUMP,INDEX (repeatable)")
+}
+
+var (
+	inputFile        = flags.String("file", "", "Path to multistream bzip2 file")
+	indexFile        = flags.String("index", "", "Path to the multistream index file: bzip2 or gzip compressed, or plain text")
+	basePath         = flags.String("base-path", "", "URL path prefix when served behind a reverse proxy (e.g. /wiki-mirror)")
+	trustProxy       = flags.String("trust-proxy", "", "Comma-separated addresses or CIDR ranges of reverse proxies whose X-Forwarded-Proto and X-Forwarded-Host headers are honored")
+	renderCacheSize  = flags.Int("render-cache", 500, "Number of rendered articles to keep in memory")
+	prerender        = flags.String("prerender", "", "Warm the render cache at startup: vital, popular[:N], or a path to a file of titles")
+	prerenderWorkers = flags.Int("prerender-workers", 4, "Number of articles rendered in parallel during warm-up")
+	renderStages     = flags.String("stages", defaultStages, "Comma-separated render pipeline stages; add strip-images for text-only output")
+	postprocessors   = flags.String("postprocessors", "", "Comma-separated chain applied to article HTML: strip-external-links, remove-citations, simplify-tables, strip-images, max-image-width=PX")
+	renderTimeout    = flags.Duration("render-timeout", time.Minute, "Give up waiting for an article to render after this long and show an error, leaving it rendering for the cache (0 waits forever)")
+	shadowRate       = flags.Float64("shadow-render", 0, "Fraction of renders (0-1) also run through the native converter and diffed against pandoc in the log")
+	apiTokenFile     = flags.String("api-tokens", "", "File of \"token scope [requests/minute]\" lines; when set, /api routes require a token")
+	streamAPI        = flags.Bool("stream-api", false, "Serve decompressed dump streams at /api/stream/{start}-{end}")
+	streamCacheDir   = flags.String("stream-cache-dir", "", "Directory to keep decompressed streams in across restarts (disabled when empty)")
+	streamCacheMB    = flags.Int("stream-cache-mb", 1024, "Maximum size of the on-disk stream cache in megabytes")
+	memoryLimit      = flags.String("memory-limit", "", "Memory to stay within, such as 512MB or 8GB; the render caches and an in-memory stream cache are then sized automatically to fit (-render-cache is the starting size)")
+	backgroundMeta   = flags.Bool("background-metadata", false, "Collect page metadata (descriptions, redirects, disambiguation pages, lead images, sizes) in the background when the index cache has none")
+	dataDir          = flags.String("data-dir", "", "Directory for persistent state such as page metadata seen while serving (disabled when empty)")
+	projectName      = flags.String("project", "auto", "Wikimedia project profile: wikipedia, wikiquote, wikivoyage, or auto to detect from the dump")
+	headerOffset     = flags.Int("header-offset", 0, "Levels added to article headings (== is h2); results are clamped to h2-h6")
+	traceRequests    = flags.Bool("trace", false, "Record per-stage timings of article requests, shown at /debug/trace")
+	otlpEndpoint     = flags.String("otlp-endpoint", "", "OTLP/HTTP traces URL (e.g. http://localhost:4318/v1/traces) to export request traces to; implies -trace")
+	embedAncestors   = flags.String("embed-ancestors", "", "Space-separated sources allowed to frame /embed/ pages (e.g. \"https://intranet.example.com\"); any site when empty")
+	profileTemplates = flags.Bool("template-profile", false, "Time every template expanded by the native converter, shown at /debug/template-profile")
+	fallbackWikis    = flags.String("fallback", "", "Comma-separated -extra-dump names to serve articles from, in order, when the main dump lacks them (default: all, in the order given; none to disable)")
+	templateDirPath  = flags.String("template-dir", "", "Directory of NAME.wiki template definitions that override the built-in template handlers")
+	watchTemplates   = flags.Bool("watch-templates", false, "Reload -template-dir definitions when they change and drop the cached pages that used them")
+	uiLang           = flags.String("ui-lang", "en", "Language for dates and numbers written by template handlers: en, de, fr, es, it, nl or pt")
+	citationStyle    = flags.String("citations", citationPopup, "How the native converter renders <ref> citations: popup, footnote or hidden")
+	rendererName     = flags.String("renderer", "auto", "Default renderer: pandoc, native, http, or auto to use pandoc when installed")
+	rendererURL      = flags.String("renderer-url", "", "URL of an external wikitext-to-HTML service (e.g. a Parsoid transform endpoint) enabling the http renderer")
+	homePanelNames   = flags.String("home-panels", "random", "Comma-separated panels shown on the home page, in order: random, featured, popular, recent, category")
+	homeCategory     = flags.String("home-category", "", "Category whose articles the category home panel spotlights")
+	prefetchLinks    = flags.Int("prefetch-links", 3, "Number of linked articles sent as Link: rel=prefetch headers with each page (0 disables)")
+	offlineSearch    = flags.Bool("offline-search", false, "Publish title lists and a service worker so title search keeps working in the browser while the server is unreachable")
+	progressiveApp   = flags.Bool("pwa", false, "Make the site an installable web app whose service worker keeps the articles read recently readable while the server is unreachable")
+	useDumpTemplates = flags.Bool("dump-templates", false, "Expand templates without a built-in handler from their Template: pages in the dump, caching them compiled next to the index")
+	aliasFile        = flags.String("aliases", "", "File of \"alias = Title\" lines giving articles extra names for URLs and search; reloaded when edited")
+	pageSections     = flags.Int("page-sections", 10, "Top-level sections on each page of an article split with ?page=")
+	paginateOverKB   = flags.Int("paginate-over", 1024, "Rendered size in KB above which articles are split into pages without ?page= (0 to split only on request)")
+	mediaSource      = flags.String("media", "commons", "Where article images load from: commons, a URL or path that file names are appended to (such as a local mirror), or none to show their captions instead")
+	popularityWeight = flags.Float64("popularity-weight", 1, "How strongly article view counts raise search results among equal matches (0 ignores them)")
+	translitSearch   = flags.Bool("transliterate", false, "Also match title searches across scripts, so moskva finds Москва (Cyrillic, Greek, kana, Hangul and Chinese pinyin)")
+)
+
+// configFromFlags returns the Config the command line flags describe.
+func configFromFlags() (Config, error) {
+	var memLimit int64
+	if *memoryLimit != "" {
+		limit, err := parseByteSize(*memoryLimit)
+		if err != nil {
+			return Config{}, fmt.Errorf("-memory-limit: %v", err)
+		}
+		memLimit = limit
+	}
+	return Config{
+		File:             *inputFile,
+		Index:            *indexFile,
+		BasePath:         *basePath,
+		ExtraDumps:       extraDumps,
+		Fallback:         *fallbackWikis,
+		TrustProxy:       *trustProxy,
+		RenderCache:      *renderCacheSize,
+		Prerender:        *prerender,
+		PrerenderWorkers: *prerenderWorkers,
+		Stages:           *renderStages,
+		Postprocessors:   *postprocessors,
+		RenderTimeout:    *renderTimeout,
+		ShadowRender:     *shadowRate,
+		APITokens:        *apiTokenFile,
+		StreamAPI:        *streamAPI,
+		StreamCacheDir:   *streamCacheDir,
+		StreamCacheMB:    *streamCacheMB,
+		MemoryLimit:      memLimit,
+		BackgroundMeta:   *backgroundMeta,
+		DataDir:          *dataDir,
+		Project:          *projectName,
+		HeaderOffset:     *headerOffset,
+		Trace:            *traceRequests,
+		OTLPEndpoint:     *otlpEndpoint,
+		EmbedAncestors:   *embedAncestors,
+		TemplateProfile:  *profileTemplates,
+		TemplateDir:      *templateDirPath,
+		WatchTemplates:   *watchTemplates,
+		UILang:           *uiLang,
+		Citations:        *citationStyle,
+		Renderer:         *rendererName,
+		RendererURL:      *rendererURL,
+		HomePanels:       *homePanelNames,
+		HomeCategory:     *homeCategory,
+		PrefetchLinks:    *prefetchLinks,
+		OfflineSearch:    *offlineSearch,
+		PWA:              *progressiveApp,
+		DumpTemplates:    *useDumpTemplates,
+		Aliases:          *aliasFile,
+		PageSections:     *pageSections,
+		PaginateOverKB:   *paginateOverKB,
+		Media:            *mediaSource,
+		PopularityWeight: *popularityWeight,
+		Transliterate:    *translitSearch,
+	}, nil
+}
+
+// normalizeBasePath ensures a base path has a leading slash and no trailing
+// slash, so it can be prepended to absolute route paths.
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// absoluteURL builds a full URL for a route path, honoring the base path and
+// the X-Forwarded-Proto and X-Forwarded-Host headers of trusted proxies.
+func absoluteURL(r *http.Request, path string) string {
+	return requestScheme(r) + "://" + requestHost(r) + requestServer(r).cfg.BasePath + path
+}
+
+// Server is one loaded dump, with any extra dumps, and the handler serving
+// them. NewServer builds it from a Config; the settings and caches it
+// serves from live here, so a process can run several.
+type Server struct {
+	cfg     Config
+	log     io.Writer
+	proxies []netip.Prefix
+	handler http.Handler
+
+	info      *DumpInfo
+	project   *projectProfile
+	renderers *rendererSet
+	streams   *streamCaches
+	tracer    *tracer
+
+	// The template handlers wikis are rendered with are built from these
+	locale          *locale
+	templateBase    *Registry
+	templateFiles   *templateDir
+	dumpTemplates   *templateStore
+	templateProfile *templateProfiler
+
+	warming atomic.Bool // a warm-up run is in progress
+}
+
+// serverContextKey is the request context key of the Server a request
+// reached, as http.ServerContextKey is of the http.Server.
+type serverContextKey struct{}
+
+// requestServer returns the Server a request reached, which handlers read
+// the base path, trusted proxies, log and other settings from. Outside a
+// Server it returns one with default settings that logs nothing.
+func requestServer(r *http.Request) *Server {
+	if s, ok := r.Context().Value(serverContextKey{}).(*Server); ok {
+		return s
+	}
+	return &Server{cfg: DefaultConfig(), log: io.Discard}
+}
+
+// projectTemplates returns the template handlers a wiki of project p is
+// rendered with: the base handlers plus the profile's, formatting for
+// -ui-lang and falling back to -template-dir and -dump-templates.
+func (s *Server) projectTemplates(p *projectProfile) *Registry {
+	t := p.templates(s.templateBase).withLocale(s.locale)
+	t.files, t.dump, t.profile = s.templateFiles, s.dumpTemplates, s.templateProfile
+	return t
+}
+
+// newServer loads the dumps a Config names and builds the handler serving
+// them.
+func newServer(cfg Config) (*Server, error) {
+	cfg.BasePath = normalizeBasePath(cfg.BasePath)
+	s := &Server{cfg: cfg, log: cfg.Log, templateBase: defaultTemplates}
+	log := s.log
+	if cfg.Templates != nil {
+		s.templateBase = cfg.Templates
+	}
+	var err error
+	if s.proxies, err = parseTrustedProxies(cfg.TrustProxy); err != nil {
+		return nil, err
+	}
+	if err := checkMediaSource(cfg.Media); err != nil {
+		return nil, err
+	}
+	if s.locale, err = lookupLocale(cfg.UILang); err != nil {
+		return nil, err
+	}
+	if !validCitationStyle(cfg.Citations) {
+		return nil, fmt.Errorf("unknown citation style %q (want popup, footnote or hidden)", cfg.Citations)
+	}
+	outputPostprocessors, err := parsePostprocessors(cfg.Postprocessors)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded, err := loadIndex(cfg.Index, loadNamespaces(cfg.File, log), log)
+	if err != nil {
+		return nil, fmt.Errorf("loading index: %v", err)
+	}
+	index := loaded.Entries
+
+	var names *titleNames
+	if cfg.Transliterate {
+		names = transliteratedNames(index, log)
+	}
+
+	s.streams = &streamCaches{}
+	if cfg.StreamCacheDir != "" {
+		if s.streams.disk, err = newStreamCache(cfg.StreamCacheDir, cfg.File, int64(cfg.StreamCacheMB)<<20, log); err != nil {
+			return nil, fmt.Errorf("opening stream cache: %v", err)
+		}
+		fmt.Fprintf(log, "Caching decompressed streams in %s (up to %d MB)\n", cfg.StreamCacheDir, cfg.StreamCacheMB)
+	}
+	if cfg.MemoryLimit > 0 {
+		s.streams.memory = newMemoryStreamCache(cfg.File, cfg.MemoryLimit/16)
+	}
+
+	var fullText *fullTextSearch
+	if ft, err := openFullTextIndex(fullTextDir(cfg.Index)); err == nil {
+		fullText = newFullTextSearch(ft, cfg.File, index, s.streams)
+		fmt.Fprintln(log, "Loaded full-text index")
+	}
+
+	if cfg.Trace || cfg.OTLPEndpoint != "" {
+		s.tracer = newTracer(cfg.OTLPEndpoint, log)
+		fmt.Fprintln(log, "Request tracing enabled at /debug/trace")
+	}
+	if cfg.TemplateProfile {
+		s.templateProfile = newTemplateProfiler()
+		fmt.Fprintln(log, "Template profiling enabled at /debug/template-profile")
+	}
+
+	s.info = loadDumpInfo(cfg.File, log)
+	fmt.Fprintf(log, "Serving %s\n", s.info.Summary())
+	if s.project, err = selectProject(cfg.Project, s.info); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(log, "Using %s project profile\n", s.project.Name)
+
+	var data *store.Store
+	if cfg.DataDir != "" {
+		if data, err = store.Open(cfg.DataDir); err != nil {
+			return nil, fmt.Errorf("opening data directory: %v", err)
+		}
+		fmt.Fprintf(log, "Persisting state in %s\n", cfg.DataDir)
+	}
+
+	meta := loadMetaStore(loaded, data.Bucket("pagemeta"))
+	collections := newCollectionStore(data.Bucket("collections"))
+	if n := meta.Len(); n > 0 {
+		fmt.Fprintf(log, "Loaded metadata for %d pages\n", n)
+	}
+
+	if cfg.TemplateDir != "" {
+		if s.templateFiles, err = loadTemplateDir(cfg.TemplateDir, log); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(log, "Loaded %d templates from %s\n", s.templateFiles.Len(), cfg.TemplateDir)
+	}
+	if cfg.DumpTemplates {
+		if loaded.Templates == nil {
+			fmt.Fprintf(log, "Warning: the index cache predates template pages; delete %s and %s to rebuild it\n",
+				indexCacheFile(cfg.Index), indexBinFile(cfg.Index))
+		}
+		if s.dumpTemplates, err = newTemplateStore(cfg.File, cfg.Index, loaded.Templates, s.streams, log); err != nil {
+			return nil, fmt.Errorf("loading dump templates: %v", err)
+		}
+		fmt.Fprintf(log, "Expanding %d templates from the dump (%d compiled in %s)\n",
+			s.dumpTemplates.Len(), s.dumpTemplates.Cached(), templateSidecarFile(cfg.Index))
+	}
+	templates := s.projectTemplates(s.project)
+
+	var description string
+	options := convertOptions{templates: templates, headerOffset: cfg.HeaderOffset, citations: cfg.Citations}
+	if s.renderers, description, err = setupRenderers(cfg.Renderer, cfg.RendererURL, options, log); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(log, "Using renderer: %s\n", description)
+
+	var offline *offlineTitles
+	if cfg.OfflineSearch {
+		if offline, err = newOfflineTitles(index, cfg.Index); err != nil {
+			return nil, err
+		}
+	}
+
+	funcMap := template.FuncMap{
+		"urlize": func(s string) string {
+			return strings.ReplaceAll(s, " ", "_")
+		},
+		"base": func() string {
+			return cfg.BasePath
+		},
+		"project": func() string {
+			return s.project.Name
+		},
+		"offline": func() bool {
+			return offline != nil
+		},
+		"pwa": func() bool {
+			return cfg.PWA
+		},
+		"quality": func(q string) string {
+			return qualityLabels[q]
+		},
+		"collections": collections.Names,
+		"wikipath":    wikiPrefix,
+		"articlepath": func(prefix, title string) template.URL {
+			return articlePath(cfg.BasePath, prefix, title)
+		},
+		"result": newSearchResultView,
+		"pagemeta": func(pageID int) *PageMeta {
+			if m, ok := meta.Get(pageID); ok {
+				return &m
+			}
+			return nil
+		},
+	}
+	homeTmpl, err := parsePageTemplate(cfg.AssetDir, "home.html", funcMap)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %v", err)
+	}
+
+	articleTmpl, err := parsePageTemplate(cfg.AssetDir, "article.html", funcMap)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %v", err)
+	}
+
+	searchTmpl, err := parsePageTemplate(cfg.AssetDir, "search.html", funcMap)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %v", err)
+	}
+
+	errorTmpl, err := parsePageTemplate(cfg.AssetDir, "error.html", funcMap)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %v", err)
+	}
+
+	printTmpl, err := template.New("print.html").Funcs(funcMap).ParseFiles(filepath.Join(cfg.AssetDir, "templates", "print.html"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %v", err)
+	}
+
+	adminTmpl, err := template.New("admin.html").Funcs(funcMap).ParseFiles(filepath.Join(cfg.AssetDir, "templates", "admin.html"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %v", err)
+	}
+
+	var tokens apiTokens
+	if cfg.APITokens != "" {
+		if tokens, err = loadAPITokens(cfg.APITokens); err != nil {
+			return nil, fmt.Errorf("loading API tokens: %v", err)
+		}
+		fmt.Fprintf(log, "API access restricted to %d tokens\n", len(tokens))
+	}
+
+	if cfg.Aliases != "" {
+		if names == nil {
+			names = &titleNames{}
+		}
+		if names.aliases, err = loadAliases(cfg.Aliases, log); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(log, "Loaded %d title aliases from %s\n", names.aliases.Len(), cfg.Aliases)
+	}
+
+	titles := loaded.titleSet()
+	fmt.Fprintf(log, "Title dictionary holds %d titles in %.1f MB\n", titles.Len(), float64(titles.Size())/(1<<20))
+	go titles.buildFolded()
+	settings := renderSettings{
+		templates:      templates,
+		renderers:      s.renderers,
+		streams:        s.streams,
+		postprocessors: outputPostprocessors,
+		basePath:       cfg.BasePath,
+		prefetchLinks:  cfg.PrefetchLinks,
+		media:          cfg.Media,
+		shadowRate:     cfg.ShadowRender,
+		log:            log,
+	}
+	pipeline, err := newPipeline(cfg.Stages, cfg.File, titles, meta, settings)
+	if err != nil {
+		return nil, err
+	}
+	if len(outputPostprocessors) > 0 && !slices.Contains(pipeline.Names(), "postprocessors") {
+		fmt.Fprintln(log, "Warning: -postprocessors has no effect without the postprocessors stage in -stages")
+	}
+	cache := newRenderCache(cfg.RenderCache)
+	primary := &wikiSource{Name: wikiName(s.info), Prefix: "/wiki/", Info: s.info, InputFile: cfg.File,
+		Index: index, Titles: titles, Pipeline: pipeline, Cache: cache, Views: newViewTracker(data.Bucket("views"), cfg.PopularityWeight),
+		Speller: newSpellModel(index, log), Names: names}
+	var extras []*wikiSource
+	taken := map[string]bool{primary.Name: true}
+	for _, spec := range cfg.ExtraDumps {
+		extra, err := s.loadExtraWiki(spec, taken, settings)
+		if err != nil {
+			return nil, fmt.Errorf("loading extra dump: %v", err)
+		}
+		extras = append(extras, extra)
+		fmt.Fprintf(log, "Serving %s under /w/%s/ (%d entries)\n", extra.Info.Summary(), extra.Name, len(extra.Index))
+	}
+	var tuner *cacheTuner
+	if cfg.MemoryLimit > 0 {
+		renders := []*renderCache{cache}
+		for _, extra := range extras {
+			renders = append(renders, extra.Cache)
+		}
+		tuner = startCacheTuner(cfg.MemoryLimit, renders, s.streams.memory, log)
+		fmt.Fprintf(log, "Tuning cache sizes to stay within %s of memory\n", formatBytes(cfg.MemoryLimit))
+	}
+	if s.templateFiles != nil && cfg.WatchTemplates {
+		go s.templateFiles.watch(cache)
+		fmt.Fprintf(log, "Watching %s for template changes\n", cfg.TemplateDir)
+	}
+	progress := newProgressHub()
+	if cfg.BackgroundMeta && !hasTextMetadata(loaded.allMeta()) {
+		go backgroundMetadata(cfg.File, cfg.Index, loaded, meta, s.streams, progress, log)
+	}
+	if cfg.Prerender != "" {
+		s.warming.Store(true)
+		go func() {
+			defer s.warming.Store(false)
+			prerenderPages(cfg.Prerender, index, titles, pipeline, cache, primary.Views, cfg.PrerenderWorkers, progress, log)
+		}()
+	}
+	homePanels, err := newHomePanels(cfg.HomePanels, homePanelDeps{
+		index:     index,
+		titles:    titles,
+		inputFile: cfg.File,
+		streams:   s.streams,
+		meta:      meta,
+		views:     primary.Views,
+		category:  cfg.HomeCategory,
+		data:      data,
+		hub:       progress,
+		log:       log,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := newRequestStats()
+	mux := http.NewServeMux()
+
+	// Serve static files, along with the generated offline title lists
+	staticFiles, err := staticHandler(filepath.Join(cfg.AssetDir, "static"))
+	if err != nil {
+		return nil, err
+	}
+	mux.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {
+		if offline != nil && offline.serve(w, r, strings.TrimPrefix(r.URL.Path, "/static/")) {
+			return
+		}
+		staticFiles.ServeHTTP(w, r)
+	})
+	if offline != nil || cfg.PWA {
+		script, err := newServiceWorker(cfg.AssetDir, cfg.BasePath, cfg.PWA, offline != nil)
+		if err != nil {
+			return nil, err
+		}
+		mux.HandleFunc("/sw.js", func(w http.ResponseWriter, r *http.Request) {
+			handleServiceWorker(w, r, script)
+		})
+	}
+	if cfg.PWA {
+		mux.HandleFunc("/manifest.json", func(w http.ResponseWriter, r *http.Request) {
+			handleWebManifest(w, r, s.info)
+		})
+	}
+
+	// Serve robots.txt to prevent scraping
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("User-agent: *\nDisallow: /\n"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "ok\nentries: %d\nrenderer: %s\nstages: %s\n", len(index), description, strings.Join(pipeline.Names(), ","))
+	})
+
+	dumpStats := newStatsCache(index, loaded.Namespaces, titles, cfg.File, meta)
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		handleStats(w, r, articleTmpl, dumpStats)
+	})
+
+	mux.HandleFunc("/about", func(w http.ResponseWriter, r *http.Request) {
+		handleAbout(w, r, articleTmpl, len(index))
+	})
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		handleSearch(w, r, searchTmpl, primary, extras, fullText, meta)
+	})
+
+	// Responses under /api carry ETags tied to this dump
+	var etags apiETags
+	if key, err := fileFingerprint(cfg.File); err != nil {
+		fmt.Fprintf(log, "Warning: API responses get no ETags: %v\n", err)
+	} else {
+		etags.dumpKey = key
+	}
+
+	quickOpen := newQuickOpenIndex(index, cfg.BasePath)
+	// Quick open backs the palette on every page, so it stays open like the UI
+	mux.HandleFunc("/api/quickopen", etags.stable(func(w http.ResponseWriter, r *http.Request) {
+		handleAPIQuickOpen(w, r, index, quickOpen)
+	}))
+
+	mux.HandleFunc("/admin", tokens.admin(func(w http.ResponseWriter, r *http.Request) {
+		adminTmpl.Execute(w, struct{ Token string }{r.URL.Query().Get("token")})
+	}))
+	mux.HandleFunc("/admin/status", tokens.admin(func(w http.ResponseWriter, r *http.Request) {
+		handleAdminStatus(w, r, stats, cache, tuner, len(index), description)
+	}))
+	mux.HandleFunc("/admin/events", tokens.admin(func(w http.ResponseWriter, r *http.Request) {
+		handleProgressEvents(w, r, progress)
+	}))
+	mux.HandleFunc("/admin/warmup", tokens.admin(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// A page elsewhere could post here, and the server's files are
+		// no business of a client: only the named lists can be run
+		if tokens == nil && !sameOrigin(r) {
+			http.Error(w, "warm-up runs can only be started from this site's pages", http.StatusForbidden)
+			return
+		}
+		mode := r.FormValue("mode")
+		if !remoteWarmupMode(mode) {
+			http.Error(w, "mode must be vital, popular or popular:N", http.StatusBadRequest)
+			return
+		}
+		if !s.warming.CompareAndSwap(false, true) {
+			http.Error(w, "a warm-up run is already in progress", http.StatusConflict)
+			return
+		}
+		go func() {
+			defer s.warming.Store(false)
+			prerenderPages(mode, index, titles, pipeline, cache, primary.Views, cfg.PrerenderWorkers, progress, log)
+		}()
+		target := "/admin"
+		if token := r.URL.Query().Get("token"); token != "" {
+			target += "?token=" + url.QueryEscape(token)
+		}
+		http.Redirect(w, r, absoluteURL(r, target), http.StatusSeeOther)
+	}))
+	mux.HandleFunc("/api/summaries", etags.content(func(w http.ResponseWriter, r *http.Request) {
+		handleAPISummaries(w, r, meta)
+	}))
+	jobs := newJobQueue(index, titles, cfg.File, s.streams, progress, log)
+	// Job results are polled and change on every poll, so they get no
+	// ETag
+	mux.HandleFunc("/api/jobs/grep", tokens.admin(func(w http.ResponseWriter, r *http.Request) {
+		handleAPIGrepSubmit(w, r, jobs)
+	}))
+	mux.HandleFunc("/api/jobs/", tokens.admin(func(w http.ResponseWriter, r *http.Request) {
+		handleAPIJob(w, r, jobs)
+	}))
+	graph := newLinkGraph(index, titles, cfg.File, s.streams)
+	mux.HandleFunc("/api/page/", tokens.require(scopeSearch, etags.stable(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/match") {
+			handleAPIMatch(w, r, index, titles, cfg.File, s.streams)
+			return
+		}
+		handleAPIChunks(w, r, index, titles, cfg.File, s.streams)
+	})))
+	mux.HandleFunc("/api/normalize", tokens.require(scopeSearch, handleAPINormalize))
+	mux.HandleFunc("/api/graph/", tokens.require(scopeSearch, etags.stable(func(w http.ResponseWriter, r *http.Request) {
+		handleAPIGraph(w, r, graph)
+	})))
+	// Links come from rendered HTML, which -template-dir can change
+	mux.HandleFunc("/api/links/", tokens.require(scopeSearch, etags.content(func(w http.ResponseWriter, r *http.Request) {
+		handleAPILinks(w, r, index, titles, pipeline, cache)
+	})))
+	mux.HandleFunc("/api/stats/", tokens.require(scopeSearch, etags.stable(func(w http.ResponseWriter, r *http.Request) {
+		handleAPITextStats(w, r, index, titles, cfg.File, s.streams)
+	})))
+	streams := newStreamList(index, cfg.File)
+	mux.HandleFunc("/debug/streams", tokens.admin(func(w http.ResponseWriter, r *http.Request) {
+		handleDebugStreams(w, r, streams)
+	}))
+	mux.HandleFunc("/debug/stream/", tokens.admin(func(w http.ResponseWriter, r *http.Request) {
+		handleDebugStream(w, r, streams)
+	}))
+	if s.tracer != nil {
+		mux.HandleFunc("/debug/trace", tokens.admin(func(w http.ResponseWriter, r *http.Request) {
+			handleDebugTrace(w, r, s.tracer)
+		}))
+	}
+	if s.templateProfile != nil {
+		mux.HandleFunc("/debug/template-profile", tokens.admin(func(w http.ResponseWriter, r *http.Request) {
+			handleTemplateProfile(w, r, s.templateProfile)
+		}))
+	}
+	mux.HandleFunc("/api/search", tokens.require(scopeSearch, etags.content(func(w http.ResponseWriter, r *http.Request) {
+		handleAPISearch(w, r, index, primary.Views, primary.Speller, primary.Names, fullText, meta)
+	})))
+
+	if cfg.StreamAPI {
+		streams := streamSet(index)
+		mux.HandleFunc("/api/stream/", tokens.admin(etags.stable(func(w http.ResponseWriter, r *http.Request) {
+			handleAPIStream(w, r, cfg.File, s.streams, streams)
+		})))
+	}
+
+	fallbacks, err := fallbackOrder(cfg.Fallback, extras)
+	if err != nil {
+		return nil, err
+	}
+	mux.HandleFunc("/wiki/", func(w http.ResponseWriter, r *http.Request) {
+		handlePage(w, r, articleTmpl, printTmpl, errorTmpl, primary, fallbacks)
+	})
+	editCollections := tokens.require(scopeFull, func(w http.ResponseWriter, r *http.Request) {
+		handleCollectionEdit(w, r, collections, index, titles)
+	})
+	mux.HandleFunc("/collections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			editCollections(w, r)
+			return
+		}
+		handleCollectionList(w, r, articleTmpl, collections)
+	})
+	mux.HandleFunc("/collections/", func(w http.ResponseWriter, r *http.Request) {
+		handleCollection(w, r, articleTmpl, collections, primary)
+	})
+	if len(extras) > 0 {
+		mux.HandleFunc("/w/", func(w http.ResponseWriter, r *http.Request) {
+			handleWiki(w, r, articleTmpl, printTmpl, errorTmpl, extras)
+		})
+	}
+	mux.HandleFunc("/embed/", func(w http.ResponseWriter, r *http.Request) {
+		handleEmbed(w, r, index, titles, pipeline, cache)
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		handleExtract(w, r, homeTmpl, homePanels, len(index))
+	})
+
+	// Mount everything under the base path when running behind a proxy
+	handler := stats.wrap(mux)
+	if cfg.BasePath != "" {
+		root := http.NewServeMux()
+		root.Handle(cfg.BasePath+"/", http.StripPrefix(cfg.BasePath, handler))
+		root.Handle(cfg.BasePath, http.RedirectHandler(cfg.BasePath+"/", http.StatusMovedPermanently))
+		handler = root
+	}
+	s.handler = handler
+	return s, nil
+}
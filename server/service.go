@@ -0,0 +1,244 @@
+package server
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// serviceUnit is the systemd unit written by install-service on Linux.
+var serviceUnit = template.Must(template.New("unit").Funcs(template.FuncMap{"quote": systemdQuote}).Parse(`[Unit]
+Description=WikiSeek offline Wikipedia server
+After=network.target
+
+[Service]
+ExecStart={{range $i, $arg := .Command}}{{if $i}} {{end}}{{quote $arg}}{{end}}
+WorkingDirectory={{.Dir}}
+Restart=on-failure
+
+[Install]
+WantedBy={{if .System}}multi-user.target{{else}}default.target{{end}}
+`))
+
+// launchdPlist is the launchd property list written by install-service on
+// macOS.
+var launchdPlist = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Command}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{xml .Dir}}</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>{{xml .Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .Log}}</string>
+</dict>
+</plist>
+`))
+
+// serviceSpec describes the service install-service writes.
+type serviceSpec struct {
+	Name    string
+	Label   string   // launchd label
+	Command []string // executable followed by the server flags
+	Dir     string   // working directory, for relative paths and assets
+	Log     string   // launchd output file
+	System  bool     // system-wide rather than for the current user
+}
+
+// runInstallService handles "wikiseek install-service [options] -- server
+// flags", writing a systemd unit or launchd plist that runs the server with
+// the given flags and enabling it.
+func runInstallService(args []string) {
+	fs := newCommandFlags("install-service")
+	name := fs.String("name", "wikiseek", "Service name")
+	system := fs.Bool("system", os.Geteuid() == 0, "Install a system-wide service rather than one for the current user (default when run as root)")
+	printOnly := fs.Bool("print", false, "Print the service file instead of installing it")
+	noStart := fs.Bool("no-start", false, "Install the service file without enabling and starting it")
+	fs.Parse(args)
+
+	spec, err := newServiceSpec(*name, *system, fs.Args())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *printOnly {
+		if err := writeServiceFile(os.Stdout, spec); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := installService(spec, !*noStart); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newServiceSpec checks the server flags and resolves the executable and
+// working directory the service runs with.
+func newServiceSpec(name string, system bool, serverArgs []string) (serviceSpec, error) {
+	spec := serviceSpec{Name: name, Label: "com.github.xanderstrike." + name, System: system}
+	if name == "" || strings.ContainsAny(name, "/\\ ") {
+		return spec, fmt.Errorf("invalid service name %q", name)
+	}
+
+	check := flag.NewFlagSet("wikiseek", flag.ContinueOnError)
+	check.SetOutput(io.Discard)
+	flags.VisitAll(func(f *flag.Flag) { check.Var(f.Value, f.Name, f.Usage) })
+	check.String("port", "8080", "")
+	daemon := check.Bool("daemon", false, "")
+	check.String("pidfile", "", "")
+	check.String("daemon-log", "", "")
+	if err := check.Parse(serverArgs); err != nil {
+		return spec, fmt.Errorf("server flags: %v", err)
+	}
+	if check.NArg() > 0 {
+		return spec, fmt.Errorf("unexpected arguments %q (server flags go after --)", check.Args())
+	}
+	if *inputFile == "" || *indexFile == "" {
+		return spec, errors.New("the server flags must include -file and -index")
+	}
+	if *daemon {
+		return spec, errors.New("services run in the foreground; drop -daemon")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return spec, fmt.Errorf("finding executable: %v", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return spec, fmt.Errorf("finding executable: %v", err)
+	}
+	if strings.Contains(exe, "go-build") {
+		return spec, errors.New("running from go run; build wikiseek with go build and install the service from that binary")
+	}
+	if spec.Dir, err = os.Getwd(); err != nil {
+		return spec, fmt.Errorf("finding working directory: %v", err)
+	}
+	spec.Command = append([]string{exe}, serverArgs...)
+
+	if system {
+		spec.Log = filepath.Join("/Library/Logs", name+".log")
+	} else if home, err := os.UserHomeDir(); err == nil {
+		spec.Log = filepath.Join(home, "Library", "Logs", name+".log")
+	}
+	return spec, nil
+}
+
+// writeServiceFile writes the service file for this platform.
+func writeServiceFile(w io.Writer, spec serviceSpec) error {
+	switch runtime.GOOS {
+	case "linux":
+		return serviceUnit.Execute(w, spec)
+	case "darwin":
+		return launchdPlist.Execute(w, spec)
+	}
+	return fmt.Errorf("install-service supports Linux (systemd) and macOS (launchd), not %s", runtime.GOOS)
+}
+
+// servicePath is where the service file for this platform is installed.
+func servicePath(spec serviceSpec) (string, error) {
+	home, err := os.UserHomeDir()
+	switch {
+	case runtime.GOOS == "linux" && spec.System:
+		return filepath.Join("/etc/systemd/system", spec.Name+".service"), nil
+	case runtime.GOOS == "darwin" && spec.System:
+		return filepath.Join("/Library/LaunchDaemons", spec.Label+".plist"), nil
+	case err != nil:
+		return "", fmt.Errorf("finding home directory: %v", err)
+	case runtime.GOOS == "linux":
+		return filepath.Join(home, ".config", "systemd", "user", spec.Name+".service"), nil
+	case runtime.GOOS == "darwin":
+		return filepath.Join(home, "Library", "LaunchAgents", spec.Label+".plist"), nil
+	}
+	return "", fmt.Errorf("install-service supports Linux (systemd) and macOS (launchd), not %s", runtime.GOOS)
+}
+
+// installService writes the service file and, if start is set, enables and
+// starts the service.
+func installService(spec serviceSpec, start bool) error {
+	path, err := servicePath(spec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeServiceFile(f, spec); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", path)
+	if !start {
+		return nil
+	}
+
+	var commands [][]string
+	switch runtime.GOOS {
+	case "linux":
+		scope := "--system"
+		if !spec.System {
+			scope = "--user"
+		}
+		commands = [][]string{
+			{"systemctl", scope, "daemon-reload"},
+			{"systemctl", scope, "enable", "--now", spec.Name},
+		}
+	case "darwin":
+		commands = [][]string{{"launchctl", "load", "-w", path}}
+	}
+	for _, command := range commands {
+		fmt.Printf("Running %s\n", strings.Join(command, " "))
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %v", command[0], err)
+		}
+	}
+	fmt.Printf("Service %s installed and started\n", spec.Name)
+	return nil
+}
+
+// systemdQuote quotes an ExecStart argument when it needs it, escaping the
+// specifiers and variables systemd would otherwise expand.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
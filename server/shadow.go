@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// shadowTokenLimit bounds the diff to keep the comparison cheap on huge
+// articles.
+const shadowTokenLimit = 2000
+
+var (
+	shadowAttrRe  = regexp.MustCompile(`<(/?[a-zA-Z0-9]+)[^>]*>`)
+	shadowTokenRe = regexp.MustCompile(`<[^>]+>|[^\s<]+`)
+)
+
+// shouldShadow samples pandoc renders for shadow rendering.
+func shouldShadow(r Renderer, rate float64) bool {
+	return r.Name() == "pandoc" && rate > 0 && rand.Float64() < rate
+}
+
+// normalizeForDiff reduces rendered HTML to a token list that ignores
+// attributes, whitespace and tag case, so only structural and textual
+// differences are reported.
+func normalizeForDiff(html string) []string {
+	html = shadowAttrRe.ReplaceAllStringFunc(html, func(tag string) string {
+		return "<" + strings.ToLower(shadowAttrRe.FindStringSubmatch(tag)[1]) + ">"
+	})
+	tokens := shadowTokenRe.FindAllString(html, -1)
+	if len(tokens) > shadowTokenLimit {
+		tokens = tokens[:shadowTokenLimit]
+	}
+	return tokens
+}
+
+// diffTokens returns the similarity of two token lists (the share of tokens
+// in their longest common subsequence) and the index of the first token
+// that differs, or -1 when they are identical.
+func diffTokens(a, b []string) (float64, int) {
+	first := -1
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i >= len(a) || i >= len(b) || a[i] != b[i] {
+			first = i
+			break
+		}
+	}
+	if first == -1 {
+		return 1, -1
+	}
+
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case a[i-1] == b[j-1]:
+				cur[j] = prev[j-1] + 1
+			case prev[j] > cur[j-1]:
+				cur[j] = prev[j]
+			default:
+				cur[j] = cur[j-1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return 2 * float64(prev[len(b)]) / float64(len(a)+len(b)), first
+}
+
+// shadowCompare renders text with the native renderer and logs to log how
+// far it diverges from the pandoc output that was served.
+func shadowCompare(renderer Renderer, log io.Writer, title, text, pandocHTML string) {
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Fprintf(log, "[%s] Shadow render %q: native converter panicked: %v\n", time.Now().Format("2006-01-02 15:04:05"), title, p)
+		}
+	}()
+
+	start := time.Now()
+	output, err := renderer.Render(text, title)
+	if err != nil {
+		fmt.Fprintf(log, "[%s] Shadow render %q: native converter failed: %v\n", time.Now().Format("2006-01-02 15:04:05"), title, err)
+		return
+	}
+	native := normalizeForDiff(output)
+	elapsed := time.Since(start)
+	pandoc := normalizeForDiff(pandocHTML)
+
+	similarity, first := diffTokens(pandoc, native)
+	if first == -1 {
+		fmt.Fprintf(log, "[%s] Shadow render %q: identical (native %v)\n", time.Now().Format("2006-01-02 15:04:05"), title, elapsed)
+		return
+	}
+	fmt.Fprintf(log, "[%s] Shadow render %q: %.1f%% similar (native %v), first difference at token %d: pandoc %q native %q\n",
+		time.Now().Format("2006-01-02 15:04:05"), title, similarity*100, elapsed, first,
+		diffContext(pandoc, first), diffContext(native, first))
+}
+
+func diffContext(tokens []string, at int) string {
+	end := at + 8
+	if end > len(tokens) {
+		end = len(tokens)
+	}
+	if at >= end {
+		return ""
+	}
+	return strings.Join(tokens[at:end], " ")
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+var spaceRe = regexp.MustCompile(`\s+`)
+
+// snippetWords is the number of words shown on either side of the first hit.
+const snippetWords = 15
+
+type wordSpan struct {
+	start, end int
+}
+
+// wordSpans returns the byte ranges of the words in text, using the same
+// word boundaries as tokenize, except that each CJK character is a span
+// of its own.
+func wordSpans(text string) []wordSpan {
+	var spans []wordSpan
+	start, inCJK := -1, false
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if start != -1 && (!isWord || inCJK || isCJK(r)) {
+			spans = append(spans, wordSpan{start, i})
+			start = -1
+		}
+		if isWord && start == -1 {
+			start, inCJK = i, isCJK(r)
+		}
+	}
+	if start != -1 {
+		spans = append(spans, wordSpan{start, len(text)})
+	}
+	return spans
+}
+
+// makeSnippet extracts a window of plain text around the first occurrence
+// of any query term and highlights every term within it. The CJK runs of
+// the query are highlighted wherever they occur, since CJK text has no
+// word boundaries to match them at.
+func makeSnippet(text string, query string) template.HTML {
+	terms := make(map[string]bool)
+	for _, t := range tokenize(query) {
+		terms[t] = true
+	}
+	var runs [][]rune
+	for _, t := range tokenize(query) {
+		for _, run := range splitCJKRuns(t) {
+			if r := []rune(run); isCJK(r[0]) {
+				runs = append(runs, r)
+			}
+		}
+	}
+	spans := wordSpans(text)
+	if len(spans) == 0 {
+		return ""
+	}
+
+	marked := make([]bool, len(spans))
+	for i, s := range spans {
+		if terms[strings.ToLower(text[s.start:s.end])] {
+			marked[i] = true
+		}
+		for _, run := range runs {
+			if cjkRunAt(text, spans[i:], run) {
+				for k := range run {
+					marked[i+k] = true
+				}
+			}
+		}
+	}
+	first := max(0, slices.Index(marked, true))
+	from := first - snippetWords
+	if from < 0 {
+		from = 0
+	}
+	to := first + snippetWords
+	if to >= len(spans) {
+		to = len(spans) - 1
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("… ")
+	}
+	pos := spans[from].start
+	for i := from; i <= to; i++ {
+		s := spans[i]
+		// Marked characters written together share one <mark>
+		joined := i > from && marked[i-1] && marked[i] && pos == s.start
+		if i > from && marked[i-1] && !joined {
+			b.WriteString("</mark>")
+		}
+		b.WriteString(html.EscapeString(spaceRe.ReplaceAllString(text[pos:s.start], " ")))
+		if marked[i] && !joined {
+			b.WriteString("<mark>")
+		}
+		b.WriteString(html.EscapeString(text[s.start:s.end]))
+		pos = s.end
+	}
+	if marked[to] {
+		b.WriteString("</mark>")
+	}
+	if to < len(spans)-1 {
+		b.WriteString(" …")
+	}
+	return template.HTML(b.String())
+}
+
+// cjkRunAt reports whether spans start with the characters of run,
+// written together.
+func cjkRunAt(text string, spans []wordSpan, run []rune) bool {
+	if len(spans) < len(run) {
+		return false
+	}
+	for k, r := range run {
+		s := spans[k]
+		if (k > 0 && spans[k-1].end != s.start) || strings.ToLower(text[s.start:s.end]) != string(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// FullTextResult is a full-text hit resolved to its index entry, with a
+// highlighted snippet of the matching text.
+type FullTextResult struct {
+	Entry   IndexEntry
+	Score   int
+	Snippet template.HTML
+}
+
+// fullTextSearch ties a loaded full-text index to the dump it was built
+// from, so hits can be turned into titles and snippets.
+type fullTextSearch struct {
+	index     *FullTextIndex
+	inputFile string
+	streams   *streamCaches
+
+	// pages is filled in the background at startup; pagesBuilt is closed
+	// once it is
+	pages      map[int]*IndexEntry
+	pagesBuilt chan struct{}
+}
+
+func newFullTextSearch(ft *FullTextIndex, inputFile string, entries []IndexEntry, streams *streamCaches) *fullTextSearch {
+	s := &fullTextSearch{index: ft, inputFile: inputFile, streams: streams, pagesBuilt: make(chan struct{})}
+	go func() {
+		defer close(s.pagesBuilt)
+		s.pages = make(map[int]*IndexEntry, len(entries))
+		for i := range entries {
+			s.pages[entries[i].PageID] = &entries[i]
+		}
+	}()
+	return s
+}
+
+// search runs a full-text query and builds snippets by re-extracting each
+// hit's text from the dump. A nil search returns no results.
+func (s *fullTextSearch) search(query string, limit int, meta *metaStore, views *viewTracker) ([]FullTextResult, error) {
+	if s == nil {
+		return nil, nil
+	}
+	<-s.pagesBuilt
+	query, filters := parseSearchFilters(query)
+	searchLimit := limit
+	if filters.active() || views.ranking() {
+		searchLimit = 0
+	}
+	hits, err := s.index.Search(query, searchLimit)
+	if err != nil {
+		return nil, err
+	}
+	views.sortHits(hits, s.pages)
+	var results []FullTextResult
+	for _, hit := range hits {
+		if limit > 0 && len(results) >= limit {
+			break
+		}
+		entry, ok := s.pages[hit.PageID]
+		if !ok || !filters.match(*entry, meta) {
+			continue
+		}
+		result := FullTextResult{Entry: *entry, Score: hit.Score}
+		if data, err := s.streams.extract(s.inputFile, entry.Offsets); err == nil {
+			if text, err := dump.ExtractPageText(data, entry.PageID); err == nil {
+				result.Snippet = makeSnippet(plainText(text), query)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	spellTableSize   = 1 << 21 // slots in each hashed count table
+	spellAlphabet    = 48      // characters tried when inserting or replacing
+	spellEditPenalty = 6.0     // log-probability cost of each edit
+	spellBeam        = 30      // single-edit candidates expanded to two edits
+	spellMaxTwoEdits = 30      // longest query, in runes, given two edits
+	spellWordChoices = 4       // corrections kept for each query word
+	spellMaxWords    = 5       // longest query, in words, that is corrected
+	spellVerify      = 16      // best phrases checked against the titles
+	spellSuggestions = 3       // suggestions shown for a query
+
+	spellStart = '\x02' // pads the start of a title
+	spellEnd   = '\x03' // marks the end of a title
+)
+
+// spellModel is a character trigram model of lowercased titles, used to
+// propose corrections for searches that match nothing. Counts live in
+// fixed-size hashed tables, so building it over millions of titles
+// allocates nothing per trigram. It is built in the background at startup;
+// until it is ready, suggest returns nothing.
+type spellModel struct {
+	entries  []IndexEntry
+	ready    atomic.Bool
+	trigrams []uint32
+	contexts []uint32
+	alphabet []rune
+	log      io.Writer
+}
+
+func newSpellModel(entries []IndexEntry, log io.Writer) *spellModel {
+	m := &spellModel{entries: entries, log: log}
+	go m.build()
+	return m
+}
+
+func spellHash(a, b, c rune) uint32 {
+	h := uint32(a)*0x9e3779b1 ^ uint32(b)*0x85ebca77 ^ uint32(c)*0xc2b2ae3d
+	h ^= h >> 15
+	return h & (spellTableSize - 1)
+}
+
+func (m *spellModel) build() {
+	start := time.Now()
+	m.trigrams = make([]uint32, spellTableSize)
+	m.contexts = make([]uint32, spellTableSize)
+	freq := make(map[rune]int)
+	var ascii [utf8.RuneSelf]int
+	for _, e := range m.entries {
+		a, b := rune(spellStart), rune(spellStart)
+		for _, c := range strings.ToLower(e.Title) {
+			m.add(a, b, c)
+			if c < utf8.RuneSelf {
+				ascii[c]++
+			} else {
+				freq[c]++
+			}
+			a, b = b, c
+		}
+		m.add(a, b, spellEnd)
+	}
+	for c, n := range ascii {
+		if n > 0 {
+			freq[rune(c)] = n
+		}
+	}
+
+	for c := range freq {
+		if c == ' ' || unicode.IsLetter(c) || unicode.IsDigit(c) {
+			m.alphabet = append(m.alphabet, c)
+		}
+	}
+	sort.Slice(m.alphabet, func(i, j int) bool { return freq[m.alphabet[i]] > freq[m.alphabet[j]] })
+	if len(m.alphabet) > spellAlphabet {
+		m.alphabet = m.alphabet[:spellAlphabet]
+	}
+	m.ready.Store(true)
+	fmt.Fprintf(m.log, "Spelling model built from %d titles in %v\n", len(m.entries), time.Since(start).Round(time.Millisecond))
+}
+
+func (m *spellModel) add(a, b, c rune) {
+	i := spellHash(a, b, c)
+	if m.trigrams[i] < math.MaxUint32 {
+		m.trigrams[i]++
+	}
+	j := spellHash(a, b, 0)
+	if m.contexts[j] < math.MaxUint32 {
+		m.contexts[j]++
+	}
+}
+
+// logProb returns the log probability of a lowercased string under the
+// model, with add-one smoothing.
+func (m *spellModel) logProb(s []rune) float64 {
+	v := float64(len(m.alphabet) + 1)
+	p := 0.0
+	a, b := rune(spellStart), rune(spellStart)
+	for i := 0; i <= len(s); i++ {
+		c := rune(spellEnd)
+		if i < len(s) {
+			c = s[i]
+		}
+		tri := float64(m.trigrams[spellHash(a, b, c)])
+		ctx := float64(m.contexts[spellHash(a, b, 0)])
+		p += math.Log((tri + 1) / (ctx + v))
+		a, b = b, c
+	}
+	return p
+}
+
+// edits returns every string one deletion, transposition, replacement or
+// insertion away from s.
+func (m *spellModel) edits(s []rune) [][]rune {
+	var out [][]rune
+	edit := func(parts ...[]rune) {
+		var e []rune
+		for _, p := range parts {
+			e = append(e, p...)
+		}
+		out = append(out, e)
+	}
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			edit(s[:i], s[i+1:])
+		}
+		if i+1 < len(s) && s[i] != s[i+1] {
+			edit(s[:i], []rune{s[i+1], s[i]}, s[i+2:])
+		}
+		for _, c := range m.alphabet {
+			if i < len(s) && c != s[i] {
+				edit(s[:i], []rune{c}, s[i+1:])
+			}
+			edit(s[:i], []rune{c}, s[i:])
+		}
+	}
+	return out
+}
+
+type spellCandidate struct {
+	text     string
+	distance int
+	score    float64
+}
+
+// wordCandidates returns the word itself and its most likely corrections
+// within two edits, best first.
+func (m *spellModel) wordCandidates(word string) []spellCandidate {
+	best := map[string]spellCandidate{word: {word, 0, m.logProb([]rune(word))}}
+	consider := func(s []rune, distance int) {
+		text := string(s)
+		score := m.logProb(s) - spellEditPenalty*float64(distance)
+		if text == "" {
+			return
+		}
+		if old, ok := best[text]; !ok || score > old.score {
+			best[text] = spellCandidate{text, distance, score}
+		}
+	}
+	runes := []rune(word)
+	first := m.edits(runes)
+	for _, e := range first {
+		consider(e, 1)
+	}
+	if len(runes) <= spellMaxTwoEdits {
+		scores := make([]float64, len(first))
+		for i, e := range first {
+			scores[i] = m.logProb(e)
+		}
+		order := make([]int, len(first))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+		if len(order) > spellBeam {
+			order = order[:spellBeam]
+		}
+		for _, i := range order {
+			for _, e := range m.edits(first[i]) {
+				consider(e, 2)
+			}
+		}
+	}
+	return sortCandidates(best, spellWordChoices)
+}
+
+// sortCandidates returns at most limit candidates, best first.
+func sortCandidates(set map[string]spellCandidate, limit int) []spellCandidate {
+	out := make([]spellCandidate, 0, len(set))
+	for _, c := range set {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		return out[i].text < out[j].text
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// suggest proposes up to spellSuggestions corrections for a query, ranked by
+// model probability less a penalty per edit. Each word is corrected on its
+// own and the combinations are ranked as whole phrases; only phrases that
+// match a title on word boundaries are returned. Queries with search
+// filters are not corrected.
+func (m *spellModel) suggest(query string) []string {
+	if m == nil || !m.ready.Load() {
+		return nil
+	}
+	words, filters := parseSearchFilters(query)
+	if filters.active() {
+		return nil
+	}
+	fields := strings.Fields(strings.ToLower(words))
+	if len(fields) == 0 || len(fields) > spellMaxWords {
+		return nil
+	}
+
+	phrases := []spellCandidate{{}}
+	for _, word := range fields {
+		choices := m.wordCandidates(word)
+		var next []spellCandidate
+		for _, p := range phrases {
+			for _, c := range choices {
+				text := c.text
+				if p.text != "" {
+					text = p.text + " " + text
+				}
+				next = append(next, spellCandidate{text: text, distance: p.distance + c.distance})
+			}
+		}
+		phrases = next
+	}
+	original := strings.Join(fields, " ")
+	set := make(map[string]spellCandidate)
+	for _, p := range phrases {
+		if p.distance == 0 || p.text == original {
+			continue
+		}
+		p.score = m.logProb([]rune(p.text)) - spellEditPenalty*float64(p.distance)
+		if old, ok := set[p.text]; !ok || p.score > old.score {
+			set[p.text] = p
+		}
+	}
+	candidates := sortCandidates(set, spellVerify)
+
+	found := make([]bool, len(candidates))
+	remaining := len(candidates)
+	for _, e := range m.entries {
+		title := strings.ToLower(e.Title)
+		for i, c := range candidates {
+			if !found[i] && containsWords(title, c.text) {
+				found[i] = true
+				remaining--
+			}
+		}
+		if remaining == 0 {
+			break
+		}
+	}
+	var out []string
+	for i, c := range candidates {
+		if found[i] && len(out) < spellSuggestions {
+			out = append(out, c.text)
+		}
+	}
+	return out
+}
+
+// containsWords reports whether s occurs in title starting and ending on
+// word boundaries, so that "appl" doesn't count as a match for "apple".
+func containsWords(title, s string) bool {
+	for offset := 0; ; {
+		i := strings.Index(title[offset:], s)
+		if i < 0 {
+			return false
+		}
+		i += offset
+		end := i + len(s)
+		before, _ := utf8.DecodeLastRuneInString(title[:i])
+		after, _ := utf8.DecodeRuneInString(title[end:])
+		if (i == 0 || !isWordRune(before)) && (end == len(title) || !isWordRune(after)) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(title[i:])
+		offset = i + size
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
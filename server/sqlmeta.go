@@ -0,0 +1,211 @@
+package server
+
+import (
+	"fmt"
+	"iter"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// Wikimedia publishes some database tables next to each dump as SQL files.
+// Two of them hold facts the server otherwise guesses from wikitext:
+// page_props has each page's short description (wikibase-shortdesc), its
+// lead image and whether it is a disambiguation page, and redirect has
+// where every redirect points. "wikiseek index sql" reads them into the
+// page metadata in the index cache, where they take precedence over what
+// is read from the wikitext.
+
+// withImported returns metadata read from wikitext, m, with the facts
+// imported from the SQL dumps in imported put over it.
+func (m PageMeta) withImported(imported PageMeta) PageMeta {
+	if imported.Props {
+		m.Props = true
+		m.Disambig = imported.Disambig
+		m.Description = firstNonEmpty(imported.Description, m.Description)
+		m.Image = firstNonEmpty(imported.Image, m.Image)
+	}
+	if imported.RedirectTarget != "" {
+		m.Redirect = true
+		m.RedirectTarget = imported.RedirectTarget
+	}
+	return m
+}
+
+// imported reports whether any of a page's metadata came from the SQL
+// dumps.
+func (m PageMeta) imported() bool {
+	return m.Props || m.RedirectTarget != ""
+}
+
+// redirectTarget returns where a page redirects, as title#fragment, when
+// the redirect table has been imported.
+func (m *metaStore) redirectTarget(pageID int) string {
+	meta, _ := m.Get(pageID)
+	return meta.RedirectTarget
+}
+
+// keepImported puts the facts imported into the previous metadata over
+// newly collected pages, so collecting again doesn't lose them.
+func keepImported(pages, previous map[int]PageMeta) {
+	for id, old := range previous {
+		if old.imported() {
+			pages[id] = pages[id].withImported(old)
+		}
+	}
+}
+
+// hasTextMetadata reports whether metadata has been collected from the
+// wikitext of the pages, rather than only imported from the SQL dumps.
+func hasTextMetadata(pages iter.Seq2[int, PageMeta]) bool {
+	for _, m := range pages {
+		if m.Bytes > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// importPageProps reads a page_props SQL dump into the metadata of the
+// indexed pages, returning how many pages it touched.
+func importPageProps(pages map[int]PageMeta, index []IndexEntry, filename string) (int, error) {
+	indexed := indexedPageIDs(index)
+	touched := make(map[int]bool)
+	for row, err := range dump.SQLRows(filename, "page_props") {
+		if err != nil {
+			return 0, err
+		}
+		// pp_page, pp_propname, pp_value, pp_sortkey
+		if len(row) < 3 {
+			continue
+		}
+		pageID, err := strconv.Atoi(row[0])
+		if err != nil || !indexed[pageID] {
+			continue
+		}
+		m := pages[pageID]
+		switch row[1] {
+		case "wikibase-shortdesc":
+			m.Description = row[2]
+		case "disambiguation":
+			m.Disambig = true
+		case "page_image_free":
+			m.Image = strings.ReplaceAll(row[2], "_", " ")
+		case "page_image":
+			if m.Image == "" {
+				m.Image = strings.ReplaceAll(row[2], "_", " ")
+			}
+		default:
+			continue
+		}
+		m.Props = true
+		pages[pageID] = m
+		touched[pageID] = true
+	}
+	return len(touched), nil
+}
+
+// importRedirects reads a redirect SQL dump into the metadata of the
+// indexed pages, returning how many redirects it recorded. Redirects to
+// other wikis are skipped.
+func importRedirects(pages map[int]PageMeta, index []IndexEntry, filename string, info *dump.SiteInfo) (int, error) {
+	indexed := indexedPageIDs(index)
+	n := 0
+	for row, err := range dump.SQLRows(filename, "redirect") {
+		if err != nil {
+			return 0, err
+		}
+		// rd_from, rd_namespace, rd_title, rd_interwiki, rd_fragment
+		if len(row) < 5 || row[3] != "" {
+			continue
+		}
+		pageID, err := strconv.Atoi(row[0])
+		if err != nil || !indexed[pageID] {
+			continue
+		}
+		target := strings.ReplaceAll(row[2], "_", " ")
+		if ns, _ := strconv.Atoi(row[1]); ns != 0 {
+			name := ""
+			if info != nil {
+				name = info.NamespaceName(ns)
+			}
+			if name == "" {
+				continue
+			}
+			target = name + ":" + target
+		}
+		if row[4] != "" {
+			target += "#" + row[4]
+		}
+		m := pages[pageID]
+		m.Redirect = true
+		m.RedirectTarget = target
+		pages[pageID] = m
+		n++
+	}
+	return n, nil
+}
+
+func indexedPageIDs(index []IndexEntry) map[int]bool {
+	ids := make(map[int]bool, len(index))
+	for _, e := range index {
+		ids[e.PageID] = true
+	}
+	return ids
+}
+
+// importSQLMetadata applies the page_props and redirect dumps that are
+// given, printing what each added.
+func importSQLMetadata(pages map[int]PageMeta, index []IndexEntry, inputFile, propsFile, redirectFile string) error {
+	if propsFile != "" {
+		n, err := importPageProps(pages, index, propsFile)
+		if err != nil {
+			return fmt.Errorf("importing %s: %v", propsFile, err)
+		}
+		fmt.Printf("Imported page properties of %d pages from %s\n", n, propsFile)
+	}
+	if redirectFile != "" {
+		info, err := dump.ReadSiteInfo(inputFile)
+		if err != nil {
+			fmt.Printf("Warning: could not read namespaces from dump, skipping redirects out of the main namespace: %v\n", err)
+			info = nil
+		}
+		n, err := importRedirects(pages, index, redirectFile, info)
+		if err != nil {
+			return fmt.Errorf("importing %s: %v", redirectFile, err)
+		}
+		fmt.Printf("Imported %d redirect targets from %s\n", n, redirectFile)
+	}
+	return nil
+}
+
+func runIndexSQL(args []string) {
+	fs := newCommandFlags("index sql")
+	inputFile := fs.String("file", "", "Path to multistream bzip2 file")
+	indexPath := fs.String("index", "", "Path to index file")
+	propsFile := fs.String("page-props", "", "page_props SQL dump, such as enwiki-20240601-page_props.sql.gz")
+	redirectFile := fs.String("redirects", "", "redirect SQL dump, such as enwiki-20240601-redirect.sql.gz")
+	fs.Parse(args)
+	requireIndexFlags(fs, *inputFile, *indexPath)
+	if *propsFile == "" && *redirectFile == "" {
+		fmt.Println("Error: give -page-props, -redirects or both")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	loaded := loadCommandIndex(*inputFile, *indexPath)
+	if loaded.pageMeta() == nil {
+		loaded.Meta = make(map[int]PageMeta)
+	}
+	if err := importSQLMetadata(loaded.Meta, loaded.Entries, *inputFile, *propsFile, *redirectFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := saveIndexCache(loaded, indexCacheFile(*indexPath), *indexPath, os.Stdout); err != nil {
+		fmt.Printf("Error saving metadata: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Saved metadata for %d pages to %s\n", len(loaded.Meta), indexCacheFile(*indexPath))
+}
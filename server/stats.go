@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// longestArticles is how many of the largest articles /stats lists.
+const longestArticles = 10
+
+// dumpStats summarizes the index and dump being served
+type dumpStats struct {
+	Articles        int            `json:"articles"`
+	Namespaces      map[string]int `json:"namespaces,omitempty"`
+	Streams         int            `json:"streams"`
+	PagesPerStream  float64        `json:"pages_per_stream"`
+	IndexMemory     int64          `json:"index_memory_bytes"`
+	DumpSize        int64          `json:"dump_size_bytes"`
+	MetadataPages   int            `json:"metadata_pages"`
+	Redirects       int            `json:"redirects"`
+	RedirectPercent float64        `json:"redirect_percent"`
+	Longest         []articleSize  `json:"longest"`
+}
+
+type articleSize struct {
+	Title string `json:"title"`
+	Bytes int    `json:"bytes"`
+}
+
+// statsCache computes the index statistics once. The parts that come from
+// page metadata are recomputed when more metadata has been collected, e.g.
+// by a background pass.
+type statsCache struct {
+	index      []IndexEntry
+	namespaces map[string]int
+	titles     titleSet
+	inputFile  string
+	meta       *metaStore
+
+	mu       sync.Mutex
+	stats    *dumpStats
+	metaSeen int
+}
+
+func newStatsCache(index []IndexEntry, namespaces map[string]int, titles titleSet, inputFile string, meta *metaStore) *statsCache {
+	return &statsCache{index: index, namespaces: namespaces, titles: titles, inputFile: inputFile, meta: meta}
+}
+
+func (c *statsCache) Get() dumpStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats == nil {
+		c.stats = c.computeIndex()
+		c.metaSeen = -1
+	}
+	if n := c.meta.Len(); n != c.metaSeen {
+		c.computeMeta(c.stats)
+		c.metaSeen = n
+	}
+	return *c.stats
+}
+
+// computeIndex gathers the statistics that only depend on the index.
+func (c *statsCache) computeIndex() *dumpStats {
+	s := &dumpStats{Articles: len(c.index), Namespaces: c.namespaces}
+	streams := make(map[*OffsetPair]bool)
+	memory := int64(len(c.index)) * int64(unsafe.Sizeof(IndexEntry{}))
+	for _, e := range c.index {
+		streams[e.Offsets] = true
+		memory += int64(len(e.Title))
+	}
+	s.Streams = len(streams)
+	if s.Streams > 0 {
+		s.PagesPerStream = float64(len(c.index)) / float64(s.Streams)
+	}
+	memory += int64(s.Streams) * int64(unsafe.Sizeof(OffsetPair{}))
+	s.IndexMemory = memory + int64(c.titles.Size())
+	if info, err := os.Stat(c.inputFile); err == nil {
+		s.DumpSize = info.Size()
+	}
+	return s
+}
+
+// computeMeta fills in the redirect share and the longest articles from
+// the page metadata collected so far.
+func (c *statsCache) computeMeta(s *dumpStats) {
+	type pageSize struct{ id, bytes int }
+	var sizes []pageSize
+	s.MetadataPages, s.Redirects = 0, 0
+	c.meta.mu.RLock()
+	c.meta.each(func(id int, m PageMeta) {
+		s.MetadataPages++
+		if m.Redirect {
+			s.Redirects++
+		} else {
+			sizes = append(sizes, pageSize{id, m.Bytes})
+		}
+	})
+	c.meta.mu.RUnlock()
+
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].bytes > sizes[j].bytes })
+	if len(sizes) > longestArticles {
+		sizes = sizes[:longestArticles]
+	}
+	titles := make(map[int]string, len(sizes))
+	for _, p := range sizes {
+		titles[p.id] = ""
+	}
+	for _, e := range c.index {
+		if _, ok := titles[e.PageID]; ok {
+			titles[e.PageID] = e.Title
+		}
+	}
+	s.Longest = make([]articleSize, 0, len(sizes))
+	for _, p := range sizes {
+		s.Longest = append(s.Longest, articleSize{Title: titles[p.id], Bytes: p.bytes})
+	}
+	s.RedirectPercent = 0
+	if s.MetadataPages > 0 {
+		s.RedirectPercent = 100 * float64(s.Redirects) / float64(s.MetadataPages)
+	}
+}
+
+// handleStats shows the dump statistics, as JSON when requested and as a
+// page otherwise.
+func handleStats(w http.ResponseWriter, r *http.Request, tmpl *template.Template, stats *statsCache) {
+	s := stats.Get()
+	if r.FormValue("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, s)
+		return
+	}
+
+	basePath := requestServer(r).cfg.BasePath
+	var b strings.Builder
+	b.WriteString("<dl class=\"dump-info\">")
+	row := func(label, value string) {
+		fmt.Fprintf(&b, "<dt>%s</dt><dd>%s</dd>", label, html.EscapeString(value))
+	}
+	row("Articles", fmt.Sprint(s.Articles))
+	row("Streams", fmt.Sprint(s.Streams))
+	row("Articles per stream", fmt.Sprintf("%.1f", s.PagesPerStream))
+	row("Index memory (estimated)", formatBytes(s.IndexMemory))
+	row("Dump file size", formatBytes(s.DumpSize))
+	if s.MetadataPages > 0 {
+		row("Redirects", fmt.Sprintf("%.1f%% of %d pages with metadata", s.RedirectPercent, s.MetadataPages))
+	}
+	b.WriteString("</dl>")
+
+	if s.Namespaces != nil {
+		names := make([]string, 0, len(s.Namespaces))
+		for name := range s.Namespaces {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return s.Namespaces[names[i]] > s.Namespaces[names[j]] })
+		b.WriteString("<h2>Entries per namespace</h2><table class=\"stats\"><tr><th>Namespace</th><th>Entries</th></tr>")
+		fmt.Fprintf(&b, "<tr><td>(Articles)</td><td>%d</td></tr>", s.Articles)
+		for _, name := range names {
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%d</td></tr>", html.EscapeString(name), s.Namespaces[name])
+		}
+		b.WriteString("</table><p>Only articles are served; other namespaces are counted from the index.</p>")
+	}
+
+	if len(s.Longest) > 0 {
+		b.WriteString("<h2>Longest articles</h2><table class=\"stats\"><tr><th>Article</th><th>Wikitext</th></tr>")
+		for _, a := range s.Longest {
+			fmt.Fprintf(&b, "<tr><td><a href=\"%s/wiki/%s\">%s</a></td><td>%s</td></tr>", basePath,
+				html.EscapeString(strings.ReplaceAll(a.Title, " ", "_")), html.EscapeString(a.Title), formatBytes(int64(a.Bytes)))
+		}
+		b.WriteString("</table>")
+	}
+	if s.MetadataPages < s.Articles {
+		fmt.Fprintf(&b, "<p>Redirect and size figures cover the %d articles with page metadata; run <code>index metadata</code> to include every article.</p>", s.MetadataPages)
+	}
+
+	tmpl.Execute(w, ArticleView{
+		Layout:  Layout{Dump: requestServer(r).info},
+		Title:   "Statistics",
+		Content: template.HTML(b.String()),
+	})
+}
+
+// formatBytes renders a byte count with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// qpsWindow is the period request rates are averaged over.
+const qpsWindow = 60
+
+// requestStats counts requests served, keeping per-second counts for the
+// last qpsWindow seconds.
+type requestStats struct {
+	started time.Time
+
+	mu      sync.Mutex
+	total   int64
+	seconds [qpsWindow]struct {
+		unix int64
+		n    int64
+	}
+}
+
+func newRequestStats() *requestStats {
+	return &requestStats{started: time.Now()}
+}
+
+// wrap counts every request handled by h.
+func (s *requestStats) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.add(time.Now())
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *requestStats) add(now time.Time) {
+	sec := now.Unix()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	slot := &s.seconds[sec%qpsWindow]
+	if slot.unix != sec {
+		slot.unix, slot.n = sec, 0
+	}
+	slot.n++
+}
+
+// rate returns the total count and the average requests per second over
+// the last qpsWindow seconds, or since startup if that is shorter.
+func (s *requestStats) rate(now time.Time) (int64, float64) {
+	sec := now.Unix()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, slot := range s.seconds {
+		if sec-slot.unix < qpsWindow {
+			n += slot.n
+		}
+	}
+	window := now.Sub(s.started).Seconds()
+	if window > qpsWindow {
+		window = qpsWindow
+	}
+	if window < 1 {
+		window = 1
+	}
+	return s.total, float64(n) / window
+}
+
+// serverStatus is the summary served at /admin/status
+type serverStatus struct {
+	Started       time.Time         `json:"started"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
+	Entries       int               `json:"index_entries"`
+	Renderer      string            `json:"renderer"`
+	Dump          *DumpInfo         `json:"dump"`
+	RenderCache   renderCacheStatus `json:"render_cache"`
+	Memory        *memoryStatus     `json:"memory,omitempty"`
+	Requests      int64             `json:"requests_total"`
+	QPS           float64           `json:"qps"`
+}
+
+type renderCacheStatus struct {
+	Entries  int     `json:"entries"`
+	Capacity int     `json:"capacity"`
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRate  float64 `json:"hit_rate"`
+}
+
+func handleAdminStatus(w http.ResponseWriter, r *http.Request, stats *requestStats, cache *renderCache, tuner *cacheTuner, entries int, renderer string) {
+	now := time.Now()
+	total, qps := stats.rate(now)
+	hits, misses := cache.Stats()
+	status := serverStatus{
+		Started:       stats.started,
+		UptimeSeconds: int64(now.Sub(stats.started).Seconds()),
+		Entries:       entries,
+		Renderer:      renderer,
+		Dump:          requestServer(r).info,
+		RenderCache:   renderCacheStatus{Entries: cache.Len(), Capacity: cache.Max(), Hits: hits, Misses: misses},
+		Memory:        tuner.Status(),
+		Requests:      total,
+		QPS:           qps,
+	}
+	if hits+misses > 0 {
+		status.RenderCache.HitRate = float64(hits) / float64(hits+misses)
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// runStatus implements "wikiseek status": it fetches /admin/status from a
+// running server and prints a short summary. The exit status is non-zero
+// when the server can't be reached, so it can be used as a health check.
+func runStatus(args []string) {
+	fs := newCommandFlags("status")
+	addr := fs.String("addr", "localhost:8080", "Address of the running server, optionally with a scheme and base path")
+	token := fs.String("token", "", "API token with full scope, when the server uses -api-tokens")
+	asJSON := fs.Bool("json", false, "Print the raw status JSON")
+	timeout := fs.Duration("timeout", 5*time.Second, "Time to wait for the server")
+	fs.Parse(args)
+
+	base := strings.TrimRight(*addr, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	req, err := http.NewRequest("GET", base+"/admin/status", nil)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := (&http.Client{Timeout: *timeout}).Do(req)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Error: %s returned %s\n", req.URL, resp.Status)
+		os.Exit(1)
+	}
+
+	var status serverStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		fmt.Printf("Error decoding status: %v\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(status, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+
+	served := "unknown dump"
+	if status.Dump != nil {
+		served = status.Dump.Summary()
+	}
+	c := status.RenderCache
+	fmt.Printf("wikiseek at %s (%s)\n", base, served)
+	fmt.Printf("  uptime:        %s (since %s)\n", (time.Duration(status.UptimeSeconds) * time.Second).String(), status.Started.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("  index entries: %d\n", status.Entries)
+	fmt.Printf("  renderer:      %s\n", status.Renderer)
+	fmt.Printf("  render cache:  %d/%d pages, %.1f%% hit rate (%d hits, %d misses)\n", c.Entries, c.Capacity, c.HitRate*100, c.Hits, c.Misses)
+	if m := status.Memory; m != nil {
+		fmt.Printf("  memory:        %s live heap of %s limit, %d cache adjustments\n", formatBytes(m.HeapLive), formatBytes(m.Limit), m.Adjustments)
+		fmt.Printf("  stream cache:  %d streams, %s of %s in memory (%d hits, %d misses)\n", m.Streams, formatBytes(m.StreamCache), formatBytes(m.StreamCapacity), m.StreamHits, m.StreamMisses)
+	}
+	fmt.Printf("  requests:      %d total, %.2f/s over the last minute\n", status.Requests, status.QPS)
+}
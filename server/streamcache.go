@@ -0,0 +1,233 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// streamCache keeps decompressed dump streams on disk so they survive
+// restarts. Files are named by a fingerprint of the dump and the stream's
+// start offset, and the least recently used are removed once the cache
+// grows past its size bound.
+type streamCache struct {
+	dir       string
+	inputFile string
+	dumpKey   string
+	maxBytes  int64
+	log       io.Writer // receives write failures
+
+	mu    sync.Mutex
+	size  int64
+	ll    *list.List
+	files map[string]*list.Element
+}
+
+type streamCacheFile struct {
+	name string
+	size int64
+}
+
+// fileFingerprint identifies a dump or index file by its size,
+// modification time and first megabyte, which is enough to tell snapshots
+// apart without hashing the whole multi-gigabyte file.
+func fileFingerprint(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("opening file: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("reading file info: %v", err)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%d:", info.Size(), info.ModTime().UnixNano())
+	if _, err := io.CopyN(h, f, 1<<20); err != nil && err != io.EOF {
+		return "", fmt.Errorf("reading file: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// newStreamCache opens the cache directory for a dump, picking up files
+// left by earlier runs in modification-time order. Failed writes are
+// logged to log.
+func newStreamCache(dir string, inputFile string, maxBytes int64, log io.Writer) (*streamCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating stream cache directory: %v", err)
+	}
+	key, err := fileFingerprint(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &streamCache{dir: dir, inputFile: inputFile, dumpKey: key, maxBytes: maxBytes, log: log, ll: list.New(), files: make(map[string]*list.Element)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading stream cache directory: %v", err)
+	}
+	var existing []os.FileInfo
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".xml") {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			existing = append(existing, info)
+		}
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].ModTime().Before(existing[j].ModTime()) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, info := range existing {
+		c.files[info.Name()] = c.ll.PushFront(&streamCacheFile{name: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+	c.evict()
+	return c, nil
+}
+
+func (c *streamCache) fileName(start int64) string {
+	return fmt.Sprintf("%s-%d.xml", c.dumpKey, start)
+}
+
+// Get returns a cached stream, or false if it isn't on disk.
+func (c *streamCache) Get(start int64) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	name := c.fileName(start)
+	c.mu.Lock()
+	el, ok := c.files[name]
+	if ok {
+		c.ll.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Add writes a stream to disk and evicts old streams if needed.
+func (c *streamCache) Add(start int64, data []byte) {
+	if c == nil || int64(len(data)) > c.maxBytes {
+		return
+	}
+	name := c.fileName(start)
+	tmp, err := os.CreateTemp(c.dir, name+".tmp*")
+	if err != nil {
+		fmt.Fprintf(c.log, "Warning: stream cache write failed: %v\n", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		fmt.Fprintf(c.log, "Warning: stream cache write failed: %v\n", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.files[name]; ok {
+		c.size -= el.Value.(*streamCacheFile).size
+		c.ll.Remove(el)
+	}
+	c.files[name] = c.ll.PushFront(&streamCacheFile{name: name, size: int64(len(data))})
+	c.size += int64(len(data))
+	c.evict()
+}
+
+// evict removes least recently used files until the cache fits. The caller
+// must hold c.mu.
+func (c *streamCache) evict() {
+	for c.size > c.maxBytes && c.ll.Len() > 0 {
+		oldest := c.ll.Back()
+		f := oldest.Value.(*streamCacheFile)
+		c.ll.Remove(oldest)
+		delete(c.files, f.name)
+		c.size -= f.size
+		os.Remove(filepath.Join(c.dir, f.name))
+	}
+}
+
+// streamCaches are the caches of a server's main dump: the disk cache of
+// -stream-cache-dir and the memory cache of -memory-limit, either of which
+// may be nil.
+type streamCaches struct {
+	disk   *streamCache
+	memory *memoryStreamCache
+}
+
+// extractStream returns the decompressed XML of one stream without
+// caching it.
+func extractStream(inputFile string, offsets *OffsetPair) ([]byte, error) {
+	return dump.ExtractBzip2Range(inputFile, offsets.Start, offsets.End)
+}
+
+// extract returns the decompressed XML of one stream, going through the
+// memory and disk caches when inputFile is the dump they belong to. A nil
+// *streamCaches always decompresses. The result may be shared with other
+// callers and must not be modified.
+func (c *streamCaches) extract(inputFile string, offsets *OffsetPair) ([]byte, error) {
+	// The caches belong to the main dump; extra dumps always decompress
+	if c == nil {
+		return extractStream(inputFile, offsets)
+	}
+	cache, memory := c.disk, c.memory
+	if cache != nil && cache.inputFile != inputFile {
+		cache = nil
+	}
+	if memory != nil && memory.inputFile != inputFile {
+		memory = nil
+	}
+	if data, ok := memory.Get(offsets.Start); ok {
+		return data, nil
+	}
+	if data, ok := cache.Get(offsets.Start); ok {
+		memory.Add(offsets.Start, data)
+		return data, nil
+	}
+	data, err := extractStream(inputFile, offsets)
+	if err != nil {
+		return nil, err
+	}
+	cache.Add(offsets.Start, data)
+	memory.Add(offsets.Start, data)
+	return data, nil
+}
+
+// cached returns a stream of inputFile from the caches without adding it,
+// or false if neither holds it.
+func (c *streamCaches) cached(inputFile string, start int64) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if c.memory != nil && c.memory.inputFile == inputFile {
+		if data, ok := c.memory.Get(start); ok {
+			return data, true
+		}
+	}
+	if c.disk != nil && c.disk.inputFile == inputFile {
+		return c.disk.Get(start)
+	}
+	return nil, false
+}
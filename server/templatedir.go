@@ -0,0 +1,264 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// templateWatchInterval is how often -watch-templates checks the template
+// directory for changes.
+const templateWatchInterval = 2 * time.Second
+
+// templateDir holds template definitions loaded from -template-dir: one
+// NAME.wiki file per template, written like a wiki template page with
+// {{{1}}} and {{{name|default}}} parameters. They take precedence over the
+// built-in handlers, so rendering can be tuned without rebuilding.
+type templateDir struct {
+	dir string
+	log io.Writer
+
+	mu     sync.RWMutex
+	bodies map[string]string // template name -> definition
+	files  map[string]templateFile
+}
+
+// templateFile is what a definition file looked like when it was loaded
+type templateFile struct {
+	name    string
+	modTime time.Time
+	size    int64
+}
+
+// loadTemplateDir reads every definition in dir, logging the files it
+// skips and later reloads to log.
+func loadTemplateDir(dir string, log io.Writer) (*templateDir, error) {
+	t := &templateDir{dir: dir, log: log, bodies: make(map[string]string), files: make(map[string]templateFile)}
+	if _, err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// templateFileName maps a definition file name such as Infobox_person.wiki
+// to the template name it defines.
+func templateFileName(file string) string {
+	name := strings.TrimSuffix(file, filepath.Ext(file))
+	return strings.ToLower(strings.ReplaceAll(name, "_", " "))
+}
+
+// reload rereads the definitions whose files were added, changed or removed
+// since the last load and returns the names of those templates.
+func (t *templateDir) reload() ([]string, error) {
+	if _, err := os.Stat(t.dir); err != nil {
+		return nil, fmt.Errorf("reading template directory: %v", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(t.dir, "*.wiki"))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(paths))
+	changed := make(map[string]bool)
+	bodies := make(map[string]string)
+	files := make(map[string]templateFile)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		file := filepath.Base(path)
+		seen[file] = true
+		current := templateFile{name: templateFileName(file), modTime: info.ModTime(), size: info.Size()}
+		t.mu.RLock()
+		old, ok := t.files[file]
+		t.mu.RUnlock()
+		if ok && old == current {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(t.log, "Warning: skipping template %s: %v\n", file, err)
+			continue
+		}
+		bodies[current.name] = transcludedPart(string(data))
+		files[file] = current
+		changed[current.name] = true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for file, f := range t.files {
+		if !seen[file] {
+			delete(t.files, file)
+			delete(t.bodies, f.name)
+			changed[f.name] = true
+		}
+	}
+	for file, f := range files {
+		t.files[file] = f
+		t.bodies[f.name] = bodies[f.name]
+	}
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Len returns the number of loaded definitions.
+func (t *templateDir) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.bodies)
+}
+
+// expand returns the output of a loaded template, or false if the
+// directory doesn't define it.
+func (t *templateDir) expand(name string, args []string) (string, bool) {
+	t.mu.RLock()
+	body, ok := t.bodies[name]
+	t.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	return substituteParams(body, args), true
+}
+
+// dependents adds to names every loaded template whose definition uses one
+// of them, directly or through other loaded templates, since pages using
+// those render differently too.
+func (t *templateDir) dependents(names []string) map[string]bool {
+	affected := make(map[string]bool, len(names))
+	for _, name := range names {
+		affected[name] = true
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for grew := true; grew; {
+		grew = false
+		for name, body := range t.bodies {
+			if affected[name] {
+				continue
+			}
+			for _, used := range templateNames(body) {
+				if affected[used] {
+					affected[name] = true
+					grew = true
+					break
+				}
+			}
+		}
+	}
+	return affected
+}
+
+// watch polls the directory and reloads changed definitions, dropping the
+// rendered pages that used them from the cache.
+func (t *templateDir) watch(cache *renderCache) {
+	for range time.Tick(templateWatchInterval) {
+		changed, err := t.reload()
+		if err != nil {
+			fmt.Fprintf(t.log, "Warning: template reload failed: %v\n", err)
+			continue
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		affected := t.dependents(changed)
+		cleared := cache.RemoveIf(func(page *renderedPage) bool {
+			for _, name := range page.Templates {
+				if affected[name] {
+					return true
+				}
+			}
+			return false
+		})
+		fmt.Fprintf(t.log, "[%s] Reloaded templates %s; cleared %d cached pages\n",
+			time.Now().Format("2006-01-02 15:04:05"), strings.Join(changed, ", "), cleared)
+	}
+}
+
+var (
+	templateNameRe = regexp.MustCompile(`\{\{\s*([^{}|#:]+?)\s*[|}]`)
+	paramRe        = regexp.MustCompile(`\{\{\{\s*([^{}|]*?)\s*(?:\|([^{}]*))?\}\}\}`)
+	noIncludeRe    = regexp.MustCompile(`(?is)<noinclude>.*?</noinclude>`)
+	onlyIncludeRe  = regexp.MustCompile(`(?is)<onlyinclude>(.*?)</onlyinclude>`)
+	includeOnlyRe  = regexp.MustCompile(`(?i)</?includeonly>`)
+)
+
+// templateNames lists the lowercase names of the templates used in
+// wikitext, nested ones included, without duplicates.
+func templateNames(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range templateNameRe.FindAllStringSubmatch(text, -1) {
+		name := strings.ToLower(strings.ReplaceAll(m[1], "_", " "))
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// transcludedPart applies <noinclude>, <includeonly> and <onlyinclude> to
+// a template page, keeping what a transclusion would see.
+func transcludedPart(text string) string {
+	if parts := onlyIncludeRe.FindAllStringSubmatch(text, -1); parts != nil {
+		var b strings.Builder
+		for _, p := range parts {
+			b.WriteString(p[1])
+		}
+		text = b.String()
+	}
+	text = noIncludeRe.ReplaceAllString(text, "")
+	text = includeOnlyRe.ReplaceAllString(text, "")
+	return strings.TrimSpace(text)
+}
+
+// substituteParams fills {{{1}}} and {{{name|default}}} parameters with the
+// template's arguments. Parameters without a value or default are shown as
+// written, as MediaWiki does, encoded so they aren't expanded as templates.
+func substituteParams(body string, args []string) string {
+	named := templateParams(args)
+	// Defaults may hold parameters of their own, so substitute innermost
+	// first until nothing changes.
+	for i := 0; i < 10 && strings.Contains(body, "{{{"); i++ {
+		next := paramRe.ReplaceAllStringFunc(body, func(m string) string {
+			sub := paramRe.FindStringSubmatch(m)
+			if v, ok := named[strings.ToLower(sub[1])]; ok {
+				return v
+			}
+			if strings.Contains(m, "|") {
+				return sub[2]
+			}
+			return "&#123;&#123;&#123;" + sub[1] + "&#125;&#125;&#125;"
+		})
+		if next == body {
+			break
+		}
+		body = next
+	}
+	return body
+}
+
+// templateParams maps parameter names to values, numbering positional
+// arguments from 1 unless a named argument takes the number.
+func templateParams(args []string) map[string]string {
+	positional, named := templateArgs(args)
+	for i, v := range positional {
+		if _, ok := named[strconv.Itoa(i+1)]; !ok {
+			named[strconv.Itoa(i+1)] = v
+		}
+	}
+	return named
+}
@@ -0,0 +1,113 @@
+package server
+
+import "strings"
+
+// TemplateHandler produces the replacement wikitext for one template call,
+// given its arguments.
+type TemplateHandler func(args []string) string
+
+// Registry maps lowercase template names to handlers. Templates without a
+// handler are dropped, unless -template-dir or -dump-templates expands
+// them; infoboxes all share infoboxTemplate. Handlers are registered
+// before the registry is used, so lookups need no locking.
+//
+// A program embedding the server can pass its own registry in
+// Config.Templates, usually built from DefaultTemplates with With.
+type Registry struct {
+	handlers map[string]TemplateHandler
+
+	// localized handlers write numbers and dates in locale, English
+	// when it is nil.
+	localized map[string]localizedHandler
+	locale    *locale
+
+	// A server sets these for -template-dir, -dump-templates and
+	// -template-profile.
+	files   *templateDir
+	dump    *templateStore
+	profile *templateProfiler
+}
+
+// localizedHandler is a TemplateHandler that formats for a locale.
+type localizedHandler func(l *locale, args []string) string
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]TemplateHandler), localized: make(map[string]localizedHandler)}
+}
+
+// defaultTemplates holds the handlers every project uses, registered by
+// the init functions of the files that define them.
+var defaultTemplates = NewRegistry()
+
+// DefaultTemplates returns a copy of the built-in handlers every project
+// uses.
+func DefaultTemplates() *Registry {
+	return defaultTemplates.With(nil)
+}
+
+// Register adds a handler, replacing any registered under the same name.
+func (r *Registry) Register(name string, h TemplateHandler) {
+	name = strings.ToLower(name)
+	delete(r.localized, name)
+	r.handlers[name] = h
+}
+
+// registerLocalized adds a handler that formats for the registry's locale.
+func (r *Registry) registerLocalized(name string, h localizedHandler) {
+	name = strings.ToLower(name)
+	delete(r.handlers, name)
+	r.localized[name] = h
+}
+
+// Lookup returns the handler for a lowercase template name.
+func (r *Registry) Lookup(name string) (TemplateHandler, bool) {
+	if lh, ok := r.localized[name]; ok {
+		l := r.locale
+		if l == nil {
+			l = locales["en"]
+		}
+		return func(args []string) string { return lh(l, args) }, true
+	}
+	h, ok := r.handlers[name]
+	if !ok && isInfobox(name) {
+		return infoboxTemplate, true
+	}
+	return h, ok
+}
+
+// With returns a copy of the registry with handlers added, leaving r as
+// it was. Project profiles compose their handlers onto the defaults this
+// way.
+func (r *Registry) With(handlers map[string]TemplateHandler) *Registry {
+	c := r.clone()
+	for name, h := range handlers {
+		c.Register(name, h)
+	}
+	return c
+}
+
+// withLocale returns a copy of the registry whose handlers format numbers
+// and dates in l, as -ui-lang.
+func (r *Registry) withLocale(l *locale) *Registry {
+	c := r.clone()
+	c.locale = l
+	return c
+}
+
+func (r *Registry) clone() *Registry {
+	c := NewRegistry()
+	for name, h := range r.handlers {
+		c.handlers[name] = h
+	}
+	for name, h := range r.localized {
+		c.localized[name] = h
+	}
+	c.locale, c.files, c.dump, c.profile = r.locale, r.files, r.dump, r.profile
+	return c
+}
+
+// Len returns the number of registered handlers.
+func (r *Registry) Len() int {
+	return len(r.handlers) + len(r.localized)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryWithLeavesDefaultsUnchanged(t *testing.T) {
+	before := defaultTemplates.Len()
+	nbsp, _ := defaultTemplates.Lookup("nbsp")
+	want := nbsp(nil)
+
+	isolated := DefaultTemplates().With(map[string]TemplateHandler{
+		"Greeting": func(args []string) string { return "hello " + strings.Join(args, ",") },
+		"nbsp":     func([]string) string { return "[space]" },
+	})
+
+	if got := defaultTemplates.Len(); got != before {
+		t.Errorf("defaultTemplates has %d handlers after With, had %d", got, before)
+	}
+	if _, ok := defaultTemplates.Lookup("greeting"); ok {
+		t.Error("handler added With leaked into defaultTemplates")
+	}
+	if h, _ := defaultTemplates.Lookup("nbsp"); h(nil) != want {
+		t.Errorf("default nbsp handler was replaced: got %q", h(nil))
+	}
+	if isolated.Len() != before+1 {
+		t.Errorf("isolated registry has %d handlers, want %d", isolated.Len(), before+1)
+	}
+	if h, ok := isolated.Lookup("greeting"); !ok || h([]string{"a", "b"}) != "hello a,b" {
+		t.Error("handler names are not registered in lower case")
+	}
+
+	// The converter expands templates only with the registry it is given
+	if got := convertWikiText("{{Greeting|world}} and{{nbsp}}end", convertOptions{templates: isolated}); !strings.Contains(got, "hello world and[space]end") {
+		t.Errorf("isolated registry output = %q", got)
+	}
+	if got := convertWikiText("{{Greeting|world}}", defaultConvertOptions); strings.Contains(got, "hello") {
+		t.Errorf("default registry expanded a template it lacks: %q", got)
+	}
+}
+
+func TestDefaultTemplatesIsACopy(t *testing.T) {
+	copied := DefaultTemplates()
+	copied.Register("only-in-copy", dropTemplate)
+	if _, ok := defaultTemplates.Lookup("only-in-copy"); ok {
+		t.Error("registering in DefaultTemplates() changed the built-in handlers")
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// sentenceEndRe matches the end of a sentence: terminal punctuation,
+// optionally followed by closing quotes or brackets, then space or the end
+// of the paragraph.
+var sentenceEndRe = regexp.MustCompile(`[.!?]+["'”’)\]]*(\s|$)`)
+
+type apiTemplateCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type apiTextStatsResponse struct {
+	Title          string             `json:"title"`
+	PageID         int                `json:"page_id"`
+	RedirectedFrom string             `json:"redirected_from,omitempty"`
+	Tokens         int                `json:"tokens"`
+	Sentences      int                `json:"sentences"`
+	Sections       int                `json:"sections"`
+	Links          int                `json:"links"`
+	UniqueLinks    int                `json:"unique_links"`
+	Citations      int                `json:"citations"`
+	CitationUses   int                `json:"citation_uses"`
+	Templates      []apiTemplateCount `json:"templates"`
+}
+
+// articleTextStats counts what /api/stats reports in the wikitext of an
+// article. Tokens and sentences are counted in the plain text, as
+// /api/page/{title}/chunks produces it; the rest come from the markup, so
+// templates aren't expanded and links or citations they add aren't seen.
+func articleTextStats(wikitext string) apiTextStatsResponse {
+	var s apiTextStatsResponse
+	for _, section := range splitSections(wikitext) {
+		for _, para := range section.Paragraphs {
+			s.Tokens += len(strings.Fields(para))
+			ends := sentenceEndRe.FindAllStringIndex(para, -1)
+			s.Sentences += len(ends)
+			last := 0
+			if len(ends) > 0 {
+				last = ends[len(ends)-1][1]
+			}
+			// Text after the last full stop, or a paragraph without one
+			if strings.TrimSpace(para[last:]) != "" {
+				s.Sentences++
+			}
+		}
+	}
+
+	text := commentRe.ReplaceAllString(wikitext, "")
+	for _, line := range strings.Split(text, "\n") {
+		if isHeader(line) {
+			s.Sections++
+		}
+	}
+
+	// A ref with content is a citation; an empty <ref name=x/> cites one
+	// again
+	for _, m := range refRe.FindAllStringSubmatch(text, -1) {
+		s.CitationUses++
+		if m[2] != "/>" && strings.TrimSpace(m[3]) != "" {
+			s.Citations++
+		}
+	}
+
+	linked := make(map[string]bool)
+	for _, m := range wikiLinkRe.FindAllStringSubmatch(text, -1) {
+		target, _, _ := strings.Cut(m[1], "#")
+		target = strings.TrimSpace(strings.ReplaceAll(target, "_", " "))
+		// Files and categories aren't links to articles
+		if target == "" || (!strings.HasPrefix(target, ":") && strings.Contains(target, ":") && mediaPrefix(target)) {
+			continue
+		}
+		s.Links++
+		linked[ucfirst(strings.TrimPrefix(target, ":"))] = true
+	}
+	s.UniqueLinks = len(linked)
+
+	counts := make(map[string]int)
+	for _, m := range templateNameRe.FindAllStringSubmatchIndex(text, -1) {
+		// Skip {{{parameters}}}, which the pattern also matches
+		if m[0] > 0 && text[m[0]-1] == '{' {
+			continue
+		}
+		counts[strings.ToLower(strings.ReplaceAll(text[m[2]:m[3]], "_", " "))]++
+	}
+	s.Templates = []apiTemplateCount{}
+	for name, n := range counts {
+		s.Templates = append(s.Templates, apiTemplateCount{Name: name, Count: n})
+	}
+	sort.Slice(s.Templates, func(i, j int) bool {
+		if s.Templates[i].Count != s.Templates[j].Count {
+			return s.Templates[i].Count > s.Templates[j].Count
+		}
+		return s.Templates[i].Name < s.Templates[j].Name
+	})
+	return s
+}
+
+// mediaPrefix reports whether a link target is in the file or category
+// namespace.
+func mediaPrefix(target string) bool {
+	prefix, _, _ := strings.Cut(strings.ToLower(target), ":")
+	switch strings.TrimSpace(prefix) {
+	case "file", "image", "media", "category":
+		return true
+	}
+	return false
+}
+
+// handleAPITextStats serves /api/stats/{title}: counts of tokens,
+// sentences, sections, links, citations and templates in an article's
+// wikitext, for corpus studies. Redirects are followed.
+func handleAPITextStats(w http.ResponseWriter, r *http.Request, index []IndexEntry, titles titleSet, inputFile string, streams *streamCaches) {
+	title, err := titleFromPath(r.URL.Path, "/api/stats/")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	entry := findPageByTitle(index, titles, title)
+	if entry == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no article with that title"})
+		return
+	}
+	entry, from, text, err := pageWikiText(index, titles, inputFile, streams, entry)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	resp := articleTextStats(text)
+	resp.Title, resp.PageID, resp.RedirectedFrom = entry.Title, entry.PageID, from
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/maphash"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// titleBlockSize is the number of titles per front-coded block. Larger
+// blocks compress better but take longer to scan on lookup.
+const titleBlockSize = 16
+
+// titleSet answers whether a page exists, applying the same first-letter
+// rule as link resolution, and maps each title to its position in the
+// index.
+//
+// Titles are kept sorted and front coded: every block stores its first
+// title in full and each following one as the length of the prefix it
+// shares with the previous title plus the remaining bytes. Sorted titles
+// share long prefixes ("List of ...", "1990 in ..."), which keeps this
+// copy small, but it is a copy: the index entries still hold every title,
+// so the dictionary adds to their memory rather than saving any. A lookup
+// binary searches the block heads and decodes a single block, unless the
+// Bloom filter in front of the dictionary already rules the title out.
+// Titles that match only when case is ignored are found through a table of
+// hashes, built the first time one is looked up.
+type titleSet struct {
+	heads     string   // first title of every block, concatenated
+	headEnds  []uint32 // end of each block's head in heads
+	blocks    []byte   // the rest of every block, front coded
+	blockEnds []uint32 // end of each block in blocks
+	positions []int32  // index position of each title, in sorted order
+	filter    titleFilter
+	folded    *foldedTitles
+}
+
+// foldedTitles maps the hash of each lower-cased title to its rank in the
+// dictionary, sorted by hash.
+type foldedTitles struct {
+	once   sync.Once
+	hashes []uint64
+	ranks  []int32
+	size   atomic.Int64 // bytes, once built
+}
+
+func newTitleSet(entries []IndexEntry) titleSet {
+	return titleSetFromOrder(entries, sortedTitleOrder(entries))
+}
+
+// titleSetFromOrder builds the set from entry positions already sorted by
+// title, as the binary index stores them. order is reused.
+func titleSetFromOrder(entries []IndexEntry, order []int32) titleSet {
+	// Keep the last entry of duplicated titles
+	sorted := order[:0]
+	for i, pos := range order {
+		if i+1 < len(order) && entries[order[i+1]].Title == entries[pos].Title {
+			continue
+		}
+		sorted = append(sorted, pos)
+	}
+
+	ts := titleSet{filter: newTitleFilter(len(sorted)), folded: new(foldedTitles)}
+	var heads strings.Builder
+	var prev string
+	for i, pos := range sorted {
+		title := entries[pos].Title
+		ts.filter.add(title)
+		if i%titleBlockSize == 0 {
+			if i > 0 {
+				ts.blockEnds = append(ts.blockEnds, uint32(len(ts.blocks)))
+			}
+			heads.WriteString(title)
+			ts.headEnds = append(ts.headEnds, uint32(heads.Len()))
+		} else {
+			shared := commonPrefix(prev, title)
+			ts.blocks = binary.AppendUvarint(ts.blocks, uint64(shared))
+			ts.blocks = binary.AppendUvarint(ts.blocks, uint64(len(title)-shared))
+			ts.blocks = append(ts.blocks, title[shared:]...)
+		}
+		ts.positions = append(ts.positions, pos)
+		prev = title
+	}
+	if len(sorted) > 0 {
+		ts.blockEnds = append(ts.blockEnds, uint32(len(ts.blocks)))
+	}
+	ts.heads = heads.String()
+	ts.positions = append([]int32(nil), ts.positions...)
+	return ts
+}
+
+func commonPrefix(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+func (ts titleSet) head(block int) string {
+	start := uint32(0)
+	if block > 0 {
+		start = ts.headEnds[block-1]
+	}
+	return ts.heads[start:ts.headEnds[block]]
+}
+
+// find returns the index position of an exact title.
+func (ts titleSet) find(title string) (int, bool) {
+	if !ts.filter.mayHave(title) {
+		return 0, false
+	}
+	n := len(ts.headEnds)
+	block := sort.Search(n, func(b int) bool { return ts.head(b) > title }) - 1
+	if block < 0 {
+		return 0, false
+	}
+	current := ts.head(block)
+	if current == title {
+		return int(ts.positions[block*titleBlockSize]), true
+	}
+
+	start := uint32(0)
+	if block > 0 {
+		start = ts.blockEnds[block-1]
+	}
+	data := ts.blocks[start:ts.blockEnds[block]]
+	buf := make([]byte, 0, 256)
+	buf = append(buf, current...)
+	for i := 1; len(data) > 0; i++ {
+		shared, n1 := binary.Uvarint(data)
+		length, n2 := binary.Uvarint(data[n1:])
+		data = data[n1+n2:]
+		buf = append(buf[:shared], data[:length]...)
+		data = data[length:]
+		if string(buf) == title {
+			return int(ts.positions[block*titleBlockSize+i]), true
+		}
+		if string(buf) > title {
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// titleAt decodes the title of the given rank in sorted order.
+func (ts titleSet) titleAt(rank int) string {
+	block := rank / titleBlockSize
+	title := ts.head(block)
+	if rank%titleBlockSize == 0 {
+		return title
+	}
+	start := uint32(0)
+	if block > 0 {
+		start = ts.blockEnds[block-1]
+	}
+	data := ts.blocks[start:ts.blockEnds[block]]
+	buf := []byte(title)
+	for range rank % titleBlockSize {
+		shared, n1 := binary.Uvarint(data)
+		length, n2 := binary.Uvarint(data[n1:])
+		data = data[n1+n2:]
+		buf = append(buf[:shared], data[:length]...)
+		data = data[length:]
+	}
+	return string(buf)
+}
+
+func foldedHash(title []byte) uint64 {
+	return maphash.Bytes(titleFilterSeed, bytes.ToLower(title))
+}
+
+// buildFolded fills the table of lower-cased titles unless it is already
+// built. The server starts it in the background; otherwise the first
+// lookup that needs it builds it.
+func (ts titleSet) buildFolded() {
+	f := ts.folded
+	if f == nil {
+		return
+	}
+	f.once.Do(func() {
+		f.hashes = make([]uint64, 0, len(ts.positions))
+		buf := make([]byte, 0, 256)
+		for block := range ts.headEnds {
+			buf = append(buf[:0], ts.head(block)...)
+			f.hashes = append(f.hashes, foldedHash(buf))
+			start := uint32(0)
+			if block > 0 {
+				start = ts.blockEnds[block-1]
+			}
+			for data := ts.blocks[start:ts.blockEnds[block]]; len(data) > 0; {
+				shared, n1 := binary.Uvarint(data)
+				length, n2 := binary.Uvarint(data[n1:])
+				data = data[n1+n2:]
+				buf = append(buf[:shared], data[:length]...)
+				data = data[length:]
+				f.hashes = append(f.hashes, foldedHash(buf))
+			}
+		}
+		f.ranks = make([]int32, len(f.hashes))
+		for rank := range f.ranks {
+			f.ranks[rank] = int32(rank)
+		}
+		sort.Sort(foldedOrder{f})
+		f.size.Store(int64(12 * len(f.hashes)))
+	})
+}
+
+// foldedOrder sorts a table of lower-cased titles by hash.
+type foldedOrder struct{ f *foldedTitles }
+
+func (o foldedOrder) Len() int           { return len(o.f.hashes) }
+func (o foldedOrder) Less(i, j int) bool { return o.f.hashes[i] < o.f.hashes[j] }
+func (o foldedOrder) Swap(i, j int) {
+	o.f.hashes[i], o.f.hashes[j] = o.f.hashes[j], o.f.hashes[i]
+	o.f.ranks[i], o.f.ranks[j] = o.f.ranks[j], o.f.ranks[i]
+}
+
+// findFolded returns the index position of a title that matches when case
+// is ignored, preferring the earliest in the index.
+func (ts titleSet) findFolded(title string) (int, bool) {
+	f := ts.folded
+	if f == nil {
+		return 0, false
+	}
+	ts.buildFolded()
+	h := foldedHash([]byte(title))
+	pos, found := 0, false
+	for i, _ := slices.BinarySearch(f.hashes, h); i < len(f.hashes) && f.hashes[i] == h; i++ {
+		rank := int(f.ranks[i])
+		if p := int(ts.positions[rank]); (!found || p < pos) && strings.EqualFold(ts.titleAt(rank), title) {
+			pos, found = p, true
+		}
+	}
+	return pos, found
+}
+
+func (ts titleSet) Has(title string) bool {
+	_, ok := ts.Lookup(title)
+	return ok
+}
+
+// Lookup returns the index position of a title.
+func (ts titleSet) Lookup(title string) (int, bool) {
+	if i, ok := ts.find(title); ok {
+		return i, true
+	}
+	return ts.find(ucfirst(title))
+}
+
+// LookupFold returns the index position of a title as Lookup does, falling
+// back to a title that differs only in case.
+func (ts titleSet) LookupFold(title string) (int, bool) {
+	if i, ok := ts.Lookup(title); ok {
+		return i, true
+	}
+	return ts.findFolded(title)
+}
+
+// Resolve returns the title as it is spelled in the index.
+func (ts titleSet) Resolve(title string) (string, bool) {
+	if _, ok := ts.find(title); ok {
+		return title, true
+	}
+	title = ucfirst(title)
+	_, ok := ts.find(title)
+	return title, ok
+}
+
+// Len returns the number of distinct titles.
+func (ts titleSet) Len() int {
+	return len(ts.positions)
+}
+
+// Size returns the memory used by the dictionary, its filter and, once
+// built, the table of lower-cased titles in bytes.
+func (ts titleSet) Size() int {
+	size := ts.filter.Size() + len(ts.heads) + len(ts.blocks) + 4*(len(ts.headEnds)+len(ts.blockEnds)+len(ts.positions))
+	if ts.folded != nil {
+		size += int(ts.folded.size.Load())
+	}
+	return size
+}
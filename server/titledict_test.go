@@ -0,0 +1,37 @@
+package server
+
+import "testing"
+
+func TestFindPageByTitle(t *testing.T) {
+	var index []IndexEntry
+	for i, title := range []string{"Apple", "Apple pie", "Apples", "IPhone", "iPod", "List of apples", "apple sauce"} {
+		index = append(index, IndexEntry{PageID: i + 1, Title: title})
+	}
+	titles := newTitleSet(index)
+	for rank := range titles.Len() {
+		if got := index[titles.positions[rank]].Title; titles.titleAt(rank) != got {
+			t.Errorf("titleAt(%d) = %q, want %q", rank, titles.titleAt(rank), got)
+		}
+	}
+	tests := []struct {
+		title string
+		want  string // "" when missing
+	}{
+		{"Apple", "Apple"},
+		{"apple_pie", "Apple pie"}, // first-letter rule
+		{"LIST OF APPLES", "List of apples"},
+		{"apple sauce", "apple sauce"},
+		{"Apple Sauce", "apple sauce"},
+		{"ipod", "iPod"},
+		{"Pear", ""},
+	}
+	for _, tt := range tests {
+		var got string
+		if entry := findPageByTitle(index, titles, tt.title); entry != nil {
+			got = entry.Title
+		}
+		if got != tt.want {
+			t.Errorf("findPageByTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
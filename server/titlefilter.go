@@ -0,0 +1,69 @@
+package server
+
+import "hash/maphash"
+
+// titleFilterBitsPerTitle and titleFilterProbes size the title filter for
+// about one false positive in a hundred lookups of missing titles.
+const (
+	titleFilterBitsPerTitle = 10
+	titleFilterProbes       = 7
+)
+
+// titleFilterSeed is chosen per process; filters are rebuilt at load time
+// and never saved.
+var titleFilterSeed = maphash.MakeSeed()
+
+// titleFilter is a Bloom filter over the titles of a titleSet. Most links
+// checked for red-link styling point at pages that exist, but an article
+// can hold hundreds that don't, and each of those would otherwise cost two
+// dictionary searches (as written and with its first letter upper cased).
+// The filter answers them with a single cache line: the bits for a title
+// all fall in one 512-bit block.
+type titleFilter struct {
+	blocks [][8]uint64
+}
+
+// newTitleFilter returns an empty filter sized for n titles.
+func newTitleFilter(n int) titleFilter {
+	size := (n*titleFilterBitsPerTitle + 511) / 512
+	return titleFilter{blocks: make([][8]uint64, max(size, 1))}
+}
+
+// add records a title.
+func (f titleFilter) add(title string) {
+	block, h := f.locate(title)
+	for i := 0; i < titleFilterProbes; i++ {
+		bit := h >> (i * 9) & 511
+		block[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayHave reports whether a title might have been added. False means it
+// certainly wasn't.
+func (f titleFilter) mayHave(title string) bool {
+	if f.blocks == nil {
+		return true
+	}
+	block, h := f.locate(title)
+	for i := 0; i < titleFilterProbes; i++ {
+		bit := h >> (i * 9) & 511
+		if block[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// locate hashes a title to its block and the hash bits left for probes.
+func (f titleFilter) locate(title string) (*[8]uint64, uint64) {
+	h := maphash.String(titleFilterSeed, title)
+	hi, lo := h>>32, h&0xffffffff
+	block := &f.blocks[hi*uint64(len(f.blocks))>>32]
+	// Mix the low half so the 63 probe bits don't repeat the block choice
+	return block, lo*0x9e3779b97f4a7c15 ^ h
+}
+
+// Size returns the memory used by the filter in bytes.
+func (f titleFilter) Size() int {
+	return len(f.blocks) * 64
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// templateStat is the accumulated cost of one template
+type templateStat struct {
+	Name    string        `json:"name"`
+	Handled bool          `json:"handled"`
+	Count   int64         `json:"count"`
+	Total   time.Duration `json:"total_ns"`
+	Max     time.Duration `json:"max_ns"`
+}
+
+// templateProfiler times every template call made by the native
+// converter. Template arguments are expanded before the template itself,
+// so each time excludes the templates nested inside it.
+type templateProfiler struct {
+	mu      sync.Mutex
+	stats   map[string]*templateStat
+	started time.Time
+}
+
+func newTemplateProfiler() *templateProfiler {
+	return &templateProfiler{stats: make(map[string]*templateStat), started: time.Now()}
+}
+
+func (p *templateProfiler) record(name string, handled bool, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.stats[name]
+	if !ok {
+		s = &templateStat{Name: name, Handled: handled}
+		p.stats[name] = s
+	}
+	s.Count++
+	s.Total += d
+	if d > s.Max {
+		s.Max = d
+	}
+}
+
+// snapshot returns the stats ordered by the given key: total (default),
+// count or max.
+func (p *templateProfiler) snapshot(order string) []templateStat {
+	p.mu.Lock()
+	stats := make([]templateStat, 0, len(p.stats))
+	for _, s := range p.stats {
+		stats = append(stats, *s)
+	}
+	p.mu.Unlock()
+
+	key := func(s templateStat) int64 { return int64(s.Total) }
+	switch order {
+	case "count":
+		key = func(s templateStat) int64 { return s.Count }
+	case "max":
+		key = func(s templateStat) int64 { return int64(s.Max) }
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if key(stats[i]) != key(stats[j]) {
+			return key(stats[i]) > key(stats[j])
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+func (p *templateProfiler) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats = make(map[string]*templateStat)
+	p.started = time.Now()
+}
+
+// handleTemplateProfile lists template timings, slowest in total first
+// (?sort=count or ?sort=max to reorder, ?format=json for JSON). A POST
+// clears the collected timings.
+func handleTemplateProfile(w http.ResponseWriter, r *http.Request, templateProfile *templateProfiler) {
+	if r.Method == http.MethodPost {
+		templateProfile.reset()
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+	stats := templateProfile.snapshot(r.FormValue("sort"))
+	if r.FormValue("format") == "json" {
+		writeJSON(w, http.StatusOK, stats)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	templateProfile.mu.Lock()
+	since := templateProfile.started
+	templateProfile.mu.Unlock()
+	fmt.Fprintf(w, "Template calls since %s\n\n", since.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "%-40s %10s %12s %12s %12s\n", "template", "calls", "total", "mean", "max")
+	for _, s := range stats {
+		name := s.Name
+		if !s.Handled {
+			name += " (dropped)"
+		}
+		mean := s.Total / time.Duration(s.Count)
+		fmt.Fprintf(w, "%-40s %10d %12v %12v %12v\n", name, s.Count,
+			s.Total.Round(time.Microsecond), mean.Round(time.Microsecond), s.Max.Round(time.Microsecond))
+	}
+}
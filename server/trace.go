@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxTraces is how many recent request traces /debug/trace keeps.
+const maxTraces = 50
+
+// span is one timed step of a request
+type span struct {
+	Name   string
+	ID     string
+	Parent string
+	Start  time.Time
+	End    time.Time
+}
+
+// trace records the spans of one request. A nil trace records nothing, so
+// code paths can be instrumented unconditionally.
+type trace struct {
+	mu     sync.Mutex
+	ID     string
+	spans  []*span
+	tracer *tracer
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// start begins a trace whose root span covers the whole request. It
+// returns nil on a nil tracer, when tracing is off.
+func (tr *tracer) start(name string) *trace {
+	if tr == nil {
+		return nil
+	}
+	return &trace{
+		ID:     randomHex(16),
+		spans:  []*span{{Name: name, ID: randomHex(8), Start: time.Now()}},
+		tracer: tr,
+	}
+}
+
+// Start opens a child span of the request and returns the function that
+// closes it.
+func (t *trace) Start(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+	s := &span{Name: name, ID: randomHex(8), Parent: t.spans[0].ID, Start: time.Now()}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		s.End = time.Now()
+		t.mu.Unlock()
+	}
+}
+
+// Finish closes the root span and hands the trace to the tracer.
+func (t *trace) Finish() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.spans[0].End = time.Now()
+	t.mu.Unlock()
+	t.tracer.record(t)
+}
+
+// tracer keeps recent traces and optionally exports them to an OTLP/HTTP
+// collector, logging failed exports to log.
+type tracer struct {
+	mu     sync.Mutex
+	recent []*trace
+	export chan *trace
+	log    io.Writer
+}
+
+func newTracer(otlpEndpoint string, log io.Writer) *tracer {
+	t := &tracer{log: log}
+	if otlpEndpoint != "" {
+		t.export = make(chan *trace, 256)
+		go t.exportLoop(otlpEndpoint)
+	}
+	return t
+}
+
+func (tr *tracer) record(t *trace) {
+	tr.mu.Lock()
+	tr.recent = append(tr.recent, t)
+	if len(tr.recent) > maxTraces {
+		tr.recent = tr.recent[len(tr.recent)-maxTraces:]
+	}
+	tr.mu.Unlock()
+	if tr.export != nil {
+		select {
+		case tr.export <- t:
+		default:
+			// Drop traces rather than slow requests down when the
+			// collector can't keep up
+		}
+	}
+}
+
+// exportLoop sends traces to the collector in batches, in the OTLP/HTTP
+// JSON encoding.
+func (tr *tracer) exportLoop(endpoint string) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var batch []*trace
+	for {
+		select {
+		case t := <-tr.export:
+			batch = append(batch, t)
+			if len(batch) < 100 {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := postOTLP(endpoint, batch); err != nil {
+			fmt.Fprintf(tr.log, "[%s] Trace export failed: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
+		}
+		batch = nil
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string `json:"traceId"`
+	SpanID            string `json:"spanId"`
+	ParentSpanID      string `json:"parentSpanId,omitempty"`
+	Name              string `json:"name"`
+	Kind              int    `json:"kind"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string `json:"endTimeUnixNano"`
+}
+
+func postOTLP(endpoint string, traces []*trace) error {
+	var spans []otlpSpan
+	for _, t := range traces {
+		t.mu.Lock()
+		for _, s := range t.spans {
+			kind := 1 // internal
+			if s.Parent == "" {
+				kind = 2 // server
+			}
+			spans = append(spans, otlpSpan{
+				TraceID:           t.ID,
+				SpanID:            s.ID,
+				ParentSpanID:      s.Parent,
+				Name:              s.Name,
+				Kind:              kind,
+				StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+				EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			})
+		}
+		t.mu.Unlock()
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: "wikiseek"}}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "wikiseek"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// handleDebugTrace lists recent request traces, newest first, with the
+// duration of each span.
+func handleDebugTrace(w http.ResponseWriter, r *http.Request, tracing *tracer) {
+	tracing.mu.Lock()
+	recent := append([]*trace(nil), tracing.recent...)
+	tracing.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for i := len(recent) - 1; i >= 0; i-- {
+		t := recent[i]
+		t.mu.Lock()
+		root := t.spans[0]
+		fmt.Fprintf(w, "%s %s %v (trace %s)\n", root.Start.Format("2006-01-02 15:04:05"), root.Name, root.End.Sub(root.Start), t.ID)
+		for _, s := range t.spans[1:] {
+			fmt.Fprintf(w, "  %-24s +%-10v %v\n", s.Name, s.Start.Sub(root.Start).Round(time.Microsecond), s.End.Sub(s.Start))
+		}
+		t.mu.Unlock()
+		fmt.Fprintln(w)
+	}
+}
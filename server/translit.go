@@ -0,0 +1,187 @@
+package server
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+// cyrillicLatin romanizes Russian, Ukrainian, Belarusian and Serbian letters
+// the way English-language sources usually spell them.
+var cyrillicLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+	'ђ': "dj", 'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz",
+}
+
+// greekLatin romanizes modern Greek, accents included.
+var greekLatin = map[rune]string{
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+	'ά': "a", 'έ': "e", 'ή': "i", 'ί': "i", 'ό': "o", 'ύ': "y", 'ώ': "o",
+	'ϊ': "i", 'ϋ': "y", 'ΐ': "i", 'ΰ': "y",
+}
+
+// kanaLatin gives the Hepburn romanization of each hiragana; katakana are
+// mapped onto hiragana first.
+var kanaLatin = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o", 'ゎ': "wa",
+}
+
+// Hangul syllables are composed arithmetically from these jamo, romanized
+// with the Revised Romanization of Korean.
+var (
+	hangulInitials = []string{"g", "kk", "n", "d", "tt", "r", "m", "b", "pp", "s", "ss", "", "j", "jj", "ch", "k", "t", "p", "h"}
+	hangulVowels   = []string{"a", "ae", "ya", "yae", "eo", "e", "yeo", "ye", "o", "wa", "wae", "oe", "yo", "u", "wo", "we", "wi", "yu", "eu", "ui", "i"}
+	hangulFinals   = []string{"", "k", "k", "k", "n", "n", "n", "t", "l", "k", "m", "l", "l", "l", "p", "l", "m", "p", "p", "t", "t", "ng", "t", "t", "k", "t", "p", "t"}
+)
+
+// pinyinArgs asks for toneless pinyin, the way Chinese names are spelled
+// in English text: 北京 is beijing.
+var pinyinArgs = pinyin.NewArgs()
+
+const (
+	hangulBase  = 0xAC00
+	hangulLast  = 0xD7A3
+	katakanaMin = 0x30A1
+	katakanaMax = 0x30F6
+	kanaOffset  = 0x60
+)
+
+// transliterate lowercases s and spells Cyrillic, Greek, kana, Hangul and
+// Chinese characters with Latin letters, so "Москва" becomes "moskva" and
+// "北京" "beijing". Chinese characters take their most common Mandarin
+// reading, one character at a time, so kanji in Japanese titles are read
+// as Mandarin too. Other characters are kept.
+func transliterate(s string) string {
+	runes := []rune(strings.ToLower(s))
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r >= katakanaMin && r <= katakanaMax {
+			r -= kanaOffset
+		}
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+			if next >= katakanaMin && next <= katakanaMax {
+				next -= kanaOffset
+			}
+		}
+		switch {
+		case cyrillicLatin[r] != "" || r == 'ъ' || r == 'ь':
+			b.WriteString(cyrillicLatin[r])
+		case greekLatin[r] != "":
+			b.WriteString(greekLatin[r])
+		case r == 'っ':
+			// A small tsu doubles the following consonant.
+			if next != 0 && kanaLatin[next] != "" {
+				b.WriteByte(kanaLatin[next][0])
+			}
+		case r == 'ー' || r == 'う' && i > 0 && isKana(runes[i-1]) && (lastByte(&b) == 'o' || lastByte(&b) == 'u'):
+			// Long vowels are written short, as in "Tokyo" for とうきょう.
+		case kanaLatin[r] != "":
+			latin := kanaLatin[r]
+			// Small ya, yu and yo merge with the preceding kana: きゃ is kya.
+			if small := smallYKana(next); small != "" && strings.HasSuffix(latin, "i") {
+				latin = strings.TrimSuffix(latin, "i")
+				if latin == "sh" || latin == "ch" || latin == "j" {
+					latin += small[1:]
+				} else {
+					latin += small
+				}
+				i++
+			}
+			b.WriteString(latin)
+		case r >= hangulBase && r <= hangulLast:
+			n := int(r - hangulBase)
+			b.WriteString(hangulInitials[n/(21*28)])
+			b.WriteString(hangulVowels[n%(21*28)/28])
+			b.WriteString(hangulFinals[n%28])
+		case unicode.Is(unicode.Han, r):
+			if readings := pinyin.SinglePinyin(r, pinyinArgs); len(readings) > 0 {
+				// ü is written v in plain pinyin; English text drops the dots
+				b.WriteString(strings.ReplaceAll(readings[0], "v", "u"))
+			} else {
+				b.WriteRune(r)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isKana reports whether r is hiragana or katakana.
+func isKana(r rune) bool {
+	return r >= 'ぁ' && r <= 'ゖ' || r >= katakanaMin && r <= 'ー'
+}
+
+func lastByte(b *strings.Builder) byte {
+	s := b.String()
+	if s == "" {
+		return 0
+	}
+	return s[len(s)-1]
+}
+
+// smallYKana returns the romanization of a small ya, yu or yo.
+func smallYKana(r rune) string {
+	switch r {
+	case 'ゃ':
+		return "ya"
+	case 'ゅ':
+		return "yu"
+	case 'ょ':
+		return "yo"
+	}
+	return ""
+}
+
+// buildTransliterations romanizes every title that isn't plain ASCII,
+// keeping only those that transliteration changes.
+func buildTransliterations(index []IndexEntry) map[int]string {
+	titles := make(map[int]string)
+	for _, entry := range index {
+		if isASCII(entry.Title) {
+			continue
+		}
+		if latin := transliterate(entry.Title); latin != strings.ToLower(entry.Title) {
+			titles[entry.PageID] = latin
+		}
+	}
+	return titles
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Typographic templates: the dashes, dots, spaces and superscripts that
+// infoboxes and lists are full of. Templates that stand for fixed text
+// are looked up in typographyText; the rest have small handlers.
+
+// typographyText maps templates to the wikitext they stand for. Separators
+// such as {{·}} keep to the item before them, like MediaWiki's.
+var typographyText = map[string]string{
+	"·":              "&nbsp;<b>·</b> ",
+	"dot":            "&nbsp;<b>·</b> ",
+	"•":              "&nbsp;<b>•</b> ",
+	"middot":         "·",
+	"bull":           "•",
+	"ndash":          "–",
+	"en dash":        "–",
+	"mdash":          "—",
+	"em dash":        "—",
+	"snd":            "&nbsp;– ",
+	"spnd":           "&nbsp;– ",
+	"sndash":         "&nbsp;– ",
+	"spaced ndash":   "&nbsp;– ",
+	"spaced en dash": "&nbsp;– ",
+	"spd":            "&nbsp;— ",
+	"spaced mdash":   "&nbsp;— ",
+	"spaced em dash": "&nbsp;— ",
+	"minus":          "−",
+	"−":              "−",
+	"times":          "×",
+	"×":              "×",
+	"thinsp":         "&thinsp;",
+	"hairsp":         "&#8202;",
+	"zwsp":           "&#8203;",
+	"nbhyph":         "&#8209;",
+	"'":              "&#39;",
+	"nowrap begin":   `<span class="nowrap">`,
+	"nowrap end":     "</span>",
+	"wrap":           `</span> <span class="nowrap">`,
+}
+
+func init() {
+	for name, text := range typographyText {
+		defaultTemplates.Register(name, func(args []string) string { return text })
+	}
+	defaultTemplates.Register("sup", wrapTemplate("<sup>", "</sup>"))
+	defaultTemplates.Register("sub", wrapTemplate("<sub>", "</sub>"))
+	defaultTemplates.Register("small", wrapTemplate("<small>", "</small>"))
+	defaultTemplates.Register("big", wrapTemplate("<big>", "</big>"))
+	nowrap := wrapTemplate(`<span class="nowrap">`, "</span>")
+	defaultTemplates.Register("nowrap", nowrap)
+	defaultTemplates.Register("nobr", nowrap)
+	defaultTemplates.Register("su", suTemplate)
+	defaultTemplates.Register("abbr", abbrTemplate)
+	defaultTemplates.Register("nbsp", nbspTemplate)
+	defaultTemplates.Register("ordinal", ordinalTemplate)
+	defaultTemplates.Register("frac", fracTemplate)
+}
+
+// typographyArgs returns a template's positional arguments, with 1=, 2=
+// and so on put in their places.
+func typographyArgs(args []string) []string {
+	positional, named := templateArgs(args)
+	for i := 1; ; i++ {
+		value, ok := named[strconv.Itoa(i)]
+		if !ok {
+			return positional
+		}
+		if i <= len(positional) {
+			positional[i-1] = value
+		} else {
+			positional = append(positional, value)
+		}
+	}
+}
+
+// wrapTemplate returns a handler putting its first argument between open
+// and close, as {{sup|st}} and {{nowrap|10 km}} do.
+func wrapTemplate(open, close string) TemplateHandler {
+	return func(args []string) string {
+		positional := typographyArgs(args)
+		if len(positional) == 0 || positional[0] == "" {
+			return ""
+		}
+		return open + positional[0] + close
+	}
+}
+
+// suTemplate renders {{su|p=upper|b=lower}}, a superscript stacked over a
+// subscript.
+func suTemplate(args []string) string {
+	_, named := templateArgs(args)
+	upper, lower := named["p"], named["b"]
+	if upper == "" && lower == "" {
+		return ""
+	}
+	return `<span class="su"><sup>` + upper + `</sup><sub>` + lower + `</sub></span>`
+}
+
+// abbrTemplate renders {{abbr|short|meaning}}.
+func abbrTemplate(args []string) string {
+	positional := typographyArgs(args)
+	switch len(positional) {
+	case 0:
+		return ""
+	case 1:
+		return positional[0]
+	}
+	return `<abbr title="` + escapeText(plainText(positional[1])) + `">` + positional[0] + `</abbr>`
+}
+
+// nbspTemplate renders {{nbsp}} and {{nbsp|3}}.
+func nbspTemplate(args []string) string {
+	n := 1
+	if positional := typographyArgs(args); len(positional) > 0 {
+		if v, err := strconv.Atoi(positional[0]); err == nil && v > 0 {
+			n = min(v, 20)
+		}
+	}
+	return strings.Repeat("&nbsp;", n)
+}
+
+// ordinalTemplate renders {{ordinal|3}} as 3rd, or with sup=yes as
+// 3<sup>rd</sup>. Suffixes are English, as on the English Wikipedia.
+func ordinalTemplate(args []string) string {
+	positional, named := templateArgs(args)
+	if len(positional) == 0 {
+		return ""
+	}
+	n, err := strconv.Atoi(strings.ReplaceAll(positional[0], ",", ""))
+	if err != nil {
+		return positional[0]
+	}
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	switch strings.ToLower(named["sup"]) {
+	case "y", "yes", "1", "true":
+		suffix = "<sup>" + suffix + "</sup>"
+	}
+	return positional[0] + suffix
+}
+
+// fracTemplate renders {{frac|b}} as 1⁄b, {{frac|a|b}} as a⁄b and
+// {{frac|w|a|b}} as w a⁄b.
+func fracTemplate(args []string) string {
+	positional := typographyArgs(args)
+	var whole, num, den string
+	switch len(positional) {
+	case 0:
+		return "⁄"
+	case 1:
+		num, den = "1", positional[0]
+	case 2:
+		num, den = positional[0], positional[1]
+	default:
+		whole, num, den = positional[0], positional[1], positional[2]
+	}
+	out := `<span class="frac">`
+	if whole != "" {
+		out += whole + "&nbsp;"
+	}
+	return out + "<sup>" + num + "</sup>⁄<sub>" + den + "</sub></span>"
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	defaultTemplates.registerLocalized("convert", convertTemplate)
+	defaultTemplates.registerLocalized("cvt", func(l *locale, args []string) string {
+		return convertTemplate(l, append(args, "abbr=on"))
+	})
+}
+
+// unit is a unit {{convert}} knows. Values are converted through the base
+// unit of the dimension: base = value*factor + offset.
+type unit struct {
+	symbol, name, plural string
+	dimension            string
+	factor, offset       float64
+	// to is the unit converted to when the template names none
+	to string
+}
+
+var units = map[string]unit{
+	"m":     {"m", "metre", "metres", "length", 1, 0, "ft"},
+	"km":    {"km", "kilometre", "kilometres", "length", 1000, 0, "mi"},
+	"cm":    {"cm", "centimetre", "centimetres", "length", 0.01, 0, "in"},
+	"mm":    {"mm", "millimetre", "millimetres", "length", 0.001, 0, "in"},
+	"ft":    {"ft", "foot", "feet", "length", 0.3048, 0, "m"},
+	"in":    {"in", "inch", "inches", "length", 0.0254, 0, "cm"},
+	"mi":    {"mi", "mile", "miles", "length", 1609.344, 0, "km"},
+	"yd":    {"yd", "yard", "yards", "length", 0.9144, 0, "m"},
+	"nmi":   {"nmi", "nautical mile", "nautical miles", "length", 1852, 0, "km"},
+	"km2":   {"km<sup>2</sup>", "square kilometre", "square kilometres", "area", 1e6, 0, "sqmi"},
+	"m2":    {"m<sup>2</sup>", "square metre", "square metres", "area", 1, 0, "sqft"},
+	"ha":    {"ha", "hectare", "hectares", "area", 1e4, 0, "acre"},
+	"sqmi":  {"sq mi", "square mile", "square miles", "area", 2589988.110336, 0, "km2"},
+	"sqft":  {"sq ft", "square foot", "square feet", "area", 0.09290304, 0, "m2"},
+	"acre":  {"acres", "acre", "acres", "area", 4046.8564224, 0, "ha"},
+	"kg":    {"kg", "kilogram", "kilograms", "mass", 1, 0, "lb"},
+	"g":     {"g", "gram", "grams", "mass", 0.001, 0, "oz"},
+	"t":     {"t", "tonne", "tonnes", "mass", 1000, 0, "LT"},
+	"lb":    {"lb", "pound", "pounds", "mass", 0.45359237, 0, "kg"},
+	"oz":    {"oz", "ounce", "ounces", "mass", 0.028349523125, 0, "g"},
+	"LT":    {"long tons", "long ton", "long tons", "mass", 1016.0469088, 0, "t"},
+	"L":     {"L", "litre", "litres", "volume", 0.001, 0, "USgal"},
+	"USgal": {"US gal", "US gallon", "US gallons", "volume", 0.003785411784, 0, "L"},
+	"C":     {"°C", "degree Celsius", "degrees Celsius", "temperature", 1, 273.15, "F"},
+	"F":     {"°F", "degree Fahrenheit", "degrees Fahrenheit", "temperature", 5.0 / 9, 273.15 - 32*5.0/9, "C"},
+	"K":     {"K", "kelvin", "kelvins", "temperature", 1, 0, "C"},
+	"km/h":  {"km/h", "kilometre per hour", "kilometres per hour", "speed", 1 / 3.6, 0, "mph"},
+	"mph":   {"mph", "mile per hour", "miles per hour", "speed", 0.44704, 0, "km/h"},
+	"m/s":   {"m/s", "metre per second", "metres per second", "speed", 1, 0, "ft/s"},
+	"ft/s":  {"ft/s", "foot per second", "feet per second", "speed", 0.3048, 0, "m/s"},
+	"kn":    {"kn", "knot", "knots", "speed", 1852.0 / 3600, 0, "km/h"},
+}
+
+// unitAliases maps alternative spellings used in articles to unit keys
+var unitAliases = map[string]string{
+	"km²": "km2", "m²": "m2", "sqkm": "km2", "mi2": "sqmi", "ft2": "sqft", "acres": "acre",
+	"°C": "C", "°F": "F", "degC": "C", "degF": "F", "kph": "km/h", "kmh": "km/h",
+	"l": "L", "usgal": "USgal", "feet": "ft", "foot": "ft", "miles": "mi", "mile": "mi",
+	"kt": "kn", "knot": "kn", "knots": "kn", "tonne": "t", "tonnes": "t",
+}
+
+func lookupUnit(name string) (unit, bool) {
+	if key, ok := unitAliases[name]; ok {
+		name = key
+	}
+	u, ok := units[name]
+	return u, ok
+}
+
+// rangeWords are the separators {{convert}} accepts between two values
+var rangeWords = map[string]string{"-": "–", "–": "–", "to": " to ", "and": " and ", "or": " or "}
+
+// convertTemplate renders {{convert|value|unit|to unit}} and the range
+// form {{convert|1|to|5|km}} as the value followed by the converted value
+// in brackets. The converted value gets about as many significant digits as
+// the input, unless a precision is given after the units. Numbers follow
+// the -ui-lang locale.
+func convertTemplate(l *locale, args []string) string {
+	positional, named := templateArgs(args)
+	if len(positional) < 2 {
+		return strings.Join(positional, " ")
+	}
+	values := []string{positional[0]}
+	sep := ""
+	rest := positional[1:]
+	if s, ok := rangeWords[rest[0]]; ok && len(rest) >= 3 {
+		sep = s
+		values = append(values, rest[1])
+		rest = rest[2:]
+	}
+	from, ok := lookupUnit(rest[0])
+	if !ok {
+		return strings.Join(positional, " ")
+	}
+	to, ok := units[from.to]
+	if len(rest) > 1 && rest[1] != "" {
+		to, ok = lookupUnit(rest[1])
+	}
+	if !ok || to.dimension != from.dimension {
+		return strings.Join(positional, " ")
+	}
+	precision := -1
+	if len(rest) > 2 {
+		if p, err := strconv.Atoi(rest[2]); err == nil {
+			precision = p
+		}
+	}
+
+	var in, out []string
+	last := 0.0
+	for _, v := range values {
+		value, err := parseNumber(v)
+		if err != nil {
+			return strings.Join(positional, " ")
+		}
+		last = value
+		in = append(in, l.formatNumberText(v))
+		converted := (value*from.factor + from.offset - to.offset) / to.factor
+		out = append(out, l.formatNumber(roundConverted(converted, v, precision)))
+	}
+
+	abbr := named["abbr"] == "on" || named["abbr"] == "yes"
+	fromLabel := from.symbol
+	if !abbr {
+		fromLabel = from.plural
+		if len(values) == 1 && last == 1 {
+			fromLabel = from.name
+		}
+	}
+	return strings.Join(in, sep) + "&nbsp;" + fromLabel + " (" + strings.Join(out, sep) + "&nbsp;" + to.symbol + ")"
+}
+
+// roundConverted rounds a converted value to precision decimal places, or
+// when precision is negative to the significant digits of the input text
+// (at least two).
+func roundConverted(v float64, input string, precision int) (float64, int) {
+	if precision < 0 {
+		digits := strings.NewReplacer(",", "", "-", "").Replace(strings.TrimSpace(input))
+		if whole, frac, ok := strings.Cut(digits, "."); ok {
+			digits = whole + frac
+		} else {
+			// Trailing zeros of a whole number only place the digits
+			digits = strings.TrimRight(digits, "0")
+		}
+		digits = strings.TrimLeft(digits, "0")
+		if v == 0 {
+			return 0, 0
+		}
+		sig := max(2, len(digits))
+		precision = sig - 1 - int(math.Floor(math.Log10(math.Abs(v))))
+	}
+	scale := math.Pow(10, float64(precision))
+	rounded := math.Round(v*scale) / scale
+	return rounded, max(0, precision)
+}
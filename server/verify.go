@@ -0,0 +1,193 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/xanderstrike/wikiseek/dump"
+)
+
+// runVerify implements "wikiseek verify": it checks that every offset in
+// the index points at a bzip2 stream of the dump and, with -deep, that
+// each stream holds the pages the index puts in it. It exits non-zero when
+// anything is wrong, so it can gate a download or a deploy.
+func runVerify(args []string) {
+	fs := newCommandFlags("verify")
+	inputFile, indexPath := indexFlags(fs)
+	deep := fs.Bool("deep", false, "Also decompress every stream and check it holds the pages the index lists")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of streams to decompress in parallel with -deep")
+	fs.Parse(args)
+	requireIndexFlags(fs, *inputFile, *indexPath)
+
+	problems, err := verifyDump(*inputFile, *indexPath, *deep, *workers)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if problems > 0 {
+		fmt.Printf("Found %d problems; if offsets are wrong, `wikiseek index repair` rewrites the index to match the dump\n", problems)
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
+
+// verifyDump runs the verify checks, printing what it finds, and returns
+// the number of problems.
+func verifyDump(inputFile, indexPath string, deep bool, workers int) (int, error) {
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return 0, fmt.Errorf("opening dump: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("reading dump: %v", err)
+	}
+
+	index, err := dump.OpenIndex(indexPath)
+	if err != nil {
+		return 0, err
+	}
+	defer index.Close()
+	reader := dump.NewIndexReader(index)
+
+	pages := make(map[int64][]int) // stream start to page IDs, kept with -deep
+	var starts []int64
+	lines, unordered := 0, 0
+	var last int64 = -1
+	for {
+		line, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("reading index: %v", err)
+		}
+		lines++
+		if line.Offset != last {
+			if line.Offset < last {
+				unordered++
+			}
+			if _, seen := pages[line.Offset]; !seen {
+				starts = append(starts, line.Offset)
+				pages[line.Offset] = nil
+			}
+			last = line.Offset
+		}
+		if deep {
+			pages[line.Offset] = append(pages[line.Offset], line.PageID)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	fmt.Printf("Index lists %d pages in %d streams", lines, len(starts))
+	if skipped := reader.Stats.Skipped(); skipped > 0 {
+		fmt.Printf(" (%d malformed lines skipped)", skipped)
+	}
+	fmt.Println()
+
+	problems := 0
+	report := func(format string, args ...any) {
+		problems++
+		if problems <= maxRepairHints {
+			fmt.Printf("  "+format+"\n", args...)
+		} else if problems == maxRepairHints+1 {
+			fmt.Println("  ...")
+		}
+	}
+	if unordered > 0 {
+		report("%d offsets are lower than the one before; the index should be in file order", unordered)
+	}
+
+	header := make([]byte, 10)
+	for _, start := range starts {
+		if start >= info.Size() {
+			report("offset %d is past the end of the dump (%d bytes); is the download complete?", start, info.Size())
+			continue
+		}
+		n, err := f.ReadAt(header, start)
+		if err != nil && err != io.EOF {
+			return problems, fmt.Errorf("reading dump: %v", err)
+		}
+		if err := dump.ValidateStreamStart(header[:n], start); err != nil {
+			report("offset %d does not start a bzip2 stream", start)
+		}
+	}
+	fmt.Printf("Checked %d stream offsets against a %d byte dump\n", len(starts), info.Size())
+
+	if deep && problems == 0 {
+		verifyStreamPages(inputFile, starts, pages, workers, report)
+	}
+	return problems, nil
+}
+
+// verifyStreamPages decompresses every stream and reports those that fail
+// to decompress or lack pages the index puts in them.
+func verifyStreamPages(inputFile string, starts []int64, pages map[int64][]int, workers int, report func(string, ...any)) {
+	if workers < 1 {
+		workers = 1
+	}
+	type result struct {
+		start   int64
+		err     error
+		missing []int
+	}
+	work := make(chan int)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				s := dump.Stream{Start: starts[i]}
+				if i+1 < len(starts) {
+					s.End = starts[i+1]
+				}
+				r := result{start: s.Start}
+				found := make(map[int]bool)
+				for page, err := range s.Pages(inputFile) {
+					if err != nil {
+						r.err = err
+						break
+					}
+					found[page.ID] = true
+				}
+				if r.err == nil {
+					for _, id := range pages[s.Start] {
+						if !found[id] {
+							r.missing = append(r.missing, id)
+						}
+					}
+				}
+				results <- r
+			}
+		}()
+	}
+	go func() {
+		for i := range starts {
+			work <- i
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	done := 0
+	for r := range results {
+		done++
+		if done%1000 == 0 {
+			fmt.Printf("Decompressed %d of %d streams\n", done, len(starts))
+		}
+		switch {
+		case r.err != nil:
+			report("stream at %d: %v", r.start, r.err)
+		case len(r.missing) > 0:
+			report("stream at %d lacks %d indexed pages, such as page ID %d", r.start, len(r.missing), r.missing[0])
+		}
+	}
+	fmt.Printf("Decompressed %d streams\n", len(starts))
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"html/template"
+	"path/filepath"
+)
+
+// Layout is what templates/layout.html needs on every page: the dump named
+// in the footer and the query shown in the search box.
+type Layout struct {
+	Dump  *DumpInfo
+	Query string
+}
+
+// HomeView is the front page and its -home-panels.
+type HomeView struct {
+	Layout
+	Panels       []homePanelView
+	IndexFile    string
+	ArticleCount int
+	Description  string
+}
+
+// ArticleView is a rendered article, or a generated page such as /about
+// shown like one.
+type ArticleView struct {
+	Layout
+	Title          string
+	DisplayTitle   string
+	Content        template.HTML
+	Error          string
+	Description    string
+	CanonicalURL   string
+	Quality        string
+	Fallback       *fallbackNotice // set when the article came from a fallback wiki
+	Links          []string        // articles linked from this one, sorted
+	LinkPrefix     string          // path the linked articles are served under
+	Highlight      string          // term marked in Content, from ?highlight=
+	HighlightCount int
+	Pagination     *articlePagination // set when only some sections are shown
+	Lang           string             // language of Content, when known
+	Dir            string             // direction of Content
+	Collectable    bool               // offer the "Add to collection" form
+	AddedTo        string             // collection the article was just added to
+	AddedToURL     string
+}
+
+// SearchView is a page of title and full-text search results.
+type SearchView struct {
+	Layout
+	Error       string
+	Results     []ResultGroup
+	ResultCount int
+	FullText    []FullTextResult
+	AlsoIn      map[string][]string // extra wikis that also have a result's title
+	Suggestions []string            // spelling corrections when nothing matched
+}
+
+// parsePageTemplate parses a page template from assetDir's templates/
+// together with the shared layout it fills in.
+func parsePageTemplate(assetDir, name string, funcMap template.FuncMap) (*template.Template, error) {
+	return template.New(name).Funcs(funcMap).ParseFiles(
+		filepath.Join(assetDir, "templates", name),
+		filepath.Join(assetDir, "templates", "layout.html"),
+	)
+}
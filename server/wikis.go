@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// wikiSource is one loaded dump and everything needed to serve its
+// articles. The -file dump is the primary wiki, served under /wiki/; dumps
+// added with -extra-dump are served under /w/{name}/ and searched
+// alongside it.
+type wikiSource struct {
+	Name      string // database name such as simplewiki
+	Prefix    string // path articles are served under
+	Info      *DumpInfo
+	InputFile string
+	Index     []IndexEntry
+	Titles    titleSet
+	Pipeline  *Pipeline
+	Cache     *renderCache
+	Views     *viewTracker // nil for extra wikis
+	Speller   *spellModel  // nil for extra wikis
+	Names     *titleNames  // nil for extra wikis
+}
+
+// extraDumpList collects repeated -extra-dump flags
+type extraDumpList []string
+
+func (l *extraDumpList) String() string { return strings.Join(*l, " ") }
+
+func (l *extraDumpList) Set(s string) error {
+	if _, _, ok := strings.Cut(s, ","); !ok {
+		return fmt.Errorf("want DUMP,INDEX")
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+// wikiName is the name a dump is served under: its database name, or the
+// file name up to the first dash when the header can't be read.
+func wikiName(info *DumpInfo) string {
+	if info.DBName != "" {
+		return info.DBName
+	}
+	name, _, _ := strings.Cut(strings.TrimSuffix(info.File, filepath.Ext(info.File)), "-")
+	return name
+}
+
+// loadExtraWiki loads a dump given as "DUMP,INDEX", rendering it with the
+// server's settings. Extra wikis have their own index, render cache and
+// pipeline, but no page metadata or full-text index.
+func (s *Server) loadExtraWiki(spec string, taken map[string]bool, settings renderSettings) (*wikiSource, error) {
+	inputFile, indexPath, _ := strings.Cut(spec, ",")
+	info := loadDumpInfo(inputFile, s.log)
+	name := wikiName(info)
+	// Older snapshots of a loaded wiki are told apart by their date
+	if taken[name] && info.DumpDate != "" {
+		name += "-" + strings.ReplaceAll(info.DumpDate, "-", "")
+	}
+	if name == "" || taken[name] {
+		return nil, fmt.Errorf("%s: a wiki named %q is already loaded", inputFile, name)
+	}
+	loaded, err := loadIndex(indexPath, loadNamespaces(inputFile, s.log), s.log)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", indexPath, err)
+	}
+	titles := loaded.titleSet()
+	// An extra dump from another project, such as a Wikivoyage served
+	// next to Wikipedia, gets that project's template handlers
+	if s.cfg.Project == "auto" {
+		settings.templates = s.projectTemplates(projectProfiles[guessProject(info)])
+	}
+	pipeline, err := newPipeline(s.cfg.Stages, inputFile, titles, newMetaStore(), settings)
+	if err != nil {
+		return nil, err
+	}
+	taken[name] = true
+	return &wikiSource{
+		Name:      name,
+		Prefix:    "/w/" + name + "/",
+		Info:      info,
+		InputFile: inputFile,
+		Index:     loaded.Entries,
+		Titles:    titles,
+		Pipeline:  pipeline,
+		Cache:     newRenderCache(s.cfg.RenderCache),
+	}, nil
+}
+
+// findWiki returns the extra wiki a /w/{name}/... path belongs to.
+func findWiki(wikis []*wikiSource, path string) *wikiSource {
+	name, _, _ := strings.Cut(strings.TrimPrefix(path, "/w/"), "/")
+	for _, w := range wikis {
+		if w.Name == name {
+			return w
+		}
+	}
+	return nil
+}
+
+// fallbackNotice explains on an article page that it was served from a
+// fallback wiki because the wiki asked for lacks it.
+type fallbackNotice struct {
+	Missing *DumpInfo
+	Source  *DumpInfo
+	URL     string // the article's own path in the fallback wiki
+}
+
+// fallbackOrder resolves -fallback to the extra wikis to try, in order.
+func fallbackOrder(spec string, extras []*wikiSource) ([]*wikiSource, error) {
+	switch spec {
+	case "":
+		return extras, nil
+	case "none":
+		return nil, nil
+	}
+	var order []*wikiSource
+	for _, name := range strings.Split(spec, ",") {
+		wiki := findWiki(extras, "/w/"+strings.TrimSpace(name)+"/")
+		if wiki == nil {
+			return nil, fmt.Errorf("-fallback names %q, which is not an -extra-dump", name)
+		}
+		order = append(order, wiki)
+	}
+	return order, nil
+}
+
+// fallbackWiki finds the first wiki in order with a title missing from the
+// primary, for bare /wiki/ links.
+func fallbackWiki(wikis []*wikiSource, title string) (*wikiSource, string) {
+	title = strings.ReplaceAll(title, "_", " ")
+	for _, w := range wikis {
+		if found, ok := w.Titles.Resolve(title); ok {
+			return w, found
+		}
+	}
+	return nil, ""
+}
+
+// searchWikis adds the title matches of the extra wikis to a search.
+// Titles the primary wiki also has are listed under its result, labelled
+// with the other wikis that have them; the rest form one group per wiki.
+func searchWikis(data *SearchView, primary titleSet, wikis []*wikiSource, query string) {
+	for _, w := range wikis {
+		var only []IndexEntry
+		for _, group := range searchIndex(w.Index, query, nil, nil, w.Names) {
+			for _, entry := range group.Entries {
+				if primary.Has(entry.Title) {
+					if data.AlsoIn == nil {
+						data.AlsoIn = make(map[string][]string)
+					}
+					data.AlsoIn[entry.Title] = append(data.AlsoIn[entry.Title], w.Name)
+					continue
+				}
+				only = append(only, entry)
+			}
+		}
+		if len(only) == 0 {
+			continue
+		}
+		group := ResultGroup{Label: "Only in " + w.Name, Wiki: w.Name, Entries: only, Shown: only}
+		if len(only) > groupPreviewSize {
+			group.Shown, group.Hidden = only[:groupPreviewSize], only[groupPreviewSize:]
+		}
+		data.Results = append(data.Results, group)
+		data.ResultCount += len(only)
+	}
+}
+
+// searchResultView is what the search template needs to show one title
+// match: the entry, the wiki it comes from ("" for the primary) and the
+// extra wikis that have the same title.
+type searchResultView struct {
+	Wiki   string
+	Entry  IndexEntry
+	AlsoIn []string
+}
+
+func newSearchResultView(wiki string, entry IndexEntry, alsoIn map[string][]string) searchResultView {
+	v := searchResultView{Wiki: wiki, Entry: entry}
+	if wiki == "" {
+		v.AlsoIn = alsoIn[entry.Title]
+	}
+	return v
+}
+
+// wikiPrefix is the template function giving the article path of a wiki
+// by name, "" being the primary.
+func wikiPrefix(name string) string {
+	if name == "" {
+		return "/wiki/"
+	}
+	return "/w/" + name + "/"
+}
+
+// articlePath gives the URL of an article served under basePath and
+// prefix, for the articlepath template function. It is typed as a URL
+// since html/template can't tell that a title such as "Star Trek: Voyager"
+// following a prefix it didn't see is not a scheme.
+func articlePath(basePath, prefix, title string) template.URL {
+	return template.URL(basePath + prefix + strings.ReplaceAll(title, " ", "_"))
+}
+
+// handleWiki serves /w/{name}/{title} from an extra wiki.
+func handleWiki(w http.ResponseWriter, r *http.Request, tmpl, printTmpl, errorTmpl *template.Template, wikis []*wikiSource) {
+	wiki := findWiki(wikis, r.URL.Path)
+	if wiki == nil {
+		http.NotFound(w, r)
+		return
+	}
+	handlePage(w, r, tmpl, printTmpl, errorTmpl, wiki, nil)
+}
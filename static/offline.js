@@ -0,0 +1,13 @@
+// Registers the service worker of -offline-search and -pwa, and has it
+// refresh its copy of the title lists.
+(function () {
+    var script = document.currentScript;
+    var base = script ? script.getAttribute('data-base') || '' : '';
+    if (!('serviceWorker' in navigator)) return;
+
+    navigator.serviceWorker.register(base + '/sw.js', { scope: base + '/' }).then(function () {
+        return navigator.serviceWorker.ready;
+    }).then(function (registration) {
+        if (registration.active) registration.active.postMessage('warm');
+    }).catch(function () {});
+})();
@@ -0,0 +1,106 @@
+// Ctrl-K / Cmd-K quick switcher backed by /api/quickopen
+(function () {
+    var script = document.currentScript;
+    var base = script ? script.getAttribute('data-base') || '' : '';
+    var overlay, input, list, results = [], selected = 0, pending;
+
+    function build() {
+        overlay = document.createElement('div');
+        overlay.className = 'quickopen-overlay';
+        overlay.hidden = true;
+        overlay.innerHTML = '<div class="quickopen" role="dialog" aria-label="Quick open">' +
+            '<input type="text" placeholder="Jump to article..." aria-label="Article title" autocomplete="off">' +
+            '<ul role="listbox"></ul></div>';
+        document.body.appendChild(overlay);
+        input = overlay.querySelector('input');
+        list = overlay.querySelector('ul');
+
+        overlay.addEventListener('mousedown', function (e) {
+            if (e.target === overlay) close();
+        });
+        input.addEventListener('input', function () {
+            clearTimeout(pending);
+            pending = setTimeout(fetchResults, 50);
+        });
+        input.addEventListener('keydown', function (e) {
+            if (e.key === 'ArrowDown') {
+                e.preventDefault();
+                select(selected + 1);
+            } else if (e.key === 'ArrowUp') {
+                e.preventDefault();
+                select(selected - 1);
+            } else if (e.key === 'Enter') {
+                e.preventDefault();
+                if (results[selected]) {
+                    window.location = results[selected].url;
+                } else if (input.value) {
+                    window.location = base + '/search?q=' + encodeURIComponent(input.value);
+                }
+            } else if (e.key === 'Escape') {
+                close();
+            }
+        });
+    }
+
+    function fetchResults() {
+        var q = input.value;
+        if (!q) {
+            render([]);
+            return;
+        }
+        fetch(base + '/api/quickopen?q=' + encodeURIComponent(q))
+            .then(function (r) { return r.json(); })
+            .then(function (data) {
+                if (input.value === q) render(data);
+            })
+            .catch(function () { render([]); });
+    }
+
+    function render(data) {
+        results = data;
+        list.innerHTML = '';
+        data.forEach(function (r, i) {
+            var li = document.createElement('li');
+            li.setAttribute('role', 'option');
+            var a = document.createElement('a');
+            a.href = r.url;
+            a.textContent = r.title;
+            li.appendChild(a);
+            li.addEventListener('mouseenter', function () { select(i); });
+            list.appendChild(li);
+        });
+        select(0);
+    }
+
+    function select(i) {
+        if (!results.length) return;
+        selected = (i + results.length) % results.length;
+        Array.prototype.forEach.call(list.children, function (li, j) {
+            li.classList.toggle('selected', j === selected);
+            li.setAttribute('aria-selected', j === selected);
+        });
+    }
+
+    function open() {
+        if (!overlay) build();
+        overlay.hidden = false;
+        input.value = '';
+        render([]);
+        input.focus();
+    }
+
+    function close() {
+        overlay.hidden = true;
+    }
+
+    document.addEventListener('keydown', function (e) {
+        if ((e.ctrlKey || e.metaKey) && e.key.toLowerCase() === 'k') {
+            e.preventDefault();
+            if (overlay && !overlay.hidden) {
+                close();
+            } else {
+                open();
+            }
+        }
+    });
+})();
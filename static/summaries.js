@@ -0,0 +1,55 @@
+// Fills in descriptions and thumbnails for search results from
+// /api/summaries once the page has loaded.
+(function () {
+    var script = document.currentScript;
+    var base = script ? script.getAttribute('data-base') || '' : '';
+
+    function load() {
+        var results = document.querySelectorAll('.result[data-page-id]');
+        var ids = [];
+        results.forEach(function (el) {
+            var id = el.getAttribute('data-page-id');
+            if (ids.indexOf(id) < 0) ids.push(id);
+        });
+        for (var i = 0; i < ids.length; i += 100) {
+            fetchBatch(ids.slice(i, i + 100), results);
+        }
+    }
+
+    function fetchBatch(ids, results) {
+        fetch(base + '/api/summaries?ids=' + ids.join(','))
+            .then(function (r) { return r.ok ? r.json() : { pages: {} }; })
+            .then(function (data) {
+                results.forEach(function (el) {
+                    var page = data.pages[el.getAttribute('data-page-id')];
+                    if (page) decorate(el, page);
+                });
+            })
+            .catch(function () {});
+    }
+
+    function decorate(el, page) {
+        if (page.thumbnail && !el.querySelector('.result-thumb')) {
+            var img = document.createElement('img');
+            img.className = 'result-thumb';
+            img.src = page.thumbnail;
+            img.alt = '';
+            img.loading = 'lazy';
+            img.onerror = function () { img.remove(); };
+            el.insertBefore(img, el.firstChild);
+        }
+        if (page.description && !el.querySelector('.result-description')) {
+            var p = document.createElement('p');
+            p.className = 'result-description';
+            p.textContent = page.description;
+            var heading = el.querySelector('h3');
+            heading.parentNode.insertBefore(p, heading.nextSibling);
+        }
+    }
+
+    if (document.readyState === 'loading') {
+        document.addEventListener('DOMContentLoaded', load);
+    } else {
+        load();
+    }
+})();
@@ -0,0 +1,115 @@
+// Sortable tables and collapsible tables and navboxes, standing in for the
+// MediaWiki scripts. The converter marks tables with data-sortable and
+// data-collapsible; collapsible divs keep their mw-collapsible class.
+(function () {
+    function headerRow(table) {
+        var row = table.rows[0];
+        return row && row.querySelector('th') ? row : null;
+    }
+
+    function sortKey(cell) {
+        var text = cell.hasAttribute('data-sort-value')
+            ? cell.getAttribute('data-sort-value')
+            : cell.textContent;
+        text = text.trim();
+        var num = text.replace(/[, \s]/g, '').replace(/^[$€£¥]/, '').replace(/%$/, '');
+        if (num !== '' && !isNaN(num)) return { num: parseFloat(num) };
+        return { text: text.toLowerCase() };
+    }
+
+    function compare(a, b) {
+        if (a.num !== undefined && b.num !== undefined) return a.num - b.num;
+        if (a.num !== undefined) return -1;
+        if (b.num !== undefined) return 1;
+        return a.text.localeCompare(b.text);
+    }
+
+    function sortBy(table, th, column) {
+        var ascending = th.getAttribute('aria-sort') !== 'ascending';
+        var head = headerRow(table);
+        var rows = Array.prototype.slice.call(table.rows).filter(function (row) {
+            return row !== head && row.cells.length > column;
+        });
+        rows.sort(function (a, b) {
+            var c = compare(sortKey(a.cells[column]), sortKey(b.cells[column]));
+            return ascending ? c : -c;
+        });
+        rows.forEach(function (row) { row.parentNode.appendChild(row); });
+        Array.prototype.forEach.call(head.cells, function (cell) {
+            cell.removeAttribute('aria-sort');
+        });
+        th.setAttribute('aria-sort', ascending ? 'ascending' : 'descending');
+    }
+
+    function makeSortable(table) {
+        var head = headerRow(table);
+        if (!head) return;
+        Array.prototype.forEach.call(head.cells, function (th, column) {
+            if (th.hasAttribute('data-unsortable')) return;
+            th.classList.add('sort-header');
+            th.tabIndex = 0;
+            th.addEventListener('click', function () { sortBy(table, th, column); });
+            th.addEventListener('keydown', function (e) {
+                if (e.key === 'Enter' || e.key === ' ') {
+                    e.preventDefault();
+                    sortBy(table, th, column);
+                }
+            });
+        });
+    }
+
+    // collapsibleParts returns the element holding the toggle and the
+    // elements hidden when collapsed: every table row but the first, or the
+    // content of a div.
+    function collapsibleParts(el) {
+        if (el.tagName === 'TABLE') {
+            var rows = Array.prototype.slice.call(el.rows);
+            return { head: rows[0] && rows[0].cells[rows[0].cells.length - 1], body: rows.slice(1) };
+        }
+        var content = el.querySelectorAll(':scope > .mw-collapsible-content');
+        if (content.length) return { head: el, body: Array.prototype.slice.call(content) };
+        var children = Array.prototype.slice.call(el.children);
+        return { head: children[0], body: children.slice(1) };
+    }
+
+    function makeCollapsible(el, collapsed) {
+        var parts = collapsibleParts(el);
+        if (!parts.head || !parts.body.length) return;
+        var toggle = document.createElement('button');
+        toggle.type = 'button';
+        toggle.className = 'collapse-toggle';
+        function set(hidden) {
+            parts.body.forEach(function (part) { part.hidden = hidden; });
+            toggle.textContent = hidden ? 'show' : 'hide';
+            toggle.setAttribute('aria-expanded', String(!hidden));
+        }
+        toggle.addEventListener('click', function () {
+            set(toggle.getAttribute('aria-expanded') === 'true');
+        });
+        parts.head.insertBefore(toggle, parts.head.firstChild);
+        set(collapsed);
+    }
+
+    function init() {
+        document.querySelectorAll('table[data-sortable]').forEach(makeSortable);
+
+        var tables = document.querySelectorAll('table[data-collapsible]');
+        // Like MediaWiki, autocollapse only collapses when a page has more
+        // than one collapsible box.
+        var crowded = tables.length + document.querySelectorAll('div.mw-collapsible').length > 1;
+        tables.forEach(function (table) {
+            var state = table.getAttribute('data-collapsible');
+            makeCollapsible(table, state === 'collapsed' || (state === 'auto' && crowded));
+        });
+        document.querySelectorAll('div.mw-collapsible').forEach(function (div) {
+            makeCollapsible(div, div.classList.contains('mw-collapsed') ||
+                (div.classList.contains('autocollapse') && crowded));
+        });
+    }
+
+    if (document.readyState === 'loading') {
+        document.addEventListener('DOMContentLoaded', init);
+    } else {
+        init();
+    }
+})();
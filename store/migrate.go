@@ -0,0 +1,155 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Data directories written before the store used bbolt hold an
+// append-only log of checksummed records instead. It is read once into the
+// database and renamed out of the way.
+
+const (
+	opPut    = 1
+	opDelete = 2
+
+	logName = "wikiseek.db"
+)
+
+var (
+	errTorn    = errors.New("torn record")
+	errCorrupt = errors.New("corrupt record followed by valid records")
+)
+
+// migrateLog copies the live keys of a legacy log at path into s and
+// renames the log to path+".migrated". There is nothing to do without one.
+func migrateLog(s *Store, path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading legacy store: %v", err)
+	}
+	buckets, err := replayLog(data)
+	if err != nil {
+		return fmt.Errorf("reading legacy store %s: %v", path, err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for name, keys := range buckets {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			for k, v := range keys {
+				if err := bucket.Put([]byte(k), v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("migrating legacy store: %v", err)
+	}
+	if err := os.Rename(path, path+".migrated"); err != nil {
+		return fmt.Errorf("migrating legacy store: %v", err)
+	}
+	return nil
+}
+
+// replayLog applies every record of a legacy log. A record cut short by a
+// crash can only be the last one, so a bad record is dropped as torn when
+// nothing valid follows it; otherwise the log is corrupt and replaying past
+// the damage would silently lose the records after it.
+func replayLog(data []byte) (map[string]map[string][]byte, error) {
+	buckets := make(map[string]map[string][]byte)
+	for off := 0; off < len(data); {
+		op, bucket, key, value, n, err := parseRecord(data[off:])
+		if err != nil {
+			if validRecordAfter(data[off+1:]) {
+				return nil, fmt.Errorf("%w at offset %d", errCorrupt, off)
+			}
+			break
+		}
+		b := buckets[bucket]
+		switch op {
+		case opPut:
+			if b == nil {
+				b = make(map[string][]byte)
+				buckets[bucket] = b
+			}
+			b[key] = value
+		case opDelete:
+			delete(b, key)
+		}
+		off += n
+	}
+	return buckets, nil
+}
+
+// validRecordAfter reports whether a well-formed record starts anywhere in
+// data.
+func validRecordAfter(data []byte) bool {
+	for i := range data {
+		if _, _, _, _, _, err := parseRecord(data[i:]); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// A record is: crc32, the body length as a uvarint, then the body: op, and
+// bucket, key and value each prefixed by a uvarint length. The checksum
+// covers the body.
+func appendRecord(buf []byte, op byte, bucket, key string, value []byte) []byte {
+	body := []byte{op}
+	body = binary.AppendUvarint(body, uint64(len(bucket)))
+	body = append(body, bucket...)
+	body = binary.AppendUvarint(body, uint64(len(key)))
+	body = append(body, key...)
+	body = binary.AppendUvarint(body, uint64(len(value)))
+	body = append(body, value...)
+
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(body))
+	buf = binary.AppendUvarint(buf, uint64(len(body)))
+	return append(buf, body...)
+}
+
+// parseRecord reads the record at the start of data and its length.
+func parseRecord(data []byte) (op byte, bucket, key string, value []byte, n int, err error) {
+	if len(data) < 4 {
+		return 0, "", "", nil, 0, errTorn
+	}
+	size, m := binary.Uvarint(data[4:])
+	if m <= 0 || size == 0 || size > uint64(len(data)-4-m) {
+		return 0, "", "", nil, 0, errTorn
+	}
+	body := data[4+m : 4+m+int(size)]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data) {
+		return 0, "", "", nil, 0, errTorn
+	}
+	op = body[0]
+	if op != opPut && op != opDelete {
+		return 0, "", "", nil, 0, errTorn
+	}
+	rest := body[1:]
+	fields := make([][]byte, 3)
+	for i := range fields {
+		l, k := binary.Uvarint(rest)
+		if k <= 0 || uint64(len(rest)-k) < l {
+			return 0, "", "", nil, 0, errTorn
+		}
+		fields[i] = rest[k : k+int(l)]
+		rest = rest[k+int(l):]
+	}
+	if len(rest) != 0 {
+		return 0, "", "", nil, 0, errTorn
+	}
+	return op, string(fields[0]), string(fields[1]), fields[2], 4 + m + int(size), nil
+}
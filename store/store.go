@@ -0,0 +1,220 @@
+// Package store is a small embedded key-value store with named buckets,
+// kept in a bbolt database in the data directory. Values are read from the
+// memory-mapped file rather than held in memory, and the file is compacted
+// on open when most of it is free pages.
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	dbName = "wikiseek.bolt"
+
+	// compactMinSize is the file size below which free pages aren't worth
+	// reclaiming.
+	compactMinSize = 1 << 20
+)
+
+// Store is an open data directory
+type Store struct {
+	path string
+	db   *bolt.DB
+}
+
+// Open opens or creates the store in dir. A data directory written by
+// earlier versions, which kept an append-only log, is migrated into the
+// database; see migrateLog.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating data directory: %v", err)
+	}
+	s := &Store{path: filepath.Join(dir, dbName)}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	if err := migrateLog(s, filepath.Join(dir, logName)); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if s.mostlyFree() {
+		if err := s.Compact(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) open() error {
+	// Another process holding the file makes bbolt wait for it; give up
+	// instead of hanging at startup
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("opening store %s: %v", s.path, err)
+	}
+	s.db = db
+	return nil
+}
+
+// mostlyFree reports whether more than half of a sizable file is free
+// pages, left behind by deleted and overwritten values.
+func (s *Store) mostlyFree() bool {
+	info, err := os.Stat(s.path)
+	if err != nil || info.Size() < compactMinSize {
+		return false
+	}
+	stats := s.db.Stats()
+	free := int64(stats.FreePageN+stats.PendingPageN) * int64(s.db.Info().PageSize)
+	return free > info.Size()/2
+}
+
+// Compact rewrites the database with only its live pages. The store is
+// closed and reopened, so it must not be in use meanwhile.
+func (s *Store) Compact() error {
+	tmp := s.path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("compacting store: %v", err)
+	}
+	if err := bolt.Compact(dst, s.db, 1<<20); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("compacting store: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("compacting store: %v", err)
+	}
+	if err := s.db.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("compacting store: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		// The original file is untouched, so carry on with it
+		if reopenErr := s.open(); reopenErr != nil {
+			return reopenErr
+		}
+		return fmt.Errorf("compacting store: %v", err)
+	}
+	return s.open()
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// Bucket returns a handle on a named bucket. Buckets are created on first
+// write. A nil Store returns a nil Bucket.
+func (s *Store) Bucket(name string) *Bucket {
+	if s == nil {
+		return nil
+	}
+	return &Bucket{s: s, name: []byte(name)}
+}
+
+// Bucket is a namespace of keys within a Store. A nil Bucket behaves as an
+// empty, read-only bucket so features work without a data directory.
+type Bucket struct {
+	s    *Store
+	name []byte
+}
+
+// view runs fn on the bucket if it exists.
+func (b *Bucket) view(fn func(*bolt.Bucket)) {
+	b.s.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(b.name); bucket != nil {
+			fn(bucket)
+		}
+		return nil
+	})
+}
+
+// Get returns a copy of the value stored under key.
+func (b *Bucket) Get(key string) ([]byte, bool) {
+	if b == nil {
+		return nil, false
+	}
+	var value []byte
+	b.view(func(bucket *bolt.Bucket) {
+		if v := bucket.Get([]byte(key)); v != nil {
+			value = append([]byte{}, v...)
+		}
+	})
+	return value, value != nil
+}
+
+// Put stores value under key.
+func (b *Bucket) Put(key string, value []byte) error {
+	if b == nil {
+		return nil
+	}
+	err := b.s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(b.name)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), value)
+	})
+	if err != nil {
+		return fmt.Errorf("writing store: %v", err)
+	}
+	return nil
+}
+
+// Delete removes key.
+func (b *Bucket) Delete(key string) error {
+	if b == nil {
+		return nil
+	}
+	err := b.s.db.Update(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(b.name); bucket != nil {
+			return bucket.Delete([]byte(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("writing store: %v", err)
+	}
+	return nil
+}
+
+// Keys returns the bucket's keys in sorted order.
+func (b *Bucket) Keys() []string {
+	if b == nil {
+		return nil
+	}
+	var keys []string
+	b.view(func(bucket *bolt.Bucket) {
+		bucket.ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys
+}
+
+// Len returns the number of keys in the bucket.
+func (b *Bucket) Len() int {
+	if b == nil {
+		return 0
+	}
+	n := 0
+	b.view(func(bucket *bolt.Bucket) {
+		n = bucket.Stats().KeyN
+	})
+	return n
+}
@@ -0,0 +1,188 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func openTest(t *testing.T, dir string) *Store {
+	t.Helper()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestPutGetDelete(t *testing.T) {
+	dir := t.TempDir()
+	s := openTest(t, dir)
+	b := s.Bucket("views")
+	if _, ok := b.Get("Apple"); ok {
+		t.Fatal("Get on an empty bucket found a value")
+	}
+	for _, k := range []string{"Banana", "Apple", "Cherry"} {
+		if err := b.Put(k, []byte(k+"!")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if err := b.Put("Apple", []byte("2")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := b.Delete("Cherry"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := b.Delete("Missing"); err != nil {
+		t.Fatalf("Delete of a missing key: %v", err)
+	}
+	if err := s.Bucket("other").Delete("Apple"); err != nil {
+		t.Fatalf("Delete in a missing bucket: %v", err)
+	}
+
+	check := func(s *Store) {
+		t.Helper()
+		b := s.Bucket("views")
+		if v, ok := b.Get("Apple"); !ok || string(v) != "2" {
+			t.Errorf("Get(Apple) = %q, %v; want 2", v, ok)
+		}
+		if _, ok := b.Get("Cherry"); ok {
+			t.Error("deleted key Cherry is still present")
+		}
+		if keys := b.Keys(); !slices.Equal(keys, []string{"Apple", "Banana"}) {
+			t.Errorf("Keys = %v", keys)
+		}
+		if n := b.Len(); n != 2 {
+			t.Errorf("Len = %d, want 2", n)
+		}
+		if n := s.Bucket("other").Len(); n != 0 {
+			t.Errorf("Len of an unwritten bucket = %d", n)
+		}
+	}
+	check(s)
+	s.Close()
+	check(openTest(t, dir))
+}
+
+func TestGetReturnsCopy(t *testing.T) {
+	b := openTest(t, t.TempDir()).Bucket("b")
+	b.Put("k", []byte("value"))
+	v, _ := b.Get("k")
+	v[0] = 'X'
+	if again, _ := b.Get("k"); string(again) != "value" {
+		t.Errorf("modifying a returned value changed the store: %q", again)
+	}
+}
+
+func TestNilBucket(t *testing.T) {
+	var s *Store
+	b := s.Bucket("views")
+	if err := b.Put("k", []byte("v")); err != nil {
+		t.Errorf("Put on a nil bucket: %v", err)
+	}
+	if _, ok := b.Get("k"); ok || b.Len() != 0 || b.Keys() != nil || b.Delete("k") != nil {
+		t.Error("a nil bucket should be empty")
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openTest(t, dir)
+	b := s.Bucket("big")
+	value := bytes.Repeat([]byte("x"), 64<<10)
+	for i := 0; i < 64; i++ {
+		if err := b.Put(string(rune('A'+i)), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i < 64; i++ {
+		b.Delete(string(rune('A' + i)))
+	}
+	s.Close()
+	path := filepath.Join(dir, dbName)
+	before, _ := os.Stat(path)
+
+	s = openTest(t, dir)
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size()/2 {
+		t.Errorf("file of %d bytes, mostly free, was not compacted (now %d bytes)", before.Size(), after.Size())
+	}
+	if v, ok := s.Bucket("big").Get("A"); !ok || !bytes.Equal(v, value) {
+		t.Error("live value lost by compaction")
+	}
+	if n := s.Bucket("big").Len(); n != 1 {
+		t.Errorf("Len after compaction = %d, want 1", n)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Error("compaction left its temporary file behind")
+	}
+}
+
+// writeLog writes a legacy log of puts of key=value pairs in bucket b.
+func writeLog(t *testing.T, dir string, pairs ...string) []byte {
+	t.Helper()
+	var log []byte
+	for i := 0; i < len(pairs); i += 2 {
+		log = appendRecord(log, opPut, "b", pairs[i], []byte(pairs[i+1]))
+	}
+	if err := os.WriteFile(filepath.Join(dir, logName), log, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
+
+func TestMigrateLog(t *testing.T) {
+	dir := t.TempDir()
+	log := writeLog(t, dir, "one", "1", "two", "2")
+	log = appendRecord(log, opDelete, "b", "one", nil)
+	os.WriteFile(filepath.Join(dir, logName), log, 0644)
+
+	s := openTest(t, dir)
+	if keys := s.Bucket("b").Keys(); !slices.Equal(keys, []string{"two"}) {
+		t.Errorf("migrated keys = %v, want [two]", keys)
+	}
+	if _, err := os.Stat(filepath.Join(dir, logName)); !os.IsNotExist(err) {
+		t.Error("legacy log was not moved out of the way")
+	}
+	if _, err := os.Stat(filepath.Join(dir, logName+".migrated")); err != nil {
+		t.Errorf("legacy log was not kept: %v", err)
+	}
+}
+
+func TestMigrateTornTail(t *testing.T) {
+	for _, cut := range []int{1, 3, 6, 10} {
+		dir := t.TempDir()
+		log := writeLog(t, dir, "one", "1", "two", "2", "three", "three3")
+		os.WriteFile(filepath.Join(dir, logName), log[:len(log)-cut], 0644)
+
+		s := openTest(t, dir)
+		if keys := s.Bucket("b").Keys(); !slices.Equal(keys, []string{"one", "two"}) {
+			t.Errorf("cut %d: keys = %v, want the records before the torn one", cut, keys)
+		}
+		s.Close()
+	}
+}
+
+func TestMigrateCorruptMiddle(t *testing.T) {
+	dir := t.TempDir()
+	log := writeLog(t, dir, "one", "1", "two", "2", "three", "3")
+	first := len(appendRecord(nil, opPut, "b", "one", []byte("1")))
+	log[first+len(log[first:])/6] ^= 0x40 // flip a bit inside the second record
+	os.WriteFile(filepath.Join(dir, logName), log, 0644)
+
+	_, err := Open(dir)
+	if err == nil || !strings.Contains(err.Error(), errCorrupt.Error()) {
+		t.Fatalf("Open of a log corrupt in the middle: err = %v, want %v", err, errCorrupt)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, logName)); !bytes.Equal(data, log) {
+		t.Error("corrupt legacy log was modified")
+	}
+	if _, err := replayLog(log); !errors.Is(err, errCorrupt) {
+		t.Errorf("replayLog: err = %v, want errCorrupt", err)
+	}
+}
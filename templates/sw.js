@@ -0,0 +1,234 @@
+// WikiSeek service worker, generated by the server for its base path.
+{{- if .Pages}}
+// With -pwa it keeps the site shell and the articles read recently, so
+// they stay readable while the server can't be reached.
+{{- end}}
+{{- if .Titles}}
+// With -offline-search it keeps a copy of the title lists published under
+// /static/titles-*.json.gz and answers /search from them whenever the
+// server can't be reached, e.g. while it restarts to reindex.
+{{- end}}
+var base = '{{js .Base}}';
+var CACHE = 'wikiseek-offline';
+var SHELL = 'wikiseek-shell-{{js .Version}}';
+var PAGES = 'wikiseek-pages';
+var shellURLs = [{{range $i, $url := .Shell}}{{if $i}}, {{end}}'{{js $url}}'{{end}}];
+var pageLimit = {{.PageLimit}};
+var manifestURL = base + '/static/titles-manifest.json';
+var resultLimit = 200;
+
+self.addEventListener('install', function (event) {
+    self.skipWaiting();
+    event.waitUntil(caches.open(SHELL).then(function (cache) {
+        return cache.addAll(shellURLs);
+    }));
+});
+
+// activate drops the shells of earlier versions.
+self.addEventListener('activate', function (event) {
+    event.waitUntil(caches.keys().then(function (names) {
+        return Promise.all(names.filter(function (name) {
+            return name.indexOf('wikiseek-shell-') === 0 && name !== SHELL;
+        }).map(function (name) { return caches.delete(name); }));
+    }).then(function () {
+        return self.clients.claim();
+    }));
+});
+{{- if .Titles}}
+
+self.addEventListener('message', function (event) {
+    if (event.data === 'warm') event.waitUntil(warm());
+});
+{{- end}}
+
+self.addEventListener('fetch', function (event) {
+    if (event.request.method !== 'GET') return;
+    var url = new URL(event.request.url);
+    if (url.origin !== self.location.origin) return;
+    if (event.request.mode === 'navigate' && url.pathname === base + '/search') {
+        event.respondWith(fetch(event.request).catch(function () {
+{{- if .Titles}}
+            return offlineSearch(url.searchParams.get('q') || '');
+{{- else}}
+            return offlinePage();
+{{- end}}
+        }));
+    } else if (shellURLs.indexOf(url.pathname) >= 0) {
+        event.respondWith(fetch(event.request).then(function (response) {
+            if (response.ok) {
+                var copy = response.clone();
+                caches.open(SHELL).then(function (cache) { cache.put(url.pathname, copy); });
+            }
+            return response;
+        }).catch(function () {
+            return caches.match(url.pathname);
+        }));
+{{- if .Pages}}
+    } else if (event.request.mode === 'navigate' && isArticle(url)) {
+        event.respondWith(fetchPage(event.request, url));
+{{- end}}
+    }
+});
+{{- if .Pages}}
+
+// isArticle reports whether a page is an article, of the main wiki or an
+// extra one, rather than a generated page such as /admin.
+function isArticle(url) {
+    var path = url.pathname;
+    return path.indexOf(base + '/wiki/') === 0 || path.indexOf(base + '/w/') === 0;
+}
+
+// fetchPage loads an article from the server, keeping a copy, and falls
+// back to the copy while the server can't be reached.
+function fetchPage(request, url) {
+    var key = url.pathname + url.search;
+    return fetch(request).then(function (response) {
+        if (response.ok && !response.redirected) {
+            var copy = response.clone();
+            caches.open(PAGES).then(function (cache) {
+                return cache.delete(key).then(function () {
+                    return cache.put(key, copy);
+                }).then(function () {
+                    return trimPages(cache);
+                });
+            });
+        }
+        return response;
+    }).catch(function () {
+        return caches.open(PAGES).then(function (cache) {
+            return cache.match(key);
+        }).then(function (saved) {
+            return saved || offlinePage();
+        });
+    });
+}
+
+// trimPages removes the oldest articles beyond pageLimit. Cache keys keep
+// insertion order, and fetchPage re-inserts pages as they are read.
+function trimPages(cache) {
+    return cache.keys().then(function (requests) {
+        return Promise.all(requests.slice(0, Math.max(0, requests.length - pageLimit)).map(function (req) {
+            return cache.delete(req);
+        }));
+    });
+}
+{{- end}}
+{{- if .Titles}}
+
+// warm downloads the title shards when the server has published a new
+// version, replacing the old ones once all of them are stored.
+function warm() {
+    return Promise.all([
+        fetch(manifestURL, { cache: 'no-cache' }).then(function (r) { return r.json(); }),
+        caches.open(CACHE)
+    ]).then(function (results) {
+        var manifest = results[0], cache = results[1];
+        return cache.match(manifestURL).then(function (old) {
+            return old ? old.json() : null;
+        }).then(function (previous) {
+            if (previous && previous.version === manifest.version) return;
+            var urls = Object.keys(manifest.shards).map(function (k) { return manifest.shards[k]; });
+            return urls.reduce(function (done, url) {
+                return done.then(function () { return cache.add(url); });
+            }, Promise.resolve()).then(function () {
+                return cache.put(manifestURL, new Response(JSON.stringify(manifest), {
+                    headers: { 'Content-Type': 'application/json' }
+                }));
+            }).then(function () {
+                return cache.keys();
+            }).then(function (requests) {
+                return Promise.all(requests.filter(function (req) {
+                    var path = new URL(req.url).pathname;
+                    return path.indexOf(base + '/static/titles-') === 0 && path !== manifestURL &&
+                        urls.every(function (u) { return req.url.indexOf(u) < 0; });
+                }).map(function (req) { return cache.delete(req); }));
+            });
+        });
+    }).catch(function () {});
+}
+
+// shardOf mirrors titleShard on the server.
+function shardOf(query) {
+    var c = query.trim().charAt(0).toLowerCase();
+    return /^[a-z0-9]$/.test(c) ? c : 'other';
+}
+{{- end}}
+
+function escapeHTML(s) {
+    return s.replace(/[&<>"]/g, function (c) {
+        return { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;' }[c];
+    });
+}
+
+// page wraps an offline page's body in the site's stylesheet and search box.
+function page(status, title, query, body) {
+    return new Response('<!DOCTYPE html><html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">' +
+        '<title>' + escapeHTML(title) + ' - WikiSeek</title><link rel="stylesheet" href="' + base + '/static/style.css"></head><body>' +
+        '<form action="' + base + '/search"><input type="text" name="q" value="' + escapeHTML(query) + '" style="width: 100%; padding: 5px;"></form>' +
+        '<h1>' + escapeHTML(title) + '</h1>' + body + '</body></html>',
+        { status: status, headers: { 'Content-Type': 'text/html; charset=utf-8' } });
+}
+
+// offlinePage explains that the server can't be reached, listing the
+// articles saved for reading offline.
+function offlinePage() {
+{{- if .Pages}}
+    return caches.open(PAGES).then(function (cache) {
+        return cache.keys();
+    }).then(function (requests) {
+        var links = requests.reverse().map(function (req) {
+            var url = new URL(req.url);
+            var m = url.pathname.slice(base.length).match(/^\/(?:wiki|w\/[^\/]+)\/(.*)$/);
+            var title = decodeURIComponent(m ? m[1] : url.pathname).replace(/_/g, ' ');
+            return '<li><a href="' + escapeHTML(url.pathname + url.search) + '">' + escapeHTML(title) + '</a></li>';
+        });
+        var body = '<p>The server can\'t be reached, and this page isn\'t saved for reading offline.</p>';
+        if (links.length) {
+            body += '<p>These articles are saved:</p><ul>' + links.join('') + '</ul>';
+        }
+        return page(503, 'Offline', '', body);
+    });
+{{- else}}
+    return Promise.resolve(page(503, 'Offline', '', '<p>The server can\'t be reached.</p>'));
+{{- end}}
+}
+{{- if .Titles}}
+
+function offlineSearch(query) {
+    var q = query.trim().toLowerCase();
+    return caches.open(CACHE).then(function (cache) {
+        return cache.match(manifestURL).then(function (r) {
+            return r ? r.json() : null;
+        }).then(function (manifest) {
+            var url = manifest && manifest.shards[shardOf(q)];
+            return url ? cache.match(url) : null;
+        });
+    }).then(function (r) {
+        return r ? r.json() : null;
+    }).then(function (titles) {
+        var body;
+        if (!q) {
+            body = '<p>Enter a title to search.</p>';
+        } else if (!titles) {
+            body = '<p>The server can\'t be reached and no titles are stored for offline search yet.</p>';
+        } else {
+            var exact = [], prefix = [];
+            titles.forEach(function (t) {
+                var title = t[0].toLowerCase();
+                if (title === q) exact.push(t);
+                else if (title.indexOf(q) === 0) prefix.push(t);
+            });
+            var matches = exact.concat(prefix);
+            body = '<p>' + matches.length + ' titles start with “' + escapeHTML(query) + '”. Articles open once the server is back' +
+{{- if .Pages}}
+                ', or now if they are saved for reading offline' +
+{{- end}}
+                '.</p>' +
+                matches.slice(0, resultLimit).map(function (t) {
+                    return '<div class="result"><a href="' + base + escapeHTML(t[1]) + '">' + escapeHTML(t[0]) + '</a></div>';
+                }).join('');
+        }
+        return page(200, 'Offline search', query, body);
+    });
+}
+{{- end}}